@@ -0,0 +1,138 @@
+// loadgen 按场景文件向 apm 服务发送压测流量。每个请求都在客户端 span 中发出并通过 traceparent 头
+// 传给服务，结束时输出各类请求的延迟百分位和错误分类，可选输出 JSON 报告用于对比。
+//
+//	go run . -scenario scenarios/checkout.yaml -json report.json
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+func initTracer(endpoint string) (*sdktrace.TracerProvider, error) {
+	exporter, err := otlptracehttp.New(context.Background(),
+		otlptracehttp.WithEndpoint(endpoint),
+		otlptracehttp.WithInsecure(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create exporter: %w", err)
+	}
+
+	res, err := resource.New(context.Background(),
+		resource.WithAttributes(
+			semconv.ServiceName("loadgen"),
+			semconv.ServiceVersion("1.0.0"),
+			attribute.String("environment", "development"),
+		),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create resource: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.AlwaysSample()),
+	)
+	otel.SetTracerProvider(tp)
+	return tp, nil
+}
+
+func main() {
+	scenarioPath := flag.String("scenario", "", "scenario file (YAML)")
+	duration := flag.Duration("duration", 0, "stop after this duration even if the scenario's rate plan is longer")
+	jsonOut := flag.String("json", "", "write the report as JSON to this file (\"-\" for stdout)")
+	otlpEndpoint := flag.String("otlp-endpoint", envOr("OTLP_ENDPOINT", "localhost:4318"), "OTLP/HTTP endpoint for client spans, empty to disable tracing")
+	progress := flag.Duration("progress", 5*time.Second, "progress log interval, 0 to disable")
+	flag.Parse()
+
+	if *scenarioPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	scenario, err := loadScenario(*scenarioPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// 即使不导出 span 也注入 traceparent，服务端仍会生成以此为父的 trace
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	if *otlpEndpoint != "" {
+		tp, err := initTracer(*otlpEndpoint)
+		if err != nil {
+			log.Fatalf("Failed to initialize tracer: %v", err)
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := tp.Shutdown(ctx); err != nil {
+				log.Printf("Error shutting down tracer provider: %v", err)
+			}
+		}()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	r := newRunner(scenario)
+	r.maxDuration = *duration
+	r.progress = *progress
+	log.Printf("Running scenario %q against %s for %s", scenario.Name, scenario.BaseURL, planDuration(scenario, *duration))
+	report := r.Run(ctx)
+
+	// JSON 输出到标准输出时表格改为输出到标准错误
+	text := os.Stdout
+	if *jsonOut == "-" {
+		text = os.Stderr
+	}
+	report.WriteText(text)
+	if err := writeJSONReport(report, *jsonOut); err != nil {
+		log.Printf("Failed to write JSON report: %v", err)
+	}
+}
+
+func planDuration(s *Scenario, limit time.Duration) time.Duration {
+	if total := s.TotalDuration(); limit <= 0 || total < limit {
+		return total
+	}
+	return limit
+}
+
+func writeJSONReport(r *Report, path string) error {
+	switch path {
+	case "":
+		return nil
+	case "-":
+		return r.WriteJSON(os.Stdout)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := r.WriteJSON(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
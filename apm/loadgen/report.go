@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// Report 一次压测的汇总结果，JSON 格式便于保存后对比不同版本或配置
+type Report struct {
+	Scenario        string         `json:"scenario"`
+	StartedAt       time.Time      `json:"started_at"`
+	DurationSeconds float64        `json:"duration_seconds"`
+	Dropped         int64          `json:"dropped"`
+	Total           RequestStats   `json:"total"`
+	Requests        []RequestStats `json:"requests"`
+}
+
+// RequestStats 一种请求（或全部请求）的统计
+type RequestStats struct {
+	Name      string  `json:"name"`
+	Count     int     `json:"count"`
+	Errors    int     `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+	RPS       float64 `json:"rps"`
+	// LatencyMs 只统计收到响应的请求，包括状态码不符合预期的
+	LatencyMs    LatencyStats   `json:"latency_ms"`
+	StatusCodes  map[string]int `json:"status_codes,omitempty"`
+	ErrorsByKind map[string]int `json:"errors_by_kind,omitempty"`
+}
+
+// LatencyStats 延迟分布，单位毫秒
+type LatencyStats struct {
+	Min  float64 `json:"min"`
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P95  float64 `json:"p95"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+type requestSamples struct {
+	stats     RequestStats
+	latencies []time.Duration
+}
+
+func newRequestSamples(name string) *requestSamples {
+	return &requestSamples{stats: RequestStats{
+		Name:         name,
+		StatusCodes:  make(map[string]int),
+		ErrorsByKind: make(map[string]int),
+	}}
+}
+
+func (s *requestSamples) add(res result) {
+	s.stats.Count++
+	if res.status != 0 {
+		s.stats.StatusCodes[strconv.Itoa(res.status)]++
+		s.latencies = append(s.latencies, res.latency)
+	}
+	if res.errKind != "" {
+		s.stats.Errors++
+		s.stats.ErrorsByKind[res.errKind]++
+	}
+}
+
+func (s *requestSamples) finish(elapsed time.Duration) RequestStats {
+	st := s.stats
+	if st.Count > 0 {
+		st.ErrorRate = float64(st.Errors) / float64(st.Count)
+	}
+	if elapsed > 0 {
+		st.RPS = float64(st.Count) / elapsed.Seconds()
+	}
+	st.LatencyMs = latencyStats(s.latencies)
+	return st
+}
+
+// collector 汇总请求结果，只在一个 goroutine 中使用
+type collector struct {
+	scenario string
+	order    []string
+	byName   map[string]*requestSamples
+	total    *requestSamples
+}
+
+func newCollector(s *Scenario) *collector {
+	c := &collector{scenario: s.Name, byName: make(map[string]*requestSamples), total: newRequestSamples("total")}
+	for _, rs := range s.Requests {
+		c.order = append(c.order, rs.Name)
+		c.byName[rs.Name] = newRequestSamples(rs.Name)
+	}
+	return c
+}
+
+func (c *collector) add(res result) {
+	c.total.add(res)
+	c.byName[res.request].add(res)
+}
+
+func (c *collector) report(start time.Time, elapsed time.Duration, dropped int64) *Report {
+	r := &Report{
+		Scenario:        c.scenario,
+		StartedAt:       start.UTC(),
+		DurationSeconds: math.Round(elapsed.Seconds()*1000) / 1000,
+		Dropped:         dropped,
+		Total:           c.total.finish(elapsed),
+	}
+	for _, name := range c.order {
+		r.Requests = append(r.Requests, c.byName[name].finish(elapsed))
+	}
+	return r
+}
+
+// latencyStats 计算延迟分布，百分位使用最近秩法
+func latencyStats(latencies []time.Duration) LatencyStats {
+	if len(latencies) == 0 {
+		return LatencyStats{}
+	}
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var sum time.Duration
+	for _, d := range sorted {
+		sum += d
+	}
+	percentile := func(p float64) float64 {
+		rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+		if rank < 1 {
+			rank = 1
+		}
+		return ms(sorted[rank-1])
+	}
+	return LatencyStats{
+		Min:  ms(sorted[0]),
+		Mean: ms(sum / time.Duration(len(sorted))),
+		P50:  percentile(50),
+		P90:  percentile(90),
+		P95:  percentile(95),
+		P99:  percentile(99),
+		Max:  ms(sorted[len(sorted)-1]),
+	}
+}
+
+func ms(d time.Duration) float64 {
+	return math.Round(float64(d)/float64(time.Microsecond)) / 1000
+}
+
+// WriteText 以表格形式输出报告
+func (r *Report) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "\nScenario %q: %d requests in %.1fs (%.1f rps), %d errors (%.2f%%), %d dropped\n\n",
+		r.Scenario, r.Total.Count, r.DurationSeconds, r.Total.RPS, r.Total.Errors, r.Total.ErrorRate*100, r.Dropped)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "request\tcount\trps\terrors\tp50 ms\tp90 ms\tp95 ms\tp99 ms\tmax ms\t")
+	for _, st := range append(r.Requests, r.Total) {
+		l := st.LatencyMs
+		fmt.Fprintf(tw, "%s\t%d\t%.1f\t%d\t%.1f\t%.1f\t%.1f\t%.1f\t%.1f\t\n",
+			st.Name, st.Count, st.RPS, st.Errors, l.P50, l.P90, l.P95, l.P99, l.Max)
+	}
+	tw.Flush()
+
+	if r.Total.Errors == 0 {
+		return
+	}
+	fmt.Fprintln(w, "\nErrors:")
+	for _, st := range r.Requests {
+		for _, kind := range sortedKeys(st.ErrorsByKind) {
+			fmt.Fprintf(w, "  %-24s %-20s %d\n", st.Name, kind, st.ErrorsByKind[kind])
+		}
+	}
+}
+
+// WriteJSON 输出 JSON 格式的报告
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
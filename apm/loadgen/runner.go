@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tickInterval 调度器计算应发请求数的间隔
+const tickInterval = 10 * time.Millisecond
+
+// result 一次请求的结果，errKind 为空表示成功
+type result struct {
+	request string
+	latency time.Duration
+	status  int
+	errKind string
+}
+
+type runner struct {
+	scenario *Scenario
+	client   *http.Client
+	// maxDuration 大于 0 时在计划结束前提前停止
+	maxDuration time.Duration
+	// progress 大于 0 时按该间隔打印进度
+	progress time.Duration
+
+	sent    atomic.Int64
+	failed  atomic.Int64
+	dropped atomic.Int64
+}
+
+func newRunner(s *Scenario) *runner {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = s.MaxConcurrency
+	return &runner{
+		scenario: s,
+		client:   &http.Client{Transport: transport, Timeout: time.Duration(s.Timeout)},
+	}
+}
+
+// Run 按速率计划发送请求直到计划结束或 ctx 结束，然后等待在途请求完成并返回汇总结果。
+// 调度是开环的：服务变慢不会降低发送速率，在途请求达到上限时计划中的请求会被丢弃
+func (r *runner) Run(ctx context.Context) *Report {
+	total := r.scenario.TotalDuration()
+	if r.maxDuration > 0 && r.maxDuration < total {
+		total = r.maxDuration
+	}
+
+	collector := newCollector(r.scenario)
+	results := make(chan result, r.scenario.MaxConcurrency)
+	collected := make(chan struct{})
+	go func() {
+		defer close(collected)
+		for res := range results {
+			collector.add(res)
+		}
+	}()
+
+	sem := make(chan struct{}, r.scenario.MaxConcurrency)
+	var wg sync.WaitGroup
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+	var lastProgress time.Time
+
+	start := time.Now()
+	last := start
+	var budget float64
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case now := <-ticker.C:
+			elapsed := now.Sub(start)
+			if elapsed >= total {
+				break loop
+			}
+			rate := r.scenario.RateAt(elapsed)
+			budget += rate * now.Sub(last).Seconds()
+			last = now
+
+			for ; budget >= 1; budget-- {
+				spec := r.scenario.pick(rng.Intn(r.scenario.totalWeight))
+				select {
+				case sem <- struct{}{}:
+				default:
+					r.dropped.Add(1)
+					continue
+				}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					defer func() { <-sem }()
+					// 请求不随 ctx 取消，中断后在途请求仍然计入结果
+					results <- r.do(context.Background(), spec)
+				}()
+			}
+
+			if r.progress > 0 && now.Sub(lastProgress) >= r.progress {
+				lastProgress = now
+				log.Printf("%s elapsed, target %.1f rps, %d sent, %d failed, %d dropped",
+					elapsed.Truncate(time.Second), rate, r.sent.Load(), r.failed.Load(), r.dropped.Load())
+			}
+		}
+	}
+	elapsed := time.Since(start)
+
+	wg.Wait()
+	close(results)
+	<-collected
+	return collector.report(start, elapsed, r.dropped.Load())
+}
+
+// do 发送一次请求，请求在客户端 span 中执行，追踪上下文通过 traceparent 头传给服务
+func (r *runner) do(ctx context.Context, spec *RequestSpec) result {
+	r.sent.Add(1)
+	res := r.send(ctx, spec)
+	if res.errKind != "" {
+		r.failed.Add(1)
+	}
+	return res
+}
+
+func (r *runner) send(ctx context.Context, spec *RequestSpec) result {
+	tracer := otel.Tracer("loadgen")
+	ctx, span := tracer.Start(ctx, spec.Name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("loadgen.scenario", r.scenario.Name),
+			attribute.String("loadgen.request", spec.Name),
+		),
+	)
+	defer span.End()
+
+	res := result{request: spec.Name}
+	fail := func(kind string, err error) result {
+		res.errKind = kind
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return res
+	}
+
+	rendered, err := spec.render()
+	if err != nil {
+		return fail("template", err)
+	}
+	url := r.scenario.BaseURL + rendered.path
+	span.SetAttributes(semconv.HTTPMethod(rendered.method), semconv.HTTPURL(url))
+
+	var body io.Reader
+	if rendered.body != "" {
+		body = strings.NewReader(rendered.body)
+	}
+	req, err := http.NewRequestWithContext(ctx, rendered.method, url, body)
+	if err != nil {
+		return fail("invalid_request", err)
+	}
+	for k, v := range r.scenario.Headers {
+		req.Header.Set(k, v)
+	}
+	for k, v := range rendered.headers {
+		req.Header.Set(k, v)
+	}
+	if rendered.body != "" && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	start := time.Now()
+	resp, err := r.client.Do(req)
+	if err != nil {
+		res.latency = time.Since(start)
+		return fail(classifyError(err), err)
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	res.latency = time.Since(start)
+	res.status = resp.StatusCode
+	span.SetAttributes(semconv.HTTPStatusCode(resp.StatusCode))
+
+	switch {
+	case err != nil:
+		return fail(classifyError(err), err)
+	case !spec.expects(resp.StatusCode):
+		return fail(fmt.Sprintf("status_%d", resp.StatusCode), fmt.Errorf("unexpected status %d", resp.StatusCode))
+	}
+	return res
+}
+
+// classifyError 把传输错误归类，便于在报告中按类型统计
+func classifyError(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) ||
+		(errors.As(err, &netErr) && netErr.Timeout()):
+		return "timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection_refused"
+	case errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF):
+		return "connection_reset"
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return "dns"
+	}
+	return "transport"
+}
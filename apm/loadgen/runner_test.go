@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestRunnerSendsTracedRequests(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var mu sync.Mutex
+	traceparents := map[string]bool{}
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		traceparents[r.Header.Get("traceparent")] = true
+		if r.Method == "POST" {
+			var buf bytes.Buffer
+			buf.ReadFrom(r.Body)
+			bodies = append(bodies, buf.String()+" "+r.Header.Get("Content-Type")+" "+r.Header.Get("Authorization"))
+		}
+		mu.Unlock()
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/fail"):
+			w.WriteHeader(503)
+		case strings.HasPrefix(r.URL.Path, "/slow"):
+			time.Sleep(200 * time.Millisecond)
+		case r.Method == "POST":
+			w.WriteHeader(201)
+		}
+	}))
+	defer srv.Close()
+
+	s, err := parseScenario([]byte(`
+name: test
+base_url: `+srv.URL+`
+headers: {Authorization: Bearer t}
+timeout: 50ms
+rps: 100
+duration: 500ms
+requests:
+  - {name: ok, path: /ok, weight: 2}
+  - {name: create, method: POST, path: /orders, body: '{"n": {{seq}}}', expect_status: [201]}
+  - {name: fail, path: /fail}
+  - {name: slow, path: /slow}
+`), "test.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := newRunner(s).Run(context.Background())
+
+	// 100 rps 持续 0.5s，允许调度误差
+	if report.Total.Count < 35 || report.Total.Count > 55 || report.Dropped != 0 {
+		t.Fatalf("sent %d, dropped %d", report.Total.Count, report.Dropped)
+	}
+	stats := map[string]RequestStats{}
+	for _, st := range report.Requests {
+		stats[st.Name] = st
+	}
+	if st := stats["ok"]; st.Errors != 0 || st.StatusCodes["200"] != st.Count || st.LatencyMs.P99 <= 0 || st.LatencyMs.P50 > st.LatencyMs.P99 {
+		t.Errorf("ok %+v", st)
+	}
+	if st := stats["create"]; st.Errors != 0 || st.StatusCodes["201"] != st.Count {
+		t.Errorf("create %+v", st)
+	}
+	if st := stats["fail"]; st.Count == 0 || st.Errors != st.Count || st.ErrorsByKind["status_503"] != st.Count || st.ErrorRate != 1 {
+		t.Errorf("fail %+v", st)
+	}
+	if st := stats["slow"]; st.Count == 0 || st.ErrorsByKind["timeout"] != st.Count {
+		t.Errorf("slow %+v", st)
+	}
+	if want := stats["fail"].Errors + stats["slow"].Errors; report.Total.Errors != want {
+		t.Errorf("total errors %d, want %d", report.Total.Errors, want)
+	}
+
+	// 每个请求一个客户端 span，trace 上下文传给了服务
+	if spans := recorder.Ended(); len(spans) != report.Total.Count {
+		t.Errorf("%d spans for %d requests", len(spans), report.Total.Count)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(traceparents) != report.Total.Count || traceparents[""] {
+		t.Errorf("%d distinct traceparent headers for %d requests", len(traceparents), report.Total.Count)
+	}
+	if len(bodies) == 0 || !strings.HasSuffix(bodies[0], " application/json Bearer t") {
+		t.Errorf("bodies %q", bodies)
+	}
+}
+
+func TestRunnerDropsWhenSaturated(t *testing.T) {
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-block }))
+	defer srv.Close()
+	defer close(block)
+
+	s, err := parseScenario([]byte("name: t\nbase_url: "+srv.URL+"\nmax_concurrency: 2\ntimeout: 1s\nrps: 100\nduration: 200ms\nrequests: [{name: r, path: /}]"), "t.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	report := newRunner(s).Run(ctx)
+	if report.Total.Count != 2 || report.Dropped == 0 {
+		t.Errorf("sent %d, dropped %d", report.Total.Count, report.Dropped)
+	}
+}
+
+func TestLatencyStats(t *testing.T) {
+	var latencies []time.Duration
+	for i := 100; i >= 1; i-- {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	got := latencyStats(latencies)
+	want := LatencyStats{Min: 1, Mean: 50.5, P50: 50, P90: 90, P95: 95, P99: 99, Max: 100}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got := latencyStats(nil); got != (LatencyStats{}) {
+		t.Errorf("empty: %+v", got)
+	}
+}
+
+func TestReportJSON(t *testing.T) {
+	c := newCollector(&Scenario{Name: "s", Requests: []RequestSpec{{Name: "a"}, {Name: "b"}}})
+	c.add(result{request: "a", latency: 10 * time.Millisecond, status: 200})
+	c.add(result{request: "b", latency: 20 * time.Millisecond, status: 500, errKind: "status_500"})
+	c.add(result{request: "b", errKind: "connection_refused"})
+	report := c.report(time.Unix(0, 0), time.Second, 1)
+
+	var buf bytes.Buffer
+	if err := report.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded Report
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	b := decoded.Requests[1]
+	if decoded.Total.Count != 3 || decoded.Total.Errors != 2 || b.ErrorsByKind["connection_refused"] != 1 || b.StatusCodes["500"] != 1 || b.LatencyMs.Max != 20 {
+		t.Errorf("report %s", buf.String())
+	}
+
+	var text bytes.Buffer
+	report.WriteText(&text)
+	if !strings.Contains(text.String(), "connection_refused") || !strings.Contains(text.String(), "total") {
+		t.Errorf("text report:\n%s", text.String())
+	}
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	mathrand "math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultTimeout        = 10 * time.Second
+	defaultMaxConcurrency = 200
+)
+
+// Scenario 一个压测场景：请求模板、权重和速率计划。
+// 速率可以用 rps + duration 表示恒定速率，也可以用 stages 表示分段线性变化的速率
+type Scenario struct {
+	Name    string            `yaml:"name"`
+	BaseURL string            `yaml:"base_url"`
+	Headers map[string]string `yaml:"headers"`
+	Timeout Duration          `yaml:"timeout"`
+	// MaxConcurrency 同时在途的请求上限，达到上限时按计划应发出的请求会被丢弃并计入 dropped
+	MaxConcurrency int      `yaml:"max_concurrency"`
+	RPS            float64  `yaml:"rps"`
+	Duration       Duration `yaml:"duration"`
+	// StartRPS 第一个阶段开始时的速率
+	StartRPS float64       `yaml:"start_rps"`
+	Stages   []Stage       `yaml:"stages"`
+	Requests []RequestSpec `yaml:"requests"`
+
+	totalWeight int
+}
+
+// Stage 速率计划的一个阶段，速率在 Duration 内从上一阶段的 Target 线性变化到本阶段的 Target
+type Stage struct {
+	Duration Duration `yaml:"duration"`
+	Target   float64  `yaml:"target"`
+}
+
+// RequestSpec 一种请求。Path、Headers 和 Body 是 text/template 模板，
+// Vars 在每次请求时先渲染，模板中用 {{.name}} 引用，使同一请求的路径和请求体使用相同的值
+type RequestSpec struct {
+	Name    string            `yaml:"name"`
+	Weight  int               `yaml:"weight"`
+	Method  string            `yaml:"method"`
+	Path    string            `yaml:"path"`
+	Vars    map[string]string `yaml:"vars"`
+	Headers map[string]string `yaml:"headers"`
+	Body    string            `yaml:"body"`
+	// ExpectStatus 视为成功的状态码，为空时 2xx 为成功
+	ExpectStatus []int `yaml:"expect_status"`
+
+	varNames []string
+	vars     map[string]*template.Template
+	path     *template.Template
+	headers  map[string]*template.Template
+	body     *template.Template
+}
+
+// Duration 支持 "5s"、"500ms" 形式的时长
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	parsed, err := time.ParseDuration(value.Value)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", value.Value)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// envPattern 只替换 ${VAR} 和 ${VAR:-default}，模板中的 $变量 保持不变
+var envPattern = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// loadScenario 读取、校验并编译场景文件
+func loadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read scenario: %w", err)
+	}
+	return parseScenario(data, path)
+}
+
+func parseScenario(data []byte, source string) (*Scenario, error) {
+	expanded := envPattern.ReplaceAllFunc(data, func(m []byte) []byte {
+		return []byte(expandEnv(string(m[2 : len(m)-1])))
+	})
+
+	var s Scenario
+	dec := yaml.NewDecoder(bytes.NewReader(expanded))
+	dec.KnownFields(true)
+	if err := dec.Decode(&s); err != nil {
+		return nil, fmt.Errorf("parse scenario %s: %w", source, err)
+	}
+	if err := s.validate(); err != nil {
+		return nil, fmt.Errorf("invalid scenario %s: %w", source, err)
+	}
+	return &s, nil
+}
+
+func expandEnv(name string) string {
+	key, def, hasDefault := strings.Cut(name, ":-")
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		return v
+	}
+	if hasDefault {
+		return def
+	}
+	return ""
+}
+
+// validate 检查场景中的全部错误并一次性返回，同时补全默认值并编译模板
+func (s *Scenario) validate() error {
+	var errs []error
+
+	if s.Name == "" {
+		errs = append(errs, errors.New("name is required"))
+	}
+	if u, err := url.Parse(s.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("base_url %q must be an absolute http(s) URL", s.BaseURL))
+	}
+	s.BaseURL = strings.TrimSuffix(s.BaseURL, "/")
+	if s.Timeout == 0 {
+		s.Timeout = Duration(defaultTimeout)
+	}
+	if s.MaxConcurrency == 0 {
+		s.MaxConcurrency = defaultMaxConcurrency
+	}
+	if s.Timeout < 0 || s.MaxConcurrency < 0 {
+		errs = append(errs, errors.New("timeout and max_concurrency must not be negative"))
+	}
+
+	switch {
+	case len(s.Stages) > 0 && (s.RPS != 0 || s.Duration != 0):
+		errs = append(errs, errors.New("rps/duration and stages are mutually exclusive"))
+	case len(s.Stages) == 0 && (s.RPS <= 0 || s.Duration <= 0):
+		errs = append(errs, errors.New("either rps and duration or stages are required"))
+	case len(s.Stages) == 0:
+		// 恒定速率等价于只有一个阶段
+		s.StartRPS = s.RPS
+		s.Stages = []Stage{{Duration: s.Duration, Target: s.RPS}}
+	}
+	if s.StartRPS < 0 {
+		errs = append(errs, errors.New("start_rps must not be negative"))
+	}
+	for i, st := range s.Stages {
+		if st.Duration <= 0 || st.Target < 0 {
+			errs = append(errs, fmt.Errorf("stage[%d]: duration must be positive and target not negative", i))
+		}
+	}
+
+	if len(s.Requests) == 0 {
+		errs = append(errs, errors.New("no requests defined"))
+	}
+	names := make(map[string]bool, len(s.Requests))
+	s.totalWeight = 0
+	for i := range s.Requests {
+		rs := &s.Requests[i]
+		prefix := fmt.Sprintf("request[%d] %q", i, rs.Name)
+		if rs.Name == "" {
+			errs = append(errs, fmt.Errorf("%s: name is required", prefix))
+		} else if names[rs.Name] {
+			errs = append(errs, fmt.Errorf("%s: duplicate name", prefix))
+		}
+		names[rs.Name] = true
+		if rs.Weight == 0 {
+			rs.Weight = 1
+		}
+		if rs.Weight < 0 {
+			errs = append(errs, fmt.Errorf("%s: weight must not be negative", prefix))
+		}
+		s.totalWeight += rs.Weight
+		if rs.Method == "" {
+			rs.Method = http.MethodGet
+		}
+		rs.Method = strings.ToUpper(rs.Method)
+		if !strings.HasPrefix(rs.Path, "/") {
+			errs = append(errs, fmt.Errorf("%s: path must start with /", prefix))
+		}
+		if err := rs.compile(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", prefix, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// TotalDuration 速率计划的总时长
+func (s *Scenario) TotalDuration() time.Duration {
+	var total time.Duration
+	for _, st := range s.Stages {
+		total += time.Duration(st.Duration)
+	}
+	return total
+}
+
+// RateAt 返回计划在 elapsed 时刻的目标 RPS，计划结束后返回 0
+func (s *Scenario) RateAt(elapsed time.Duration) float64 {
+	from := s.StartRPS
+	for _, st := range s.Stages {
+		d := time.Duration(st.Duration)
+		if elapsed < d {
+			return from + (st.Target-from)*float64(elapsed)/float64(d)
+		}
+		elapsed -= d
+		from = st.Target
+	}
+	return 0
+}
+
+// pick 按权重选择一种请求，r 在 [0, totalWeight) 内
+func (s *Scenario) pick(r int) *RequestSpec {
+	for i := range s.Requests {
+		if r < s.Requests[i].Weight {
+			return &s.Requests[i]
+		}
+		r -= s.Requests[i].Weight
+	}
+	return &s.Requests[len(s.Requests)-1]
+}
+
+func (rs *RequestSpec) compile() error {
+	var err error
+	newTemplate := func(name, text string) *template.Template {
+		t, e := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+		if e != nil && err == nil {
+			err = e
+		}
+		return t
+	}
+
+	rs.vars = make(map[string]*template.Template, len(rs.Vars))
+	rs.varNames = rs.varNames[:0]
+	for name, text := range rs.Vars {
+		rs.vars[name] = newTemplate("vars."+name, text)
+		rs.varNames = append(rs.varNames, name)
+	}
+	sort.Strings(rs.varNames)
+	rs.path = newTemplate("path", rs.Path)
+	rs.headers = make(map[string]*template.Template, len(rs.Headers))
+	for name, text := range rs.Headers {
+		rs.headers[name] = newTemplate("headers."+name, text)
+	}
+	if rs.Body != "" {
+		rs.body = newTemplate("body", rs.Body)
+	}
+	return err
+}
+
+// renderedRequest 渲染后的一次请求
+type renderedRequest struct {
+	method  string
+	path    string
+	headers map[string]string
+	body    string
+}
+
+func (rs *RequestSpec) render() (renderedRequest, error) {
+	data := make(map[string]string, len(rs.vars))
+	for _, name := range rs.varNames {
+		v, err := execute(rs.vars[name], nil)
+		if err != nil {
+			return renderedRequest{}, err
+		}
+		data[name] = v
+	}
+
+	out := renderedRequest{method: rs.Method, headers: make(map[string]string, len(rs.headers))}
+	var err error
+	if out.path, err = execute(rs.path, data); err != nil {
+		return renderedRequest{}, err
+	}
+	for name, t := range rs.headers {
+		if out.headers[name], err = execute(t, data); err != nil {
+			return renderedRequest{}, err
+		}
+	}
+	if rs.body != nil {
+		if out.body, err = execute(rs.body, data); err != nil {
+			return renderedRequest{}, err
+		}
+	}
+	return out, nil
+}
+
+func execute(t *template.Template, data map[string]string) (string, error) {
+	var buf strings.Builder
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// expects 判断状态码是否符合预期
+func (rs *RequestSpec) expects(status int) bool {
+	if len(rs.ExpectStatus) == 0 {
+		return status >= 200 && status < 300
+	}
+	for _, s := range rs.ExpectStatus {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+var sequence atomic.Int64
+
+// templateFuncs 请求模板中可用的函数
+var templateFuncs = template.FuncMap{
+	// randInt 返回 [min, max] 内的随机整数
+	"randInt": func(min, max int) int {
+		if max <= min {
+			return min
+		}
+		return min + mathrand.Intn(max-min+1)
+	},
+	// randFloat 返回 [min, max) 内保留两位小数的随机数
+	"randFloat": func(min, max float64) string {
+		return fmt.Sprintf("%.2f", min+mathrand.Float64()*(max-min))
+	},
+	"pick": func(choices ...string) string {
+		if len(choices) == 0 {
+			return ""
+		}
+		return choices[mathrand.Intn(len(choices))]
+	},
+	// seq 本次运行内递增的序号，从 1 开始
+	"seq": func() int64 { return sequence.Add(1) },
+	"uuid": func() string {
+		b := make([]byte, 16)
+		rand.Read(b)
+		return hex.EncodeToString(b)
+	},
+	"now": func() string { return time.Now().UTC().Format(time.RFC3339) },
+	"env": os.Getenv,
+}
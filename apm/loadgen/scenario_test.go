@@ -0,0 +1,127 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testScenario = `
+name: test
+base_url: ${TEST_BASE_URL:-http://localhost:8080}/
+headers:
+  Authorization: Bearer ${TEST_TOKEN}
+start_rps: 10
+stages:
+  - duration: 10s
+    target: 20
+  - duration: 5s
+    target: 20
+requests:
+  - name: get-user
+    weight: 3
+    path: /users/{{randInt 1 3}}
+  - name: create-order
+    method: post
+    path: /users/{{.user}}/orders
+    vars:
+      user: '{{randInt 7 7}}'
+    headers:
+      Idempotency-Key: 'order-{{.user}}'
+    body: '{"user_id": {{.user}}, "product": "{{pick "book"}}", "note": "{{$n := 1}}{{$n}}"}'
+    expect_status: [201]
+`
+
+func TestParseScenario(t *testing.T) {
+	t.Setenv("TEST_TOKEN", "s3cret")
+	s, err := parseScenario([]byte(testScenario), "test.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.BaseURL != "http://localhost:8080" || s.Headers["Authorization"] != "Bearer s3cret" {
+		t.Errorf("base url %q, headers %v", s.BaseURL, s.Headers)
+	}
+	if s.Timeout != Duration(defaultTimeout) || s.MaxConcurrency != defaultMaxConcurrency || s.totalWeight != 4 {
+		t.Errorf("defaults: %+v", s)
+	}
+
+	order := &s.Requests[1]
+	if order.Method != "POST" || order.Weight != 1 {
+		t.Errorf("request %+v", order)
+	}
+	rendered, err := order.render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 模板中的 $ 变量不会被当作环境变量替换
+	if rendered.path != "/users/7/orders" || rendered.headers["Idempotency-Key"] != "order-7" ||
+		rendered.body != `{"user_id": 7, "product": "book", "note": "1"}` {
+		t.Errorf("rendered %+v", rendered)
+	}
+	if !order.expects(201) || order.expects(200) || !s.Requests[0].expects(204) || s.Requests[0].expects(500) {
+		t.Error("unexpected status matching")
+	}
+
+	if s.pick(0).Name != "get-user" || s.pick(2).Name != "get-user" || s.pick(3).Name != "create-order" {
+		t.Error("weighted pick")
+	}
+}
+
+func TestScenarioRatePlan(t *testing.T) {
+	s, err := parseScenario([]byte(testScenario), "test.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[time.Duration]float64{
+		0:                      10,
+		5 * time.Second:        15,
+		10 * time.Second:       20,
+		12 * time.Second:       20,
+		15 * time.Second:       0,
+		500 * time.Millisecond: 10.5,
+	}
+	for elapsed, want := range cases {
+		if got := s.RateAt(elapsed); math.Abs(got-want) > 1e-9 {
+			t.Errorf("RateAt(%s) = %v, want %v", elapsed, got, want)
+		}
+	}
+	if s.TotalDuration() != 15*time.Second {
+		t.Errorf("total duration %s", s.TotalDuration())
+	}
+
+	constant, err := parseScenario([]byte("name: c\nbase_url: http://x\nrps: 5\nduration: 1m\nrequests: [{name: r, path: /}]"), "c.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if constant.RateAt(0) != 5 || constant.RateAt(59*time.Second) != 5 || constant.TotalDuration() != time.Minute {
+		t.Errorf("constant plan %+v", constant.Stages)
+	}
+}
+
+func TestScenarioValidation(t *testing.T) {
+	invalid := map[string]string{
+		"no rate":       "name: x\nbase_url: http://x\nrequests: [{name: r, path: /}]",
+		"both rates":    "name: x\nbase_url: http://x\nrps: 1\nduration: 1s\nstages: [{duration: 1s, target: 1}]\nrequests: [{name: r, path: /}]",
+		"relative url":  "name: x\nbase_url: localhost:8080\nrps: 1\nduration: 1s\nrequests: [{name: r, path: /}]",
+		"no requests":   "name: x\nbase_url: http://x\nrps: 1\nduration: 1s",
+		"duplicate":     "name: x\nbase_url: http://x\nrps: 1\nduration: 1s\nrequests: [{name: r, path: /}, {name: r, path: /}]",
+		"bad path":      "name: x\nbase_url: http://x\nrps: 1\nduration: 1s\nrequests: [{name: r, path: users}]",
+		"bad template":  "name: x\nbase_url: http://x\nrps: 1\nduration: 1s\nrequests: [{name: r, path: '/{{nope}}'}]",
+		"unknown field": "name: x\nbase_url: http://x\nrps: 1\nduration: 1s\nrequests: [{name: r, path: /, url: /}]",
+		"bad duration":  "name: x\nbase_url: http://x\nrps: 1\nduration: soon\nrequests: [{name: r, path: /}]",
+	}
+	for name, data := range invalid {
+		if _, err := parseScenario([]byte(data), name); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	// 所有错误一次性返回
+	_, err := parseScenario([]byte("base_url: x\nrequests: [{path: a}]"), "many.yaml")
+	for _, want := range []string{"name is required", "base_url", "rps and duration", "path must start with /"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("error %v does not mention %q", err, want)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultInterval = 30 * time.Second
+	defaultTimeout  = 10 * time.Second
+)
+
+// Config 探测配置：网关地址、公共请求头和若干多步骤检查
+type Config struct {
+	BaseURL string            `yaml:"base_url"`
+	Headers map[string]string `yaml:"headers"`
+	// Interval 两次检查之间的间隔，检查可以单独覆盖
+	Interval Duration `yaml:"interval"`
+	// Timeout 单个步骤的超时
+	Timeout Duration `yaml:"timeout"`
+	Checks  []Check  `yaml:"checks"`
+}
+
+// Check 一个按顺序执行的多步骤检查，某一步失败后后续步骤不再执行
+type Check struct {
+	Name     string   `yaml:"name"`
+	Interval Duration `yaml:"interval"`
+	Steps    []Step   `yaml:"steps"`
+}
+
+// Step 检查中的一次请求。Path、Headers、Body 和 Expect 的值是 text/template 模板，
+// 可以用 {{.run}} 引用本次检查的唯一标识，用 {{.name}} 引用前面步骤 Capture 的值
+type Step struct {
+	Name    string            `yaml:"name"`
+	Method  string            `yaml:"method"`
+	Path    string            `yaml:"path"`
+	Headers map[string]string `yaml:"headers"`
+	Body    string            `yaml:"body"`
+	// ExpectStatus 视为成功的状态码，为空时 2xx 为成功
+	ExpectStatus []int `yaml:"expect_status"`
+	// Expect 响应 JSON 字段（如 items.0.id）-> 期望值
+	Expect map[string]string `yaml:"expect"`
+	// Capture 变量名 -> 响应 JSON 字段，供后续步骤使用
+	Capture map[string]string `yaml:"capture"`
+
+	path    *template.Template
+	headers map[string]*template.Template
+	body    *template.Template
+	expect  map[string]*template.Template
+}
+
+// Duration 支持 "5s"、"500ms" 形式的时长
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	parsed, err := time.ParseDuration(value.Value)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", value.Value)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// envPattern 只替换 ${VAR} 和 ${VAR:-default}，模板中的 $变量 保持不变
+var envPattern = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// loadConfig 读取、校验并编译探测配置
+func loadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read probe config: %w", err)
+	}
+	return parseConfig(data, path)
+}
+
+func parseConfig(data []byte, source string) (*Config, error) {
+	expanded := envPattern.ReplaceAllFunc(data, func(m []byte) []byte {
+		return []byte(expandEnv(string(m[2 : len(m)-1])))
+	})
+
+	var cfg Config
+	dec := yaml.NewDecoder(bytes.NewReader(expanded))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("parse probe config %s: %w", source, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid probe config %s: %w", source, err)
+	}
+	return &cfg, nil
+}
+
+func expandEnv(name string) string {
+	key, def, hasDefault := strings.Cut(name, ":-")
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		return v
+	}
+	if hasDefault {
+		return def
+	}
+	return ""
+}
+
+// validate 检查配置中的全部错误并一次性返回，同时补全默认值并编译模板
+func (c *Config) validate() error {
+	var errs []error
+
+	if u, err := url.Parse(c.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("base_url %q must be an absolute http(s) URL", c.BaseURL))
+	}
+	c.BaseURL = strings.TrimSuffix(c.BaseURL, "/")
+	if c.Interval == 0 {
+		c.Interval = Duration(defaultInterval)
+	}
+	if c.Timeout == 0 {
+		c.Timeout = Duration(defaultTimeout)
+	}
+	if c.Interval < 0 || c.Timeout < 0 {
+		errs = append(errs, errors.New("interval and timeout must not be negative"))
+	}
+
+	if len(c.Checks) == 0 {
+		errs = append(errs, errors.New("no checks defined"))
+	}
+	checks := make(map[string]bool, len(c.Checks))
+	for i := range c.Checks {
+		ch := &c.Checks[i]
+		prefix := fmt.Sprintf("check[%d] %q", i, ch.Name)
+		if ch.Name == "" {
+			errs = append(errs, fmt.Errorf("%s: name is required", prefix))
+		} else if checks[ch.Name] {
+			errs = append(errs, fmt.Errorf("%s: duplicate name", prefix))
+		}
+		checks[ch.Name] = true
+		if ch.Interval == 0 {
+			ch.Interval = c.Interval
+		}
+		if ch.Interval < 0 {
+			errs = append(errs, fmt.Errorf("%s: interval must not be negative", prefix))
+		}
+		if len(ch.Steps) == 0 {
+			errs = append(errs, fmt.Errorf("%s: no steps defined", prefix))
+		}
+
+		// 只能引用前面步骤捕获的变量
+		known := map[string]bool{"run": true}
+		steps := make(map[string]bool, len(ch.Steps))
+		for j := range ch.Steps {
+			st := &ch.Steps[j]
+			sp := fmt.Sprintf("%s step[%d] %q", prefix, j, st.Name)
+			if st.Name == "" {
+				errs = append(errs, fmt.Errorf("%s: name is required", sp))
+			} else if steps[st.Name] {
+				errs = append(errs, fmt.Errorf("%s: duplicate name", sp))
+			}
+			steps[st.Name] = true
+			if st.Method == "" {
+				st.Method = http.MethodGet
+			}
+			st.Method = strings.ToUpper(st.Method)
+			if !strings.HasPrefix(st.Path, "/") {
+				errs = append(errs, fmt.Errorf("%s: path must start with /", sp))
+			}
+			if err := st.compile(); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", sp, err))
+			} else if missing := st.missingVars(known); len(missing) > 0 {
+				errs = append(errs, fmt.Errorf("%s: undefined variables %s", sp, strings.Join(missing, ", ")))
+			}
+			for name, field := range st.Capture {
+				if name == "run" || field == "" {
+					errs = append(errs, fmt.Errorf("%s: invalid capture %q", sp, name))
+				}
+				known[name] = true
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (st *Step) compile() error {
+	var err error
+	newTemplate := func(name, text string) *template.Template {
+		t, e := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+		if e != nil && err == nil {
+			err = e
+		}
+		return t
+	}
+
+	st.path = newTemplate("path", st.Path)
+	st.headers = make(map[string]*template.Template, len(st.Headers))
+	for name, text := range st.Headers {
+		st.headers[name] = newTemplate("headers."+name, text)
+	}
+	if st.Body != "" {
+		st.body = newTemplate("body", st.Body)
+	}
+	st.expect = make(map[string]*template.Template, len(st.Expect))
+	for field, text := range st.Expect {
+		st.expect[field] = newTemplate("expect."+field, text)
+	}
+	return err
+}
+
+// missingVars 用空值试渲染全部模板，返回 known 之外被引用的变量
+func (st *Step) missingVars(known map[string]bool) []string {
+	data := make(map[string]string, len(known))
+	for name := range known {
+		data[name] = "0"
+	}
+	var missing []string
+	check := func(t *template.Template) {
+		if t == nil {
+			return
+		}
+		if err := t.Execute(new(strings.Builder), data); err != nil {
+			if m := missingKeyPattern.FindStringSubmatch(err.Error()); m != nil {
+				missing = append(missing, m[1])
+			}
+		}
+	}
+	check(st.path)
+	check(st.body)
+	for _, t := range st.headers {
+		check(t)
+	}
+	for _, t := range st.expect {
+		check(t)
+	}
+	return missing
+}
+
+var missingKeyPattern = regexp.MustCompile(`map has no entry for key "([^"]+)"`)
+
+// renderedStep 渲染后的一次请求
+type renderedStep struct {
+	method  string
+	path    string
+	headers map[string]string
+	body    string
+	expect  map[string]string
+}
+
+func (st *Step) render(vars map[string]string) (renderedStep, error) {
+	out := renderedStep{
+		method:  st.Method,
+		headers: make(map[string]string, len(st.headers)),
+		expect:  make(map[string]string, len(st.expect)),
+	}
+	var err error
+	if out.path, err = execute(st.path, vars); err != nil {
+		return renderedStep{}, err
+	}
+	for name, t := range st.headers {
+		if out.headers[name], err = execute(t, vars); err != nil {
+			return renderedStep{}, err
+		}
+	}
+	if st.body != nil {
+		if out.body, err = execute(st.body, vars); err != nil {
+			return renderedStep{}, err
+		}
+	}
+	for field, t := range st.expect {
+		if out.expect[field], err = execute(t, vars); err != nil {
+			return renderedStep{}, err
+		}
+	}
+	return out, nil
+}
+
+func execute(t *template.Template, data map[string]string) (string, error) {
+	var buf strings.Builder
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// expects 判断状态码是否符合预期
+func (st *Step) expects(status int) bool {
+	if len(st.ExpectStatus) == 0 {
+		return status >= 200 && status < 300
+	}
+	for _, s := range st.ExpectStatus {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// templateFuncs 请求模板中可用的函数
+var templateFuncs = template.FuncMap{
+	"uuid": newID,
+	"now":  func() string { return time.Now().UTC().Format(time.RFC3339) },
+	"env":  os.Getenv,
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	t.Setenv("TEST_PROBE_TOKEN", "s3cret")
+	cfg, err := parseConfig([]byte(`
+base_url: ${TEST_GATEWAY_URL:-http://localhost:8080}/
+headers:
+  Authorization: Bearer ${TEST_PROBE_TOKEN}
+checks:
+  - name: checkout
+    interval: 5s
+    steps:
+      - name: create-user
+        method: post
+        path: /users
+        body: '{"name": "probe-{{.run}}"}'
+        capture: {user_id: id}
+      - name: get-user
+        path: /users/{{.user_id}}
+        expect: {id: '{{.user_id}}'}
+  - name: health
+    steps:
+      - {name: health, path: /health}
+`), "test.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.BaseURL != "http://localhost:8080" || cfg.Headers["Authorization"] != "Bearer s3cret" {
+		t.Errorf("base url %q, headers %v", cfg.BaseURL, cfg.Headers)
+	}
+	if cfg.Timeout != Duration(defaultTimeout) || cfg.Checks[0].Interval != Duration(5*time.Second) || cfg.Checks[1].Interval != Duration(defaultInterval) {
+		t.Errorf("defaults: timeout %v, intervals %v %v", cfg.Timeout, cfg.Checks[0].Interval, cfg.Checks[1].Interval)
+	}
+
+	step := &cfg.Checks[0].Steps[1]
+	rendered, err := step.render(map[string]string{"run": "r1", "user_id": "42"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rendered.method != "GET" || rendered.path != "/users/42" || rendered.expect["id"] != "42" {
+		t.Errorf("rendered %+v", rendered)
+	}
+	if !step.expects(204) || step.expects(404) {
+		t.Error("unexpected status matching")
+	}
+}
+
+func TestConfigValidation(t *testing.T) {
+	invalid := map[string]string{
+		"relative url":  "base_url: localhost\nchecks: [{name: c, steps: [{name: s, path: /}]}]",
+		"no checks":     "base_url: http://x",
+		"no steps":      "base_url: http://x\nchecks: [{name: c}]",
+		"duplicate":     "base_url: http://x\nchecks: [{name: c, steps: [{name: s, path: /}, {name: s, path: /}]}]",
+		"bad path":      "base_url: http://x\nchecks: [{name: c, steps: [{name: s, path: users}]}]",
+		"bad template":  "base_url: http://x\nchecks: [{name: c, steps: [{name: s, path: '/{{nope}}'}]}]",
+		"unknown field": "base_url: http://x\nchecks: [{name: c, steps: [{name: s, path: /, url: /}]}]",
+		"bad duration":  "base_url: http://x\ninterval: soon\nchecks: [{name: c, steps: [{name: s, path: /}]}]",
+		// 变量在捕获它的步骤之前被引用
+		"capture order": "base_url: http://x\nchecks: [{name: c, steps: [{name: a, path: '/{{.id}}'}, {name: b, path: /, capture: {id: id}}]}]",
+	}
+	for name, data := range invalid {
+		if _, err := parseConfig([]byte(data), name); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	// 所有错误一次性返回
+	_, err := parseConfig([]byte("base_url: x\nchecks: [{steps: [{name: s, path: a}]}]"), "many.yaml")
+	for _, want := range []string{"base_url", "name is required", "path must start with /"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("error %v does not mention %q", err, want)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// prober 定期通过 api-gateway 执行多步骤的合成检查（如创建用户、为其下单、再查询订单），
+// 导出每个步骤的 probe_success 和 probe_duration_seconds 指标。每次检查是一个 trace，
+// 失败时在日志、/status 和 probe_failures_total 的 exemplar 中记录其 trace ID。
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+func initTracer() (*sdktrace.TracerProvider, error) {
+	endpoint := os.Getenv("JAEGER_ENDPOINT")
+	if endpoint == "" {
+		endpoint = "tempo:4318"
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(),
+		otlptracehttp.WithInsecure(),
+		otlptracehttp.WithEndpoint(endpoint),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create exporter: %w", err)
+	}
+
+	res, err := resource.New(context.Background(),
+		resource.WithAttributes(
+			semconv.ServiceName("prober"),
+			semconv.ServiceVersion("1.0.0"),
+			attribute.String("environment", "development"),
+		),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create resource: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.AlwaysSample()),
+	)
+
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return tp, nil
+}
+
+func getLogWriter() io.Writer {
+	logFile, err := os.OpenFile("/app/logs/prober.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		log.Printf("Failed to open log file: %v", err)
+		return os.Stdout
+	}
+	return io.MultiWriter(os.Stdout, logFile)
+}
+
+func newMux(p *prober) *http.ServeMux {
+	mux := http.NewServeMux()
+	// exemplar 只在 OpenMetrics 格式中输出
+	mux.Handle("/metrics", promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}))
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "healthy", "service": "prober"})
+	})
+	// status 返回每个检查最近一次的结果，任一检查失败时返回 503
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		results := p.Results()
+		status := http.StatusOK
+		for _, res := range results {
+			if !res.Success {
+				status = http.StatusServiceUnavailable
+			}
+		}
+		writeJSON(w, status, map[string]any{"checks": results})
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func main() {
+	configPath := os.Getenv("PROBE_CONFIG")
+	if configPath == "" {
+		configPath = "probes.yaml"
+	}
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	tp, err := initTracer()
+	if err != nil {
+		log.Fatalf("Failed to initialize tracer: %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := tp.Shutdown(ctx); err != nil {
+			log.Printf("Error shutting down tracer provider: %v", err)
+		}
+	}()
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	p := newProber(cfg, getLogWriter())
+	srv := &http.Server{Addr: ":" + port, Handler: newMux(p)}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+	}()
+
+	log.Printf("Prober starting on port %s with %d checks against %s", port, len(cfg.Checks), cfg.BaseURL)
+	p.Run(ctx)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv.Shutdown(shutdownCtx)
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxBodyBytes 读取响应体的上限，超出部分不参与字段提取
+const maxBodyBytes = 1 << 20
+
+var (
+	probeSuccess = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "probe_success",
+			Help: "Whether the last run of a probe step succeeded (1) or not (0)",
+		},
+		[]string{"check", "step"},
+	)
+
+	probeDuration = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "probe_duration_seconds",
+			Help: "Duration of the last run of a probe step",
+		},
+		[]string{"check", "step"},
+	)
+
+	// probeFailures 带 TraceID exemplar，可以从 Grafana 直接跳到失败的那次探测
+	probeFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "probe_failures_total",
+			Help: "Total number of failed probe steps",
+		},
+		[]string{"check", "step"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(probeSuccess)
+	prometheus.MustRegister(probeDuration)
+	prometheus.MustRegister(probeFailures)
+}
+
+// CheckResult 一次检查的结果，失败时 TraceID 指向这次检查的 trace
+type CheckResult struct {
+	Check           string       `json:"check"`
+	Success         bool         `json:"success"`
+	StartedAt       time.Time    `json:"started_at"`
+	DurationSeconds float64      `json:"duration_seconds"`
+	TraceID         string       `json:"trace_id"`
+	FailedStep      string       `json:"failed_step,omitempty"`
+	Error           string       `json:"error,omitempty"`
+	Steps           []StepResult `json:"steps"`
+}
+
+// StepResult 一个步骤的结果，前面的步骤失败时 Skipped 为 true
+type StepResult struct {
+	Step            string  `json:"step"`
+	Success         bool    `json:"success"`
+	Skipped         bool    `json:"skipped,omitempty"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	Status          int     `json:"status,omitempty"`
+	Error           string  `json:"error,omitempty"`
+}
+
+type prober struct {
+	cfg    *Config
+	client *http.Client
+	logger *log.Logger
+
+	mu   sync.RWMutex
+	last map[string]*CheckResult
+}
+
+func newProber(cfg *Config, logOutput io.Writer) *prober {
+	return &prober{
+		cfg:    cfg,
+		client: &http.Client{Timeout: time.Duration(cfg.Timeout)},
+		logger: log.New(logOutput, "", 0),
+		last:   make(map[string]*CheckResult),
+	}
+}
+
+// Run 为每个检查启动一个循环，启动时立即执行一次，直到 ctx 结束
+func (p *prober) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := range p.cfg.Checks {
+		check := &p.cfg.Checks[i]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(time.Duration(check.Interval))
+			defer ticker.Stop()
+			for {
+				p.runCheck(ctx, check)
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// runCheck 在同一个 trace 中按顺序执行检查的全部步骤并记录结果
+func (p *prober) runCheck(ctx context.Context, check *Check) *CheckResult {
+	tracer := otel.Tracer("prober")
+	ctx, span := tracer.Start(ctx, "probe "+check.Name,
+		trace.WithNewRoot(),
+		trace.WithAttributes(attribute.String("probe.check", check.Name)),
+	)
+	defer span.End()
+
+	traceID := span.SpanContext().TraceID()
+	res := &CheckResult{
+		Check:     check.Name,
+		Success:   true,
+		StartedAt: time.Now().UTC(),
+		TraceID:   traceID.String(),
+	}
+	vars := map[string]string{"run": newID()[:12]}
+	span.SetAttributes(attribute.String("probe.run", vars["run"]))
+
+	for i := range check.Steps {
+		step := &check.Steps[i]
+		if !res.Success {
+			res.Steps = append(res.Steps, StepResult{Step: step.Name, Skipped: true})
+			probeSuccess.WithLabelValues(check.Name, step.Name).Set(0)
+			// 没有运行的步骤没有耗时，删除序列，避免留下上一次运行的值
+			probeDuration.DeleteLabelValues(check.Name, step.Name)
+			continue
+		}
+
+		sr := p.runStep(ctx, check, step, vars)
+		res.Steps = append(res.Steps, sr)
+		probeSuccess.WithLabelValues(check.Name, step.Name).Set(boolToFloat(sr.Success))
+		probeDuration.WithLabelValues(check.Name, step.Name).Set(sr.DurationSeconds)
+		if sr.Success {
+			continue
+		}
+
+		res.Success = false
+		res.FailedStep = step.Name
+		res.Error = sr.Error
+		failures := probeFailures.WithLabelValues(check.Name, step.Name)
+		if traceID.IsValid() {
+			failures.(prometheus.ExemplarAdder).AddWithExemplar(1, prometheus.Labels{"TraceID": traceID.String()})
+		} else {
+			failures.Inc()
+		}
+		span.SetStatus(codes.Error, fmt.Sprintf("step %s failed: %s", step.Name, sr.Error))
+		p.logFailure(res, sr)
+	}
+	res.DurationSeconds = time.Since(res.StartedAt).Seconds()
+	span.SetAttributes(attribute.Bool("probe.success", res.Success))
+
+	p.mu.Lock()
+	p.last[check.Name] = res
+	p.mu.Unlock()
+	return res
+}
+
+// runStep 在客户端 span 中发送一个步骤的请求，校验响应并把 Capture 的字段写入 vars
+func (p *prober) runStep(ctx context.Context, check *Check, step *Step, vars map[string]string) StepResult {
+	tracer := otel.Tracer("prober")
+	ctx, span := tracer.Start(ctx, step.Name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("probe.check", check.Name),
+			attribute.String("probe.step", step.Name),
+		),
+	)
+	defer span.End()
+
+	sr := StepResult{Step: step.Name}
+	start := time.Now()
+	fail := func(err error) StepResult {
+		sr.DurationSeconds = time.Since(start).Seconds()
+		sr.Error = err.Error()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return sr
+	}
+
+	rendered, err := step.render(vars)
+	if err != nil {
+		return fail(fmt.Errorf("render request: %w", err))
+	}
+	url := p.cfg.BaseURL + rendered.path
+	span.SetAttributes(semconv.HTTPMethod(rendered.method), semconv.HTTPURL(url))
+
+	var body io.Reader
+	if rendered.body != "" {
+		body = strings.NewReader(rendered.body)
+	}
+	req, err := http.NewRequestWithContext(ctx, rendered.method, url, body)
+	if err != nil {
+		return fail(err)
+	}
+	for k, v := range p.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	for k, v := range rendered.headers {
+		req.Header.Set(k, v)
+	}
+	if rendered.body != "" && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fail(err)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBodyBytes))
+	resp.Body.Close()
+	sr.Status = resp.StatusCode
+	span.SetAttributes(semconv.HTTPStatusCode(resp.StatusCode))
+	if err != nil {
+		return fail(fmt.Errorf("read response: %w", err))
+	}
+	if !step.expects(resp.StatusCode) {
+		return fail(fmt.Errorf("unexpected status %d: %s", resp.StatusCode, truncate(string(data), 200)))
+	}
+
+	if len(rendered.expect) > 0 || len(step.Capture) > 0 {
+		var doc any
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if err := dec.Decode(&doc); err != nil {
+			return fail(fmt.Errorf("decode response: %w", err))
+		}
+		for _, field := range sortedKeys(rendered.expect) {
+			got, ok := lookup(doc, field)
+			if !ok {
+				return fail(fmt.Errorf("response has no field %q", field))
+			}
+			if want := rendered.expect[field]; got != want {
+				return fail(fmt.Errorf("field %q is %q, want %q", field, got, want))
+			}
+		}
+		for _, name := range sortedKeys(step.Capture) {
+			v, ok := lookup(doc, step.Capture[name])
+			if !ok {
+				return fail(fmt.Errorf("response has no field %q", step.Capture[name]))
+			}
+			vars[name] = v
+		}
+	}
+
+	sr.Success = true
+	sr.DurationSeconds = time.Since(start).Seconds()
+	return sr
+}
+
+// logFailure 以 JSON 格式记录失败，trace_id 供 Loki 关联到 Tempo
+func (p *prober) logFailure(res *CheckResult, sr StepResult) {
+	line, _ := json.Marshal(map[string]any{
+		"time":     time.Now().Format(time.RFC3339),
+		"service":  "prober",
+		"level":    "error",
+		"msg":      "probe failed",
+		"check":    res.Check,
+		"step":     sr.Step,
+		"status":   sr.Status,
+		"error":    sr.Error,
+		"trace_id": res.TraceID,
+	})
+	p.logger.Println(string(line))
+}
+
+// Results 每个检查最近一次的结果，按名称排序
+func (p *prober) Results() []*CheckResult {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	results := make([]*CheckResult, 0, len(p.last))
+	for _, res := range p.last {
+		results = append(results, res)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Check < results[j].Check })
+	return results
+}
+
+// lookup 按 a.b.0.c 形式的路径取 JSON 字段，返回其字符串形式
+func lookup(doc any, path string) (string, bool) {
+	cur := doc
+	for _, key := range strings.Split(path, ".") {
+		switch v := cur.(type) {
+		case map[string]any:
+			next, ok := v[key]
+			if !ok {
+				return "", false
+			}
+			cur = next
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return "", false
+			}
+			cur = v[i]
+		default:
+			return "", false
+		}
+	}
+	switch v := cur.(type) {
+	case nil:
+		return "null", true
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	data, err := json.Marshal(cur)
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func truncate(s string, n int) string {
+	s = strings.TrimSpace(s)
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const testProbes = `
+base_url: %s
+headers: {Authorization: Bearer t}
+checks:
+  - name: checkout
+    steps:
+      - name: create-user
+        method: POST
+        path: /api/v1/users
+        body: '{"name": "probe-{{.run}}"}'
+        expect_status: [201]
+        capture: {user_id: id}
+      - name: create-order
+        method: POST
+        path: /api/v1/orders
+        body: '{"user_id": {{.user_id}}}'
+        expect_status: [201]
+        capture: {order_id: id}
+      - name: get-order
+        path: /api/v1/orders/{{.order_id}}
+        expect: {user_id: '{{.user_id}}'}
+`
+
+// fakeGateway 模拟网关的三个接口，failOrders 为 true 时创建订单返回 503
+type fakeGateway struct {
+	mu          sync.Mutex
+	failOrders  bool
+	traceIDs    map[string]bool
+	orderBodies []string
+}
+
+func (g *fakeGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	sc := trace.SpanContextFromContext(propagation.TraceContext{}.Extract(context.Background(), propagation.HeaderCarrier(r.Header)))
+	g.traceIDs[sc.TraceID().String()] = true
+	if r.Header.Get("Authorization") != "Bearer t" {
+		w.WriteHeader(401)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.Method == "POST" && r.URL.Path == "/api/v1/users":
+		w.WriteHeader(201)
+		io.WriteString(w, `{"id": 7, "name": "probe"}`)
+	case r.Method == "POST" && r.URL.Path == "/api/v1/orders":
+		body, _ := io.ReadAll(r.Body)
+		g.orderBodies = append(g.orderBodies, string(body))
+		if g.failOrders {
+			w.WriteHeader(503)
+			io.WriteString(w, `{"error": "injected fault"}`)
+			return
+		}
+		w.WriteHeader(201)
+		io.WriteString(w, `{"id": 100, "user_id": 7}`)
+	case r.Method == "GET" && r.URL.Path == "/api/v1/orders/100":
+		io.WriteString(w, `{"id": 100, "user_id": 7, "status": "created"}`)
+	default:
+		w.WriteHeader(404)
+	}
+}
+
+func newTestProber(t *testing.T) (*prober, *fakeGateway, *tracetest.SpanRecorder, *bytes.Buffer) {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	gw := &fakeGateway{traceIDs: make(map[string]bool)}
+	srv := httptest.NewServer(gw)
+	t.Cleanup(srv.Close)
+
+	cfg, err := parseConfig([]byte(strings.Replace(testProbes, "%s", srv.URL, 1)), "test.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logs bytes.Buffer
+	return newProber(cfg, &logs), gw, recorder, &logs
+}
+
+func TestRunCheckSuccess(t *testing.T) {
+	p, gw, recorder, logs := newTestProber(t)
+	res := p.runCheck(context.Background(), &p.cfg.Checks[0])
+
+	if !res.Success || len(res.Steps) != 3 || res.FailedStep != "" {
+		t.Fatalf("result %+v", res)
+	}
+	for _, step := range []string{"create-user", "create-order", "get-order"} {
+		if got := testutil.ToFloat64(probeSuccess.WithLabelValues("checkout", step)); got != 1 {
+			t.Errorf("probe_success{step=%q} = %v", step, got)
+		}
+		if got := testutil.ToFloat64(probeDuration.WithLabelValues("checkout", step)); got <= 0 {
+			t.Errorf("probe_duration_seconds{step=%q} = %v", step, got)
+		}
+	}
+	// 捕获的用户 ID 用在了下一步的请求体中
+	if len(gw.orderBodies) != 1 || gw.orderBodies[0] != `{"user_id": 7}` {
+		t.Errorf("order bodies %q", gw.orderBodies)
+	}
+	// 三个步骤属于同一个 trace，并传给了网关
+	if len(gw.traceIDs) != 1 || !gw.traceIDs[res.TraceID] {
+		t.Errorf("gateway saw traces %v, probe trace %s", gw.traceIDs, res.TraceID)
+	}
+	if spans := recorder.Ended(); len(spans) != 4 || spans[3].Name() != "probe checkout" {
+		t.Errorf("%d spans", len(spans))
+	}
+	if logs.Len() != 0 {
+		t.Errorf("unexpected logs: %s", logs.String())
+	}
+}
+
+func TestRunCheckFailureCarriesTraceID(t *testing.T) {
+	p, gw, _, logs := newTestProber(t)
+	// 先成功运行一次，跳过的步骤留下过耗时
+	if res := p.runCheck(context.Background(), &p.cfg.Checks[0]); !res.Success {
+		t.Fatalf("first run %+v", res)
+	}
+	gw.failOrders = true
+	before := testutil.ToFloat64(probeFailures.WithLabelValues("checkout", "create-order"))
+
+	res := p.runCheck(context.Background(), &p.cfg.Checks[0])
+	if res.Success || res.FailedStep != "create-order" || !strings.Contains(res.Error, "unexpected status 503") {
+		t.Fatalf("result %+v", res)
+	}
+	if !res.Steps[0].Success || res.Steps[1].Status != 503 || !res.Steps[2].Skipped {
+		t.Errorf("steps %+v", res.Steps)
+	}
+	if got := testutil.ToFloat64(probeSuccess.WithLabelValues("checkout", "create-order")); got != 0 {
+		t.Errorf("probe_success = %v", got)
+	}
+	if got := testutil.ToFloat64(probeSuccess.WithLabelValues("checkout", "get-order")); got != 0 {
+		t.Errorf("skipped step probe_success = %v", got)
+	}
+	// 跳过的步骤不保留上一次运行的耗时
+	if probeDuration.DeleteLabelValues("checkout", "get-order") {
+		t.Error("skipped step kept a stale probe_duration_seconds")
+	}
+
+	// 失败计数带有这次检查的 trace ID
+	var m dto.Metric
+	probeFailures.WithLabelValues("checkout", "create-order").(prometheus.Metric).Write(&m)
+	if m.Counter.GetValue() != before+1 {
+		t.Errorf("probe_failures_total = %v, want %v", m.Counter.GetValue(), before+1)
+	}
+	if ex := m.Counter.Exemplar; ex == nil || len(ex.Label) != 1 || ex.Label[0].GetName() != "TraceID" || ex.Label[0].GetValue() != res.TraceID {
+		t.Errorf("exemplar %v, want trace %s", ex, res.TraceID)
+	}
+
+	var entry map[string]any
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatalf("log %q: %v", logs.String(), err)
+	}
+	if entry["trace_id"] != res.TraceID || entry["step"] != "create-order" || entry["status"] != float64(503) {
+		t.Errorf("log entry %v", entry)
+	}
+}
+
+func TestStatusEndpoint(t *testing.T) {
+	p, gw, _, _ := newTestProber(t)
+	mux := newMux(p)
+	status := func() (int, map[string][]CheckResult) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "/status", nil))
+		var body map[string][]CheckResult
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return w.Code, body
+	}
+
+	p.runCheck(context.Background(), &p.cfg.Checks[0])
+	if code, body := status(); code != 200 || len(body["checks"]) != 1 || !body["checks"][0].Success {
+		t.Errorf("healthy status %d %+v", code, body)
+	}
+
+	gw.failOrders = true
+	res := p.runCheck(context.Background(), &p.cfg.Checks[0])
+	if code, body := status(); code != 503 || body["checks"][0].TraceID != res.TraceID || body["checks"][0].FailedStep != "create-order" {
+		t.Errorf("failing status %d %+v", code, body)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	var doc any
+	dec := json.NewDecoder(strings.NewReader(`{"id": 12, "amount": 9.5, "ok": true, "user": {"name": "a"}, "items": [{"id": "x"}], "none": null}`))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]string{
+		"id":         "12",
+		"amount":     "9.5",
+		"ok":         "true",
+		"user.name":  "a",
+		"items.0.id": "x",
+		"none":       "null",
+		"user":       `{"name":"a"}`,
+	}
+	for path, want := range cases {
+		if got, ok := lookup(doc, path); !ok || got != want {
+			t.Errorf("lookup(%q) = %q, %v; want %q", path, got, ok, want)
+		}
+	}
+	for _, path := range []string{"missing", "items.1.id", "items.x", "id.nested"} {
+		if _, ok := lookup(doc, path); ok {
+			t.Errorf("lookup(%q) should fail", path)
+		}
+	}
+}
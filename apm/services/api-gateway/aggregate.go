@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// overviewOrderLimit 概览中返回的最近订单数
+const overviewOrderLimit = 10
+
+// overviewSection 聚合结果中的一个分区
+type overviewSection struct {
+	name  string
+	fetch func() (interface{}, error)
+	data  interface{}
+	err   error
+}
+
+// userOverview 并发调用用户服务和订单服务，合并用户信息和订单列表；
+// 某个上游失败时返回其余数据，并在 errors 中标记失败的分区
+func (g *Gateway) userOverview(c *gin.Context) {
+	if !g.auth.authorize(c) {
+		return
+	}
+
+	userID := c.Param("id")
+	table := g.table.Load()
+	key := balanceKey(c)
+
+	// 整个聚合请求共享一个截止时间
+	ctx, cancel := context.WithTimeout(c.Request.Context(), g.overviewTimeout)
+	defer cancel()
+
+	tracer := otel.Tracer("api-gateway")
+	ctx, span := tracer.Start(ctx, "aggregate-user-overview")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("user.id", userID),
+		attribute.Int64("aggregate.deadline_ms", g.overviewTimeout.Milliseconds()),
+	)
+
+	sections := []*overviewSection{
+		{
+			name: "user",
+			fetch: func() (interface{}, error) {
+				return table.call(ctx, table.overview.UserUpstream, "/users/"+url.PathEscape(userID), key)
+			},
+		},
+		{
+			name: "orders",
+			fetch: func() (interface{}, error) {
+				// 由订单服务按用户过滤，只取最近的一页
+				query := url.Values{"user_id": {userID}, "sort": {"-created_at"}, "limit": {strconv.Itoa(overviewOrderLimit)}}
+				result, err := table.call(ctx, table.overview.OrderUpstream, "/orders?"+query.Encode(), key)
+				if err != nil {
+					return nil, err
+				}
+				return result["orders"], nil
+			},
+		},
+	}
+
+	var wg sync.WaitGroup
+	for _, sec := range sections {
+		wg.Add(1)
+		go func(sec *overviewSection) {
+			defer wg.Done()
+			sec.data, sec.err = sec.fetch()
+		}(sec)
+	}
+	wg.Wait()
+
+	response := gin.H{}
+	sectionErrors := gin.H{}
+	for _, sec := range sections {
+		if sec.err != nil {
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				sec.err = fmt.Errorf("deadline exceeded: %w", sec.err)
+			}
+			sectionErrors[sec.name] = sec.err.Error()
+			response[sec.name] = nil
+			span.AddEvent("section.failed", trace.WithAttributes(
+				attribute.String("section", sec.name),
+				attribute.String("error", sec.err.Error()),
+			))
+			continue
+		}
+		response[sec.name] = sec.data
+	}
+
+	partial := len(sectionErrors) > 0
+	response["partial"] = partial
+	if partial {
+		response["errors"] = sectionErrors
+	}
+	span.SetAttributes(
+		attribute.Bool("aggregate.partial", partial),
+		attribute.Int("aggregate.failed_sections", len(sectionErrors)),
+	)
+
+	if len(sectionErrors) == len(sections) {
+		span.SetStatus(codes.Error, "all sections failed")
+		status := 502
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			status = 504
+		}
+		c.JSON(status, response)
+		return
+	}
+	c.JSON(200, response)
+}
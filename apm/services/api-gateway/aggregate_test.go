@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// stubUpstream 健康检查总是成功，其他请求等待 delay 后返回 status 和 body
+func stubUpstream(t *testing.T, status int, delay time.Duration, body string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			return
+		}
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// newTestGateway 以 upstreams（名称到地址）生成路由配置并加载，不启用认证
+func newTestGateway(t *testing.T, upstreams map[string]string) *Gateway {
+	t.Helper()
+	var b strings.Builder
+	b.WriteString("upstreams:\n")
+	for name, u := range upstreams {
+		b.WriteString("  " + name + ":\n    url: " + u + "\n")
+	}
+	b.WriteString("routes:\n  - {name: users, path: /api/v1/users/:id, upstream: user-service, rewrite: /users/:id}\n")
+	path := filepath.Join(t.TempDir(), "routes.yaml")
+	writeConfig(t, path, b.String())
+
+	g, err := newGateway(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		for _, p := range g.table.Load().pools {
+			p.stop()
+		}
+	})
+	return g
+}
+
+func TestUserOverview(t *testing.T) {
+	const (
+		user   = `{"id": 1, "name": "张三"}`
+		orders = `{"orders": [{"id": 7, "user_id": 1}], "total": 1}`
+		fast   = time.Duration(0)
+		slow   = 500 * time.Millisecond
+	)
+	type upstream struct {
+		status int
+		delay  time.Duration
+		body   string
+	}
+	tests := []struct {
+		name           string
+		user, orders   upstream
+		status         int
+		partial        bool
+		failedSections map[string]string
+	}{
+		{"both succeed", upstream{200, fast, user}, upstream{200, fast, orders}, 200, false, nil},
+		{"orders fail", upstream{200, fast, user}, upstream{500, fast, `{"error": "db down"}`}, 200, true,
+			map[string]string{"orders": "500"}},
+		{"user not found", upstream{404, fast, `{"error": "User not found"}`}, upstream{200, fast, orders}, 200, true,
+			map[string]string{"user": "404"}},
+		{"orders time out", upstream{200, fast, user}, upstream{200, slow, orders}, 200, true,
+			map[string]string{"orders": "deadline exceeded"}},
+		{"both fail", upstream{503, fast, `{}`}, upstream{500, fast, `{}`}, 502, true,
+			map[string]string{"user": "503", "orders": "500"}},
+		{"both time out", upstream{200, slow, user}, upstream{200, slow, orders}, 504, true,
+			map[string]string{"user": "deadline exceeded", "orders": "deadline exceeded"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestGateway(t, map[string]string{
+				"user-service":  stubUpstream(t, tt.user.status, tt.user.delay, tt.user.body).URL,
+				"order-service": stubUpstream(t, tt.orders.status, tt.orders.delay, tt.orders.body).URL,
+			})
+			g.overviewTimeout = 100 * time.Millisecond
+
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.GET("/api/v1/users/:id/overview", g.userOverview)
+			w := httptest.NewRecorder()
+			start := time.Now()
+			r.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/users/1/overview", nil))
+			// 慢的上游不会拖住整个请求
+			if elapsed := time.Since(start); elapsed > slow {
+				t.Errorf("overview took %v", elapsed)
+			}
+
+			var resp struct {
+				User    map[string]interface{}   `json:"user"`
+				Orders  []map[string]interface{} `json:"orders"`
+				Partial bool                     `json:"partial"`
+				Errors  map[string]string        `json:"errors"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("%d %s: %v", w.Code, w.Body, err)
+			}
+			if w.Code != tt.status || resp.Partial != tt.partial || len(resp.Errors) != len(tt.failedSections) {
+				t.Fatalf("%d %s, want %d partial=%v", w.Code, w.Body, tt.status, tt.partial)
+			}
+			for section, want := range tt.failedSections {
+				if !strings.Contains(resp.Errors[section], want) {
+					t.Errorf("%s error %q, want %q", section, resp.Errors[section], want)
+				}
+			}
+			// 成功的分区保留数据，失败的分区为 null
+			if _, failed := tt.failedSections["user"]; failed != (resp.User == nil) {
+				t.Errorf("user %v", resp.User)
+			}
+			if _, failed := tt.failedSections["orders"]; failed != (resp.Orders == nil) {
+				t.Errorf("orders %v", resp.Orders)
+			}
+		})
+	}
+}
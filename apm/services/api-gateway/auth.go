@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/xyzbit/devops-demo/pkg/identity"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// identityHeader 网关签发给下游服务的身份头，由下游服务的 ginidentity 中间件校验
+	identityHeader = identity.Header
+	// subjectKey 保存在 gin.Context 中的认证主体，供访问日志使用
+	subjectKey = "auth.subject"
+)
+
+var authRequests = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "auth_requests_total",
+		Help: "Total number of JWT authentication attempts",
+	},
+	[]string{"result"},
+)
+
+func init() {
+	prometheus.MustRegister(authRequests)
+}
+
+// Identity 经过认证的调用方身份
+type Identity struct {
+	Subject  string `json:"sub"`
+	IssuedAt int64  `json:"iat"`
+	// signed 转发给下游的签名身份头
+	signed string
+}
+
+type identityContextKey struct{}
+
+func withIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityContextKey{}, id)
+}
+
+func identityFromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityContextKey{}).(*Identity)
+	return id, ok
+}
+
+// jwk JWKS 文件中的单个密钥，支持对称密钥 (oct) 和 RSA 公钥
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	K   string `json:"k"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// keySet 解析后的密钥集合，按 kid 索引
+type keySet struct {
+	keys    map[string]interface{}
+	algs    map[string]string
+	modTime time.Time
+}
+
+// Authenticator 基于本地 JWKS 文件校验 JWT，文件变化时自动加载新密钥以支持密钥轮换
+type Authenticator struct {
+	jwksFile    string
+	issuer      string
+	audience    string
+	signingKey  []byte
+	keys        atomic.Pointer[keySet]
+	parserOpts  []jwt.ParserOption
+	refreshTick time.Duration
+}
+
+func newAuthenticatorFromEnv() (*Authenticator, error) {
+	jwksFile := os.Getenv("JWT_JWKS_FILE")
+	if jwksFile == "" {
+		return nil, nil
+	}
+
+	signingKey := os.Getenv("IDENTITY_SIGNING_KEY")
+	if signingKey == "" {
+		return nil, errors.New("IDENTITY_SIGNING_KEY is required when JWT_JWKS_FILE is set")
+	}
+
+	refresh := 30 * time.Second
+	if v := os.Getenv("JWT_JWKS_REFRESH_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWT_JWKS_REFRESH_INTERVAL %q: %w", v, err)
+		}
+		refresh = d
+	}
+
+	a := &Authenticator{
+		jwksFile:    jwksFile,
+		issuer:      os.Getenv("JWT_ISSUER"),
+		audience:    os.Getenv("JWT_AUDIENCE"),
+		signingKey:  []byte(signingKey),
+		refreshTick: refresh,
+	}
+
+	a.parserOpts = []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"HS256", "RS256"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(30 * time.Second),
+	}
+	if a.issuer != "" {
+		a.parserOpts = append(a.parserOpts, jwt.WithIssuer(a.issuer))
+	}
+	if a.audience != "" {
+		a.parserOpts = append(a.parserOpts, jwt.WithAudience(a.audience))
+	}
+
+	if err := a.reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// watch 定期检查 JWKS 文件的修改时间，变化后重新加载
+func (a *Authenticator) watch(ctx context.Context) {
+	ticker := time.NewTicker(a.refreshTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(a.jwksFile)
+			if err != nil {
+				log.Printf("Failed to stat JWKS file: %v", err)
+				continue
+			}
+			if info.ModTime().Equal(a.keys.Load().modTime) {
+				continue
+			}
+			if err := a.reload(); err != nil {
+				// 保留旧密钥，避免错误的文件导致全部请求被拒绝
+				log.Printf("Failed to reload JWKS, keeping previous keys: %v", err)
+				continue
+			}
+			log.Printf("JWKS reloaded from %s", a.jwksFile)
+		}
+	}
+}
+
+func (a *Authenticator) reload() error {
+	info, err := os.Stat(a.jwksFile)
+	if err != nil {
+		return fmt.Errorf("failed to stat JWKS file: %w", err)
+	}
+	data, err := os.ReadFile(a.jwksFile)
+	if err != nil {
+		return fmt.Errorf("failed to read JWKS file: %w", err)
+	}
+
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("failed to parse JWKS file: %w", err)
+	}
+	if len(doc.Keys) == 0 {
+		return errors.New("JWKS file contains no keys")
+	}
+
+	ks := &keySet{
+		keys:    make(map[string]interface{}, len(doc.Keys)),
+		algs:    make(map[string]string, len(doc.Keys)),
+		modTime: info.ModTime(),
+	}
+	for _, k := range doc.Keys {
+		if k.Kid == "" {
+			return errors.New("JWKS key without kid")
+		}
+		key, alg, err := parseJWK(k)
+		if err != nil {
+			return fmt.Errorf("invalid key %q: %w", k.Kid, err)
+		}
+		ks.keys[k.Kid] = key
+		ks.algs[k.Kid] = alg
+	}
+
+	a.keys.Store(ks)
+	return nil
+}
+
+func parseJWK(k jwk) (interface{}, string, error) {
+	switch k.Kty {
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(k.K)
+		if err != nil {
+			return nil, "", fmt.Errorf("decode k: %w", err)
+		}
+		if len(secret) < 32 {
+			return nil, "", errors.New("HS256 secret must be at least 32 bytes")
+		}
+		return secret, "HS256", nil
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, "", fmt.Errorf("decode n: %w", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, "", fmt.Errorf("decode e: %w", err)
+		}
+		pub := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+		return pub, "RS256", nil
+	default:
+		return nil, "", fmt.Errorf("unsupported kty %q", k.Kty)
+	}
+}
+
+// keyFunc 根据 token 头部的 kid 选择密钥，并确认算法与密钥类型一致
+func (a *Authenticator) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, errors.New("missing kid")
+	}
+	ks := a.keys.Load()
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+	if token.Method.Alg() != ks.algs[kid] {
+		return nil, fmt.Errorf("alg %s does not match key %q", token.Method.Alg(), kid)
+	}
+	return key, nil
+}
+
+func (a *Authenticator) authenticate(raw string) (*Identity, error) {
+	claims := &jwt.RegisteredClaims{}
+	if _, err := jwt.ParseWithClaims(raw, claims, a.keyFunc, a.parserOpts...); err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("token has no subject")
+	}
+
+	id := &Identity{Subject: claims.Subject, IssuedAt: time.Now().Unix()}
+	id.signed = identity.Sign(a.signingKey, identity.Identity{Subject: id.Subject, IssuedAt: id.IssuedAt})
+	return id, nil
+}
+
+// authorize 校验 Authorization: Bearer 令牌，通过后把身份写入请求上下文；
+// 返回 false 时已经写回 401 响应。未配置认证时直接放行。
+func (a *Authenticator) authorize(c *gin.Context) bool {
+	if a == nil {
+		return true
+	}
+
+	authz := c.GetHeader("Authorization")
+	raw, found := strings.CutPrefix(authz, "Bearer ")
+	if !found || raw == "" {
+		authRequests.WithLabelValues("missing").Inc()
+		c.Header("WWW-Authenticate", `Bearer realm="api-gateway"`)
+		c.AbortWithStatusJSON(401, gin.H{"error": "missing bearer token"})
+		return false
+	}
+
+	id, err := a.authenticate(raw)
+	if err != nil {
+		authRequests.WithLabelValues("invalid").Inc()
+		span := trace.SpanFromContext(c.Request.Context())
+		span.AddEvent("auth.rejected")
+		// 具体原因只写日志，不返回给客户端
+		log.Printf("Rejected bearer token for %s %s: %v", c.Request.Method, c.Request.URL.Path, err)
+		c.Header("WWW-Authenticate", `Bearer realm="api-gateway", error="invalid_token"`)
+		c.AbortWithStatusJSON(401, gin.H{"error": "invalid token"})
+		return false
+	}
+
+	authRequests.WithLabelValues("success").Inc()
+	trace.SpanFromContext(c.Request.Context()).SetAttributes(semconv.EnduserID(id.Subject))
+	c.Set(subjectKey, id.Subject)
+	c.Request = c.Request.WithContext(withIdentity(c.Request.Context(), id))
+	return true
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/xyzbit/devops-demo/pkg/identity"
+)
+
+const testSigningKey = "identity-signing-key"
+
+var (
+	testHMACKey = []byte(strings.Repeat("h", 32))
+	testNewKey  = []byte(strings.Repeat("n", 32))
+)
+
+func octJWK(kid string, secret []byte) jwk {
+	return jwk{Kty: "oct", Kid: kid, K: base64.RawURLEncoding.EncodeToString(secret)}
+}
+
+func rsaJWK(kid string, pub *rsa.PublicKey) jwk {
+	return jwk{Kty: "RSA", Kid: kid,
+		N: base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())}
+}
+
+// writeJWKS 写入 JWKS 文件，并把修改时间设为 modTime，让 watch 能发现变化
+func writeJWKS(t *testing.T, path string, modTime time.Time, keys ...jwk) {
+	t.Helper()
+	data, err := json.Marshal(map[string][]jwk{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestAuthenticator 使用包含 HS256 密钥 hs 和 RS256 公钥 rs 的 JWKS 文件
+func newTestAuthenticator(t *testing.T, rsaKey *rsa.PrivateKey) (*Authenticator, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, time.Now().Add(-time.Hour), octJWK("hs", testHMACKey), rsaJWK("rs", &rsaKey.PublicKey))
+	t.Setenv("JWT_JWKS_FILE", path)
+	t.Setenv("IDENTITY_SIGNING_KEY", testSigningKey)
+	t.Setenv("JWT_ISSUER", "https://auth.example.com")
+	t.Setenv("JWT_AUDIENCE", "apm")
+	t.Setenv("JWT_JWKS_REFRESH_INTERVAL", "5ms")
+
+	a, err := newAuthenticatorFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a, path
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub": "user-1",
+		"iss": "https://auth.example.com",
+		"aud": "apm",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, claims jwt.MapClaims, key interface{}) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	raw, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestAuthenticate(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := newTestAuthenticator(t, rsaKey)
+
+	with := func(key string, value interface{}) jwt.MapClaims {
+		c := validClaims()
+		if value == nil {
+			delete(c, key)
+		} else {
+			c[key] = value
+		}
+		return c
+	}
+	tests := []struct {
+		name  string
+		token func(t *testing.T) string
+		// errContains 为空时应通过认证
+		errContains string
+	}{
+		{"valid HS256", func(t *testing.T) string {
+			return signToken(t, jwt.SigningMethodHS256, "hs", validClaims(), testHMACKey)
+		}, ""},
+		{"valid RS256", func(t *testing.T) string {
+			return signToken(t, jwt.SigningMethodRS256, "rs", validClaims(), rsaKey)
+		}, ""},
+		{"expired within leeway", func(t *testing.T) string {
+			return signToken(t, jwt.SigningMethodHS256, "hs", with("exp", time.Now().Add(-10*time.Second).Unix()), testHMACKey)
+		}, ""},
+		{"expired", func(t *testing.T) string {
+			return signToken(t, jwt.SigningMethodHS256, "hs", with("exp", time.Now().Add(-time.Minute).Unix()), testHMACKey)
+		}, "expired"},
+		{"missing exp", func(t *testing.T) string {
+			return signToken(t, jwt.SigningMethodHS256, "hs", with("exp", nil), testHMACKey)
+		}, "exp"},
+		{"missing kid", func(t *testing.T) string {
+			return signToken(t, jwt.SigningMethodHS256, "", validClaims(), testHMACKey)
+		}, "missing kid"},
+		{"unknown kid", func(t *testing.T) string {
+			return signToken(t, jwt.SigningMethodHS256, "other", validClaims(), testHMACKey)
+		}, `unknown kid "other"`},
+		// 用 RSA 公钥的字节作为 HMAC 密钥伪造的令牌
+		{"HS256 with RSA kid", func(t *testing.T) string {
+			return signToken(t, jwt.SigningMethodHS256, "rs", validClaims(), rsaKey.PublicKey.N.Bytes())
+		}, `alg HS256 does not match key "rs"`},
+		{"RS256 with HMAC kid", func(t *testing.T) string {
+			return signToken(t, jwt.SigningMethodRS256, "hs", validClaims(), rsaKey)
+		}, `alg RS256 does not match key "hs"`},
+		{"HS384 not allowed", func(t *testing.T) string {
+			return signToken(t, jwt.SigningMethodHS384, "hs", validClaims(), testHMACKey)
+		}, "signing method HS384 is invalid"},
+		{"alg none", func(t *testing.T) string {
+			return signToken(t, jwt.SigningMethodNone, "hs", validClaims(), jwt.UnsafeAllowNoneSignatureType)
+		}, "signing method none is invalid"},
+		{"wrong secret", func(t *testing.T) string {
+			return signToken(t, jwt.SigningMethodHS256, "hs", validClaims(), testNewKey)
+		}, "signature is invalid"},
+		{"wrong issuer", func(t *testing.T) string {
+			return signToken(t, jwt.SigningMethodHS256, "hs", with("iss", "https://evil.example.com"), testHMACKey)
+		}, "issuer"},
+		{"wrong audience", func(t *testing.T) string {
+			return signToken(t, jwt.SigningMethodHS256, "hs", with("aud", "other"), testHMACKey)
+		}, "audience"},
+		{"no subject", func(t *testing.T) string {
+			return signToken(t, jwt.SigningMethodHS256, "hs", with("sub", nil), testHMACKey)
+		}, "no subject"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := a.authenticate(tt.token(t))
+			if tt.errContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+					t.Fatalf("got %v, want error containing %q", err, tt.errContains)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			// 下游服务能用共享密钥校验转发的身份头
+			got, err := identity.Verify([]byte(testSigningKey), id.signed, time.Now(), time.Minute)
+			if err != nil || id.Subject != "user-1" || got.Subject != "user-1" {
+				t.Errorf("identity %+v, signed %+v: %v", id, got, err)
+			}
+		})
+	}
+}
+
+func TestAuthorizeHidesTokenErrors(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := newTestAuthenticator(t, rsaKey)
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name   string
+		authz  string
+		status int
+		body   string
+	}{
+		{"missing", "", 401, "missing bearer token"},
+		{"not bearer", "Basic dXNlcjpwYXNz", 401, "missing bearer token"},
+		{"expired", "Bearer " + signToken(t, jwt.SigningMethodHS256, "hs", jwt.MapClaims{"sub": "user-1", "exp": time.Now().Add(-time.Hour).Unix()}, testHMACKey), 401, "invalid token"},
+		{"valid", "Bearer " + signToken(t, jwt.SigningMethodHS256, "hs", validClaims(), testHMACKey), 200, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("GET", "/api/orders", nil)
+			if tt.authz != "" {
+				c.Request.Header.Set("Authorization", tt.authz)
+			}
+			ok := a.authorize(c)
+			if ok != (tt.status == 200) {
+				t.Fatalf("authorize = %v, status %d", ok, w.Code)
+			}
+			if !ok {
+				var resp map[string]string
+				json.Unmarshal(w.Body.Bytes(), &resp)
+				if w.Code != tt.status || resp["error"] != tt.body {
+					t.Errorf("%d %s, want %d %q", w.Code, w.Body, tt.status, tt.body)
+				}
+				return
+			}
+			if id, found := identityFromContext(c.Request.Context()); !found || id.Subject != "user-1" {
+				t.Errorf("identity %+v", id)
+			}
+		})
+	}
+}
+
+func TestJWKSReload(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, path := newTestAuthenticator(t, rsaKey)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go a.watch(ctx)
+
+	oldToken := signToken(t, jwt.SigningMethodHS256, "hs", validClaims(), testHMACKey)
+	newToken := signToken(t, jwt.SigningMethodHS256, "hs-2", validClaims(), testNewKey)
+	if _, err := a.authenticate(newToken); err == nil {
+		t.Fatal("token with a key that is not in the JWKS was accepted")
+	}
+
+	// 轮换：新文件只有新密钥，旧 kid 不再被接受
+	writeJWKS(t, path, time.Now(), octJWK("hs-2", testNewKey))
+	waitUntil(t, "new key loaded", func() bool {
+		_, err := a.authenticate(newToken)
+		return err == nil
+	})
+	if _, err := a.authenticate(oldToken); err == nil || !strings.Contains(err.Error(), "unknown kid") {
+		t.Errorf("rotated-out key: got %v", err)
+	}
+
+	// 无效的文件不替换已加载的密钥
+	invalid := []string{`{"keys": []}`, `{"keys": [{"kty": "oct", "kid": "short", "k": "c2hvcnQ"}]}`, `not json`}
+	for i, content := range invalid {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		modTime := time.Now().Add(time.Duration(i+1) * time.Second)
+		os.Chtimes(path, modTime, modTime)
+		if err := a.reload(); err == nil {
+			t.Errorf("%s: reload succeeded", content)
+		}
+		// 等 watch 也检查过这个文件
+		time.Sleep(20 * time.Millisecond)
+		if _, err := a.authenticate(newToken); err != nil {
+			t.Errorf("%s: loaded keys were replaced: %v", content, err)
+		}
+	}
+}
+
+func waitUntil(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultRouteTimeout 路由未配置 timeout 时使用的上游超时
+const defaultRouteTimeout = 10 * time.Second
+
+// GatewayConfig 网关的声明式路由配置，支持 YAML 和 JSON
+type GatewayConfig struct {
+	Upstreams map[string]UpstreamConfig `yaml:"upstreams"`
+	Routes    []RouteConfig             `yaml:"routes"`
+	Overview  OverviewConfig            `yaml:"overview"`
+}
+
+// OverviewConfig 聚合接口 /api/v1/users/:id/overview 调用的上游，未配置时使用 user-service 和 order-service
+type OverviewConfig struct {
+	UserUpstream  string `yaml:"user_upstream"`
+	OrderUpstream string `yaml:"order_upstream"`
+}
+
+func (oc OverviewConfig) withDefaults() OverviewConfig {
+	if oc.UserUpstream == "" {
+		oc.UserUpstream = "user-service"
+	}
+	if oc.OrderUpstream == "" {
+		oc.OrderUpstream = "order-service"
+	}
+	return oc
+}
+
+// UpstreamConfig 上游服务，单实例时可以只配置 url
+type UpstreamConfig struct {
+	URL       string   `yaml:"url"`
+	Instances []string `yaml:"instances"`
+	// Strategy 负载均衡策略：round_robin（默认）、least_outstanding、consistent_hash（按用户）
+	Strategy    string            `yaml:"strategy"`
+	HealthCheck HealthCheckConfig `yaml:"health_check"`
+}
+
+func (up UpstreamConfig) instanceURLs() []string {
+	if up.URL != "" {
+		return []string{up.URL}
+	}
+	return up.Instances
+}
+
+// HealthCheckConfig 主动健康检查，连续失败 UnhealthyThreshold 次剔除实例，连续成功 HealthyThreshold 次恢复
+type HealthCheckConfig struct {
+	Path               string   `yaml:"path"`
+	Interval           Duration `yaml:"interval"`
+	Timeout            Duration `yaml:"timeout"`
+	UnhealthyThreshold int      `yaml:"unhealthy_threshold"`
+	HealthyThreshold   int      `yaml:"healthy_threshold"`
+}
+
+func (hc HealthCheckConfig) withDefaults() HealthCheckConfig {
+	if hc.Path == "" {
+		hc.Path = "/health"
+	}
+	if hc.Interval == 0 {
+		hc.Interval = Duration(5 * time.Second)
+	}
+	if hc.Timeout == 0 {
+		hc.Timeout = Duration(time.Second)
+	}
+	if hc.UnhealthyThreshold == 0 {
+		hc.UnhealthyThreshold = 3
+	}
+	if hc.HealthyThreshold == 0 {
+		hc.HealthyThreshold = 2
+	}
+	return hc
+}
+
+// RouteConfig 单条路由规则
+type RouteConfig struct {
+	Name string `yaml:"name"`
+	// Method 为空或 "*" 时匹配任意方法
+	Method string `yaml:"method"`
+	// Path 支持 :param 和结尾的 *wildcard，例如 /api/v1/users/:id
+	Path string `yaml:"path"`
+	// Upstream 与 Variants 二选一
+	Upstream string `yaml:"upstream"`
+	// Variants 按权重在多个上游版本之间分流，用于灰度发布
+	Variants []VariantConfig `yaml:"variants"`
+	// Rewrite 转发到上游的路径模板，可引用 Path 中的参数；为空时原样转发
+	Rewrite string   `yaml:"rewrite"`
+	Timeout Duration `yaml:"timeout"`
+	Plugins []string `yaml:"plugins"`
+}
+
+// VariantConfig 上游的一个版本。请求携带匹配的 Header 或 Cookie 时固定转发到该版本，
+// 其余请求按用户标识哈希后按 Weight 分配，同一用户总是落在同一版本
+type VariantConfig struct {
+	Name     string `yaml:"name"`
+	Upstream string `yaml:"upstream"`
+	Weight   int    `yaml:"weight"`
+	// Header 形如 "X-Canary: true"
+	Header string `yaml:"header"`
+	// Cookie 形如 "canary=true"
+	Cookie string `yaml:"cookie"`
+}
+
+// headerMatch 解析 "Name: value" 形式的请求头条件
+func (v VariantConfig) headerMatch() (name, value string, ok bool) {
+	name, value, ok = strings.Cut(v.Header, ":")
+	return http.CanonicalHeaderKey(strings.TrimSpace(name)), strings.TrimSpace(value), ok && strings.TrimSpace(name) != ""
+}
+
+// cookieMatch 解析 "name=value" 形式的 Cookie 条件
+func (v VariantConfig) cookieMatch() (name, value string, ok bool) {
+	name, value, ok = strings.Cut(v.Cookie, "=")
+	return strings.TrimSpace(name), strings.TrimSpace(value), ok && strings.TrimSpace(name) != ""
+}
+
+// Duration 支持 "5s"、"500ms" 形式的时长
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	parsed, err := time.ParseDuration(value.Value)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", value.Value)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// loadGatewayConfig 读取并校验路由配置，文件中的 ${VAR} 和 ${VAR:-default} 会替换为环境变量
+func loadGatewayConfig(path string) (*GatewayConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read route config: %w", err)
+	}
+
+	expanded := os.Expand(string(data), expandEnv)
+
+	var cfg GatewayConfig
+	dec := yaml.NewDecoder(bytes.NewReader([]byte(expanded)))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("parse route config %s: %w", path, err)
+	}
+	cfg.Overview = cfg.Overview.withDefaults()
+
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid route config %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+func expandEnv(name string) string {
+	key, def, hasDefault := strings.Cut(name, ":-")
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		return v
+	}
+	if hasDefault {
+		return def
+	}
+	return ""
+}
+
+// validate 检查配置中的全部错误并一次性返回，便于修正
+func (cfg *GatewayConfig) validate() error {
+	var errs []error
+
+	if len(cfg.Upstreams) == 0 {
+		errs = append(errs, errors.New("no upstreams defined"))
+	}
+	for name, up := range cfg.Upstreams {
+		errs = append(errs, up.validate(name)...)
+	}
+
+	if len(cfg.Routes) == 0 {
+		errs = append(errs, errors.New("no routes defined"))
+	}
+	names := make(map[string]bool, len(cfg.Routes))
+	for i, rt := range cfg.Routes {
+		prefix := fmt.Sprintf("route[%d] %q", i, rt.Name)
+		if rt.Name == "" {
+			errs = append(errs, fmt.Errorf("%s: name is required", prefix))
+		} else if names[rt.Name] {
+			errs = append(errs, fmt.Errorf("%s: duplicate name", prefix))
+		}
+		names[rt.Name] = true
+
+		switch {
+		case rt.Upstream != "" && len(rt.Variants) > 0:
+			errs = append(errs, fmt.Errorf("%s: upstream and variants are mutually exclusive", prefix))
+		case len(rt.Variants) > 0:
+			errs = append(errs, rt.validateVariants(prefix, cfg.Upstreams)...)
+		default:
+			if _, ok := cfg.Upstreams[rt.Upstream]; !ok {
+				errs = append(errs, fmt.Errorf("%s: unknown upstream %q", prefix, rt.Upstream))
+			}
+		}
+		params, err := validatePattern(rt.Path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: path: %w", prefix, err))
+		}
+		if rt.Rewrite != "" {
+			if err := validateRewrite(rt.Rewrite, params); err != nil {
+				errs = append(errs, fmt.Errorf("%s: rewrite: %w", prefix, err))
+			}
+		}
+		if rt.Timeout < 0 {
+			errs = append(errs, fmt.Errorf("%s: timeout must be positive", prefix))
+		}
+		for _, p := range rt.Plugins {
+			factory, ok := plugins[p]
+			if !ok {
+				errs = append(errs, fmt.Errorf("%s: unknown plugin %q", prefix, p))
+				continue
+			}
+			if factory.validate == nil {
+				continue
+			}
+			if err := factory.validate(cfg); err != nil {
+				errs = append(errs, fmt.Errorf("%s: plugin %q: %w", prefix, p, err))
+			}
+		}
+	}
+
+	// 聚合接口引用的上游必须存在，否则直到请求到来才会失败
+	if _, ok := cfg.Upstreams[cfg.Overview.UserUpstream]; !ok {
+		errs = append(errs, fmt.Errorf("overview: unknown user_upstream %q", cfg.Overview.UserUpstream))
+	}
+	if _, ok := cfg.Upstreams[cfg.Overview.OrderUpstream]; !ok {
+		errs = append(errs, fmt.Errorf("overview: unknown order_upstream %q", cfg.Overview.OrderUpstream))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (rt RouteConfig) validateVariants(prefix string, upstreams map[string]UpstreamConfig) []error {
+	var errs []error
+	names := make(map[string]bool, len(rt.Variants))
+	total := 0
+	for i, v := range rt.Variants {
+		vp := fmt.Sprintf("%s: variants[%d] %q", prefix, i, v.Name)
+		if v.Name == "" {
+			errs = append(errs, fmt.Errorf("%s: name is required", vp))
+		} else if names[v.Name] {
+			errs = append(errs, fmt.Errorf("%s: duplicate name", vp))
+		}
+		names[v.Name] = true
+
+		if _, ok := upstreams[v.Upstream]; !ok {
+			errs = append(errs, fmt.Errorf("%s: unknown upstream %q", vp, v.Upstream))
+		}
+		if v.Weight < 0 {
+			errs = append(errs, fmt.Errorf("%s: weight must not be negative", vp))
+		}
+		total += v.Weight
+		if _, _, ok := v.headerMatch(); v.Header != "" && !ok {
+			errs = append(errs, fmt.Errorf("%s: header must look like \"Name: value\"", vp))
+		}
+		if _, _, ok := v.cookieMatch(); v.Cookie != "" && !ok {
+			errs = append(errs, fmt.Errorf("%s: cookie must look like \"name=value\"", vp))
+		}
+	}
+	if total == 0 {
+		errs = append(errs, fmt.Errorf("%s: variants need a positive total weight", prefix))
+	}
+	return errs
+}
+
+func (up UpstreamConfig) validate(name string) []error {
+	var errs []error
+	switch {
+	case up.URL != "" && len(up.Instances) > 0:
+		errs = append(errs, fmt.Errorf("upstream %q: url and instances are mutually exclusive", name))
+	case len(up.instanceURLs()) == 0:
+		errs = append(errs, fmt.Errorf("upstream %q: url or instances is required", name))
+	}
+
+	seen := make(map[string]bool)
+	for _, raw := range up.instanceURLs() {
+		u, err := url.Parse(raw)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("upstream %q: invalid url %q", name, raw))
+			continue
+		}
+		if seen[u.Host] {
+			errs = append(errs, fmt.Errorf("upstream %q: duplicate instance %q", name, u.Host))
+		}
+		seen[u.Host] = true
+	}
+
+	switch up.Strategy {
+	case "", strategyRoundRobin, strategyLeastOutstanding, strategyConsistentHash:
+	default:
+		errs = append(errs, fmt.Errorf("upstream %q: unknown strategy %q", name, up.Strategy))
+	}
+
+	hc := up.HealthCheck
+	if hc.Path != "" && !strings.HasPrefix(hc.Path, "/") {
+		errs = append(errs, fmt.Errorf("upstream %q: health_check.path must start with /", name))
+	}
+	if hc.Interval < 0 || hc.Timeout < 0 || hc.UnhealthyThreshold < 0 || hc.HealthyThreshold < 0 {
+		errs = append(errs, fmt.Errorf("upstream %q: health_check values must be positive", name))
+	}
+	return errs
+}
+
+// validatePattern 校验路由路径并返回其中声明的参数名
+func validatePattern(pattern string) (map[string]bool, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("%q must start with /", pattern)
+	}
+	params := make(map[string]bool)
+	segments := strings.Split(strings.Trim(pattern, "/"), "/")
+	for i, seg := range segments {
+		switch {
+		case strings.HasPrefix(seg, ":") || strings.HasPrefix(seg, "*"):
+			name := seg[1:]
+			if name == "" {
+				return nil, fmt.Errorf("%q has an unnamed parameter", pattern)
+			}
+			if params[name] {
+				return nil, fmt.Errorf("%q declares %q twice", pattern, name)
+			}
+			if seg[0] == '*' && i != len(segments)-1 {
+				return nil, fmt.Errorf("%q: wildcard must be the last segment", pattern)
+			}
+			params[name] = true
+		case seg == "" && len(segments) > 1:
+			return nil, fmt.Errorf("%q has an empty segment", pattern)
+		}
+	}
+	return params, nil
+}
+
+func validateRewrite(rewrite string, params map[string]bool) error {
+	if !strings.HasPrefix(rewrite, "/") {
+		return fmt.Errorf("%q must start with /", rewrite)
+	}
+	for _, seg := range strings.Split(rewrite, "/") {
+		if strings.HasPrefix(seg, ":") || strings.HasPrefix(seg, "*") {
+			if !params[seg[1:]] {
+				return fmt.Errorf("%q references unknown parameter %q", rewrite, seg[1:])
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+const validRouteConfig = `
+upstreams:
+  users:
+    url: http://users.internal:8080
+  orders:
+    instances: [http://orders-1:8080, http://orders-2:8080]
+    strategy: consistent_hash
+  orders-canary:
+    url: http://orders-canary:8080
+routes:
+  - name: get-user
+    method: GET
+    path: /api/v1/users/:id
+    upstream: users
+    rewrite: /users/:id
+    timeout: 5s
+    plugins: [jwt]
+  - name: orders
+    path: /api/v1/orders/*rest
+    variants:
+      - {name: stable, upstream: orders, weight: 90}
+      - {name: canary, upstream: orders-canary, weight: 10, header: "X-Canary: true", cookie: "canary=true"}
+overview:
+  user_upstream: users
+  order_upstream: orders
+`
+
+func TestLoadGatewayConfig(t *testing.T) {
+	t.Setenv("USERS_URL", "http://users.example:9000")
+	path := filepath.Join(t.TempDir(), "routes.yaml")
+	writeConfig(t, path, `
+upstreams:
+  users:
+    url: ${USERS_URL}
+  orders:
+    url: ${ORDERS_URL:-http://orders:8080}
+routes:
+  - {name: users, path: /users/:id, upstream: users}
+  - {name: orders, path: /orders, upstream: orders}
+overview: {user_upstream: users, order_upstream: orders}
+`)
+
+	cfg, err := loadGatewayConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.Upstreams["users"].URL; got != "http://users.example:9000" {
+		t.Errorf("users url %q", got)
+	}
+	if got := cfg.Upstreams["orders"].URL; got != "http://orders:8080" {
+		t.Errorf("orders url %q, want the default", got)
+	}
+}
+
+func TestValidateRejectsBadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		replace [2]string
+		// want 错误信息中应包含的内容
+		want []string
+	}{
+		{"unknown field", [2]string{"rewrite: /users/:id", "rewrites: /users/:id"}, []string{"field rewrites not found"}},
+		{"bad duration", [2]string{"timeout: 5s", "timeout: soon"}, []string{`invalid duration "soon"`}},
+		{"duplicate route", [2]string{"name: orders", "name: get-user"}, []string{`route[1] "get-user": duplicate name`}},
+		{"unknown upstream", [2]string{"upstream: users", "upstream: accounts"}, []string{`unknown upstream "accounts"`}},
+		{"unknown plugin", [2]string{"plugins: [jwt]", "plugins: [jwt, ratelimit]"}, []string{`unknown plugin "ratelimit"`}},
+		{"relative path", [2]string{"path: /api/v1/users/:id", "path: api/v1/users/:id"}, []string{"must start with /"}},
+		{"wildcard not last", [2]string{"/api/v1/orders/*rest", "/api/v1/*rest/orders"}, []string{"wildcard must be the last segment"}},
+		{"rewrite unknown param", [2]string{"rewrite: /users/:id", "rewrite: /users/:uid"}, []string{`unknown parameter "uid"`}},
+		{"invalid instance url", [2]string{"http://orders-2:8080", "orders-2:8080"}, []string{`invalid url "orders-2:8080"`}},
+		{"duplicate instance", [2]string{"http://orders-2:8080", "http://orders-1:8080"}, []string{`duplicate instance "orders-1:8080"`}},
+		{"unknown strategy", [2]string{"strategy: consistent_hash", "strategy: random"}, []string{`unknown strategy "random"`}},
+		{"upstream and variants", [2]string{"    variants:", "    upstream: users\n    variants:"}, []string{"mutually exclusive"}},
+		{"negative weight", [2]string{"weight: 10,", "weight: -10,"}, []string{"weight must not be negative"}},
+		{"bad variant header", [2]string{`header: "X-Canary: true"`, `header: "X-Canary"`}, []string{`header must look like "Name: value"`}},
+		{"unknown overview upstream", [2]string{"order_upstream: orders", "order_upstream: order-service"}, []string{`overview: unknown order_upstream "order-service"`}},
+		{"bad variant cookie", [2]string{`cookie: "canary=true"`, `cookie: "canary"`}, []string{`cookie must look like "name=value"`}},
+		// 所有错误一次性返回
+		{"several errors", [2]string{"upstream: users\n    rewrite: /users/:id", "upstream: accounts\n    rewrite: /users/:uid"},
+			[]string{`unknown upstream "accounts"`, `unknown parameter "uid"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !strings.Contains(validRouteConfig, tt.replace[0]) {
+				t.Fatalf("config does not contain %q", tt.replace[0])
+			}
+			path := filepath.Join(t.TempDir(), "routes.yaml")
+			writeConfig(t, path, strings.Replace(validRouteConfig, tt.replace[0], tt.replace[1], 1))
+
+			_, err := loadGatewayConfig(path)
+			if err == nil {
+				t.Fatal("config was accepted")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not contain %q", err, want)
+				}
+			}
+		})
+	}
+
+	empty := filepath.Join(t.TempDir(), "routes.yaml")
+	writeConfig(t, empty, "upstreams: {}\nroutes: []\n")
+	if _, err := loadGatewayConfig(empty); err == nil || !strings.Contains(err.Error(), "no upstreams defined") || !strings.Contains(err.Error(), "no routes defined") {
+		t.Errorf("empty config: %v", err)
+	}
+}
+
+func TestReloadKeepsPreviousTable(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(upstream.Close)
+	config := func(routePath string) string {
+		return "upstreams:\n  users:\n    url: " + upstream.URL + "\nroutes:\n  - {name: users, path: " + routePath + ", upstream: users}\noverview: {user_upstream: users, order_upstream: users}\n"
+	}
+
+	path := filepath.Join(t.TempDir(), "routes.yaml")
+	writeConfig(t, path, config("/users/:id"))
+	g, err := newGateway(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		for _, p := range g.table.Load().pools {
+			p.stop()
+		}
+	})
+	first := g.table.Load()
+
+	invalid := []string{
+		"routes: [",
+		strings.Replace(config("/users/:id"), "upstream: users", "upstream: accounts", 1),
+		strings.Replace(config("/users/:id"), "url: ", "instances: [x]\n    url: ", 1),
+	}
+	for _, content := range invalid {
+		writeConfig(t, path, content)
+		if err := g.reload(); err == nil {
+			t.Fatalf("%q was accepted", content)
+		}
+		if g.table.Load() != first {
+			t.Fatalf("%q replaced the route table", content)
+		}
+		if rt, _ := g.table.Load().match("GET", "/users/1"); rt == nil {
+			t.Fatalf("route lost after rejecting %q", content)
+		}
+	}
+
+	// 合法的配置替换路由表
+	writeConfig(t, path, config("/accounts/:id"))
+	if err := g.reload(); err != nil {
+		t.Fatal(err)
+	}
+	table := g.table.Load()
+	if table == first {
+		t.Fatal("route table was not replaced")
+	}
+	if rt, _ := table.match("GET", "/users/1"); rt != nil {
+		t.Error("old route still matches")
+	}
+	if rt, params := table.match("GET", "/accounts/7"); rt == nil || params["id"] != "7" {
+		t.Errorf("new route: %v, %v", rt, params)
+	}
+}
@@ -16,12 +16,18 @@ import (
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
 	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
 	"go.opentelemetry.io/otel/trace"
+
+	"github.com/xyzbit/devops-demo/pkg/deadline"
+	"github.com/xyzbit/devops-demo/pkg/deadline/gindeadline"
+	"github.com/xyzbit/devops-demo/pkg/requestid"
+	"github.com/xyzbit/devops-demo/pkg/requestid/ginrequestid"
 )
 
 var (
@@ -31,7 +37,7 @@ var (
 			Name: "http_requests_total",
 			Help: "Total number of HTTP requests",
 		},
-		[]string{"method", "endpoint", "status_code"},
+		[]string{"method", "endpoint", "status_code", "variant"},
 	)
 
 	httpRequestDuration = prometheus.NewHistogramVec(
@@ -40,7 +46,7 @@ var (
 			Help:    "Duration of HTTP requests in seconds",
 			Buckets: prometheus.DefBuckets,
 		},
-		[]string{"method", "endpoint"},
+		[]string{"method", "endpoint", "variant"},
 	)
 
 	serviceCalls = prometheus.NewCounterVec(
@@ -112,6 +118,13 @@ func callService(ctx context.Context, serviceName, url string) (map[string]inter
 		attribute.String("http.method", "GET"),
 	)
 
+	// 没有截止时间时使用默认超时，否则沿用剩余的时间预算
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+	}
+
 	// 创建 HTTP 请求
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
@@ -120,14 +133,24 @@ func callService(ctx context.Context, serviceName, url string) (map[string]inter
 		return nil, err
 	}
 
-	// 注入追踪头
+	// 注入追踪头、剩余时间预算和请求 ID
 	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	deadline.Inject(ctx, req)
+	requestid.Inject(ctx, req)
+
+	// 转发网关签名的身份头
+	if id, ok := identityFromContext(ctx); ok {
+		req.Header.Set(identityHeader, id.signed)
+	}
 
 	// 发送请求
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		span.RecordError(err)
+		if ctx.Err() != nil {
+			deadline.RecordCancellation(span, err)
+		} else {
+			span.RecordError(err)
+		}
 		serviceCalls.WithLabelValues(serviceName, "error").Inc()
 		return nil, err
 	}
@@ -154,9 +177,27 @@ func callService(ctx context.Context, serviceName, url string) (map[string]inter
 	}
 
 	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+
+	// 4xx/5xx 同时返回响应体和错误，调用方可以决定如何处理
+	if resp.StatusCode >= 400 {
+		err := &upstreamError{Service: serviceName, StatusCode: resp.StatusCode}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return result, err
+	}
 	return result, nil
 }
 
+// upstreamError 上游返回了错误状态码
+type upstreamError struct {
+	Service    string
+	StatusCode int
+}
+
+func (e *upstreamError) Error() string {
+	return fmt.Sprintf("%s returned status %d", e.Service, e.StatusCode)
+}
+
 func prometheusMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		start := time.Now()
@@ -164,12 +205,21 @@ func prometheusMiddleware() gin.HandlerFunc {
 		// 处理请求
 		c.Next()
 
-		// 记录指标
+		// 记录指标，动态路由使用路由表中的路径模式
 		duration := time.Since(start).Seconds()
 		statusCode := fmt.Sprintf("%d", c.Writer.Status())
+		endpoint := c.FullPath()
+		if endpoint == "" {
+			endpoint = c.GetString(routeKey)
+		}
+		// 灰度路由记录实际转发的上游版本，便于在 Grafana 中对比
+		variant := c.GetString(variantKey)
+		if variant == "" {
+			variant = defaultVariant
+		}
 
-		httpRequestsTotal.WithLabelValues(c.Request.Method, c.FullPath(), statusCode).Inc()
-		httpRequestDuration.WithLabelValues(c.Request.Method, c.FullPath()).Observe(duration)
+		httpRequestsTotal.WithLabelValues(c.Request.Method, endpoint, statusCode, variant).Inc()
+		httpRequestDuration.WithLabelValues(c.Request.Method, endpoint, variant).Observe(duration)
 	}
 }
 
@@ -179,8 +229,11 @@ func loggingMiddleware() gin.HandlerFunc {
 			// 获取 trace ID
 			spanCtx := trace.SpanContextFromContext(param.Request.Context())
 			traceID := spanCtx.TraceID().String()
+			requestID, _ := param.Keys[ginrequestid.Key].(string)
+			subject, _ := param.Keys[subjectKey].(string)
+			variant, _ := param.Keys[variantKey].(string)
 
-			return fmt.Sprintf(`{"time":"%s","method":"%s","uri":"%s","status":%d,"latency":"%s","user_agent":"%s","trace_id":"%s"}`+"\n",
+			return fmt.Sprintf(`{"time":"%s","method":"%s","uri":"%s","status":%d,"latency":"%s","user_agent":"%s","trace_id":"%s","request_id":"%s","subject":"%s","variant":"%s"}`+"\n",
 				param.TimeStamp.Format(time.RFC3339),
 				param.Method,
 				param.Path,
@@ -188,6 +241,9 @@ func loggingMiddleware() gin.HandlerFunc {
 				param.Latency,
 				param.Request.UserAgent(),
 				traceID,
+				requestID,
+				subject,
+				variant,
 			)
 		},
 		Output: getLogWriter(),
@@ -218,12 +274,36 @@ func main() {
 		}
 	}()
 
+	// 初始化 JWT 认证，未配置 JWKS 时 /api/v1 保持匿名访问
+	auth, err := newAuthenticatorFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to initialize authenticator: %v", err)
+	}
+	if auth != nil {
+		go auth.watch(context.Background())
+	} else {
+		log.Println("JWT_JWKS_FILE not set, /api/v1 is served without authentication")
+	}
+
+	// 加载路由表，启动时配置非法直接退出
+	configPath := os.Getenv("ROUTES_CONFIG")
+	if configPath == "" {
+		configPath = "config/routes.yaml"
+	}
+	gw, err := newGateway(configPath, auth)
+	if err != nil {
+		log.Fatalf("Failed to load route config: %v", err)
+	}
+	go gw.watch(context.Background(), 2*time.Second)
+
 	// 创建 Gin 路由
 	r := gin.New()
 
 	// 添加中间件 - 顺序很重要！
 	r.Use(gin.Recovery())
 	r.Use(otelgin.Middleware("api-gateway")) // 必须在 loggingMiddleware 之前
+	r.Use(ginrequestid.Middleware())
+	r.Use(gindeadline.Middleware())
 	r.Use(prometheusMiddleware())
 	r.Use(loggingMiddleware())
 
@@ -235,83 +315,11 @@ func main() {
 	// Prometheus 指标端点
 	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
 
-	// API 路由
-	api := r.Group("/api/v1")
-	{
-		// 用户相关接口
-		api.GET("/users/:id", func(c *gin.Context) {
-			userID := c.Param("id")
-			userServiceURL := os.Getenv("USER_SERVICE_URL")
-			if userServiceURL == "" {
-				userServiceURL = "http://localhost:8081"
-			}
-
-			// 调用用户服务
-			user, err := callService(c.Request.Context(), "user-service", fmt.Sprintf("%s/users/%s", userServiceURL, userID))
-			if err != nil {
-				c.JSON(500, gin.H{"error": err.Error()})
-				return
-			}
-
-			c.JSON(200, user)
-		})
-
-		// 订单相关接口
-		api.POST("/orders", func(c *gin.Context) {
-			var orderReq map[string]interface{}
-			if err := c.ShouldBindJSON(&orderReq); err != nil {
-				c.JSON(400, gin.H{"error": err.Error()})
-				return
-			}
-
-			orderServiceURL := os.Getenv("ORDER_SERVICE_URL")
-			if orderServiceURL == "" {
-				orderServiceURL = "http://localhost:8082"
-			}
-
-			// 创建订单
-			order, err := callService(c.Request.Context(), "order-service", fmt.Sprintf("%s/orders", orderServiceURL))
-			if err != nil {
-				c.JSON(500, gin.H{"error": err.Error()})
-				return
-			}
-
-			// 发送通知
-			notificationServiceURL := os.Getenv("NOTIFICATION_SERVICE_URL")
-			if notificationServiceURL == "" {
-				notificationServiceURL = "http://localhost:8083"
-			}
-
-			notification, err := callService(c.Request.Context(), "notification-service", fmt.Sprintf("%s/notify", notificationServiceURL))
-			if err != nil {
-				log.Printf("Failed to send notification: %v", err)
-				// 不影响订单创建的结果
-			}
-
-			response := map[string]interface{}{
-				"order":        order,
-				"notification": notification,
-			}
-
-			c.JSON(200, response)
-		})
-
-		// 获取订单列表
-		api.GET("/orders", func(c *gin.Context) {
-			orderServiceURL := os.Getenv("ORDER_SERVICE_URL")
-			if orderServiceURL == "" {
-				orderServiceURL = "http://localhost:8082"
-			}
-
-			orders, err := callService(c.Request.Context(), "order-service", fmt.Sprintf("%s/orders", orderServiceURL))
-			if err != nil {
-				c.JSON(500, gin.H{"error": err.Error()})
-				return
-			}
-
-			c.JSON(200, orders)
-		})
-	}
+	// 聚合接口
+	r.GET("/api/v1/users/:id/overview", gw.userOverview)
+
+	// 其余请求按照声明式路由表转发
+	r.NoRoute(gw.handle)
 
 	log.Println("API Gateway starting on port 8080...")
 	if err := r.Run(":8080"); err != nil {
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// routePlugin 路由插件，在转发前后执行
+type routePlugin struct {
+	// before 返回 false 表示插件已经写回响应，终止转发
+	before func(c *gin.Context) bool
+	// after 在上游响应写回客户端之后执行
+	after func(c *gin.Context, status int)
+}
+
+type pluginFactory struct {
+	// validate 检查插件依赖的配置，可为空
+	validate func(cfg *GatewayConfig) error
+	build    func(g *Gateway, table *routeTable) routePlugin
+}
+
+// plugins 可在路由配置中引用的插件
+var plugins = map[string]pluginFactory{
+	// jwt 要求请求携带有效的 Bearer 令牌
+	"jwt": {
+		build: func(g *Gateway, _ *routeTable) routePlugin {
+			return routePlugin{before: g.auth.authorize}
+		},
+	},
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/xyzbit/devops-demo/pkg/deadline"
+	"github.com/xyzbit/devops-demo/pkg/requestid"
+)
+
+// routeKey 保存在 gin.Context 中的路由模式，供指标和日志使用
+const routeKey = "gateway.route"
+
+var configReloads = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "gateway_config_reloads_total",
+		Help: "Total number of route config reload attempts",
+	},
+	[]string{"result"},
+)
+
+func init() {
+	prometheus.MustRegister(configReloads)
+}
+
+// route 编译后的路由
+type route struct {
+	cfg      RouteConfig
+	segments []string
+	timeout  time.Duration
+	variants []*variant
+	plugins  []routePlugin
+}
+
+// routeTable 某一版本配置生成的不可变路由表，重新加载时整体替换
+type routeTable struct {
+	routes   []*route
+	pools    map[string]*upstreamPool
+	overview OverviewConfig
+}
+
+// Gateway 按照声明式路由表转发请求，路由表可在运行时热加载
+type Gateway struct {
+	configPath string
+	auth       *Authenticator
+	table      atomic.Pointer[routeTable]
+	modTime    time.Time
+	transport  http.RoundTripper
+	// overviewTimeout 聚合接口的整体截止时间
+	overviewTimeout time.Duration
+}
+
+func newGateway(configPath string, auth *Authenticator) (*Gateway, error) {
+	g := &Gateway{
+		configPath:      configPath,
+		auth:            auth,
+		transport:       http.DefaultTransport,
+		overviewTimeout: 3 * time.Second,
+	}
+	if v := os.Getenv("OVERVIEW_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OVERVIEW_TIMEOUT %q: %w", v, err)
+		}
+		g.overviewTimeout = d
+	}
+	if err := g.reload(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// reload 加载并编译配置，只有完全合法的配置才会替换当前路由表；
+// 正在处理的请求继续使用旧路由表，不受影响
+func (g *Gateway) reload() error {
+	info, err := os.Stat(g.configPath)
+	if err != nil {
+		configReloads.WithLabelValues("error").Inc()
+		return fmt.Errorf("stat route config: %w", err)
+	}
+	// 无论成功与否都记录本次读取的版本，避免同一个非法文件被反复加载
+	g.modTime = info.ModTime()
+
+	cfg, err := loadGatewayConfig(g.configPath)
+	if err != nil {
+		configReloads.WithLabelValues("error").Inc()
+		return err
+	}
+
+	table, err := g.compile(cfg)
+	if err != nil {
+		configReloads.WithLabelValues("error").Inc()
+		return err
+	}
+
+	// 先停止旧路由表的健康检查再启动新的，避免同一实例的健康指标被旧协程覆盖
+	if old := g.table.Swap(table); old != nil {
+		for _, p := range old.pools {
+			p.stop()
+		}
+	}
+	for _, p := range table.pools {
+		p.start()
+	}
+	configReloads.WithLabelValues("success").Inc()
+	log.Printf("Loaded %d routes from %s", len(table.routes), g.configPath)
+	return nil
+}
+
+func (g *Gateway) compile(cfg *GatewayConfig) (*routeTable, error) {
+	table := &routeTable{
+		pools:    make(map[string]*upstreamPool, len(cfg.Upstreams)),
+		overview: cfg.Overview,
+	}
+	for name, up := range cfg.Upstreams {
+		pool, err := newUpstreamPool(name, up)
+		if err != nil {
+			return nil, err
+		}
+		table.pools[name] = pool
+	}
+
+	for _, rc := range cfg.Routes {
+		rt := &route{
+			cfg:      rc,
+			segments: splitPath(rc.Path),
+			timeout:  time.Duration(rc.Timeout),
+			variants: newVariants(rc, table.pools),
+		}
+		if rt.timeout == 0 {
+			rt.timeout = defaultRouteTimeout
+		}
+		table.routes = append(table.routes, rt)
+	}
+
+	// 插件可能依赖完整的路由表，最后构建
+	for _, rt := range table.routes {
+		for _, name := range rt.cfg.Plugins {
+			rt.plugins = append(rt.plugins, plugins[name].build(g, table))
+		}
+	}
+	return table, nil
+}
+
+// watch 在收到 SIGHUP 或配置文件修改时重新加载路由表，非法配置会被拒绝并保留当前路由表
+func (g *Gateway) watch(ctx context.Context, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.Println("SIGHUP received, reloading route config")
+		case <-ticker.C:
+			info, err := os.Stat(g.configPath)
+			if err != nil || info.ModTime().Equal(g.modTime) {
+				continue
+			}
+		}
+		if err := g.reload(); err != nil {
+			log.Printf("Rejected route config, keeping previous routes: %v", err)
+		}
+	}
+}
+
+func splitPath(p string) []string {
+	return strings.Split(strings.Trim(p, "/"), "/")
+}
+
+// match 按配置顺序查找第一条匹配的路由
+func (t *routeTable) match(method, path string) (*route, map[string]string) {
+	segments := splitPath(path)
+	for _, rt := range t.routes {
+		if rt.cfg.Method != "" && rt.cfg.Method != "*" && !strings.EqualFold(rt.cfg.Method, method) {
+			continue
+		}
+		if params, ok := rt.matchPath(segments); ok {
+			return rt, params
+		}
+	}
+	return nil, nil
+}
+
+func (rt *route) matchPath(segments []string) (map[string]string, bool) {
+	params := make(map[string]string)
+	for i, pattern := range rt.segments {
+		if strings.HasPrefix(pattern, "*") {
+			params[pattern[1:]] = strings.Join(segments[i:], "/")
+			return params, true
+		}
+		if i >= len(segments) {
+			return nil, false
+		}
+		switch {
+		case strings.HasPrefix(pattern, ":"):
+			if segments[i] == "" {
+				return nil, false
+			}
+			params[pattern[1:]] = segments[i]
+		case pattern != segments[i]:
+			return nil, false
+		}
+	}
+	return params, len(segments) == len(rt.segments)
+}
+
+// upstreamPath 根据 rewrite 模板生成上游路径
+func (rt *route) upstreamPath(path string, params map[string]string) string {
+	if rt.cfg.Rewrite == "" {
+		return path
+	}
+	segments := strings.Split(rt.cfg.Rewrite, "/")
+	for i, seg := range segments {
+		if strings.HasPrefix(seg, ":") || strings.HasPrefix(seg, "*") {
+			segments[i] = params[seg[1:]]
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// balanceKey 一致性哈希使用的用户标识：认证主体优先，其次是 X-User-ID 头，最后是客户端 IP
+func balanceKey(c *gin.Context) string {
+	if id, ok := identityFromContext(c.Request.Context()); ok {
+		return id.Subject
+	}
+	if userID := c.GetHeader("X-User-ID"); userID != "" {
+		return userID
+	}
+	return c.ClientIP()
+}
+
+// handle 作为 gin 的 NoRoute 处理器，转发所有未被静态注册的请求
+func (g *Gateway) handle(c *gin.Context) {
+	table := g.table.Load()
+	rt, params := table.match(c.Request.Method, c.Request.URL.Path)
+	if rt == nil {
+		c.JSON(404, gin.H{"error": "route not found"})
+		return
+	}
+	c.Set(routeKey, rt.cfg.Path)
+
+	span := trace.SpanFromContext(c.Request.Context())
+	span.SetAttributes(
+		attribute.String("gateway.route", rt.cfg.Name),
+		attribute.String("http.route", rt.cfg.Path),
+	)
+
+	for _, p := range rt.plugins {
+		if p.before != nil && !p.before(c) {
+			return
+		}
+	}
+
+	status := g.forward(c, rt, params)
+
+	for _, p := range rt.plugins {
+		if p.after != nil {
+			p.after(c, status)
+		}
+	}
+}
+
+// forward 把请求转发到上游并返回上游的状态码
+func (g *Gateway) forward(c *gin.Context, rt *route, params map[string]string) int {
+	key := balanceKey(c)
+	v := rt.pickVariant(c, key)
+	c.Set(variantKey, v.name)
+	trace.SpanFromContext(c.Request.Context()).SetAttributes(attribute.String("gateway.variant", v.name))
+
+	serviceName := v.upstream
+	ctx, cancel := context.WithTimeout(c.Request.Context(), rt.timeout)
+	defer cancel()
+
+	tracer := otel.Tracer("api-gateway")
+	ctx, span := tracer.Start(ctx, fmt.Sprintf("call-%s", serviceName), trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	inst := v.pool.pick(key)
+	if inst == nil {
+		err := fmt.Errorf("no healthy instance for upstream %s", serviceName)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		serviceCalls.WithLabelValues(serviceName, "error").Inc()
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return http.StatusServiceUnavailable
+	}
+
+	upstreamURL := *inst.url
+	upstreamURL.Path = inst.url.Path + rt.upstreamPath(c.Request.URL.Path, params)
+	upstreamURL.RawQuery = c.Request.URL.RawQuery
+
+	span.SetAttributes(
+		attribute.String("service.name", serviceName),
+		attribute.String("gateway.variant", v.name),
+		attribute.String("upstream.instance", inst.url.Host),
+		attribute.String("http.url", upstreamURL.String()),
+		attribute.String("http.method", c.Request.Method),
+	)
+
+	status := http.StatusBadGateway
+	done := inst.begin()
+	defer func() { done(status) }()
+	proxy := &httputil.ReverseProxy{
+		Transport: g.transport,
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.URL = &upstreamURL
+			pr.Out.Host = upstreamURL.Host
+			pr.SetXForwarded()
+			pr.Out.Header.Del("Authorization")
+			pr.Out.Header.Del(identityHeader)
+			if id, ok := identityFromContext(pr.In.Context()); ok {
+				pr.Out.Header.Set(identityHeader, id.signed)
+			}
+			otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(pr.Out.Header))
+			deadline.Inject(ctx, pr.Out)
+			requestid.Inject(ctx, pr.Out)
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			status = resp.StatusCode
+			span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+			if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+				serviceCalls.WithLabelValues(serviceName, "success").Inc()
+			} else {
+				serviceCalls.WithLabelValues(serviceName, "error").Inc()
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			serviceCalls.WithLabelValues(serviceName, "error").Inc()
+			status = http.StatusBadGateway
+			if ctx.Err() != nil {
+				deadline.RecordCancellation(span, err)
+				status = http.StatusGatewayTimeout
+			} else {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			// 上游的错误细节（地址、端口等）只写日志，不返回给客户端
+			requestID, _ := requestid.FromContext(ctx)
+			log.Printf("Proxy to %s instance %s failed (request_id=%s): %v", serviceName, inst.url.Host, requestID, err)
+			c.JSON(status, gin.H{"error": "upstream unavailable"})
+		},
+	}
+
+	proxy.ServeHTTP(c.Writer, c.Request.WithContext(ctx))
+	return status
+}
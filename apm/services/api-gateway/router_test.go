@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/xyzbit/devops-demo/pkg/requestid"
+	"github.com/xyzbit/devops-demo/pkg/requestid/ginrequestid"
+)
+
+func TestProxyHidesUpstreamErrors(t *testing.T) {
+	slow := stubUpstream(t, 200, time.Second, `{}`)
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	path := filepath.Join(t.TempDir(), "routes.yaml")
+	writeConfig(t, path, "upstreams:\n  slow:\n    url: "+slow.URL+"\n  down:\n    url: "+down.URL+"\n"+
+		"routes:\n  - {name: slow, path: /slow, upstream: slow, timeout: 50ms}\n  - {name: down, path: /down, upstream: down}\n"+
+		"overview: {user_upstream: slow, order_upstream: down}\n")
+	g, err := newGateway(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		for _, p := range g.table.Load().pools {
+			p.stop()
+		}
+	})
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(ginrequestid.Middleware())
+	r.NoRoute(g.handle)
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/down", http.StatusBadGateway},
+		{"/slow", http.StatusGatewayTimeout},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", tt.path, nil)
+		req.Header.Set(requestid.Header, "req-"+tt.path[1:])
+		r.ServeHTTP(w, req)
+
+		var resp map[string]string
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if w.Code != tt.status || resp["error"] != "upstream unavailable" {
+			t.Errorf("%s: got %d %s, want %d upstream unavailable", tt.path, w.Code, w.Body, tt.status)
+		}
+		// 错误细节只出现在带请求 ID 的日志中
+		if !strings.Contains(logs.String(), "request_id=req-"+tt.path[1:]) {
+			t.Errorf("%s: log %q does not contain the request id", tt.path, logs.String())
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// 负载均衡策略
+const (
+	strategyRoundRobin       = "round_robin"
+	strategyLeastOutstanding = "least_outstanding"
+	strategyConsistentHash   = "consistent_hash"
+)
+
+// virtualNodes 一致性哈希环上每个实例的虚拟节点数
+const virtualNodes = 100
+
+var (
+	instanceRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "upstream_instance_requests_total",
+			Help: "Total number of requests sent to each upstream instance",
+		},
+		[]string{"upstream", "instance", "status_code"},
+	)
+
+	instanceRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "upstream_instance_request_duration_seconds",
+			Help:    "Duration of requests sent to each upstream instance",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"upstream", "instance"},
+	)
+
+	instanceOutstanding = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "upstream_instance_outstanding_requests",
+			Help: "Number of in-flight requests for each upstream instance",
+		},
+		[]string{"upstream", "instance"},
+	)
+
+	instanceHealthy = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "upstream_instance_healthy",
+			Help: "Whether the upstream instance passes active health checks (1) or is ejected (0)",
+		},
+		[]string{"upstream", "instance"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(instanceRequests)
+	prometheus.MustRegister(instanceRequestDuration)
+	prometheus.MustRegister(instanceOutstanding)
+	prometheus.MustRegister(instanceHealthy)
+}
+
+// instance 上游服务的单个实例
+type instance struct {
+	upstream    string
+	url         *url.URL
+	healthy     atomic.Bool
+	outstanding atomic.Int64
+	// 健康检查连续成功/失败次数，只在健康检查协程中访问
+	successes int
+	failures  int
+}
+
+// begin 记录一次请求开始，返回的函数在请求结束时调用
+func (inst *instance) begin() func(status int) {
+	start := time.Now()
+	inst.outstanding.Add(1)
+	instanceOutstanding.WithLabelValues(inst.upstream, inst.url.Host).Inc()
+
+	return func(status int) {
+		inst.outstanding.Add(-1)
+		instanceOutstanding.WithLabelValues(inst.upstream, inst.url.Host).Dec()
+		instanceRequests.WithLabelValues(inst.upstream, inst.url.Host, strconv.Itoa(status)).Inc()
+		instanceRequestDuration.WithLabelValues(inst.upstream, inst.url.Host).Observe(time.Since(start).Seconds())
+	}
+}
+
+// upstreamPool 上游服务的实例集合，负责选择实例和主动健康检查
+type upstreamPool struct {
+	name      string
+	strategy  string
+	instances []*instance
+	check     HealthCheckConfig
+	next      atomic.Uint64
+	ring      []ringNode
+	cancel    context.CancelFunc
+}
+
+type ringNode struct {
+	hash uint32
+	inst *instance
+}
+
+func newUpstreamPool(name string, cfg UpstreamConfig) (*upstreamPool, error) {
+	p := &upstreamPool{
+		name:     name,
+		strategy: cfg.Strategy,
+		check:    cfg.HealthCheck.withDefaults(),
+	}
+	if p.strategy == "" {
+		p.strategy = strategyRoundRobin
+	}
+
+	for _, raw := range cfg.instanceURLs() {
+		u, err := url.Parse(strings.TrimRight(raw, "/"))
+		if err != nil {
+			return nil, fmt.Errorf("upstream %q: %w", name, err)
+		}
+		inst := &instance{upstream: name, url: u}
+		// 新实例先视为健康，由健康检查尽快纠正
+		inst.healthy.Store(true)
+		p.instances = append(p.instances, inst)
+	}
+
+	if p.strategy == strategyConsistentHash {
+		for _, inst := range p.instances {
+			for i := 0; i < virtualNodes; i++ {
+				p.ring = append(p.ring, ringNode{hash: hashKey(fmt.Sprintf("%s#%d", inst.url.Host, i)), inst: inst})
+			}
+		}
+		sort.Slice(p.ring, func(i, j int) bool { return p.ring[i].hash < p.ring[j].hash })
+	}
+	return p, nil
+}
+
+func hashKey(key string) uint32 {
+	return crc32.ChecksumIEEE([]byte(key))
+}
+
+// pick 按策略选择一个健康实例，key 用于一致性哈希；没有健康实例时返回 nil
+func (p *upstreamPool) pick(key string) *instance {
+	switch p.strategy {
+	case strategyLeastOutstanding:
+		var best *instance
+		for _, inst := range p.instances {
+			if !inst.healthy.Load() {
+				continue
+			}
+			if best == nil || inst.outstanding.Load() < best.outstanding.Load() {
+				best = inst
+			}
+		}
+		return best
+	case strategyConsistentHash:
+		if len(p.ring) == 0 {
+			return nil
+		}
+		h := hashKey(key)
+		start := sort.Search(len(p.ring), func(i int) bool { return p.ring[i].hash >= h })
+		// 顺时针寻找第一个健康实例，被剔除实例的用户会迁移到相邻实例
+		for i := 0; i < len(p.ring); i++ {
+			node := p.ring[(start+i)%len(p.ring)]
+			if node.inst.healthy.Load() {
+				return node.inst
+			}
+		}
+		return nil
+	default:
+		n := uint64(len(p.instances))
+		for i := uint64(0); i < n; i++ {
+			inst := p.instances[(p.next.Add(1)-1)%n]
+			if inst.healthy.Load() {
+				return inst
+			}
+		}
+		return nil
+	}
+}
+
+// call 选择 upstream 的一个实例发起 GET 请求，用于网关自身的编排逻辑
+func (t *routeTable) call(ctx context.Context, upstream, path, key string) (map[string]interface{}, error) {
+	pool, ok := t.pools[upstream]
+	if !ok {
+		return nil, fmt.Errorf("unknown upstream %s", upstream)
+	}
+	inst := pool.pick(key)
+	if inst == nil {
+		return nil, fmt.Errorf("no healthy instance for upstream %s", upstream)
+	}
+
+	done := inst.begin()
+	result, err := callService(ctx, upstream, inst.url.String()+path)
+	status := http.StatusOK
+	var upErr *upstreamError
+	switch {
+	case errors.As(err, &upErr):
+		status = upErr.StatusCode
+	case err != nil:
+		status = http.StatusBadGateway
+	}
+	done(status)
+	return result, err
+}
+
+// start 为每个实例启动主动健康检查
+func (p *upstreamPool) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	for _, inst := range p.instances {
+		instanceHealthy.WithLabelValues(p.name, inst.url.Host).Set(1)
+		go p.healthCheck(ctx, inst)
+	}
+}
+
+// stop 停止健康检查并清理实例的健康状态指标，路由表被替换时调用
+func (p *upstreamPool) stop() {
+	if p.cancel != nil {
+		p.cancel()
+	}
+	for _, inst := range p.instances {
+		instanceHealthy.DeleteLabelValues(p.name, inst.url.Host)
+	}
+}
+
+func (p *upstreamPool) healthCheck(ctx context.Context, inst *instance) {
+	client := &http.Client{Timeout: time.Duration(p.check.Timeout)}
+	target := inst.url.String() + p.check.Path
+
+	ticker := time.NewTicker(time.Duration(p.check.Interval))
+	defer ticker.Stop()
+
+	for {
+		ok := probe(ctx, client, target)
+		if ctx.Err() != nil {
+			return
+		}
+		p.record(inst, ok)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func probe(ctx context.Context, client *http.Client, target string) bool {
+	req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
+
+// record 根据连续成功/失败次数剔除或恢复实例
+func (p *upstreamPool) record(inst *instance, ok bool) {
+	if ok {
+		inst.successes++
+		inst.failures = 0
+		if !inst.healthy.Load() && inst.successes >= p.check.HealthyThreshold {
+			inst.healthy.Store(true)
+			instanceHealthy.WithLabelValues(p.name, inst.url.Host).Set(1)
+			log.Printf("Upstream %s instance %s is healthy again", p.name, inst.url.Host)
+		}
+		return
+	}
+
+	inst.failures++
+	inst.successes = 0
+	if inst.healthy.Load() && inst.failures >= p.check.UnhealthyThreshold {
+		inst.healthy.Store(false)
+		instanceHealthy.WithLabelValues(p.name, inst.url.Host).Set(0)
+		log.Printf("Upstream %s instance %s ejected after %d failed health checks", p.name, inst.url.Host, inst.failures)
+	}
+}
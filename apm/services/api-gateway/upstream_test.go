@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestPool(t *testing.T, strategy string, hosts ...string) *upstreamPool {
+	t.Helper()
+	var urls []string
+	for _, h := range hosts {
+		urls = append(urls, "http://"+h)
+	}
+	p, err := newUpstreamPool("orders", UpstreamConfig{Instances: urls, Strategy: strategy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// instanceByHost 按主机名查找实例
+func instanceByHost(p *upstreamPool, host string) *instance {
+	for _, inst := range p.instances {
+		if inst.url.Host == host {
+			return inst
+		}
+	}
+	return nil
+}
+
+func TestUpstreamPoolPick(t *testing.T) {
+	tests := []struct {
+		name      string
+		strategy  string
+		unhealthy []string
+		// outstanding 每个实例正在处理的请求数
+		outstanding map[string]int64
+		// want 连续 6 次选择的实例，key 依次为 user-0 到 user-5
+		want []string
+	}{
+		{"round robin", "", nil, nil, []string{"a", "b", "c", "a", "b", "c"}},
+		{"round robin skips ejected", strategyRoundRobin, []string{"b"}, nil, []string{"a", "c", "a", "c", "a", "c"}},
+		{"least outstanding", strategyLeastOutstanding, nil, map[string]int64{"a": 3, "b": 1, "c": 2}, []string{"b", "b", "b", "b", "b", "b"}},
+		{"least outstanding skips ejected", strategyLeastOutstanding, []string{"b"}, map[string]int64{"a": 3, "b": 1, "c": 2}, []string{"c", "c", "c", "c", "c", "c"}},
+		{"all ejected", strategyRoundRobin, []string{"a", "b", "c"}, nil, []string{"", "", "", "", "", ""}},
+		{"consistent hash all ejected", strategyConsistentHash, []string{"a", "b", "c"}, nil, []string{"", "", "", "", "", ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPool(t, tt.strategy, "a", "b", "c")
+			for _, h := range tt.unhealthy {
+				instanceByHost(p, h).healthy.Store(false)
+			}
+			for h, n := range tt.outstanding {
+				instanceByHost(p, h).outstanding.Store(n)
+			}
+			for i, want := range tt.want {
+				inst := p.pick(fmt.Sprintf("user-%d", i))
+				got := ""
+				if inst != nil {
+					got = inst.url.Host
+				}
+				if got != want {
+					t.Errorf("pick %d = %q, want %q", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLeastOutstandingFollowsInFlightRequests(t *testing.T) {
+	p := newTestPool(t, strategyLeastOutstanding, "a", "b")
+	first := p.pick("")
+	done := first.begin()
+	if second := p.pick(""); second == first {
+		t.Errorf("picked busy instance %s again", first.url.Host)
+	}
+	done(200)
+	if first.outstanding.Load() != 0 {
+		t.Errorf("outstanding %d after the request finished", first.outstanding.Load())
+	}
+}
+
+func TestConsistentHashMovesOnlyEjectedUsers(t *testing.T) {
+	p := newTestPool(t, strategyConsistentHash, "a", "b", "c")
+	before := make(map[string]string)
+	counts := make(map[string]int)
+	for i := 0; i < 300; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		host := p.pick(key).url.Host
+		if again := p.pick(key).url.Host; again != host {
+			t.Fatalf("%s picked %s then %s", key, host, again)
+		}
+		before[key] = host
+		counts[host]++
+	}
+	// 虚拟节点让用户大致均匀地分布
+	for _, h := range []string{"a", "b", "c"} {
+		if counts[h] < 50 {
+			t.Errorf("instance %s got %d of 300 users", h, counts[h])
+		}
+	}
+
+	// 剔除 b：只有原来在 b 上的用户迁移，恢复后回到 b
+	b := instanceByHost(p, "b")
+	b.healthy.Store(false)
+	for key, host := range before {
+		got := p.pick(key).url.Host
+		if host != "b" && got != host {
+			t.Errorf("%s moved from %s to %s", key, host, got)
+		}
+		if host == "b" && got == "b" {
+			t.Errorf("%s still on ejected instance", key)
+		}
+	}
+	b.healthy.Store(true)
+	for key, host := range before {
+		if got := p.pick(key).url.Host; got != host {
+			t.Errorf("%s on %s after recovery, want %s", key, got, host)
+		}
+	}
+}
+
+func TestHealthCheckEjectsAndRecovers(t *testing.T) {
+	var failing atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ready" {
+			t.Errorf("health check path %s", r.URL.Path)
+		}
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+
+	p, err := newUpstreamPool("orders", UpstreamConfig{
+		Instances: []string{srv.URL, "http://127.0.0.1:1"},
+		HealthCheck: HealthCheckConfig{
+			Path:               "/ready",
+			Interval:           Duration(5 * time.Millisecond),
+			Timeout:            Duration(time.Second),
+			UnhealthyThreshold: 2,
+			HealthyThreshold:   2,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.start()
+	t.Cleanup(p.stop)
+	up, down := p.instances[0], p.instances[1]
+
+	waitUntil(t, "unreachable instance ejected", func() bool { return !down.healthy.Load() })
+	if !up.healthy.Load() {
+		t.Fatal("healthy instance was ejected")
+	}
+	for i := 0; i < 4; i++ {
+		if inst := p.pick(""); inst != up {
+			t.Fatalf("picked %v, want only the healthy instance", inst)
+		}
+	}
+
+	failing.Store(true)
+	waitUntil(t, "failing instance ejected", func() bool { return !up.healthy.Load() })
+	if inst := p.pick(""); inst != nil {
+		t.Errorf("picked %s with every instance ejected", inst.url)
+	}
+
+	failing.Store(false)
+	waitUntil(t, "instance recovered", func() bool { return up.healthy.Load() })
+	if inst := p.pick(""); inst != up {
+		t.Errorf("picked %v after recovery", inst)
+	}
+}
+
+func TestRecordThresholds(t *testing.T) {
+	p := newTestPool(t, "", "a")
+	p.check = HealthCheckConfig{UnhealthyThreshold: 3, HealthyThreshold: 2}
+	inst := p.instances[0]
+
+	steps := []struct {
+		ok      bool
+		healthy bool
+	}{
+		{false, true},
+		{false, true},
+		// 中间的一次成功让失败计数重新开始
+		{true, true},
+		{false, true},
+		{false, true},
+		{false, false},
+		{true, false},
+		{false, false},
+		{true, false},
+		{true, true},
+	}
+	for i, s := range steps {
+		p.record(inst, s.ok)
+		if inst.healthy.Load() != s.healthy {
+			t.Fatalf("step %d (ok=%v): healthy=%v, want %v", i, s.ok, inst.healthy.Load(), s.healthy)
+		}
+	}
+}
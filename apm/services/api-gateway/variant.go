@@ -0,0 +1,77 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// variantKey 保存在 gin.Context 中的上游版本，供指标和日志使用
+	variantKey = "gateway.variant"
+	// defaultVariant 未配置 variants 的路由使用的版本名
+	defaultVariant = "default"
+)
+
+// variant 路由的一个上游版本
+type variant struct {
+	name     string
+	upstream string
+	weight   int
+	pool     *upstreamPool
+
+	headerName, headerValue string
+	cookieName, cookieValue string
+}
+
+func newVariants(rc RouteConfig, pools map[string]*upstreamPool) []*variant {
+	if len(rc.Variants) == 0 {
+		return []*variant{{name: defaultVariant, upstream: rc.Upstream, weight: 1, pool: pools[rc.Upstream]}}
+	}
+
+	variants := make([]*variant, 0, len(rc.Variants))
+	for _, vc := range rc.Variants {
+		v := &variant{name: vc.Name, upstream: vc.Upstream, weight: vc.Weight, pool: pools[vc.Upstream]}
+		v.headerName, v.headerValue, _ = vc.headerMatch()
+		v.cookieName, v.cookieValue, _ = vc.cookieMatch()
+		variants = append(variants, v)
+	}
+	return variants
+}
+
+// matches 请求是否通过 Header 或 Cookie 显式选择了该版本
+func (v *variant) matches(c *gin.Context) bool {
+	if v.headerName != "" && c.GetHeader(v.headerName) == v.headerValue {
+		return true
+	}
+	if v.cookieName != "" {
+		if value, err := c.Cookie(v.cookieName); err == nil && value == v.cookieValue {
+			return true
+		}
+	}
+	return false
+}
+
+// pickVariant 优先使用请求显式选择的版本，否则按用户标识哈希后按权重分配。
+// 哈希与路由无关，权重相同的路由会把同一用户分到同一版本，避免订单跨版本读写
+func (rt *route) pickVariant(c *gin.Context, key string) *variant {
+	if len(rt.variants) == 1 {
+		return rt.variants[0]
+	}
+	for _, v := range rt.variants {
+		if v.matches(c) {
+			return v
+		}
+	}
+
+	total := 0
+	for _, v := range rt.variants {
+		total += v.weight
+	}
+	n := int(hashKey("variant#"+key) % uint32(total))
+	for _, v := range rt.variants {
+		if n < v.weight {
+			return v
+		}
+		n -= v.weight
+	}
+	return rt.variants[len(rt.variants)-1]
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newTestRoute(variants ...VariantConfig) *route {
+	rc := RouteConfig{Name: "orders", Path: "/api/v1/orders", Variants: variants}
+	pools := map[string]*upstreamPool{}
+	for _, v := range variants {
+		pools[v.Upstream] = &upstreamPool{name: v.Upstream}
+	}
+	return &route{cfg: rc, variants: newVariants(rc, pools)}
+}
+
+func variantContext(headers map[string]string, cookie string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/api/v1/orders", nil)
+	for k, v := range headers {
+		c.Request.Header.Set(k, v)
+	}
+	if cookie != "" {
+		c.Request.Header.Set("Cookie", cookie)
+	}
+	return c
+}
+
+var (
+	stableVariant = VariantConfig{Name: "stable", Upstream: "order-service", Weight: 90, Header: "X-Canary: false"}
+	canaryVariant = VariantConfig{Name: "canary", Upstream: "order-service-canary", Weight: 10, Header: "X-Canary: true", Cookie: "canary=true"}
+)
+
+func TestPickVariantWeights(t *testing.T) {
+	tests := []struct {
+		name     string
+		variants []VariantConfig
+		// want 每个版本分到的用户比例，允许 ±3%
+		want map[string]float64
+	}{
+		{"90/10", []VariantConfig{stableVariant, canaryVariant}, map[string]float64{"stable": 0.9, "canary": 0.1}},
+		{"50/50", []VariantConfig{{Name: "a", Upstream: "a", Weight: 1}, {Name: "b", Upstream: "b", Weight: 1}},
+			map[string]float64{"a": 0.5, "b": 0.5}},
+		{"zero weight gets no hashed users", []VariantConfig{{Name: "a", Upstream: "a", Weight: 3}, {Name: "b", Upstream: "b", Weight: 0, Header: "X-B: 1"}},
+			map[string]float64{"a": 1}},
+		{"three variants", []VariantConfig{{Name: "a", Upstream: "a", Weight: 60}, {Name: "b", Upstream: "b", Weight: 30}, {Name: "c", Upstream: "c", Weight: 10}},
+			map[string]float64{"a": 0.6, "b": 0.3, "c": 0.1}},
+	}
+	const users = 10000
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := newTestRoute(tt.variants...)
+			c := variantContext(nil, "")
+			counts := make(map[string]int)
+			for i := 0; i < users; i++ {
+				key := fmt.Sprintf("user-%d", i)
+				v := rt.pickVariant(c, key)
+				// 同一用户总是落在同一版本
+				if again := rt.pickVariant(c, key); again != v {
+					t.Fatalf("%s got %s then %s", key, v.name, again.name)
+				}
+				counts[v.name]++
+			}
+			for name, share := range tt.want {
+				got := float64(counts[name]) / users
+				if got < share-0.03 || got > share+0.03 {
+					t.Errorf("%s got %.3f of users, want %.2f", name, got, share)
+				}
+			}
+			for name, n := range counts {
+				if _, ok := tt.want[name]; !ok {
+					t.Errorf("%s got %d users, want none", name, n)
+				}
+			}
+		})
+	}
+}
+
+func TestPickVariantOverrides(t *testing.T) {
+	rt := newTestRoute(stableVariant, canaryVariant)
+	// 找一个按哈希落在 stable 和一个落在 canary 的用户
+	var stableUser, canaryUser string
+	for i := 0; stableUser == "" || canaryUser == ""; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		if rt.pickVariant(variantContext(nil, ""), key).name == "stable" {
+			stableUser = key
+		} else {
+			canaryUser = key
+		}
+	}
+
+	tests := []struct {
+		name    string
+		user    string
+		headers map[string]string
+		cookie  string
+		want    string
+	}{
+		{"hash stable", stableUser, nil, "", "stable"},
+		{"hash canary", canaryUser, nil, "", "canary"},
+		{"header selects canary", stableUser, map[string]string{"X-Canary": "true"}, "", "canary"},
+		{"header selects stable", canaryUser, map[string]string{"X-Canary": "false"}, "", "stable"},
+		{"header name is case insensitive", stableUser, map[string]string{"x-canary": "true"}, "", "canary"},
+		{"unmatched header value falls back to hash", stableUser, map[string]string{"X-Canary": "yes"}, "", "stable"},
+		{"cookie selects canary", stableUser, nil, "canary=true", "canary"},
+		{"cookie among others", stableUser, nil, "session=abc; canary=true", "canary"},
+		{"unmatched cookie falls back to hash", stableUser, nil, "canary=false", "stable"},
+		// 按配置顺序匹配，第一个匹配的版本生效
+		{"first matching variant wins", canaryUser, map[string]string{"X-Canary": "false"}, "canary=true", "stable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rt.pickVariant(variantContext(tt.headers, tt.cookie), tt.user); got.name != tt.want {
+				t.Errorf("got %s, want %s", got.name, tt.want)
+			}
+		})
+	}
+
+	// 没有 variants 的路由只有默认版本
+	single := &route{variants: newVariants(RouteConfig{Upstream: "user-service"}, map[string]*upstreamPool{})}
+	if v := single.pickVariant(variantContext(map[string]string{"X-Canary": "true"}, ""), stableUser); v.name != defaultVariant || v.upstream != "user-service" {
+		t.Errorf("default variant %+v", v)
+	}
+}
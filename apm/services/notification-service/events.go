@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	eventOrderCreated = "order.created"
+	// eventIDHeader 事件的去重 ID，由 order-service 的 outbox 中继设置，重试时保持不变
+	eventIDHeader = "X-Event-ID"
+	// eventDedupTTL 已处理事件 ID 的保留时间，应长于发送方的最大重试窗口
+	eventDedupTTL = 24 * time.Hour
+	// eventProcessingLease 处理中的事件 ID 的有效期，处理它的进程崩溃后事件可以被重新处理
+	eventProcessingLease = time.Minute
+)
+
+var eventsReceived = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "events_received_total",
+		Help: "Total number of events received, by result (processed, duplicate, in_progress, failed, ignored)",
+	},
+	[]string{"type", "result"},
+)
+
+func init() {
+	prometheus.MustRegister(eventsReceived)
+}
+
+// Event order-service 投递的事件
+type Event struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	OccurredAt string          `json:"occurred_at"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// eventDeduper 按事件 ID 去重，已处理的事件 ID 保存在 NotificationStore 中，重启后仍然有效。
+// 发送方保证至少一次投递，同一事件只有第一次成功处理会发送通知
+type eventDeduper struct {
+	store NotificationStore
+	ttl   time.Duration
+}
+
+func newEventDeduper(store NotificationStore, ttl time.Duration) *eventDeduper {
+	d := &eventDeduper{store: store, ttl: ttl}
+	go d.sweep(time.Minute)
+	return d
+}
+
+// sweep 定期清理过期的事件 ID
+func (d *eventDeduper) sweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if _, err := d.store.DeleteExpiredEvents(context.Background(), now); err != nil {
+			log.Printf("Failed to delete expired event IDs: %v", err)
+		}
+	}
+}
+
+// begin 开始处理一个事件。已处理过的返回 duplicate，正在处理中的返回 busy
+func (d *eventDeduper) begin(ctx context.Context, id string) (duplicate, busy bool, err error) {
+	now := time.Now()
+	return d.store.BeginEvent(ctx, id, now, now.Add(eventProcessingLease))
+}
+
+// finish 结束处理；只有成功时才记录事件 ID，失败的事件可以被重新投递
+func (d *eventDeduper) finish(ctx context.Context, id string, ok bool) {
+	// 请求可能已超时，结果仍要落库
+	if err := d.store.FinishEvent(context.WithoutCancel(ctx), id, ok, time.Now().Add(d.ttl)); err != nil {
+		log.Printf("Failed to record event %s: %v", id, err)
+	}
+}
+
+// receiveEvent 处理 POST /events。重复的事件返回 200 但不再发送通知；
+// 处理失败返回 5xx，由发送方稍后重试
+func receiveEvent(dedup *eventDeduper) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		span := trace.SpanFromContext(c.Request.Context())
+		span.SetAttributes(attribute.String("operation", "receive_event"))
+
+		var ev Event
+		if err := c.ShouldBindJSON(&ev); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if id := c.GetHeader(eventIDHeader); id != "" {
+			ev.ID = id
+		}
+		if ev.ID == "" || ev.Type == "" {
+			c.JSON(400, gin.H{"error": "event id and type are required"})
+			return
+		}
+		span.SetAttributes(
+			attribute.String("event.id", ev.ID),
+			attribute.String("event.type", ev.Type),
+		)
+		// 格式错误的事件重试也无法处理，直接拒绝
+		if ev.Type == eventOrderCreated {
+			var order orderData
+			if err := json.Unmarshal(ev.Data, &order); err != nil || order.ID == 0 {
+				if err == nil {
+					err = errors.New("order id is required")
+				}
+				c.JSON(400, gin.H{"error": fmt.Sprintf("invalid %s payload: %v", ev.Type, err)})
+				return
+			}
+		}
+
+		duplicate, busy, err := dedup.begin(c.Request.Context(), ev.ID)
+		switch {
+		case err != nil:
+			eventsReceived.WithLabelValues(ev.Type, "failed").Inc()
+			span.RecordError(err)
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		case duplicate:
+			eventsReceived.WithLabelValues(ev.Type, "duplicate").Inc()
+			span.SetAttributes(attribute.Bool("event.duplicate", true))
+			c.JSON(200, gin.H{"id": ev.ID, "duplicate": true})
+			return
+		case busy:
+			// 同一事件的另一次投递正在处理，让发送方稍后重试
+			eventsReceived.WithLabelValues(ev.Type, "in_progress").Inc()
+			c.JSON(409, gin.H{"error": "event is being processed"})
+			return
+		}
+
+		// 先放入 webhook 投递：之后的处理失败时发送方会重试，订阅方可能收到重复的事件，按事件 ID 去重
+		hooks, err := webhooks.Publish(c.Request.Context(), ev)
+		span.SetAttributes(attribute.Int("webhook.deliveries", hooks))
+		var job *notificationJob
+		if err == nil {
+			job, err = handleEvent(c, ev)
+		}
+		dedup.finish(c.Request.Context(), ev.ID, err == nil)
+		if err != nil {
+			eventsReceived.WithLabelValues(ev.Type, "failed").Inc()
+			span.RecordError(err)
+			status := 500
+			switch {
+			case c.Request.Context().Err() != nil:
+				status = 504
+			case errors.Is(err, errQueueFull), errors.Is(err, errChannelDisabled):
+				status = 503
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		if job == nil {
+			eventsReceived.WithLabelValues(ev.Type, "ignored").Inc()
+			c.JSON(200, gin.H{"id": ev.ID, "duplicate": false, "ignored": true})
+			return
+		}
+
+		eventsReceived.WithLabelValues(ev.Type, "processed").Inc()
+		span.SetAttributes(attribute.Bool("event.duplicate", false))
+		c.JSON(200, gin.H{"id": ev.ID, "duplicate": false, "notification_id": job.ID})
+	}
+}
+
+// handleEvent 按事件类型把通知放入队列，不关心的事件类型返回 nil。
+// 入队成功即视为处理完成，之后的发送失败由队列重试或转入死信
+func handleEvent(c *gin.Context, ev Event) (*notificationJob, error) {
+	if ev.Type != eventOrderCreated {
+		return nil, nil
+	}
+
+	// 事件只包含订单，收件人的联系方式从用户服务查询；locale 取自投递请求的 Accept-Language
+	var order orderData
+	json.Unmarshal(ev.Data, &order)
+	user, err := userContacts.Lookup(c.Request.Context(), order.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("look up user %d: %w", order.UserID, err)
+	}
+	req := notificationRequest{
+		UserID: order.UserID,
+		Locale: c.GetHeader("Accept-Language"),
+		User:   user,
+		Order:  order,
+	}
+
+	// 按用户偏好选择渠道，没有可用渠道时按忽略处理
+	route, err := notificationRouter.Route(c.Request.Context(), req)
+	if err != nil {
+		return nil, err
+	}
+	recordRouting(trace.SpanFromContext(c.Request.Context()), route)
+	if route.Reason == routeSuppressed {
+		return nil, nil
+	}
+	return enqueue(c, route.Channel, req, route.NotBefore)
+}
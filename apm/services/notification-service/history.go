@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	defaultHistoryLimit = 20
+	maxHistoryLimit     = 100
+)
+
+var (
+	deliveryReceiptsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "notification_delivery_receipts_total",
+			Help: "Total number of delivery receipts received from providers, by result (delivered, failed, duplicate, conflict, not_found)",
+		},
+		[]string{"type", "result"},
+	)
+
+	deliveryLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "notification_delivery_latency_seconds",
+			Help:    "Time from accepting a notification to the provider reporting it delivered",
+			Buckets: []float64{.1, .5, 1, 5, 15, 60, 300, 900, 3600},
+		},
+		[]string{"type"},
+	)
+
+	// notificationStore 通知投递记录，在 main 中初始化
+	notificationStore NotificationStore
+)
+
+func init() {
+	prometheus.MustRegister(deliveryReceiptsTotal)
+	prometheus.MustRegister(deliveryLatency)
+}
+
+// getNotification 处理 GET /notifications/:id，返回通知的状态、发送尝试和回执
+func getNotification(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid notification ID"})
+		return
+	}
+
+	n, err := notificationStore.Get(c.Request.Context(), id)
+	if errors.Is(err, errNotificationNotFound) {
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	trace.SpanFromContext(c.Request.Context()).SetAttributes(
+		attribute.Int("notification.id", n.ID),
+		attribute.String("notification.status", n.Status),
+	)
+	c.JSON(200, n)
+}
+
+// listNotifications 处理 GET /notifications?user_id=&limit=，按时间倒序返回用户最近的通知
+func listNotifications(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Query("user_id"))
+	if err != nil || userID <= 0 {
+		c.JSON(400, gin.H{"error": "user_id is required"})
+		return
+	}
+	limit := defaultHistoryLimit
+	if v := c.Query("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxHistoryLimit {
+			c.JSON(400, gin.H{"error": "limit must be between 1 and 100"})
+			return
+		}
+	}
+
+	list, err := notificationStore.ListByUser(c.Request.Context(), userID, limit)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	trace.SpanFromContext(c.Request.Context()).SetAttributes(attribute.Int("user.id", userID))
+	c.JSON(200, gin.H{"notifications": list, "count": len(list)})
+}
+
+// callbackAuthorized 配置了 DELIVERY_CALLBACK_TOKEN 时，回调必须携带 Authorization: Bearer <token>
+func callbackAuthorized(c *gin.Context) bool {
+	token := os.Getenv("DELIVERY_CALLBACK_TOKEN")
+	if token == "" {
+		return true
+	}
+	got := c.GetHeader("Authorization")
+	return subtle.ConstantTimeCompare([]byte(got), []byte("Bearer "+token)) == 1
+}
+
+// receiveDeliveryReceipt 处理 POST /callbacks/delivery，渠道上报投递结果。
+// notification_id 取自请求体，缺省时取 X-Notification-Id 头；重复的回执返回 200，与终态矛盾的回执返回 409
+func receiveDeliveryReceipt(c *gin.Context) {
+	span := trace.SpanFromContext(c.Request.Context())
+	if !callbackAuthorized(c) {
+		c.JSON(401, gin.H{"error": "invalid callback token"})
+		return
+	}
+
+	var r DeliveryReceipt
+	if err := c.ShouldBindJSON(&r); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if r.NotificationID == 0 {
+		r.NotificationID, _ = strconv.Atoi(c.GetHeader(notificationIDHeader))
+	}
+	if r.NotificationID <= 0 {
+		c.JSON(400, gin.H{"error": "notification_id is required"})
+		return
+	}
+	if r.Status != statusDelivered && r.Status != statusFailed {
+		c.JSON(400, gin.H{"error": "status must be delivered or failed"})
+		return
+	}
+	r.ReceivedAt = time.Now().UTC()
+	if r.Timestamp.IsZero() {
+		r.Timestamp = r.ReceivedAt
+	}
+	r.Timestamp = r.Timestamp.UTC()
+	span.SetAttributes(
+		attribute.Int("notification.id", r.NotificationID),
+		attribute.String("notification.receipt.status", r.Status),
+		attribute.String("notification.receipt.provider", r.Provider),
+	)
+
+	// 查询原记录以区分重复回执，并取得渠道类型
+	before, err := notificationStore.Get(c.Request.Context(), r.NotificationID)
+	if errors.Is(err, errNotificationNotFound) {
+		deliveryReceiptsTotal.WithLabelValues("unknown", "not_found").Inc()
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	n, err := notificationStore.ApplyReceipt(c.Request.Context(), r)
+	switch {
+	case errors.Is(err, errReceiptConflict):
+		deliveryReceiptsTotal.WithLabelValues(before.Type, "conflict").Inc()
+		span.SetStatus(codes.Error, err.Error())
+		c.JSON(409, gin.H{"error": err.Error(), "status": before.Status})
+		return
+	case err != nil:
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	if before.Status == r.Status {
+		deliveryReceiptsTotal.WithLabelValues(n.Type, "duplicate").Inc()
+		c.JSON(200, gin.H{"id": n.ID, "status": n.Status, "duplicate": true})
+		return
+	}
+	deliveryReceiptsTotal.WithLabelValues(n.Type, r.Status).Inc()
+	if r.Status == statusDelivered {
+		deliveryLatency.WithLabelValues(n.Type).Observe(r.Timestamp.Sub(n.CreatedAt).Seconds())
+	}
+	c.JSON(200, gin.H{"id": n.ID, "status": n.Status})
+}
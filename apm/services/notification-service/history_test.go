@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newHistoryRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/notifications", listNotifications)
+	r.GET("/notifications/:id", getNotification)
+	r.POST("/callbacks/delivery", receiveDeliveryReceipt)
+	return r
+}
+
+func serve(r http.Handler, method, path, body string, header http.Header) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestNotificationHistoryAndReceipts(t *testing.T) {
+	p := &flakyProvider{failures: 1}
+	q := newTestQueue(t, p, 10, 5)
+	notificationStore = q.store
+	r := newHistoryRouter()
+
+	job, err := q.Enqueue(context.Background(), channelPush, Message{UserID: 7, Subject: "Order placed", Body: "hi"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "delivery", func() bool { return q.Depth() == 0 })
+
+	var n Notification
+	w := serve(r, "GET", "/notifications/"+strconv.Itoa(job.ID), "", nil)
+	if err := json.Unmarshal(w.Body.Bytes(), &n); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("get: %d %s", w.Code, w.Body)
+	}
+	if n.Status != statusSent || len(n.Attempts) != 2 || n.Attempts[0].Error != "gateway unavailable" || n.Provider != "flaky" {
+		t.Errorf("notification %+v", n)
+	}
+	if strings.Contains(w.Body.String(), `"body"`) {
+		t.Errorf("message content exposed: %s", w.Body)
+	}
+
+	// 回执用请求头中的通知 ID 关联
+	w = serve(r, "POST", "/callbacks/delivery", `{"status":"delivered","provider":"flaky"}`, http.Header{notificationIDHeader: {strconv.Itoa(job.ID)}})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"status":"delivered"`) {
+		t.Errorf("receipt: %d %s", w.Code, w.Body)
+	}
+	w = serve(r, "POST", "/callbacks/delivery", `{"notification_id":`+strconv.Itoa(job.ID)+`,"status":"delivered"}`, nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"duplicate":true`) {
+		t.Errorf("duplicate receipt: %d %s", w.Code, w.Body)
+	}
+	w = serve(r, "POST", "/callbacks/delivery", `{"notification_id":`+strconv.Itoa(job.ID)+`,"status":"failed","error":"bounced"}`, nil)
+	if w.Code != http.StatusConflict {
+		t.Errorf("conflicting receipt: %d %s", w.Code, w.Body)
+	}
+	for _, body := range []string{`{"notification_id":1,"status":"read"}`, `{"status":"delivered"}`, `not json`} {
+		if w := serve(r, "POST", "/callbacks/delivery", body, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", body, w.Code)
+		}
+	}
+	if w := serve(r, "POST", "/callbacks/delivery", `{"notification_id":999,"status":"delivered"}`, nil); w.Code != http.StatusNotFound {
+		t.Errorf("unknown notification: status %d", w.Code)
+	}
+
+	w = serve(r, "GET", "/notifications?user_id=7", "", nil)
+	var list struct {
+		Notifications []Notification `json:"notifications"`
+		Count         int            `json:"count"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || list.Count != 1 || list.Notifications[0].Status != statusDelivered {
+		t.Errorf("list: %d %s", w.Code, w.Body)
+	}
+	for _, path := range []string{"/notifications", "/notifications?user_id=7&limit=500", "/notifications/abc"} {
+		if w := serve(r, "GET", path, "", nil); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", path, w.Code)
+		}
+	}
+	if w := serve(r, "GET", "/notifications/999", "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("missing notification: status %d", w.Code)
+	}
+}
+
+func TestDeliveryCallbackToken(t *testing.T) {
+	t.Setenv("DELIVERY_CALLBACK_TOKEN", "s3cret")
+	notificationStore = newMemoryNotificationStore()
+	n := newTestNotification(1)
+	if err := notificationStore.Create(context.Background(), &n); err != nil {
+		t.Fatal(err)
+	}
+	r := newHistoryRouter()
+	body := `{"notification_id":1,"status":"delivered"}`
+
+	if w := serve(r, "POST", "/callbacks/delivery", body, http.Header{"Authorization": {"Bearer wrong"}}); w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token: status %d", w.Code)
+	}
+	if w := serve(r, "POST", "/callbacks/delivery", body, http.Header{"Authorization": {"Bearer s3cret"}}); w.Code != http.StatusOK {
+		t.Errorf("valid token: status %d %s", w.Code, w.Body)
+	}
+}
+
+func TestQueueRecordsDeadLettersAndRecovers(t *testing.T) {
+	store, err := newSQLiteNotificationStore(context.Background(), filepath.Join(t.TempDir(), "notifications.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	// 重试耗尽后记为 failed
+	failing := newNotificationQueue(newNotifier(map[string]Provider{channelPush: &flakyProvider{failures: 5}}, []string{channelPush}), store, 10, 2, time.Millisecond)
+	fctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	failing.Start(fctx, 1)
+	job, err := failing.Enqueue(ctx, channelPush, Message{UserID: 1, Body: "hi"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "dead letter", func() bool { return len(deadLetters(t, failing)) == 1 })
+	if n, _ := store.Get(ctx, job.ID); n.Status != statusFailed || len(n.Attempts) != 2 || n.LastError != "gateway unavailable" {
+		t.Errorf("dead-lettered notification %+v", n)
+	}
+
+	// 模拟重启：入队后进程退出，新的队列从 store 恢复尚未发送的通知
+	stopped := newNotificationQueue(newNotifier(map[string]Provider{channelPush: &flakyProvider{}}, []string{channelPush}), store, 10, 5, time.Millisecond)
+	pending, err := stopped.Enqueue(ctx, channelPush, Message{UserID: 1, Body: "after restart"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &flakyProvider{}
+	restarted := newNotificationQueue(newNotifier(map[string]Provider{channelPush: p}, []string{channelPush}), store, 10, 5, time.Millisecond)
+	restarted.Start(fctx, 1)
+	if n, err := restarted.Recover(ctx); err != nil || n != 1 {
+		t.Fatalf("recovered %d, %v", n, err)
+	}
+	waitFor(t, "recovered delivery", func() bool { return p.Calls() == 1 && restarted.Depth() == 0 })
+	if n, _ := store.Get(ctx, pending.ID); n.Status != statusSent {
+		t.Errorf("recovered notification %+v", n)
+	}
+}
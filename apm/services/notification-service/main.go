@@ -2,16 +2,22 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"math/rand"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/xyzbit/devops-demo/pkg/deadline/gindeadline"
+	"github.com/xyzbit/devops-demo/pkg/faults"
+	"github.com/xyzbit/devops-demo/pkg/faults/ginfaults"
+	"github.com/xyzbit/devops-demo/pkg/identity/ginidentity"
+	"github.com/xyzbit/devops-demo/pkg/requestid/ginrequestid"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
@@ -23,15 +29,6 @@ import (
 	"go.opentelemetry.io/otel/trace"
 )
 
-// Notification 通知结构体
-type Notification struct {
-	ID      int    `json:"id"`
-	Type    string `json:"type"`
-	Message string `json:"message"`
-	Status  string `json:"status"`
-	SentAt  string `json:"sent_at"`
-}
-
 var (
 	// Prometheus 指标
 	httpRequestsTotal = prometheus.NewCounterVec(
@@ -59,7 +56,14 @@ var (
 		[]string{"type", "status"},
 	)
 
-	nextNotificationID = 1
+	// notifications 按渠道发送通知，在 main 中根据配置初始化
+	notifications *notifier
+	// notificationRouter 按 notificationStore 中的用户偏好选择渠道
+	notificationRouter *router
+	// userContacts 为只带有用户 ID 的事件查询收件人
+	userContacts = newUserDirectoryFromEnv()
+	// notificationTemplates 通知模板，在 main 中从内嵌的 templates 目录加载
+	notificationTemplates *templateEngine
 )
 
 func init() {
@@ -125,7 +129,8 @@ func loggingMiddleware() gin.HandlerFunc {
 		Formatter: func(param gin.LogFormatterParams) string {
 			spanCtx := trace.SpanContextFromContext(param.Request.Context())
 			traceID := spanCtx.TraceID().String()
-			return fmt.Sprintf(`{"time":"%s","service":"notification-service","method":"%s","uri":"%s","status":%d,"latency":"%s","user_agent":"%s","trace_id":"%s"}`+"\n",
+			requestID, _ := param.Keys[ginrequestid.Key].(string)
+			return fmt.Sprintf(`{"time":"%s","service":"notification-service","method":"%s","uri":"%s","status":%d,"latency":"%s","user_agent":"%s","trace_id":"%s","request_id":"%s"}`+"\n",
 				param.TimeStamp.Format(time.RFC3339),
 				param.Method,
 				param.Path,
@@ -133,6 +138,7 @@ func loggingMiddleware() gin.HandlerFunc {
 				param.Latency,
 				param.Request.UserAgent(),
 				traceID,
+				requestID,
 			)
 		},
 		Output: getLogWriter(),
@@ -148,118 +154,203 @@ func getLogWriter() io.Writer {
 	return io.MultiWriter(os.Stdout, logFile)
 }
 
-func simulateNotificationSending(ctx context.Context, notificationType string) {
-	tracer := otel.Tracer("notification-service")
-	_, span := tracer.Start(ctx, fmt.Sprintf("send-%s-notification", notificationType))
-	defer span.End()
-
-	// 模拟发送时间（邮件比短信慢）
-	var sendTime time.Duration
-	switch notificationType {
-	case "email":
-		sendTime = time.Duration(rand.Intn(1000)+500) * time.Millisecond
-	case "sms":
-		sendTime = time.Duration(rand.Intn(300)+100) * time.Millisecond
-	case "push":
-		sendTime = time.Duration(rand.Intn(200)+50) * time.Millisecond
-	default:
-		sendTime = time.Duration(rand.Intn(500)+200) * time.Millisecond
-	}
-
-	time.Sleep(sendTime)
-
-	span.SetAttributes(
-		attribute.String("notification.type", notificationType),
-		attribute.Int64("send_duration_ms", sendTime.Milliseconds()),
-	)
+// notificationRequest 发送接口的请求体，通知内容由模板根据其中的用户和订单数据渲染
+type notificationRequest struct {
+	UserID int    `json:"user_id"`
+	To     string `json:"to"`
+	// Template 模板名，默认 order_created
+	Template string `json:"template"`
+	// Locale zh-CN 或 en-US，为空时依次使用 user.locale、Accept-Language 和 zh-CN
+	Locale string    `json:"locale"`
+	User   userData  `json:"user"`
+	Order  orderData `json:"order"`
+}
 
-	// 模拟偶发的发送失败
-	if rand.Float32() < 0.05 { // 5% 失败率
-		span.SetAttributes(attribute.String("result", "failed"))
-		notificationsSent.WithLabelValues(notificationType, "failed").Inc()
-		span.RecordError(fmt.Errorf("notification sending failed"))
-	} else {
-		span.SetAttributes(attribute.String("result", "success"))
-		notificationsSent.WithLabelValues(notificationType, "success").Inc()
+// bindNotificationRequest 解析请求体，GET /notify 的参数来自查询字符串
+func bindNotificationRequest(c *gin.Context) (notificationRequest, error) {
+	var req notificationRequest
+	if c.Request.Method == "GET" {
+		req.To = c.Query("to")
+		req.Template = c.Query("template")
+		req.Locale = c.Query("locale")
+		req.UserID, _ = strconv.Atoi(c.Query("user_id"))
+		req.Order.ID, _ = strconv.Atoi(c.Query("order_id"))
+		req.Order.Product = c.Query("product")
+		req.Order.Amount, _ = strconv.ParseFloat(c.Query("amount"), 64)
+	} else if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			return req, err
+		}
+	}
+
+	if req.UserID == 0 {
+		req.UserID = req.User.ID
+	}
+	if req.User.ID == 0 {
+		req.User.ID = req.UserID
+	}
+	if req.Locale == "" {
+		req.Locale = req.User.Locale
 	}
+	if req.Locale == "" {
+		req.Locale = c.GetHeader("Accept-Language")
+	}
+	return req, nil
 }
 
-func sendNotification(c *gin.Context) {
-	span := trace.SpanFromContext(c.Request.Context())
-	span.SetAttributes(attribute.String("operation", "send_notification"))
+// reachable 请求中是否有渠道需要的收件人
+func (r notificationRequest) reachable(channel string) bool {
+	switch channel {
+	case channelEmail, channelSMS:
+		return r.recipient(channel) != ""
+	case channelPush:
+		return r.To != "" || r.UserID != 0
+	}
+	return true
+}
 
-	// 随机选择通知类型
-	notificationTypes := []string{"email", "sms", "push"}
-	notificationType := notificationTypes[rand.Intn(len(notificationTypes))]
+// recipient 请求未指定收件人时使用用户资料中对应渠道的地址
+func (r notificationRequest) recipient(channel string) string {
+	if r.To != "" {
+		return r.To
+	}
+	switch channel {
+	case channelEmail:
+		return r.User.Email
+	case channelSMS:
+		return r.User.Phone
+	}
+	return ""
+}
 
-	// 模拟发送不同类型的通知
-	simulateNotificationSending(c.Request.Context(), notificationType)
+// respondEnqueueError 模板或数据不合法返回 400，用户退订了渠道返回 409；队列已满或渠道未启用返回 503，调用方可以稍后重试
+func respondEnqueueError(c *gin.Context, err error) {
+	status := 500
+	switch {
+	case errors.Is(err, errUnknownTemplate), errors.Is(err, errMissingTemplateData):
+		status = 400
+	case errors.Is(err, errOptedOut):
+		status = 409
+	case errors.Is(err, errQueueFull), errors.Is(err, errChannelDisabled):
+		status = 503
+	}
+	c.JSON(status, gin.H{"error": err.Error()})
+}
 
-	notification := Notification{
-		ID:      nextNotificationID,
-		Type:    notificationType,
-		Message: fmt.Sprintf("您的订单已创建成功，订单号：%d", rand.Intn(10000)+1000),
-		Status:  "sent",
-		SentAt:  time.Now().Format(time.RFC3339),
+// enqueue 用模板渲染渠道的通知内容后放入队列，立即返回任务，由 worker 异步发送。
+// notBefore 不为空时延后到该时间发送
+func enqueue(c *gin.Context, channel string, req notificationRequest, notBefore *time.Time) (*notificationJob, error) {
+	name := req.Template
+	if name == "" {
+		name = templateOrderCreated
 	}
+	msg, locale, err := notificationTemplates.Render(name, req.Locale, channel, templateData{User: req.User, Order: req.Order})
+	if err != nil {
+		return nil, err
+	}
+	msg.UserID, msg.To = req.UserID, req.recipient(channel)
+	msg.Template, msg.Locale = name, locale
 
-	nextNotificationID++
+	job, err := jobQueue.Enqueue(c.Request.Context(), channel, msg, notBefore)
+	if err != nil {
+		return nil, err
+	}
 
-	span.SetAttributes(
-		attribute.String("notification.type", notificationType),
-		attribute.Int("notification.id", notification.ID),
-		attribute.String("result", "success"),
+	trace.SpanFromContext(c.Request.Context()).SetAttributes(
+		attribute.String("notification.type", channel),
+		attribute.String("notification.template", name),
+		attribute.String("notification.locale", locale),
+		attribute.Int("notification.id", job.ID),
+		attribute.String("result", "queued"),
 	)
+	return job, nil
+}
 
-	c.JSON(200, notification)
+// jobStatus 刚入队的任务的状态：延后发送的为 deferred
+func jobStatus(job *notificationJob) string {
+	if job.NotBefore != nil {
+		return "deferred"
+	}
+	return "queued"
 }
 
-func sendEmail(c *gin.Context) {
+// respondQueued 返回 202 和任务 ID
+func respondQueued(c *gin.Context, job *notificationJob) {
+	c.JSON(202, gin.H{"id": job.ID, "type": job.Type, "status": jobStatus(job)})
+}
+
+func sendNotification(c *gin.Context) {
 	span := trace.SpanFromContext(c.Request.Context())
-	span.SetAttributes(attribute.String("operation", "send_email"))
+	span.SetAttributes(attribute.String("operation", "send_notification"))
+
+	req, err := bindNotificationRequest(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
 
-	simulateNotificationSending(c.Request.Context(), "email")
+	// 按用户偏好选择渠道
+	route, err := notificationRouter.Route(c.Request.Context(), req)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	recordRouting(span, route)
+	if route.Reason == routeSuppressed {
+		c.JSON(200, gin.H{"status": routeSuppressed, "routing": route})
+		return
+	}
 
-	notification := Notification{
-		ID:      nextNotificationID,
-		Type:    "email",
-		Message: "邮件通知已发送",
-		Status:  "sent",
-		SentAt:  time.Now().Format(time.RFC3339),
+	job, err := enqueue(c, route.Channel, req, route.NotBefore)
+	if err != nil {
+		respondEnqueueError(c, err)
+		return
 	}
+	c.JSON(202, gin.H{"id": job.ID, "type": job.Type, "status": jobStatus(job), "routing": route})
+}
 
-	nextNotificationID++
+func sendEmail(c *gin.Context) {
+	span := trace.SpanFromContext(c.Request.Context())
+	span.SetAttributes(attribute.String("operation", "send_email"))
 
-	span.SetAttributes(
-		attribute.Int("notification.id", notification.ID),
-		attribute.String("result", "success"),
-	)
+	req, err := bindNotificationRequest(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
 
-	c.JSON(200, notification)
+	if err := optOutChecked(c.Request.Context(), req, channelEmail); err != nil {
+		respondEnqueueError(c, err)
+		return
+	}
+	job, err := enqueue(c, channelEmail, req, nil)
+	if err != nil {
+		respondEnqueueError(c, err)
+		return
+	}
+	respondQueued(c, job)
 }
 
 func sendSMS(c *gin.Context) {
 	span := trace.SpanFromContext(c.Request.Context())
 	span.SetAttributes(attribute.String("operation", "send_sms"))
 
-	simulateNotificationSending(c.Request.Context(), "sms")
-
-	notification := Notification{
-		ID:      nextNotificationID,
-		Type:    "sms",
-		Message: "短信通知已发送",
-		Status:  "sent",
-		SentAt:  time.Now().Format(time.RFC3339),
+	req, err := bindNotificationRequest(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
 	}
 
-	nextNotificationID++
-
-	span.SetAttributes(
-		attribute.Int("notification.id", notification.ID),
-		attribute.String("result", "success"),
-	)
-
-	c.JSON(200, notification)
+	if err := optOutChecked(c.Request.Context(), req, channelSMS); err != nil {
+		respondEnqueueError(c, err)
+		return
+	}
+	job, err := enqueue(c, channelSMS, req, nil)
+	if err != nil {
+		respondEnqueueError(c, err)
+		return
+	}
+	respondQueued(c, job)
 }
 
 func main() {
@@ -273,12 +364,50 @@ func main() {
 		}
 	}()
 
+	faultInjector, err = faults.NewFromEnv(defaultFaults)
+	if err != nil {
+		log.Fatalf("Failed to load fault rules: %v", err)
+	}
+	go faultInjector.Watch(context.Background(), 5*time.Second)
+
+	notifications, err = newNotifierFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure notification channels: %v", err)
+	}
+	notificationTemplates, err = newTemplateEngine(templateFiles)
+	if err != nil {
+		log.Fatalf("Failed to load notification templates: %v", err)
+	}
+
+	notificationStore, err = newNotificationStore()
+	if err != nil {
+		log.Fatalf("Failed to initialize notification store: %v", err)
+	}
+	defer notificationStore.Close()
+	notificationRouter = newRouter(notificationStore, notifications)
+
+	jobQueue = newNotificationQueueFromEnv(notifications, notificationStore)
+	jobQueue.Start(context.Background(), notificationWorkers())
+	if n, err := jobQueue.Recover(context.Background()); err != nil {
+		log.Fatalf("Failed to recover queued notifications: %v", err)
+	} else if n > 0 {
+		log.Printf("Recovered %d queued notifications", n)
+	}
+
+	webhooks = newWebhookDispatcherFromEnv(notificationStore)
+	webhooks.Start(context.Background(), webhookWorkers())
+
 	r := gin.New()
 	// 添加中间件 - 顺序很重要！
 	r.Use(gin.Recovery())
 	r.Use(otelgin.Middleware("notification-service")) // 必须在 loggingMiddleware 之前
+	r.Use(ginrequestid.Middleware())
+	r.Use(gindeadline.Middleware())
 	r.Use(prometheusMiddleware())
 	r.Use(loggingMiddleware())
+	// 只信任网关签名的身份，客户端自带的 X-User-ID 会被丢弃
+	r.Use(ginidentity.Middleware([]byte(os.Getenv("IDENTITY_SIGNING_KEY"))))
+	r.Use(ginfaults.Middleware(faultInjector))
 
 	r.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{"status": "healthy", "service": "notification-service"})
@@ -286,8 +415,27 @@ func main() {
 
 	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
 	r.GET("/notify", sendNotification)
+	r.POST("/notify", sendNotification)
 	r.POST("/email", sendEmail)
 	r.POST("/sms", sendSMS)
+	r.POST("/events", receiveEvent(newEventDeduper(notificationStore, eventDedupTTL)))
+	r.GET("/users/:id/preferences", getPreferences)
+	r.PUT("/users/:id/preferences", putPreferences)
+	r.DELETE("/users/:id/preferences", deletePreferences)
+	r.GET("/notifications", listNotifications)
+	r.GET("/notifications/:id", getNotification)
+	r.POST("/callbacks/delivery", receiveDeliveryReceipt)
+	r.POST("/webhooks", createWebhook)
+	r.GET("/webhooks", listWebhooks)
+	r.GET("/webhooks/:id", getWebhook)
+	r.DELETE("/webhooks/:id", deleteWebhook)
+	r.POST("/webhooks/:id/enable", enableWebhook)
+	r.GET("/admin/queue", getQueueStats)
+	r.GET("/admin/dead-letters", listDeadLetters)
+	r.POST("/admin/dead-letters/:id/replay", replayDeadLetter)
+
+	// 故障注入管理接口，需要 FAULTS_ADMIN_TOKEN
+	ginfaults.Register(r, faultInjector)
 
 	log.Println("Notification Service starting on port 8080...")
 	if err := r.Run(":8080"); err != nil {
@@ -0,0 +1,25 @@
+-- 通知的投递记录，attempts 和 receipt 为 JSON，message 和 trace_context 用于重启后恢复尚未发送的任务
+CREATE TABLE notifications (
+    id            INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id       INTEGER NOT NULL,
+    type          TEXT    NOT NULL,
+    status        TEXT    NOT NULL,
+    recipient     TEXT    NOT NULL DEFAULT '',
+    subject       TEXT    NOT NULL DEFAULT '',
+    template      TEXT    NOT NULL DEFAULT '',
+    locale        TEXT    NOT NULL DEFAULT '',
+    provider      TEXT    NOT NULL DEFAULT '',
+    last_error    TEXT    NOT NULL DEFAULT '',
+    attempts      TEXT    NOT NULL DEFAULT '[]',
+    receipt       TEXT    NOT NULL DEFAULT '',
+    message       TEXT    NOT NULL,
+    trace_context TEXT    NOT NULL,
+    created_at    TEXT    NOT NULL,
+    updated_at    TEXT    NOT NULL,
+    not_before    TEXT    NOT NULL DEFAULT '',
+    sent_at       TEXT    NOT NULL DEFAULT '',
+    delivered_at  TEXT    NOT NULL DEFAULT ''
+);
+
+CREATE INDEX idx_notifications_user_id ON notifications (user_id, id);
+CREATE INDEX idx_notifications_status ON notifications (status);
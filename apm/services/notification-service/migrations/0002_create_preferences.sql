@@ -0,0 +1,6 @@
+-- 用户的通知偏好，preferences 为 Preferences 的 JSON
+CREATE TABLE preferences (
+    user_id     INTEGER PRIMARY KEY,
+    preferences TEXT    NOT NULL,
+    updated_at  TEXT    NOT NULL
+);
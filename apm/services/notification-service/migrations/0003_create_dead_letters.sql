@@ -0,0 +1,7 @@
+-- 重试耗尽或无法重试的通知任务，job 为任务的 JSON，重放时删除
+CREATE TABLE dead_letters (
+    notification_id INTEGER PRIMARY KEY,
+    job             TEXT    NOT NULL,
+    error           TEXT    NOT NULL DEFAULT '',
+    failed_at       TEXT    NOT NULL
+);
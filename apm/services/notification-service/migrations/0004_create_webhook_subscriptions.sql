@@ -0,0 +1,13 @@
+-- webhook 订阅，events 为事件类型的 JSON 数组。停用状态和失败计数也保存在这里，重启后保持
+CREATE TABLE webhook_subscriptions (
+    id                   TEXT    PRIMARY KEY,
+    url                  TEXT    NOT NULL,
+    events               TEXT    NOT NULL,
+    secret               TEXT    NOT NULL,
+    status               TEXT    NOT NULL,
+    consecutive_failures INTEGER NOT NULL DEFAULT 0,
+    disabled_reason      TEXT    NOT NULL DEFAULT '',
+    last_error           TEXT    NOT NULL DEFAULT '',
+    last_delivery_at     TEXT    NOT NULL DEFAULT '',
+    created_at           TEXT    NOT NULL
+);
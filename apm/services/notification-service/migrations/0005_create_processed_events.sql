@@ -0,0 +1,8 @@
+-- 已处理（processed = 1）或正在处理的事件 ID，按事件去重；重启后重复投递的事件不会再次发送通知
+CREATE TABLE processed_events (
+    event_id   TEXT    PRIMARY KEY,
+    processed  INTEGER NOT NULL DEFAULT 0,
+    expires_at TEXT    NOT NULL
+);
+
+CREATE INDEX idx_processed_events_expires_at ON processed_events (expires_at);
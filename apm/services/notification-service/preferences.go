@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+	// 运行镜像没有时区数据库，内嵌到二进制中
+	_ "time/tzdata"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 路由结果
+const (
+	routePreferred = "preferred"
+	routeFallback  = "fallback"
+	// routeDeferred 处于免打扰时段，延后到时段结束再发送
+	routeDeferred = "deferred"
+	// routeSuppressed 没有可用的渠道，不发送
+	routeSuppressed = "suppressed"
+)
+
+// errOptedOut 用户退订了请求的渠道
+var errOptedOut = errors.New("user has opted out of this channel")
+
+// defaultQuietHoursTimezone 免打扰时段未指定时区时使用
+const defaultQuietHoursTimezone = "Asia/Shanghai"
+
+var notificationRouting = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "notification_routing_total",
+		Help: "Total number of notification routing decisions, by chosen channel and reason (preferred, fallback, deferred, suppressed)",
+	},
+	[]string{"channel", "reason"},
+)
+
+func init() {
+	prometheus.MustRegister(notificationRouting)
+}
+
+// QuietHours 免打扰时段，Start 和 End 为 HH:MM，Start 晚于 End 时跨越午夜
+type QuietHours struct {
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Timezone string `json:"timezone,omitempty"`
+}
+
+// Preferences 用户的通知偏好
+type Preferences struct {
+	UserID int `json:"user_id"`
+	// Channels 按优先级排列的渠道，为空时使用服务启用的渠道顺序
+	Channels []string `json:"channels"`
+	// OptOut 退订的渠道，任何情况下都不会使用
+	OptOut []string `json:"opt_out"`
+	// Fallback 首选渠道都不可用时的备用渠道，为空时为 email
+	Fallback   string      `json:"fallback,omitempty"`
+	QuietHours *QuietHours `json:"quiet_hours,omitempty"`
+	UpdatedAt  string      `json:"updated_at,omitempty"`
+}
+
+func isKnownChannel(ch string) bool {
+	switch ch {
+	case channelEmail, channelSMS, channelPush, channelWebhook:
+		return true
+	}
+	return false
+}
+
+// validatePreferences 检查渠道名称和免打扰时段的格式
+func validatePreferences(p Preferences) error {
+	for _, list := range [][]string{p.Channels, p.OptOut} {
+		for _, ch := range list {
+			if !isKnownChannel(ch) {
+				return fmt.Errorf("unknown channel %q", ch)
+			}
+		}
+	}
+	if p.Fallback != "" && !isKnownChannel(p.Fallback) {
+		return fmt.Errorf("unknown fallback channel %q", p.Fallback)
+	}
+	if q := p.QuietHours; q != nil {
+		if _, err := parseClock(q.Start); err != nil {
+			return fmt.Errorf("invalid quiet_hours.start: %w", err)
+		}
+		if _, err := parseClock(q.End); err != nil {
+			return fmt.Errorf("invalid quiet_hours.end: %w", err)
+		}
+		if q.Timezone != "" {
+			if _, err := time.LoadLocation(q.Timezone); err != nil {
+				return fmt.Errorf("invalid quiet_hours.timezone: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// parseClock 解析 HH:MM，返回距午夜的时长
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// quietUntil 判断 now 是否处于免打扰时段，是则返回时段结束的时间
+func (q *QuietHours) quietUntil(now time.Time) (time.Time, bool) {
+	if q == nil {
+		return time.Time{}, false
+	}
+	tz := q.Timezone
+	if tz == "" {
+		tz = defaultQuietHoursTimezone
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return time.Time{}, false
+	}
+	start, err1 := parseClock(q.Start)
+	end, err2 := parseClock(q.End)
+	if err1 != nil || err2 != nil || start == end {
+		return time.Time{}, false
+	}
+
+	local := now.In(loc)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	offset := local.Sub(midnight)
+	switch {
+	case start < end && offset >= start && offset < end:
+		return midnight.Add(end), true
+	case start > end && offset >= start:
+		// 跨午夜，结束于次日
+		return midnight.AddDate(0, 0, 1).Add(end), true
+	case start > end && offset < end:
+		return midnight.Add(end), true
+	}
+	return time.Time{}, false
+}
+
+// intrusive 会打扰用户的渠道，免打扰时段内不使用
+func intrusive(ch string) bool {
+	return ch == channelSMS || ch == channelPush
+}
+
+// routingDecision 一次通知的渠道选择结果
+type routingDecision struct {
+	Channel string `json:"channel,omitempty"`
+	Reason  string `json:"reason"`
+	// NotBefore 延后发送的时间，仅 deferred 时设置
+	NotBefore *time.Time `json:"not_before,omitempty"`
+	// Skipped 被跳过的渠道及原因，例如 sms:opted_out
+	Skipped []string `json:"skipped,omitempty"`
+}
+
+// router 根据 NotificationStore 中的用户偏好为通知选择渠道
+type router struct {
+	store    NotificationStore
+	notifier *notifier
+	now      func() time.Time
+}
+
+func newRouter(store NotificationStore, n *notifier) *router {
+	return &router{store: store, notifier: n, now: time.Now}
+}
+
+// preferences 返回用户的偏好，没有设置时 ok 为 false
+func (r *router) preferences(ctx context.Context, userID int) (p Preferences, ok bool, err error) {
+	p, err = r.store.GetPreferences(ctx, userID)
+	if errors.Is(err, errPreferencesNotFound) {
+		return Preferences{}, false, nil
+	}
+	return p, err == nil, err
+}
+
+// Route 按优先级选择第一个可用的渠道：跳过退订、未启用、缺少收件人的渠道，
+// 免打扰时段内跳过短信和推送。都不可用时使用备用渠道；备用渠道也只因免打扰不可用时延后发送。
+// 只有读取偏好失败时返回错误
+func (r *router) Route(ctx context.Context, req notificationRequest) (routingDecision, error) {
+	p, ok, err := r.preferences(ctx, req.UserID)
+	if err != nil {
+		return routingDecision{}, err
+	}
+	channels := p.Channels
+	if !ok || len(channels) == 0 {
+		channels = r.notifier.Channels()
+	}
+	fallback := p.Fallback
+	if fallback == "" {
+		fallback = channelEmail
+	}
+	optedOut := make(map[string]bool)
+	for _, ch := range p.OptOut {
+		optedOut[ch] = true
+	}
+	quietEnd, quiet := p.QuietHours.quietUntil(r.now())
+
+	var d routingDecision
+	// deferrable 因免打扰被跳过、时段结束后可以使用的渠道
+	var deferrable string
+	usable := func(ch string) bool {
+		reason := ""
+		switch {
+		case optedOut[ch]:
+			reason = "opted_out"
+		case !r.notifier.Enabled(ch):
+			reason = "disabled"
+		case !req.reachable(ch):
+			reason = "no_recipient"
+		case quiet && intrusive(ch):
+			reason = "quiet_hours"
+			if deferrable == "" {
+				deferrable = ch
+			}
+		default:
+			return true
+		}
+		d.Skipped = append(d.Skipped, ch+":"+reason)
+		return false
+	}
+
+	fallbackTried := false
+	for _, ch := range channels {
+		if usable(ch) {
+			d.Channel, d.Reason = ch, routePreferred
+			return d, nil
+		}
+		fallbackTried = fallbackTried || ch == fallback
+	}
+	if !fallbackTried && usable(fallback) {
+		d.Channel, d.Reason = fallback, routeFallback
+		return d, nil
+	}
+	if deferrable != "" {
+		d.Channel, d.Reason, d.NotBefore = deferrable, routeDeferred, &quietEnd
+		return d, nil
+	}
+	d.Reason = routeSuppressed
+	return d, nil
+}
+
+// Allowed 检查直接指定渠道的请求（/email、/sms）：只遵守退订，不做路由
+func (r *router) Allowed(ctx context.Context, userID int, channel string) (bool, error) {
+	p, _, err := r.preferences(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	for _, ch := range p.OptOut {
+		if ch == channel {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// recordRouting 在请求 span 和指标中记录路由结果
+func recordRouting(span trace.Span, d routingDecision) {
+	channel := d.Channel
+	if channel == "" {
+		channel = "none"
+	}
+	notificationRouting.WithLabelValues(channel, d.Reason).Inc()
+	attrs := []attribute.KeyValue{
+		attribute.String("notification.route.channel", channel),
+		attribute.String("notification.route.reason", d.Reason),
+		attribute.StringSlice("notification.route.skipped", d.Skipped),
+	}
+	if d.NotBefore != nil {
+		attrs = append(attrs, attribute.String("notification.route.not_before", d.NotBefore.UTC().Format(time.RFC3339)))
+	}
+	span.SetAttributes(attrs...)
+	span.AddEvent("notification.routed", trace.WithAttributes(attrs...))
+}
+
+func parsePreferenceUserID(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(400, gin.H{"error": "Invalid user ID"})
+		return 0, false
+	}
+	return id, true
+}
+
+// getPreferences 处理 GET /users/:id/preferences，未设置时返回默认偏好
+func getPreferences(c *gin.Context) {
+	id, ok := parsePreferenceUserID(c)
+	if !ok {
+		return
+	}
+	p, err := notificationStore.GetPreferences(c.Request.Context(), id)
+	found := err == nil
+	if err != nil && !errors.Is(err, errPreferencesNotFound) {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if !found {
+		p = Preferences{UserID: id, Channels: notifications.Channels(), OptOut: []string{}, Fallback: channelEmail}
+	}
+	c.JSON(200, gin.H{"preferences": p, "default": !found})
+}
+
+// putPreferences 处理 PUT /users/:id/preferences，整体替换用户偏好
+func putPreferences(c *gin.Context) {
+	id, ok := parsePreferenceUserID(c)
+	if !ok {
+		return
+	}
+	var p Preferences
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	p.UserID = id
+	if p.OptOut == nil {
+		p.OptOut = []string{}
+	}
+
+	if err := validatePreferences(p); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	p.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	if err := notificationStore.PutPreferences(c.Request.Context(), p); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	trace.SpanFromContext(c.Request.Context()).SetAttributes(attribute.Int("user.id", id))
+	c.JSON(200, gin.H{"preferences": p})
+}
+
+// deletePreferences 处理 DELETE /users/:id/preferences，恢复默认偏好
+func deletePreferences(c *gin.Context) {
+	id, ok := parsePreferenceUserID(c)
+	if !ok {
+		return
+	}
+	if err := notificationStore.DeletePreferences(c.Request.Context(), id); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(204)
+}
+
+// optOutChecked 直接指定渠道的请求在入队前检查退订
+func optOutChecked(ctx context.Context, req notificationRequest, channel string) error {
+	allowed, err := notificationRouter.Allowed(ctx, req.UserID, channel)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return fmt.Errorf("%w: %s", errOptedOut, channel)
+	}
+	return nil
+}
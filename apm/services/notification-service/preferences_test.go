@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newTestRouter(t *testing.T, now time.Time) (*router, NotificationStore) {
+	t.Helper()
+	providers := map[string]Provider{}
+	for _, ch := range []string{channelEmail, channelSMS, channelPush} {
+		providers[ch] = &flakyProvider{}
+	}
+	store := newMemoryNotificationStore()
+	r := newRouter(store, newNotifier(providers, []string{channelEmail, channelSMS, channelPush}))
+	r.now = func() time.Time { return now }
+	return r, store
+}
+
+func TestRouteByPreferences(t *testing.T) {
+	// 北京时间 23:30
+	now := time.Date(2024, 3, 1, 15, 30, 0, 0, time.UTC)
+	quiet := &QuietHours{Start: "22:00", End: "08:00"}
+	full := notificationRequest{UserID: 1, User: userData{ID: 1, Email: "a@example.com", Phone: "13800000000"}}
+
+	cases := []struct {
+		name    string
+		prefs   *Preferences
+		req     notificationRequest
+		channel string
+		reason  string
+		skipped []string
+	}{
+		{"no preferences", nil, full, channelEmail, routePreferred, nil},
+		{"preferred order", &Preferences{Channels: []string{channelSMS, channelEmail}}, full, channelSMS, routePreferred, nil},
+		{"opted out", &Preferences{Channels: []string{channelSMS, channelPush}, OptOut: []string{channelSMS}}, full, channelPush, routePreferred, []string{"sms:opted_out"}},
+		{"no recipient falls back", &Preferences{Channels: []string{channelSMS}}, notificationRequest{UserID: 1, User: userData{Email: "a@example.com"}}, channelEmail, routeFallback, []string{"sms:no_recipient"}},
+		{"disabled channel", &Preferences{Channels: []string{channelWebhook}, Fallback: channelPush}, full, channelPush, routeFallback, []string{"webhook:disabled"}},
+		{"quiet hours fall back to email", &Preferences{Channels: []string{channelPush, channelSMS}, QuietHours: quiet}, full, channelEmail, routeFallback, []string{"push:quiet_hours", "sms:quiet_hours"}},
+		{"quiet hours defer", &Preferences{Channels: []string{channelSMS}, OptOut: []string{channelEmail}, QuietHours: quiet}, full, channelSMS, routeDeferred, []string{"sms:quiet_hours", "email:opted_out"}},
+		{"suppressed", &Preferences{Channels: []string{channelEmail}, OptOut: []string{channelEmail}}, full, "", routeSuppressed, []string{"email:opted_out"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, store := newTestRouter(t, now)
+			if tc.prefs != nil {
+				p := *tc.prefs
+				p.UserID = 1
+				if err := store.PutPreferences(context.Background(), p); err != nil {
+					t.Fatal(err)
+				}
+			}
+			d, err := r.Route(context.Background(), tc.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.Channel != tc.channel || d.Reason != tc.reason || strings.Join(d.Skipped, ",") != strings.Join(tc.skipped, ",") {
+				t.Errorf("got %+v, want channel %q reason %q skipped %v", d, tc.channel, tc.reason, tc.skipped)
+			}
+			if (d.Reason == routeDeferred) != (d.NotBefore != nil) {
+				t.Errorf("not_before %v for reason %s", d.NotBefore, d.Reason)
+			}
+			if d.NotBefore != nil {
+				// 次日北京时间 08:00
+				if want := time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC); !d.NotBefore.Equal(want) {
+					t.Errorf("not_before %s, want %s", d.NotBefore.UTC(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestQuietUntil(t *testing.T) {
+	overnight := &QuietHours{Start: "22:00", End: "07:30", Timezone: "UTC"}
+	daytime := &QuietHours{Start: "12:00", End: "14:00", Timezone: "UTC"}
+	at := func(h, m int) time.Time { return time.Date(2024, 3, 1, h, m, 0, 0, time.UTC) }
+
+	cases := []struct {
+		q     *QuietHours
+		now   time.Time
+		quiet bool
+		until time.Time
+	}{
+		{overnight, at(23, 0), true, time.Date(2024, 3, 2, 7, 30, 0, 0, time.UTC)},
+		{overnight, at(6, 0), true, at(7, 30)},
+		{overnight, at(7, 30), false, time.Time{}},
+		{overnight, at(12, 0), false, time.Time{}},
+		{daytime, at(13, 0), true, at(14, 0)},
+		{daytime, at(21, 0), false, time.Time{}},
+		{nil, at(23, 0), false, time.Time{}},
+	}
+	for _, tc := range cases {
+		until, quiet := tc.q.quietUntil(tc.now)
+		if quiet != tc.quiet || !until.Equal(tc.until) {
+			t.Errorf("%+v at %s: got %v %s, want %v %s", tc.q, tc.now.Format("15:04"), quiet, until, tc.quiet, tc.until)
+		}
+	}
+}
+
+func TestValidatePreferences(t *testing.T) {
+	invalid := []Preferences{
+		{Channels: []string{"fax"}},
+		{OptOut: []string{"pigeon"}},
+		{Fallback: "fax"},
+		{QuietHours: &QuietHours{Start: "25:00", End: "07:00"}},
+		{QuietHours: &QuietHours{Start: "22:00", End: "7am"}},
+		{QuietHours: &QuietHours{Start: "22:00", End: "07:00", Timezone: "Mars/Olympus"}},
+	}
+	for _, p := range invalid {
+		if err := validatePreferences(p); err == nil {
+			t.Errorf("%+v: expected an error", p)
+		}
+	}
+	valid := Preferences{Channels: []string{channelPush, channelEmail}, OptOut: []string{channelSMS}, Fallback: channelEmail,
+		QuietHours: &QuietHours{Start: "22:00", End: "07:00", Timezone: "America/New_York"}}
+	if err := validatePreferences(valid); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPreferencesAPI(t *testing.T) {
+	n := newNotifier(map[string]Provider{channelEmail: &flakyProvider{}, channelSMS: &flakyProvider{}}, []string{channelEmail, channelSMS})
+	notifications = n
+	notificationStore = newMemoryNotificationStore()
+	notificationRouter = newRouter(notificationStore, n)
+	jobQueue = newNotificationQueue(n, newMemoryNotificationStore(), 10, 1, time.Millisecond)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/users/:id/preferences", getPreferences)
+	r.PUT("/users/:id/preferences", putPreferences)
+	r.DELETE("/users/:id/preferences", deletePreferences)
+	r.POST("/sms", sendSMS)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+	decode := func(w *httptest.ResponseRecorder) (resp struct {
+		Preferences Preferences `json:"preferences"`
+		Default     bool        `json:"default"`
+	}) {
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	w := do("GET", "/users/7/preferences", "")
+	if got := decode(w); w.Code != http.StatusOK || !got.Default || strings.Join(got.Preferences.Channels, ",") != "email,sms" {
+		t.Fatalf("default preferences: %d %s", w.Code, w.Body)
+	}
+
+	w = do("PUT", "/users/7/preferences", `{"channels":["sms"],"opt_out":["sms"],"quiet_hours":{"start":"22:00","end":"08:00"}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("put: %d %s", w.Code, w.Body)
+	}
+	w = do("GET", "/users/7/preferences", "")
+	if got := decode(w); got.Default || got.Preferences.UserID != 7 || got.Preferences.QuietHours == nil || got.Preferences.UpdatedAt == "" {
+		t.Errorf("stored preferences: %s", w.Body)
+	}
+
+	// 直接指定渠道的请求也遵守退订
+	if w := do("POST", "/sms", `{"user_id":7,"to":"13800000000","order":{"id":1}}`); w.Code != http.StatusConflict {
+		t.Errorf("sms to opted-out user: %d %s", w.Code, w.Body)
+	}
+
+	if w := do("PUT", "/users/7/preferences", `{"channels":["fax"]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid channel: %d", w.Code)
+	}
+	if w := do("PUT", "/users/abc/preferences", `{}`); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid user id: %d", w.Code)
+	}
+
+	if w := do("DELETE", "/users/7/preferences", ""); w.Code != http.StatusNoContent {
+		t.Errorf("delete: %d", w.Code)
+	}
+	if got := decode(do("GET", "/users/7/preferences", "")); !got.Default {
+		t.Error("preferences still stored after delete")
+	}
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/xyzbit/devops-demo/pkg/deadline"
+	"github.com/xyzbit/devops-demo/pkg/requestid"
+)
+
+// 通知渠道
+const (
+	channelEmail   = "email"
+	channelSMS     = "sms"
+	channelPush    = "push"
+	channelWebhook = "webhook"
+)
+
+var (
+	// errNoRecipient 渠道需要收件人（邮箱、手机号）但消息中没有
+	errNoRecipient = errors.New("notification has no recipient")
+	// errChannelDisabled 渠道没有在 NOTIFICATION_CHANNELS 中启用
+	errChannelDisabled = errors.New("notification channel is not enabled")
+)
+
+var notificationSendDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "notification_send_duration_seconds",
+		Help:    "Duration of notification sending, by channel and provider",
+		Buckets: prometheus.DefBuckets,
+	},
+	[]string{"type", "provider"},
+)
+
+func init() {
+	prometheus.MustRegister(notificationSendDuration)
+}
+
+// notificationIDHeader 网关请求和邮件中携带通知 ID 的头
+const notificationIDHeader = "X-Notification-Id"
+
+// Message 交给渠道发送的一条通知
+type Message struct {
+	// ID 通知 ID，通过 X-Notification-Id 头交给渠道，渠道的投递回执用它关联通知
+	ID     int `json:"id,omitempty"`
+	UserID int `json:"user_id,omitempty"`
+	// To 收件人：邮箱、手机号或推送设备 token，取决于渠道
+	To      string `json:"to,omitempty"`
+	Subject string `json:"subject,omitempty"`
+	Body    string `json:"body"`
+	// HTML 邮件的 HTML 正文，为空时只发送纯文本
+	HTML string `json:"html,omitempty"`
+
+	// Template 和 Locale 记录渲染内容使用的模板，渠道发送时不使用
+	Template string `json:"template,omitempty"`
+	Locale   string `json:"locale,omitempty"`
+}
+
+// Provider 通知渠道的发送实现
+type Provider interface {
+	// Name 实现的名称，记录在 span 和指标中
+	Name() string
+	Send(ctx context.Context, msg Message) error
+}
+
+// notifier 按渠道选择 Provider 发送通知，统一记录 span 和指标
+type notifier struct {
+	providers map[string]Provider
+	// channels 启用的渠道，按配置顺序
+	channels []string
+}
+
+func newNotifier(providers map[string]Provider, channels []string) *notifier {
+	return &notifier{providers: providers, channels: channels}
+}
+
+// newNotifierFromEnv 由 NOTIFICATION_CHANNELS（默认 email,sms,push）选择启用的渠道，
+// 每个渠道的实现由 <CHANNEL>_PROVIDER 选择，未配置时使用模拟实现
+func newNotifierFromEnv() (*notifier, error) {
+	channels := []string{channelEmail, channelSMS, channelPush}
+	if v := os.Getenv("NOTIFICATION_CHANNELS"); v != "" {
+		channels = nil
+		for _, ch := range strings.Split(v, ",") {
+			if ch = strings.TrimSpace(ch); ch != "" {
+				channels = append(channels, ch)
+			}
+		}
+	}
+
+	providers := make(map[string]Provider)
+	for _, ch := range channels {
+		p, err := providerFromEnv(ch)
+		if err != nil {
+			return nil, err
+		}
+		providers[ch] = p
+	}
+	return newNotifier(providers, channels), nil
+}
+
+func providerFromEnv(channel string) (Provider, error) {
+	prefix := strings.ToUpper(channel)
+	kind := os.Getenv(prefix + "_PROVIDER")
+	if kind == "" || kind == "simulated" {
+		return newSimulatedProvider(channel), nil
+	}
+
+	switch channel + "/" + kind {
+	case channelEmail + "/smtp":
+		addr := os.Getenv("SMTP_ADDR")
+		if addr == "" {
+			return nil, fmt.Errorf("EMAIL_PROVIDER=smtp requires SMTP_ADDR")
+		}
+		from := os.Getenv("SMTP_FROM")
+		if from == "" {
+			from = "notifications@apm.local"
+		}
+		return newSMTPProvider(addr, from, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD")), nil
+	case channelSMS + "/http", channelPush + "/http", channelWebhook + "/http":
+		url := os.Getenv(prefix + "_GATEWAY_URL")
+		if url == "" {
+			return nil, fmt.Errorf("%s_PROVIDER=http requires %s_GATEWAY_URL", prefix, prefix)
+		}
+		apiKey := os.Getenv(prefix + "_API_KEY")
+		switch channel {
+		case channelSMS:
+			return newSMSGatewayProvider(url, apiKey), nil
+		case channelPush:
+			return newPushProvider(url, apiKey), nil
+		default:
+			return newWebhookProvider(url, apiKey), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown provider %q for channel %q", kind, channel)
+}
+
+// Channels 启用的渠道
+func (n *notifier) Channels() []string {
+	return n.channels
+}
+
+// Enabled 渠道是否已启用
+func (n *notifier) Enabled(channel string) bool {
+	_, ok := n.providers[channel]
+	return ok
+}
+
+// Send 通过渠道发送通知并返回使用的 Provider 名称
+func (n *notifier) Send(ctx context.Context, channel string, msg Message) (string, error) {
+	p, ok := n.providers[channel]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", errChannelDisabled, channel)
+	}
+
+	tracer := otel.Tracer("notification-service")
+	ctx, span := tracer.Start(ctx, fmt.Sprintf("send-%s-notification", channel), trace.WithAttributes(
+		attribute.String("notification.type", channel),
+		attribute.String("notification.provider", p.Name()),
+	))
+	defer span.End()
+
+	start := time.Now()
+	err := p.Send(ctx, msg)
+	notificationSendDuration.WithLabelValues(channel, p.Name()).Observe(time.Since(start).Seconds())
+
+	switch {
+	case err == nil:
+		span.SetAttributes(attribute.String("result", "success"))
+		notificationsSent.WithLabelValues(channel, "success").Inc()
+	case ctx.Err() != nil:
+		deadline.RecordCancellation(span, err)
+		notificationsSent.WithLabelValues(channel, "cancelled").Inc()
+		err = ctx.Err()
+	default:
+		span.SetAttributes(attribute.String("result", "failed"))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		notificationsSent.WithLabelValues(channel, "failed").Inc()
+	}
+	return p.Name(), err
+}
+
+// simulatedProvider 模拟发送，耗时和失败率由操作 "send_<channel>" 的故障注入规则决定，
+// 默认使用 faults.json 中的基线规则：邮件比短信慢，5% 的概率失败
+type simulatedProvider struct {
+	channel string
+}
+
+func newSimulatedProvider(channel string) *simulatedProvider {
+	return &simulatedProvider{channel: channel}
+}
+
+func (p *simulatedProvider) Name() string { return "simulated" }
+
+func (p *simulatedProvider) Send(ctx context.Context, msg Message) error {
+	start := time.Now()
+	err := faultInjector.Inject(ctx, "send_"+p.channel)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int64("send_duration_ms", time.Since(start).Milliseconds()))
+	return err
+}
+
+// smtpProvider 通过 SMTP 发送邮件。设置了用户名时使用 PLAIN 认证，
+// net/smtp 只允许在 TLS 连接或本机地址上使用
+type smtpProvider struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func newSMTPProvider(addr, from, username, password string) *smtpProvider {
+	p := &smtpProvider{addr: addr, from: from}
+	if username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		p.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return p
+}
+
+func (p *smtpProvider) Name() string { return "smtp" }
+
+func (p *smtpProvider) Send(ctx context.Context, msg Message) (err error) {
+	if msg.To == "" {
+		return errNoRecipient
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("net.peer.name", p.addr))
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", p.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// net/smtp 不支持 context，取消时关闭连接以中断阻塞的读写
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	defer func() {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+	}()
+
+	host, _, _ := net.SplitHostPort(p.addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if p.auth != nil {
+		if err := c.Auth(p.auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(p.from); err != nil {
+		return err
+	}
+	if err := c.Rcpt(msg.To); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(p.buildMessage(ctx, msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// buildMessage 生成 RFC 5322 邮件，X-Trace-Id 便于从邮件追溯到发送链路
+func (p *smtpProvider) buildMessage(ctx context.Context, msg Message) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", p.from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.BEncoding.Encode("UTF-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "X-Trace-Id: %s\r\n", trace.SpanContextFromContext(ctx).TraceID())
+	if msg.ID != 0 {
+		fmt.Fprintf(&b, "%s: %d\r\n", notificationIDHeader, msg.ID)
+	}
+	b.WriteString("MIME-Version: 1.0\r\n")
+	if msg.HTML == "" {
+		writeMIMEPart(&b, "text/plain", msg.Body)
+		return b.Bytes()
+	}
+
+	// 同时附带纯文本和 HTML 正文，由邮件客户端选择
+	boundary := "apm-" + requestid.New()
+	fmt.Fprintf(&b, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", boundary)
+	fmt.Fprintf(&b, "--%s\r\n", boundary)
+	writeMIMEPart(&b, "text/plain", msg.Body)
+	fmt.Fprintf(&b, "--%s\r\n", boundary)
+	writeMIMEPart(&b, "text/html", msg.HTML)
+	fmt.Fprintf(&b, "--%s--\r\n", boundary)
+	return b.Bytes()
+}
+
+func writeMIMEPart(b *bytes.Buffer, contentType, body string) {
+	fmt.Fprintf(b, "Content-Type: %s; charset=UTF-8\r\n", contentType)
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+	b.WriteString("\r\n")
+}
+
+// httpGatewayProvider 把通知以 JSON POST 给 HTTP 网关，非 2xx 视为失败。
+// 短信、推送和 webhook 只是请求体不同
+type httpGatewayProvider struct {
+	name    string
+	url     string
+	apiKey  string
+	payload func(msg Message) (interface{}, error)
+	client  *http.Client
+}
+
+// newSMSGatewayProvider 短信网关，请求体为 {"to": 手机号, "text": 内容}
+func newSMSGatewayProvider(url, apiKey string) *httpGatewayProvider {
+	return &httpGatewayProvider{name: "sms-gateway", url: url, apiKey: apiKey, client: http.DefaultClient,
+		payload: func(msg Message) (interface{}, error) {
+			if msg.To == "" {
+				return nil, errNoRecipient
+			}
+			return map[string]interface{}{"to": msg.To, "text": msg.Body}, nil
+		}}
+}
+
+// newPushProvider 推送网关，没有设备 token 时按用户推送
+func newPushProvider(url, apiKey string) *httpGatewayProvider {
+	return &httpGatewayProvider{name: "push-gateway", url: url, apiKey: apiKey, client: http.DefaultClient,
+		payload: func(msg Message) (interface{}, error) {
+			if msg.To == "" && msg.UserID == 0 {
+				return nil, errNoRecipient
+			}
+			return map[string]interface{}{"user_id": msg.UserID, "token": msg.To, "title": msg.Subject, "body": msg.Body}, nil
+		}}
+}
+
+// newWebhookProvider 通用 webhook，把整条通知 POST 给配置的 URL
+func newWebhookProvider(url, apiKey string) *httpGatewayProvider {
+	return &httpGatewayProvider{name: "webhook", url: url, apiKey: apiKey, client: http.DefaultClient,
+		payload: func(msg Message) (interface{}, error) {
+			return map[string]interface{}{
+				"user_id": msg.UserID,
+				"to":      msg.To,
+				"subject": msg.Subject,
+				"body":    msg.Body,
+				"html":    msg.HTML,
+				"locale":  msg.Locale,
+				"sent_at": time.Now().UTC().Format(time.RFC3339),
+			}, nil
+		}}
+}
+
+func (p *httpGatewayProvider) Name() string { return p.name }
+
+func (p *httpGatewayProvider) Send(ctx context.Context, msg Message) error {
+	payload, err := p.payload(msg)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if msg.ID != 0 {
+		req.Header.Set(notificationIDHeader, strconv.Itoa(msg.ID))
+	}
+	if p.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.String("http.url", p.url))
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned status %d", p.name, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/xyzbit/devops-demo/pkg/faults"
+)
+
+// smtpStandIn 进程内的最小 SMTP 服务，只实现发送一封邮件需要的命令
+type smtpStandIn struct {
+	lis net.Listener
+
+	mu   sync.Mutex
+	from string
+	rcpt []string
+	data string
+	// rejectRcpt 为 true 时拒绝所有收件人
+	rejectRcpt bool
+}
+
+func newSMTPStandIn(t *testing.T) *smtpStandIn {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpStandIn{lis: lis}
+	go s.serve()
+	t.Cleanup(func() { lis.Close() })
+	return s
+}
+
+func (s *smtpStandIn) Addr() string { return s.lis.Addr().String() }
+
+func (s *smtpStandIn) serve() {
+	for {
+		conn, err := s.lis.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *smtpStandIn) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 stand-in ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.TrimRight(line, "\r\n")
+		upper := strings.ToUpper(cmd)
+		switch {
+		case strings.HasPrefix(upper, "EHLO"), strings.HasPrefix(upper, "HELO"):
+			reply("250 stand-in")
+		case strings.HasPrefix(upper, "MAIL FROM:"):
+			s.mu.Lock()
+			s.from = strings.Trim(cmd[len("MAIL FROM:"):], "<> ")
+			s.mu.Unlock()
+			reply("250 OK")
+		case strings.HasPrefix(upper, "RCPT TO:"):
+			s.mu.Lock()
+			reject := s.rejectRcpt
+			if !reject {
+				s.rcpt = append(s.rcpt, strings.Trim(cmd[len("RCPT TO:"):], "<> "))
+			}
+			s.mu.Unlock()
+			if reject {
+				reply("550 no such user")
+			} else {
+				reply("250 OK")
+			}
+		case upper == "DATA":
+			reply("354 end with .")
+			var b strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				b.WriteString(l)
+			}
+			s.mu.Lock()
+			s.data = b.String()
+			s.mu.Unlock()
+			reply("250 queued")
+		case upper == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestSMTPProviderSendsMail(t *testing.T) {
+	server := newSMTPStandIn(t)
+	p := newSMTPProvider(server.Addr(), "noreply@apm.local", "", "")
+
+	err := p.Send(context.Background(), Message{To: "zhangsan@example.com", Subject: "订单创建成功", Body: "订单号：1001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.from != "noreply@apm.local" || len(server.rcpt) != 1 || server.rcpt[0] != "zhangsan@example.com" {
+		t.Errorf("from %q, rcpt %v", server.from, server.rcpt)
+	}
+	if !strings.Contains(server.data, "订单号：1001") {
+		t.Errorf("body missing from message:\n%s", server.data)
+	}
+	var subject string
+	for _, line := range strings.Split(server.data, "\r\n") {
+		if strings.HasPrefix(line, "Subject: ") {
+			subject, _ = new(mime.WordDecoder).DecodeHeader(strings.TrimPrefix(line, "Subject: "))
+		}
+	}
+	if subject != "订单创建成功" {
+		t.Errorf("subject %q", subject)
+	}
+}
+
+func TestSMTPProviderErrors(t *testing.T) {
+	server := newSMTPStandIn(t)
+	p := newSMTPProvider(server.Addr(), "noreply@apm.local", "", "")
+
+	if err := p.Send(context.Background(), Message{Body: "x"}); !errors.Is(err, errNoRecipient) {
+		t.Errorf("no recipient: got %v", err)
+	}
+
+	server.mu.Lock()
+	server.rejectRcpt = true
+	server.mu.Unlock()
+	if err := p.Send(context.Background(), Message{To: "nobody@example.com", Body: "x"}); err == nil {
+		t.Error("rejected recipient: expected error")
+	}
+
+	// 服务端不响应时按截止时间放弃
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = newSMTPProvider(silent.Addr().String(), "noreply@apm.local", "", "").Send(ctx, Message{To: "a@example.com"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("silent server: got %v, want deadline exceeded", err)
+	}
+}
+
+func TestHTTPGatewayProviders(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		auth     string
+		received map[string]interface{}
+		status   = 200
+	)
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		auth = r.Header.Get("Authorization")
+		received = nil
+		json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(status)
+	}))
+	defer gateway.Close()
+
+	cases := []struct {
+		provider *httpGatewayProvider
+		msg      Message
+		want     map[string]interface{}
+	}{
+		{newSMSGatewayProvider(gateway.URL, "k1"), Message{To: "13800000000", Body: "hi"}, map[string]interface{}{"to": "13800000000", "text": "hi"}},
+		{newPushProvider(gateway.URL, "k1"), Message{UserID: 7, Subject: "t", Body: "b"}, map[string]interface{}{"user_id": float64(7), "title": "t", "body": "b"}},
+		{newWebhookProvider(gateway.URL, "k1"), Message{UserID: 7, Body: "b"}, map[string]interface{}{"user_id": float64(7), "body": "b"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.provider.Name(), func(t *testing.T) {
+			if err := tc.provider.Send(context.Background(), tc.msg); err != nil {
+				t.Fatal(err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if auth != "Bearer k1" {
+				t.Errorf("authorization %q", auth)
+			}
+			for k, v := range tc.want {
+				if received[k] != v {
+					t.Errorf("%s = %v, want %v (payload %v)", k, received[k], v, received)
+				}
+			}
+		})
+	}
+
+	if err := newSMSGatewayProvider(gateway.URL, "").Send(context.Background(), Message{Body: "hi"}); !errors.Is(err, errNoRecipient) {
+		t.Errorf("sms without recipient: got %v", err)
+	}
+
+	mu.Lock()
+	status = 503
+	mu.Unlock()
+	if err := newWebhookProvider(gateway.URL, "").Send(context.Background(), Message{Body: "b"}); err == nil {
+		t.Error("expected error for 503 response")
+	}
+}
+
+// failingProvider 总是失败的 Provider
+type failingProvider struct{}
+
+func (failingProvider) Name() string                        { return "failing" }
+func (failingProvider) Send(context.Context, Message) error { return errors.New("boom") }
+
+func TestNotifierRecordsMetrics(t *testing.T) {
+	n := newNotifier(map[string]Provider{
+		channelPush:    &simulatedProvider{},
+		channelWebhook: failingProvider{},
+	}, []string{channelPush, channelWebhook})
+
+	success := testutil.ToFloat64(notificationsSent.WithLabelValues(channelPush, "success"))
+	failed := testutil.ToFloat64(notificationsSent.WithLabelValues(channelWebhook, "failed"))
+
+	if provider, err := n.Send(context.Background(), channelPush, Message{}); err != nil || provider != "simulated" {
+		t.Errorf("push: provider %q, err %v", provider, err)
+	}
+	if _, err := n.Send(context.Background(), channelWebhook, Message{}); err == nil {
+		t.Error("webhook: expected error")
+	}
+	if _, err := n.Send(context.Background(), channelSMS, Message{}); !errors.Is(err, errChannelDisabled) {
+		t.Errorf("sms: got %v, want errChannelDisabled", err)
+	}
+
+	if got := testutil.ToFloat64(notificationsSent.WithLabelValues(channelPush, "success")) - success; got != 1 {
+		t.Errorf("push success count +%v, want +1", got)
+	}
+	if got := testutil.ToFloat64(notificationsSent.WithLabelValues(channelWebhook, "failed")) - failed; got != 1 {
+		t.Errorf("webhook failed count +%v, want +1", got)
+	}
+}
+
+func TestSimulatedProviderUsesFaultRules(t *testing.T) {
+	if err := faultInjector.Replace([]faults.Rule{
+		{Name: "sms-outage", Operation: "send_sms", ErrorPercent: 100},
+		{Name: "baseline", Operation: "*", Delay: faults.Duration(time.Millisecond), Baseline: true},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { faultInjector.Replace(nil) })
+	n := newNotifier(map[string]Provider{
+		channelSMS:  newSimulatedProvider(channelSMS),
+		channelPush: newSimulatedProvider(channelPush),
+	}, []string{channelSMS, channelPush})
+
+	var fault *faults.Error
+	if _, err := n.Send(context.Background(), channelSMS, Message{}); !errors.As(err, &fault) || fault.Rule != "sms-outage" {
+		t.Errorf("sms: got %v, want the injected fault", err)
+	}
+	if _, err := n.Send(context.Background(), channelPush, Message{}); err != nil {
+		t.Errorf("push: %v", err)
+	}
+}
+
+func TestProviderFromEnv(t *testing.T) {
+	t.Setenv("NOTIFICATION_CHANNELS", "email, webhook")
+	t.Setenv("EMAIL_PROVIDER", "smtp")
+	t.Setenv("SMTP_ADDR", "localhost:2525")
+	t.Setenv("WEBHOOK_PROVIDER", "http")
+	t.Setenv("WEBHOOK_GATEWAY_URL", "http://localhost:9999/hook")
+
+	n, err := newNotifierFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(n.Channels(), ","); got != "email,webhook" {
+		t.Errorf("channels %q", got)
+	}
+	if n.providers[channelEmail].Name() != "smtp" || n.providers[channelWebhook].Name() != "webhook" {
+		t.Errorf("providers %v", n.providers)
+	}
+
+	t.Setenv("WEBHOOK_GATEWAY_URL", "")
+	if _, err := newNotifierFromEnv(); err == nil {
+		t.Error("expected error for missing WEBHOOK_GATEWAY_URL")
+	}
+	t.Setenv("EMAIL_PROVIDER", "carrier-pigeon")
+	if _, err := providerFromEnv(channelEmail); err == nil {
+		t.Error("expected error for unknown provider")
+	}
+}
@@ -0,0 +1,547 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// notificationSendTimeout 单次发送的超时时间，与发起请求的截止时间无关
+const notificationSendTimeout = 30 * time.Second
+
+var (
+	// errQueueFull 队列已满，调用方应稍后重试
+	errQueueFull = errors.New("notification queue is full")
+	// errDeadLetterNotFound 死信不存在或已被重放
+	errDeadLetterNotFound = errors.New("dead letter not found")
+)
+
+var (
+	notificationJobsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "notification_jobs_total",
+			Help: "Total number of notification job attempts, by result (delivered, retried, dead_lettered)",
+		},
+		[]string{"type", "result"},
+	)
+
+	notificationQueueWait = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "notification_queue_wait_seconds",
+			Help:    "Time a notification job waited in the queue before a worker picked it up",
+			Buckets: []float64{.001, .01, .05, .1, .5, 1, 5, 15, 60, 300},
+		},
+		[]string{"type"},
+	)
+
+	// jobQueue 通知队列，在 main 中初始化，供队列指标读取
+	jobQueue *notificationQueue
+)
+
+func init() {
+	prometheus.MustRegister(notificationJobsTotal)
+	prometheus.MustRegister(notificationQueueWait)
+	prometheus.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "notification_queue_depth",
+			Help: "Number of notification jobs waiting for delivery, including jobs waiting for a retry",
+		},
+		func() float64 {
+			if jobQueue == nil {
+				return 0
+			}
+			return float64(jobQueue.Depth())
+		},
+	))
+	prometheus.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "notification_queue_oldest_age_seconds",
+			Help: "Age of the oldest undelivered notification job",
+		},
+		func() float64 {
+			if jobQueue == nil {
+				return 0
+			}
+			return jobQueue.OldestAge().Seconds()
+		},
+	))
+	prometheus.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "notification_dead_letters",
+			Help: "Number of notification jobs in the dead-letter store",
+		},
+		func() float64 {
+			if jobQueue == nil {
+				return 0
+			}
+			list, err := jobQueue.DeadLetters(context.Background())
+			if err != nil {
+				return math.NaN()
+			}
+			return float64(len(list))
+		},
+	))
+}
+
+// notificationJob 队列中等待发送的一条通知
+type notificationJob struct {
+	ID         int       `json:"id"`
+	Type       string    `json:"type"`
+	Message    Message   `json:"message"`
+	Attempts   int       `json:"attempts"`
+	LastError  string    `json:"last_error,omitempty"`
+	EnqueuedAt time.Time `json:"enqueued_at"`
+	// NotBefore 延后发送的时间，例如用户的免打扰时段结束时
+	NotBefore *time.Time `json:"not_before,omitempty"`
+
+	// TraceContext 发起通知的请求的追踪上下文，每次发送都作为父 span
+	TraceContext map[string]string `json:"-"`
+	// readyAt 本次进入就绪队列的时间，用于统计等待时间
+	readyAt time.Time
+}
+
+// deadLetter 重试耗尽或无法重试的任务
+type deadLetter struct {
+	Job      notificationJob `json:"job"`
+	Error    string          `json:"error"`
+	FailedAt time.Time       `json:"failed_at"`
+}
+
+// notificationQueue 进程内的通知队列：由 worker 池发送，失败后按指数退避重试，
+// 重试耗尽的任务进入死信，可以通过管理接口查看和重放。每条通知的状态、发送尝试和死信记录在 store 中，
+// 重启后由 Recover 重新放入尚未发送的通知，死信仍可以重放
+type notificationQueue struct {
+	notifier    *notifier
+	store       NotificationStore
+	ready       chan *notificationJob
+	maxAttempts int
+	retryBase   time.Duration
+
+	mu sync.Mutex
+	// pending 尚未结束的任务（就绪或等待重试）-> 入队时间
+	pending map[int]time.Time
+	done    chan struct{}
+}
+
+func newNotificationQueue(n *notifier, store NotificationStore, size, maxAttempts int, retryBase time.Duration) *notificationQueue {
+	return &notificationQueue{
+		notifier:    n,
+		store:       store,
+		ready:       make(chan *notificationJob, size),
+		maxAttempts: maxAttempts,
+		retryBase:   retryBase,
+		pending:     make(map[int]time.Time),
+		done:        make(chan struct{}),
+	}
+}
+
+// newNotificationQueueFromEnv 由 NOTIFICATION_QUEUE_SIZE（默认 1000）、NOTIFICATION_MAX_ATTEMPTS（默认 5）
+// 和 NOTIFICATION_RETRY_BASE（默认 1s）配置
+func newNotificationQueueFromEnv(n *notifier, store NotificationStore) *notificationQueue {
+	return newNotificationQueue(n, store,
+		envInt("NOTIFICATION_QUEUE_SIZE", 1000),
+		envInt("NOTIFICATION_MAX_ATTEMPTS", 5),
+		envDuration("NOTIFICATION_RETRY_BASE", time.Second),
+	)
+}
+
+// notificationWorkers worker 数量，由 NOTIFICATION_WORKERS 配置，默认 4
+func notificationWorkers() int {
+	return envInt("NOTIFICATION_WORKERS", 4)
+}
+
+func envInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		log.Fatalf("Invalid %s %q", name, v)
+	}
+	return n
+}
+
+func envDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Fatalf("Invalid %s %q", name, v)
+	}
+	return d
+}
+
+// Start 启动 workers 个 worker，ctx 结束后停止取新任务
+func (q *notificationQueue) Start(ctx context.Context, workers int) {
+	go func() {
+		<-ctx.Done()
+		close(q.done)
+	}()
+	for i := 0; i < workers; i++ {
+		go func() {
+			for {
+				select {
+				case job := <-q.ready:
+					q.process(ctx, job)
+				case <-q.done:
+					return
+				}
+			}
+		}()
+	}
+}
+
+// Enqueue 保存通知并加入队列，返回任务的副本；通知 ID 由 store 分配。
+// 队列已满时返回 errQueueFull，已保存的通知记为 failed。notBefore 晚于当前时间时任务等到那时才进入就绪队列
+func (q *notificationQueue) Enqueue(ctx context.Context, channel string, msg Message, notBefore *time.Time) (*notificationJob, error) {
+	if !q.notifier.Enabled(channel) {
+		return nil, errChannelDisabled
+	}
+
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	now := time.Now().UTC()
+	if notBefore != nil && notBefore.After(now) {
+		t := notBefore.UTC()
+		notBefore = &t
+	} else {
+		notBefore = nil
+	}
+
+	n := Notification{
+		UserID:       msg.UserID,
+		Type:         channel,
+		Status:       statusQueued,
+		To:           msg.To,
+		Subject:      msg.Subject,
+		Template:     msg.Template,
+		Locale:       msg.Locale,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+		NotBefore:    notBefore,
+		Message:      msg,
+		TraceContext: carrier,
+	}
+	if err := q.store.Create(ctx, &n); err != nil {
+		return nil, err
+	}
+	job := jobFromNotification(n)
+
+	// 入队后任务归 worker 所有，返回入队时的副本
+	snapshot := *job
+	if err := q.start(job); err != nil {
+		if serr := q.store.UpdateStatus(ctx, n.ID, statusFailed, err.Error()); serr != nil {
+			log.Printf("Failed to mark notification %d as failed: %v", n.ID, serr)
+		}
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// jobFromNotification 由保存的通知生成队列任务，已有的发送尝试计入重试次数
+func jobFromNotification(n Notification) *notificationJob {
+	job := &notificationJob{
+		ID:           n.ID,
+		Type:         n.Type,
+		Message:      n.Message,
+		Attempts:     len(n.Attempts),
+		LastError:    n.LastError,
+		EnqueuedAt:   n.CreatedAt,
+		NotBefore:    n.NotBefore,
+		TraceContext: n.TraceContext,
+	}
+	// 渠道实现用 ID 关联投递回执
+	job.Message.ID = n.ID
+	return job
+}
+
+// start 让任务进入队列：延后发送的任务到时才进入就绪队列，其余立即放入
+func (q *notificationQueue) start(job *notificationJob) error {
+	if job.NotBefore != nil && time.Until(*job.NotBefore) > 0 {
+		q.mu.Lock()
+		q.pending[job.ID] = job.EnqueuedAt
+		q.mu.Unlock()
+		q.schedule(job, time.Until(*job.NotBefore))
+		return nil
+	}
+	return q.push(job)
+}
+
+// Recover 重新放入 store 中尚未发送的通知，在 Start 之后调用。
+// 就绪队列放不下的通知等待放入，不会被丢弃
+func (q *notificationQueue) Recover(ctx context.Context) (int, error) {
+	pending, err := q.store.Pending(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, n := range pending {
+		job := jobFromNotification(n)
+		delay := time.Duration(0)
+		if job.NotBefore != nil {
+			delay = time.Until(*job.NotBefore)
+		}
+		q.mu.Lock()
+		q.pending[job.ID] = job.EnqueuedAt
+		q.mu.Unlock()
+		q.schedule(job, delay)
+	}
+	return len(pending), nil
+}
+
+// push 不阻塞地放入就绪队列
+func (q *notificationQueue) push(job *notificationJob) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job.readyAt = time.Now()
+	select {
+	case q.ready <- job:
+		q.pending[job.ID] = job.EnqueuedAt
+		return nil
+	default:
+		return errQueueFull
+	}
+}
+
+// process 发送一次；失败时安排重试或转入死信
+func (q *notificationQueue) process(ctx context.Context, job *notificationJob) {
+	notificationQueueWait.WithLabelValues(job.Type).Observe(time.Since(job.readyAt).Seconds())
+	job.Attempts++
+
+	// 以发起通知的请求作为父 span，每次重试都出现在原链路中
+	parent := otel.GetTextMapPropagator().Extract(context.WithoutCancel(ctx), propagation.MapCarrier(job.TraceContext))
+	tracer := otel.Tracer("notification-service")
+	sctx, span := tracer.Start(parent, "notification-job "+job.Type, trace.WithAttributes(
+		attribute.Int("notification.id", job.ID),
+		attribute.String("notification.type", job.Type),
+		attribute.Int("notification.attempt", job.Attempts),
+	))
+	defer span.End()
+
+	sctx, cancel := context.WithTimeout(sctx, notificationSendTimeout)
+	defer cancel()
+	start := time.Now()
+	provider, err := q.notifier.Send(sctx, job.Type, job.Message)
+	attempt := DeliveryAttempt{
+		Attempt:    job.Attempts,
+		Provider:   provider,
+		StartedAt:  start.UTC(),
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	if err == nil {
+		q.recordAttempt(sctx, span, job, attempt, statusSent)
+		q.finish(job)
+		notificationJobsTotal.WithLabelValues(job.Type, "delivered").Inc()
+		span.SetAttributes(attribute.String("result", "delivered"))
+		return
+	}
+
+	job.LastError = err.Error()
+	attempt.Error = err.Error()
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+
+	// 缺少收件人或渠道被关闭时重试也不会成功
+	if errors.Is(err, errNoRecipient) || errors.Is(err, errChannelDisabled) || job.Attempts >= q.maxAttempts {
+		q.recordAttempt(sctx, span, job, attempt, statusFailed)
+		q.deadLetter(sctx, span, job)
+		notificationJobsTotal.WithLabelValues(job.Type, "dead_lettered").Inc()
+		span.SetAttributes(attribute.String("result", "dead_lettered"))
+		log.Printf("Notification %d dead-lettered after %d attempts: %v", job.ID, job.Attempts, err)
+		return
+	}
+
+	q.recordAttempt(sctx, span, job, attempt, statusQueued)
+	delay := q.backoff(job.Attempts)
+	notificationJobsTotal.WithLabelValues(job.Type, "retried").Inc()
+	span.SetAttributes(
+		attribute.String("result", "retried"),
+		attribute.Int64("retry_in_ms", delay.Milliseconds()),
+	)
+	q.schedule(job, delay)
+}
+
+// recordAttempt 保存发送尝试；保存失败只记录日志，不影响发送和重试
+func (q *notificationQueue) recordAttempt(ctx context.Context, span trace.Span, job *notificationJob, attempt DeliveryAttempt, status string) {
+	// 发送超时后仍然要保存结果
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if err := q.store.RecordAttempt(ctx, job.ID, attempt, status); err != nil {
+		span.AddEvent("record attempt failed", trace.WithAttributes(attribute.String("error", err.Error())))
+		log.Printf("Failed to record attempt %d of notification %d: %v", attempt.Attempt, job.ID, err)
+	}
+}
+
+// schedule 在 delay 后把任务放入就绪队列，队列已满时等待
+func (q *notificationQueue) schedule(job *notificationJob, delay time.Duration) {
+	time.AfterFunc(delay, func() {
+		job.readyAt = time.Now()
+		select {
+		case q.ready <- job:
+		case <-q.done:
+		}
+	})
+}
+
+func (q *notificationQueue) backoff(attempts int) time.Duration {
+	return exponentialBackoff(q.retryBase, attempts)
+}
+
+// exponentialBackoff 第 attempts 次失败后的等待时间：base、2×、4×……，最长 1 分钟
+func exponentialBackoff(base time.Duration, attempts int) time.Duration {
+	d := base
+	for i := 1; i < attempts && d < time.Minute; i++ {
+		d *= 2
+	}
+	if d > time.Minute {
+		d = time.Minute
+	}
+	return d
+}
+
+func (q *notificationQueue) finish(job *notificationJob) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.pending, job.ID)
+}
+
+// deadLetter 把任务保存为死信；保存失败只记录日志，通知的 failed 状态已经保存
+func (q *notificationQueue) deadLetter(ctx context.Context, span trace.Span, job *notificationJob) {
+	q.finish(job)
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	dl := deadLetter{Job: *job, Error: job.LastError, FailedAt: time.Now().UTC()}
+	if err := q.store.SaveDeadLetter(ctx, dl); err != nil {
+		span.AddEvent("save dead letter failed", trace.WithAttributes(attribute.String("error", err.Error())))
+		log.Printf("Failed to save dead letter for notification %d: %v", job.ID, err)
+	}
+}
+
+// Depth 尚未结束的任务数，包括等待重试的任务
+func (q *notificationQueue) Depth() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+// OldestAge 最早入队且尚未结束的任务已等待的时间
+func (q *notificationQueue) OldestAge() time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var oldest time.Time
+	for _, t := range q.pending {
+		if oldest.IsZero() || t.Before(oldest) {
+			oldest = t
+		}
+	}
+	if oldest.IsZero() {
+		return 0
+	}
+	return time.Since(oldest)
+}
+
+// DeadLetters 按 ID 顺序返回所有死信
+func (q *notificationQueue) DeadLetters(ctx context.Context) ([]deadLetter, error) {
+	return q.store.DeadLetters(ctx)
+}
+
+// Replay 把死信重新放回队列，重试次数清零，之后的发送以发起重放的请求作为父 span。
+// 队列已满时死信保持不变
+func (q *notificationQueue) Replay(ctx context.Context, id int) (*notificationJob, error) {
+	// 先从存储中取出死信，避免并发重放同一条死信
+	dl, err := q.store.TakeDeadLetter(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	restore := func() {
+		if err := q.store.SaveDeadLetter(context.WithoutCancel(ctx), dl); err != nil {
+			log.Printf("Failed to restore dead letter for notification %d: %v", id, err)
+		}
+	}
+
+	job := dl.Job
+	job.Attempts = 0
+	job.LastError = ""
+	job.EnqueuedAt = time.Now().UTC()
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	job.TraceContext = carrier
+	snapshot := job
+	// 先恢复状态再入队，否则 worker 的发送结果可能被覆盖
+	if err := q.store.UpdateStatus(ctx, id, statusQueued, ""); err != nil {
+		restore()
+		return nil, err
+	}
+	if err := q.push(&job); err != nil {
+		restore()
+		if serr := q.store.UpdateStatus(ctx, id, statusFailed, dl.Error); serr != nil {
+			log.Printf("Failed to mark notification %d as failed: %v", id, serr)
+		}
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// getQueueStats 处理 GET /admin/queue
+func getQueueStats(c *gin.Context) {
+	list, err := jobQueue.DeadLetters(c.Request.Context())
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{
+		"depth":              jobQueue.Depth(),
+		"oldest_age_seconds": jobQueue.OldestAge().Seconds(),
+		"dead_letters":       len(list),
+	})
+}
+
+// listDeadLetters 处理 GET /admin/dead-letters
+func listDeadLetters(c *gin.Context) {
+	list, err := jobQueue.DeadLetters(c.Request.Context())
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"dead_letters": list, "total": len(list)})
+}
+
+// replayDeadLetter 处理 POST /admin/dead-letters/:id/replay，重新发送一条死信
+func replayDeadLetter(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid notification ID"})
+		return
+	}
+
+	job, err := jobQueue.Replay(c.Request.Context(), id)
+	switch {
+	case errors.Is(err, errDeadLetterNotFound):
+		c.JSON(404, gin.H{"error": err.Error()})
+	case errors.Is(err, errQueueFull):
+		c.JSON(503, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(500, gin.H{"error": err.Error()})
+	default:
+		trace.SpanFromContext(c.Request.Context()).SetAttributes(attribute.Int("notification.id", job.ID))
+		respondQueued(c, job)
+	}
+}
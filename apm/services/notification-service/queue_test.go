@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// flakyProvider 前 failures 次发送失败，之后成功
+type flakyProvider struct {
+	mu       sync.Mutex
+	failures int
+	calls    int
+}
+
+func (p *flakyProvider) Name() string { return "flaky" }
+
+func (p *flakyProvider) Send(context.Context, Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	if p.calls <= p.failures {
+		return errors.New("gateway unavailable")
+	}
+	return nil
+}
+
+func (p *flakyProvider) Calls() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.calls
+}
+
+func newTestQueue(t *testing.T, p Provider, size, maxAttempts int) *notificationQueue {
+	t.Helper()
+	q := newNotificationQueue(newNotifier(map[string]Provider{channelPush: p}, []string{channelPush}), newMemoryNotificationStore(), size, maxAttempts, time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	q.Start(ctx, 2)
+	return q
+}
+
+// deadLetters 返回队列当前的死信
+func deadLetters(t *testing.T, q *notificationQueue) []deadLetter {
+	t.Helper()
+	list, err := q.DeadLetters(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
+
+// waitFor 等待条件成立，超时则失败
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestQueueRetriesUntilDelivered(t *testing.T) {
+	p := &flakyProvider{failures: 2}
+	q := newTestQueue(t, p, 10, 5)
+	delivered := testutil.ToFloat64(notificationJobsTotal.WithLabelValues(channelPush, "delivered"))
+	retried := testutil.ToFloat64(notificationJobsTotal.WithLabelValues(channelPush, "retried"))
+
+	if _, err := q.Enqueue(context.Background(), channelPush, Message{UserID: 1, Body: "hi"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "delivery", func() bool { return p.Calls() == 3 && q.Depth() == 0 })
+
+	if n := len(deadLetters(t, q)); n != 0 {
+		t.Errorf("%d dead letters, want 0", n)
+	}
+	if got := testutil.ToFloat64(notificationJobsTotal.WithLabelValues(channelPush, "delivered")) - delivered; got != 1 {
+		t.Errorf("delivered +%v, want +1", got)
+	}
+	if got := testutil.ToFloat64(notificationJobsTotal.WithLabelValues(channelPush, "retried")) - retried; got != 2 {
+		t.Errorf("retried +%v, want +2", got)
+	}
+}
+
+func TestQueueDeadLettersAndReplays(t *testing.T) {
+	p := &flakyProvider{failures: 3}
+	q := newTestQueue(t, p, 10, 3)
+
+	job, err := q.Enqueue(context.Background(), channelPush, Message{UserID: 1, Body: "hi"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "dead letter", func() bool { return len(deadLetters(t, q)) == 1 })
+
+	dl := deadLetters(t, q)[0]
+	if dl.Job.ID != job.ID || dl.Job.Attempts != 3 || dl.Error != "gateway unavailable" {
+		t.Errorf("dead letter %+v", dl)
+	}
+	if q.Depth() != 0 {
+		t.Errorf("depth %d after dead-lettering, want 0", q.Depth())
+	}
+
+	// 网关恢复后重放
+	replayed, err := q.Replay(context.Background(), job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed.ID != job.ID || replayed.Attempts != 0 {
+		t.Errorf("replayed %+v", replayed)
+	}
+	waitFor(t, "replayed delivery", func() bool { return p.Calls() == 4 && q.Depth() == 0 })
+	if n := len(deadLetters(t, q)); n != 0 {
+		t.Errorf("%d dead letters after replay, want 0", n)
+	}
+
+	if _, err := q.Replay(context.Background(), job.ID); !errors.Is(err, errDeadLetterNotFound) {
+		t.Errorf("second replay: got %v, want errDeadLetterNotFound", err)
+	}
+}
+
+func TestQueueDoesNotRetryMissingRecipient(t *testing.T) {
+	q := newNotificationQueue(newNotifier(map[string]Provider{channelSMS: newSMSGatewayProvider("http://127.0.0.1:0", "")}, []string{channelSMS}), newMemoryNotificationStore(), 10, 5, time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	q.Start(ctx, 1)
+
+	if _, err := q.Enqueue(context.Background(), channelSMS, Message{Body: "hi"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "dead letter", func() bool { return len(deadLetters(t, q)) == 1 })
+	if dl := deadLetters(t, q)[0]; dl.Job.Attempts != 1 {
+		t.Errorf("attempts %d, want 1", dl.Job.Attempts)
+	}
+}
+
+func TestQueueRejectsWhenFull(t *testing.T) {
+	// 不启动 worker，队列中的任务不会被取走
+	q := newNotificationQueue(newNotifier(map[string]Provider{channelPush: &flakyProvider{}}, []string{channelPush}), newMemoryNotificationStore(), 1, 5, time.Millisecond)
+
+	if _, err := q.Enqueue(context.Background(), channelPush, Message{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Enqueue(context.Background(), channelPush, Message{}, nil); !errors.Is(err, errQueueFull) {
+		t.Errorf("got %v, want errQueueFull", err)
+	}
+	if _, err := q.Enqueue(context.Background(), channelEmail, Message{}, nil); !errors.Is(err, errChannelDisabled) {
+		t.Errorf("got %v, want errChannelDisabled", err)
+	}
+	if q.Depth() != 1 || q.OldestAge() <= 0 {
+		t.Errorf("depth %d, oldest age %s", q.Depth(), q.OldestAge())
+	}
+}
+
+func TestQueueBackoff(t *testing.T) {
+	q := newNotificationQueue(nil, nil, 1, 5, time.Second)
+	cases := map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 7: time.Minute, 30: time.Minute}
+	for attempts, want := range cases {
+		if got := q.backoff(attempts); got != want {
+			t.Errorf("backoff(%d) = %s, want %s", attempts, got, want)
+		}
+	}
+}
+
+func TestDeadLettersSurviveRestart(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "notifications.db")
+	store, err := newSQLiteNotificationStore(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failing := newNotificationQueue(newNotifier(map[string]Provider{channelPush: &flakyProvider{failures: 10}}, []string{channelPush}), store, 10, 2, time.Millisecond)
+	qctx, stop := context.WithCancel(ctx)
+	failing.Start(qctx, 1)
+	job, err := failing.Enqueue(ctx, channelPush, Message{UserID: 1, Body: "hi"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "dead letter", func() bool { return len(deadLetters(t, failing)) == 1 })
+	stop()
+	store.Close()
+
+	// 重启后死信仍然可以查看和重放
+	store, err = newSQLiteNotificationStore(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	p := &flakyProvider{}
+	q := newNotificationQueue(newNotifier(map[string]Provider{channelPush: p}, []string{channelPush}), store, 10, 2, time.Millisecond)
+	qctx, stop = context.WithCancel(ctx)
+	defer stop()
+	q.Start(qctx, 1)
+
+	list := deadLetters(t, q)
+	if len(list) != 1 || list[0].Job.ID != job.ID || list[0].Job.Message.Body != "hi" || list[0].Error != "gateway unavailable" {
+		t.Fatalf("dead letters after restart %+v", list)
+	}
+	if _, err := q.Replay(ctx, job.ID); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "replayed delivery", func() bool {
+		n, err := store.Get(ctx, job.ID)
+		return err == nil && n.Status == statusSent
+	})
+	if p.Calls() != 1 || len(deadLetters(t, q)) != 0 {
+		t.Errorf("calls %d, dead letters %d", p.Calls(), len(deadLetters(t, q)))
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// 通知的投递状态：queued（等待发送或重试）-> sent（渠道已接收）-> delivered / failed（回执或重试耗尽）
+const (
+	statusQueued    = "queued"
+	statusSent      = "sent"
+	statusDelivered = "delivered"
+	statusFailed    = "failed"
+)
+
+var (
+	// errNotificationNotFound 通知不存在
+	errNotificationNotFound = errors.New("notification not found")
+	// errReceiptConflict 通知已处于另一个终态，回执不能改变它
+	errReceiptConflict = errors.New("notification already has a different final status")
+	// errPreferencesNotFound 用户没有设置通知偏好
+	errPreferencesNotFound = errors.New("preferences not found")
+)
+
+// DeliveryAttempt 一次发送尝试
+type DeliveryAttempt struct {
+	Attempt    int       `json:"attempt"`
+	Provider   string    `json:"provider,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+// DeliveryReceipt 渠道回调上报的投递结果
+type DeliveryReceipt struct {
+	NotificationID int    `json:"notification_id"`
+	Provider       string `json:"provider,omitempty"`
+	// Status delivered 或 failed
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// Timestamp 渠道记录的投递时间，未提供时使用收到回执的时间
+	Timestamp  time.Time `json:"timestamp"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// Notification 一条通知的投递记录
+type Notification struct {
+	ID        int    `json:"id"`
+	UserID    int    `json:"user_id"`
+	Type      string `json:"type"`
+	Status    string `json:"status"`
+	To        string `json:"to,omitempty"`
+	Subject   string `json:"subject,omitempty"`
+	Template  string `json:"template,omitempty"`
+	Locale    string `json:"locale,omitempty"`
+	Provider  string `json:"provider,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+
+	Attempts []DeliveryAttempt `json:"attempts"`
+	Receipt  *DeliveryReceipt  `json:"receipt,omitempty"`
+
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	NotBefore   *time.Time `json:"not_before,omitempty"`
+	SentAt      *time.Time `json:"sent_at,omitempty"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+
+	// Message 和 TraceContext 用于重启后恢复尚未发送的任务，不对外返回
+	Message      Message           `json:"-"`
+	TraceContext map[string]string `json:"-"`
+}
+
+// NotificationStore 通知投递记录、死信、用户通知偏好、webhook 订阅和已处理事件 ID 的存储，实现必须可以被并发调用
+type NotificationStore interface {
+	// Create 保存新通知并回填 ID，ID 同时作为队列任务的 ID
+	Create(ctx context.Context, n *Notification) error
+	// Get 按 ID 查询，不存在时返回 errNotificationNotFound
+	Get(ctx context.Context, id int) (Notification, error)
+	// ListByUser 按 ID 倒序返回用户最近的 limit 条通知
+	ListByUser(ctx context.Context, userID, limit int) ([]Notification, error)
+	// RecordAttempt 追加一次发送尝试，并把状态改为 status（sent、重试时的 queued 或 failed）
+	RecordAttempt(ctx context.Context, id int, attempt DeliveryAttempt, status string) error
+	// UpdateStatus 不经过发送直接改变状态，例如入队失败或重放死信
+	UpdateStatus(ctx context.Context, id int, status, lastError string) error
+	// ApplyReceipt 根据回执更新状态并返回更新后的通知
+	ApplyReceipt(ctx context.Context, r DeliveryReceipt) (Notification, error)
+	// Pending 按 ID 顺序返回所有 queued 状态的通知
+	Pending(ctx context.Context) ([]Notification, error)
+
+	// GetPreferences 返回用户的通知偏好，没有设置时返回 errPreferencesNotFound
+	GetPreferences(ctx context.Context, userID int) (Preferences, error)
+	// PutPreferences 保存用户的通知偏好，已存在时覆盖
+	PutPreferences(ctx context.Context, p Preferences) error
+	// DeletePreferences 删除用户的通知偏好，没有设置时不报错
+	DeletePreferences(ctx context.Context, userID int) error
+
+	// SaveDeadLetter 保存一条死信，同一通知已有死信时覆盖
+	SaveDeadLetter(ctx context.Context, dl deadLetter) error
+	// DeadLetters 按通知 ID 顺序返回所有死信
+	DeadLetters(ctx context.Context) ([]deadLetter, error)
+	// TakeDeadLetter 删除并返回一条死信，不存在时返回 errDeadLetterNotFound；
+	// 并发取同一条死信时只有一个调用成功
+	TakeDeadLetter(ctx context.Context, id int) (deadLetter, error)
+
+	// CreateSubscription 保存新的 webhook 订阅，包括密钥
+	CreateSubscription(ctx context.Context, sub Subscription) error
+	// GetSubscription 按 ID 查询订阅，不存在时返回 errSubscriptionNotFound
+	GetSubscription(ctx context.Context, id string) (Subscription, error)
+	// ListSubscriptions 按创建时间返回所有订阅
+	ListSubscriptions(ctx context.Context) ([]Subscription, error)
+	// UpdateSubscription 读出订阅、用 fn 修改后写回并返回修改后的订阅，读写之间不会插入其他修改
+	UpdateSubscription(ctx context.Context, id string, fn func(sub *Subscription) error) (Subscription, error)
+	// DeleteSubscription 删除订阅，不存在时返回 errSubscriptionNotFound
+	DeleteSubscription(ctx context.Context, id string) error
+
+	// BeginEvent 登记开始处理事件 id：已处理且未过期时返回 duplicate，另一次投递正在处理时返回 busy，
+	// 否则记录为处理中直到 leaseUntil，处理它的进程崩溃后事件可以被重新处理
+	BeginEvent(ctx context.Context, id string, now, leaseUntil time.Time) (duplicate, busy bool, err error)
+	// FinishEvent 结束处理：成功时把事件 ID 保留到 expiresAt，失败时删除，事件可以被重新投递
+	FinishEvent(ctx context.Context, id string, ok bool, expiresAt time.Time) error
+	// DeleteExpiredEvents 删除 now 之前过期的事件 ID，返回删除的数量
+	DeleteExpiredEvents(ctx context.Context, now time.Time) (int, error)
+
+	Close() error
+}
+
+// newNotificationStore 根据 NOTIFICATION_STORE 选择存储：memory（默认）或 sqlite，
+// sqlite 的数据库文件由 NOTIFICATION_DB_PATH 指定
+func newNotificationStore() (NotificationStore, error) {
+	switch store := os.Getenv("NOTIFICATION_STORE"); store {
+	case "", "memory":
+		return newMemoryNotificationStore(), nil
+	case "sqlite":
+		path := os.Getenv("NOTIFICATION_DB_PATH")
+		if path == "" {
+			path = "/app/data/notifications.db"
+		}
+		return newSQLiteNotificationStore(context.Background(), path)
+	default:
+		return nil, fmt.Errorf("unknown NOTIFICATION_STORE %q", store)
+	}
+}
+
+// 以下状态迁移由两种存储共用
+
+// recordAttempt 追加发送尝试。已收到回执的通知保持回执的状态，回执可能先于发送结果到达
+func recordAttempt(n *Notification, a DeliveryAttempt, status string, now time.Time) {
+	n.Attempts = append(n.Attempts, a)
+	n.Provider = a.Provider
+	n.LastError = a.Error
+	n.UpdatedAt = now
+	if n.Receipt != nil {
+		return
+	}
+	n.Status = status
+	if status == statusSent {
+		n.SentAt = &now
+	}
+}
+
+func updateStatus(n *Notification, status, lastError string, now time.Time) {
+	n.Status = status
+	n.LastError = lastError
+	n.UpdatedAt = now
+	if status == statusQueued {
+		// 重放的通知重新开始，旧的回执不再有效
+		n.Receipt = nil
+		n.DeliveredAt = nil
+	}
+}
+
+// applyReceipt 回执把 queued 或 sent 的通知改为 delivered 或 failed；
+// 重复的回执不改变记录，与当前终态矛盾的回执返回 errReceiptConflict
+func applyReceipt(n *Notification, r DeliveryReceipt) error {
+	if n.Status == statusDelivered || n.Status == statusFailed {
+		if n.Status == r.Status {
+			return nil
+		}
+		return fmt.Errorf("%w: %s", errReceiptConflict, n.Status)
+	}
+	n.Status = r.Status
+	n.Receipt = &r
+	n.UpdatedAt = r.ReceivedAt
+	if r.Status == statusDelivered {
+		n.DeliveredAt = &r.Timestamp
+	} else {
+		n.LastError = r.Error
+	}
+	return nil
+}
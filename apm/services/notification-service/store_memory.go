@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryNotificationStore 进程内存储，重启后数据丢失
+type memoryNotificationStore struct {
+	mu            sync.RWMutex
+	notifications map[int]*Notification
+	nextID        int
+	preferences   map[int]Preferences
+	deadLetters   map[int]deadLetter
+	subscriptions map[string]Subscription
+	events        map[string]processedEvent
+}
+
+// processedEvent 已处理或正在处理的事件
+type processedEvent struct {
+	processed bool
+	expires   time.Time
+}
+
+func newMemoryNotificationStore() *memoryNotificationStore {
+	return &memoryNotificationStore{
+		notifications: make(map[int]*Notification),
+		nextID:        1,
+		preferences:   make(map[int]Preferences),
+		deadLetters:   make(map[int]deadLetter),
+		subscriptions: make(map[string]Subscription),
+		events:        make(map[string]processedEvent),
+	}
+}
+
+// clone 返回不与存储共享切片的副本
+func clone(n *Notification) Notification {
+	c := *n
+	c.Attempts = append([]DeliveryAttempt{}, n.Attempts...)
+	return c
+}
+
+func (s *memoryNotificationStore) Create(_ context.Context, n *Notification) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n.ID = s.nextID
+	s.nextID++
+	stored := clone(n)
+	s.notifications[n.ID] = &stored
+	return nil
+}
+
+func (s *memoryNotificationStore) Get(_ context.Context, id int) (Notification, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	n, ok := s.notifications[id]
+	if !ok {
+		return Notification{}, errNotificationNotFound
+	}
+	return clone(n), nil
+}
+
+func (s *memoryNotificationStore) ListByUser(_ context.Context, userID, limit int) ([]Notification, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := []Notification{}
+	for _, n := range s.notifications {
+		if n.UserID == userID {
+			list = append(list, clone(n))
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID > list[j].ID })
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+	return list, nil
+}
+
+func (s *memoryNotificationStore) RecordAttempt(_ context.Context, id int, attempt DeliveryAttempt, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, ok := s.notifications[id]
+	if !ok {
+		return errNotificationNotFound
+	}
+	recordAttempt(n, attempt, status, time.Now().UTC())
+	return nil
+}
+
+func (s *memoryNotificationStore) UpdateStatus(_ context.Context, id int, status, lastError string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, ok := s.notifications[id]
+	if !ok {
+		return errNotificationNotFound
+	}
+	updateStatus(n, status, lastError, time.Now().UTC())
+	return nil
+}
+
+func (s *memoryNotificationStore) ApplyReceipt(_ context.Context, r DeliveryReceipt) (Notification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, ok := s.notifications[r.NotificationID]
+	if !ok {
+		return Notification{}, errNotificationNotFound
+	}
+	if err := applyReceipt(n, r); err != nil {
+		return Notification{}, err
+	}
+	return clone(n), nil
+}
+
+func (s *memoryNotificationStore) Pending(context.Context) ([]Notification, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var list []Notification
+	for _, n := range s.notifications {
+		if n.Status == statusQueued {
+			list = append(list, clone(n))
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+func (s *memoryNotificationStore) GetPreferences(_ context.Context, userID int) (Preferences, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p, ok := s.preferences[userID]
+	if !ok {
+		return Preferences{}, errPreferencesNotFound
+	}
+	return p, nil
+}
+
+func (s *memoryNotificationStore) PutPreferences(_ context.Context, p Preferences) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.preferences[p.UserID] = p
+	return nil
+}
+
+func (s *memoryNotificationStore) DeletePreferences(_ context.Context, userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.preferences, userID)
+	return nil
+}
+
+func (s *memoryNotificationStore) SaveDeadLetter(_ context.Context, dl deadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deadLetters[dl.Job.ID] = dl
+	return nil
+}
+
+func (s *memoryNotificationStore) DeadLetters(context.Context) ([]deadLetter, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]deadLetter, 0, len(s.deadLetters))
+	for _, dl := range s.deadLetters {
+		list = append(list, dl)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Job.ID < list[j].Job.ID })
+	return list, nil
+}
+
+func (s *memoryNotificationStore) TakeDeadLetter(_ context.Context, id int) (deadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dl, ok := s.deadLetters[id]
+	if !ok {
+		return deadLetter{}, errDeadLetterNotFound
+	}
+	delete(s.deadLetters, id)
+	return dl, nil
+}
+
+// cloneSubscription 返回不与存储共享事件列表的副本
+func cloneSubscription(sub Subscription) Subscription {
+	sub.Events = append([]string(nil), sub.Events...)
+	return sub
+}
+
+func (s *memoryNotificationStore) CreateSubscription(_ context.Context, sub Subscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.subscriptions[sub.ID]; ok {
+		return fmt.Errorf("webhook subscription %s already exists", sub.ID)
+	}
+	s.subscriptions[sub.ID] = cloneSubscription(sub)
+	return nil
+}
+
+func (s *memoryNotificationStore) GetSubscription(_ context.Context, id string) (Subscription, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sub, ok := s.subscriptions[id]
+	if !ok {
+		return Subscription{}, errSubscriptionNotFound
+	}
+	return cloneSubscription(sub), nil
+}
+
+func (s *memoryNotificationStore) ListSubscriptions(context.Context) ([]Subscription, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]Subscription, 0, len(s.subscriptions))
+	for _, sub := range s.subscriptions {
+		list = append(list, cloneSubscription(sub))
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.Before(list[j].CreatedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
+
+func (s *memoryNotificationStore) UpdateSubscription(_ context.Context, id string, fn func(sub *Subscription) error) (Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.subscriptions[id]
+	if !ok {
+		return Subscription{}, errSubscriptionNotFound
+	}
+	sub := cloneSubscription(stored)
+	if err := fn(&sub); err != nil {
+		return Subscription{}, err
+	}
+	s.subscriptions[id] = cloneSubscription(sub)
+	return sub, nil
+}
+
+func (s *memoryNotificationStore) DeleteSubscription(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.subscriptions[id]; !ok {
+		return errSubscriptionNotFound
+	}
+	delete(s.subscriptions, id)
+	return nil
+}
+
+func (s *memoryNotificationStore) Close() error {
+	return nil
+}
+
+func (s *memoryNotificationStore) BeginEvent(_ context.Context, id string, now, leaseUntil time.Time) (duplicate, busy bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ev, ok := s.events[id]; ok && now.Before(ev.expires) {
+		return ev.processed, !ev.processed, nil
+	}
+	s.events[id] = processedEvent{expires: leaseUntil}
+	return false, false, nil
+}
+
+func (s *memoryNotificationStore) FinishEvent(_ context.Context, id string, ok bool, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ok {
+		s.events[id] = processedEvent{processed: true, expires: expiresAt}
+	} else {
+		delete(s.events, id)
+	}
+	return nil
+}
+
+func (s *memoryNotificationStore) DeleteExpiredEvents(_ context.Context, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for id, ev := range s.events {
+		if !now.Before(ev.expires) {
+			delete(s.events, id)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,644 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	_ "modernc.org/sqlite"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// storeTimeFormat 固定宽度，按字符串排序即按时间排序
+const storeTimeFormat = "2006-01-02T15:04:05.000000Z"
+
+// sqliteNotificationStore 基于 SQLite 的持久化存储，启动时自动执行未应用的迁移
+type sqliteNotificationStore struct {
+	db     *sql.DB
+	dbName string
+}
+
+func newSQLiteNotificationStore(ctx context.Context, path string) (*sqliteNotificationStore, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", path)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite %s: %w", path, err)
+	}
+	// SQLite 同一时刻只允许一个写入者，使用单连接避免 SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+
+	s := &sqliteNotificationStore{db: db, dbName: filepath.Base(path)}
+	if err := s.migrate(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// migrate 按文件名顺序执行尚未应用的迁移，每个迁移在独立事务中完成
+func (s *sqliteNotificationStore) migrate(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    TEXT PRIMARY KEY,
+		applied_at TEXT NOT NULL
+	)`); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+
+	files, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		version := strings.TrimSuffix(filepath.Base(file), ".sql")
+
+		var applied int
+		if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM schema_migrations WHERE version = ?`, version).Scan(&applied); err != nil {
+			return fmt.Errorf("check migration %s: %w", version, err)
+		}
+		if applied > 0 {
+			continue
+		}
+
+		stmt, err := migrations.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, string(stmt)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("apply migration %s: %w", version, err)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`,
+			version, time.Now().UTC().Format(time.RFC3339)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("record migration %s: %w", version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("commit migration %s: %w", version, err)
+		}
+	}
+	return nil
+}
+
+// startSpan 为一次 notifications 表的数据库调用创建客户端 span，没有父 span 时不创建
+func (s *sqliteNotificationStore) startSpan(ctx context.Context, operation, statement string) (context.Context, trace.Span) {
+	return s.startTableSpan(ctx, "notifications", operation, statement)
+}
+
+func (s *sqliteNotificationStore) startTableSpan(ctx context.Context, table, operation, statement string) (context.Context, trace.Span) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+	tracer := otel.Tracer("notification-service")
+	return tracer.Start(ctx, operation+" "+table,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemSqlite,
+			semconv.DBName(s.dbName),
+			semconv.DBOperation(operation),
+			semconv.DBStatement(statement),
+		),
+	)
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, errNotificationNotFound) && !errors.Is(err, errReceiptConflict) && !errors.Is(err, errPreferencesNotFound) && !errors.Is(err, errDeadLetterNotFound) &&
+		!errors.Is(err, errSubscriptionNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func formatStoreTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(storeTimeFormat)
+}
+
+func parseStoreTime(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(storeTimeFormat, s)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+const insertNotificationSQL = `INSERT INTO notifications (user_id, type, status, recipient, subject, template, locale,
+	message, trace_context, created_at, updated_at, not_before) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+func (s *sqliteNotificationStore) Create(ctx context.Context, n *Notification) (err error) {
+	ctx, span := s.startSpan(ctx, "INSERT", insertNotificationSQL)
+	defer func() { endSpan(span, err) }()
+
+	message, err := json.Marshal(n.Message)
+	if err != nil {
+		return err
+	}
+	traceContext, err := json.Marshal(n.TraceContext)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, insertNotificationSQL, n.UserID, n.Type, n.Status, n.To, n.Subject, n.Template, n.Locale,
+		string(message), string(traceContext), formatStoreTime(&n.CreatedAt), formatStoreTime(&n.UpdatedAt), formatStoreTime(n.NotBefore))
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	n.ID = int(id)
+	return nil
+}
+
+const selectNotificationSQL = `SELECT id, user_id, type, status, recipient, subject, template, locale, provider, last_error,
+	attempts, receipt, message, trace_context, created_at, updated_at, not_before, sent_at, delivered_at FROM notifications`
+
+func scanNotification(row interface{ Scan(...interface{}) error }) (Notification, error) {
+	var n Notification
+	var attempts, receipt, message, traceContext string
+	var createdAt, updatedAt, notBefore, sentAt, deliveredAt string
+	if err := row.Scan(&n.ID, &n.UserID, &n.Type, &n.Status, &n.To, &n.Subject, &n.Template, &n.Locale, &n.Provider, &n.LastError,
+		&attempts, &receipt, &message, &traceContext, &createdAt, &updatedAt, &notBefore, &sentAt, &deliveredAt); err != nil {
+		return Notification{}, err
+	}
+
+	if err := json.Unmarshal([]byte(attempts), &n.Attempts); err != nil {
+		return Notification{}, fmt.Errorf("notification %d attempts: %w", n.ID, err)
+	}
+	if receipt != "" {
+		n.Receipt = &DeliveryReceipt{}
+		if err := json.Unmarshal([]byte(receipt), n.Receipt); err != nil {
+			return Notification{}, fmt.Errorf("notification %d receipt: %w", n.ID, err)
+		}
+	}
+	if err := json.Unmarshal([]byte(message), &n.Message); err != nil {
+		return Notification{}, fmt.Errorf("notification %d message: %w", n.ID, err)
+	}
+	if err := json.Unmarshal([]byte(traceContext), &n.TraceContext); err != nil {
+		return Notification{}, fmt.Errorf("notification %d trace context: %w", n.ID, err)
+	}
+
+	var created, updated *time.Time
+	times := []struct {
+		value string
+		dst   **time.Time
+	}{{createdAt, &created}, {updatedAt, &updated}, {notBefore, &n.NotBefore}, {sentAt, &n.SentAt}, {deliveredAt, &n.DeliveredAt}}
+	for _, t := range times {
+		parsed, err := parseStoreTime(t.value)
+		if err != nil {
+			return Notification{}, fmt.Errorf("notification %d: %w", n.ID, err)
+		}
+		*t.dst = parsed
+	}
+	if created == nil || updated == nil {
+		return Notification{}, fmt.Errorf("notification %d: missing timestamps", n.ID)
+	}
+	n.CreatedAt, n.UpdatedAt = *created, *updated
+	return n, nil
+}
+
+func (s *sqliteNotificationStore) Get(ctx context.Context, id int) (n Notification, err error) {
+	query := selectNotificationSQL + ` WHERE id = ?`
+	ctx, span := s.startSpan(ctx, "SELECT", query)
+	defer func() { endSpan(span, err) }()
+
+	n, err = scanNotification(s.db.QueryRowContext(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Notification{}, errNotificationNotFound
+	}
+	return n, err
+}
+
+func (s *sqliteNotificationStore) list(ctx context.Context, query string, args ...interface{}) ([]Notification, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Notification{}
+	for rows.Next() {
+		n, err := scanNotification(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, n)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqliteNotificationStore) ListByUser(ctx context.Context, userID, limit int) (list []Notification, err error) {
+	query := selectNotificationSQL + ` WHERE user_id = ? ORDER BY id DESC`
+	args := []interface{}{userID}
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+	ctx, span := s.startSpan(ctx, "SELECT", query)
+	defer func() { endSpan(span, err) }()
+
+	return s.list(ctx, query, args...)
+}
+
+func (s *sqliteNotificationStore) Pending(ctx context.Context) (list []Notification, err error) {
+	query := selectNotificationSQL + ` WHERE status = ? ORDER BY id`
+	ctx, span := s.startSpan(ctx, "SELECT", query)
+	defer func() { endSpan(span, err) }()
+
+	return s.list(ctx, query, statusQueued)
+}
+
+const updateNotificationSQL = `UPDATE notifications SET status = ?, provider = ?, last_error = ?, attempts = ?, receipt = ?,
+	updated_at = ?, sent_at = ?, delivered_at = ? WHERE id = ?`
+
+// modify 在事务中读出通知、用 fn 修改后写回；单连接保证读写之间不会插入其他写入
+func (s *sqliteNotificationStore) modify(ctx context.Context, id int, fn func(n *Notification) error) (n Notification, err error) {
+	ctx, span := s.startSpan(ctx, "UPDATE", updateNotificationSQL)
+	defer func() { endSpan(span, err) }()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Notification{}, err
+	}
+	defer tx.Rollback()
+
+	n, err = scanNotification(tx.QueryRowContext(ctx, selectNotificationSQL+` WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Notification{}, errNotificationNotFound
+	}
+	if err != nil {
+		return Notification{}, err
+	}
+	if err := fn(&n); err != nil {
+		return Notification{}, err
+	}
+
+	attempts, err := json.Marshal(n.Attempts)
+	if err != nil {
+		return Notification{}, err
+	}
+	receipt := ""
+	if n.Receipt != nil {
+		b, err := json.Marshal(n.Receipt)
+		if err != nil {
+			return Notification{}, err
+		}
+		receipt = string(b)
+	}
+	if _, err := tx.ExecContext(ctx, updateNotificationSQL, n.Status, n.Provider, n.LastError, string(attempts), receipt,
+		formatStoreTime(&n.UpdatedAt), formatStoreTime(n.SentAt), formatStoreTime(n.DeliveredAt), id); err != nil {
+		return Notification{}, err
+	}
+	return n, tx.Commit()
+}
+
+func (s *sqliteNotificationStore) RecordAttempt(ctx context.Context, id int, attempt DeliveryAttempt, status string) error {
+	_, err := s.modify(ctx, id, func(n *Notification) error {
+		recordAttempt(n, attempt, status, time.Now().UTC())
+		return nil
+	})
+	return err
+}
+
+func (s *sqliteNotificationStore) UpdateStatus(ctx context.Context, id int, status, lastError string) error {
+	_, err := s.modify(ctx, id, func(n *Notification) error {
+		updateStatus(n, status, lastError, time.Now().UTC())
+		return nil
+	})
+	return err
+}
+
+func (s *sqliteNotificationStore) ApplyReceipt(ctx context.Context, r DeliveryReceipt) (Notification, error) {
+	return s.modify(ctx, r.NotificationID, func(n *Notification) error {
+		return applyReceipt(n, r)
+	})
+}
+
+const selectPreferencesSQL = `SELECT preferences FROM preferences WHERE user_id = ?`
+
+func (s *sqliteNotificationStore) GetPreferences(ctx context.Context, userID int) (p Preferences, err error) {
+	ctx, span := s.startTableSpan(ctx, "preferences", "SELECT", selectPreferencesSQL)
+	defer func() { endSpan(span, err) }()
+
+	var data string
+	err = s.db.QueryRowContext(ctx, selectPreferencesSQL, userID).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Preferences{}, errPreferencesNotFound
+	}
+	if err != nil {
+		return Preferences{}, err
+	}
+	if err := json.Unmarshal([]byte(data), &p); err != nil {
+		return Preferences{}, fmt.Errorf("preferences of user %d: %w", userID, err)
+	}
+	return p, nil
+}
+
+const upsertPreferencesSQL = `INSERT INTO preferences (user_id, preferences, updated_at) VALUES (?, ?, ?)
+	ON CONFLICT (user_id) DO UPDATE SET preferences = excluded.preferences, updated_at = excluded.updated_at`
+
+func (s *sqliteNotificationStore) PutPreferences(ctx context.Context, p Preferences) (err error) {
+	ctx, span := s.startTableSpan(ctx, "preferences", "INSERT", upsertPreferencesSQL)
+	defer func() { endSpan(span, err) }()
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, upsertPreferencesSQL, p.UserID, string(data), p.UpdatedAt)
+	return err
+}
+
+const deletePreferencesSQL = `DELETE FROM preferences WHERE user_id = ?`
+
+func (s *sqliteNotificationStore) DeletePreferences(ctx context.Context, userID int) (err error) {
+	ctx, span := s.startTableSpan(ctx, "preferences", "DELETE", deletePreferencesSQL)
+	defer func() { endSpan(span, err) }()
+
+	_, err = s.db.ExecContext(ctx, deletePreferencesSQL, userID)
+	return err
+}
+
+const saveDeadLetterSQL = `INSERT INTO dead_letters (notification_id, job, error, failed_at) VALUES (?, ?, ?, ?)
+	ON CONFLICT (notification_id) DO UPDATE SET job = excluded.job, error = excluded.error, failed_at = excluded.failed_at`
+
+func (s *sqliteNotificationStore) SaveDeadLetter(ctx context.Context, dl deadLetter) (err error) {
+	ctx, span := s.startTableSpan(ctx, "dead_letters", "INSERT", saveDeadLetterSQL)
+	defer func() { endSpan(span, err) }()
+
+	job, err := json.Marshal(dl.Job)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, saveDeadLetterSQL, dl.Job.ID, string(job), dl.Error, formatStoreTime(&dl.FailedAt))
+	return err
+}
+
+func scanDeadLetter(row interface{ Scan(...interface{}) error }) (deadLetter, error) {
+	var dl deadLetter
+	var job, failedAt string
+	if err := row.Scan(&job, &dl.Error, &failedAt); err != nil {
+		return deadLetter{}, err
+	}
+	if err := json.Unmarshal([]byte(job), &dl.Job); err != nil {
+		return deadLetter{}, fmt.Errorf("dead letter job: %w", err)
+	}
+	t, err := parseStoreTime(failedAt)
+	if err != nil || t == nil {
+		return deadLetter{}, fmt.Errorf("dead letter %d: invalid failed_at %q", dl.Job.ID, failedAt)
+	}
+	dl.FailedAt = *t
+	return dl, nil
+}
+
+const selectDeadLettersSQL = `SELECT job, error, failed_at FROM dead_letters ORDER BY notification_id`
+
+func (s *sqliteNotificationStore) DeadLetters(ctx context.Context) (list []deadLetter, err error) {
+	ctx, span := s.startTableSpan(ctx, "dead_letters", "SELECT", selectDeadLettersSQL)
+	defer func() { endSpan(span, err) }()
+
+	rows, err := s.db.QueryContext(ctx, selectDeadLettersSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list = []deadLetter{}
+	for rows.Next() {
+		dl, err := scanDeadLetter(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, dl)
+	}
+	return list, rows.Err()
+}
+
+// takeDeadLetterSQL 删除和读取在同一条语句中完成，并发重放同一条死信时只有一个能取到
+const takeDeadLetterSQL = `DELETE FROM dead_letters WHERE notification_id = ? RETURNING job, error, failed_at`
+
+func (s *sqliteNotificationStore) TakeDeadLetter(ctx context.Context, id int) (dl deadLetter, err error) {
+	ctx, span := s.startTableSpan(ctx, "dead_letters", "DELETE", takeDeadLetterSQL)
+	defer func() { endSpan(span, err) }()
+
+	dl, err = scanDeadLetter(s.db.QueryRowContext(ctx, takeDeadLetterSQL, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return deadLetter{}, errDeadLetterNotFound
+	}
+	return dl, err
+}
+
+const subscriptionColumns = `id, url, events, secret, status, consecutive_failures, disabled_reason, last_error, last_delivery_at, created_at`
+
+const insertSubscriptionSQL = `INSERT INTO webhook_subscriptions (` + subscriptionColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+func (s *sqliteNotificationStore) CreateSubscription(ctx context.Context, sub Subscription) (err error) {
+	ctx, span := s.startTableSpan(ctx, "webhook_subscriptions", "INSERT", insertSubscriptionSQL)
+	defer func() { endSpan(span, err) }()
+
+	events, err := json.Marshal(sub.Events)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, insertSubscriptionSQL, sub.ID, sub.URL, string(events), sub.Secret, sub.Status,
+		sub.ConsecutiveFailures, sub.DisabledReason, sub.LastError, formatStoreTime(sub.LastDeliveryAt), formatStoreTime(&sub.CreatedAt))
+	return err
+}
+
+func scanSubscription(row interface{ Scan(...interface{}) error }) (Subscription, error) {
+	var sub Subscription
+	var events, lastDeliveryAt, createdAt string
+	if err := row.Scan(&sub.ID, &sub.URL, &events, &sub.Secret, &sub.Status, &sub.ConsecutiveFailures,
+		&sub.DisabledReason, &sub.LastError, &lastDeliveryAt, &createdAt); err != nil {
+		return Subscription{}, err
+	}
+	if err := json.Unmarshal([]byte(events), &sub.Events); err != nil {
+		return Subscription{}, fmt.Errorf("webhook subscription %s events: %w", sub.ID, err)
+	}
+	var err error
+	if sub.LastDeliveryAt, err = parseStoreTime(lastDeliveryAt); err != nil {
+		return Subscription{}, fmt.Errorf("webhook subscription %s: invalid last_delivery_at %q", sub.ID, lastDeliveryAt)
+	}
+	t, err := parseStoreTime(createdAt)
+	if err != nil || t == nil {
+		return Subscription{}, fmt.Errorf("webhook subscription %s: invalid created_at %q", sub.ID, createdAt)
+	}
+	sub.CreatedAt = *t
+	return sub, nil
+}
+
+const selectSubscriptionSQL = `SELECT ` + subscriptionColumns + ` FROM webhook_subscriptions WHERE id = ?`
+
+func (s *sqliteNotificationStore) GetSubscription(ctx context.Context, id string) (sub Subscription, err error) {
+	ctx, span := s.startTableSpan(ctx, "webhook_subscriptions", "SELECT", selectSubscriptionSQL)
+	defer func() { endSpan(span, err) }()
+
+	sub, err = scanSubscription(s.db.QueryRowContext(ctx, selectSubscriptionSQL, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Subscription{}, errSubscriptionNotFound
+	}
+	return sub, err
+}
+
+const selectSubscriptionsSQL = `SELECT ` + subscriptionColumns + ` FROM webhook_subscriptions ORDER BY created_at, id`
+
+func (s *sqliteNotificationStore) ListSubscriptions(ctx context.Context) (list []Subscription, err error) {
+	ctx, span := s.startTableSpan(ctx, "webhook_subscriptions", "SELECT", selectSubscriptionsSQL)
+	defer func() { endSpan(span, err) }()
+
+	rows, err := s.db.QueryContext(ctx, selectSubscriptionsSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list = []Subscription{}
+	for rows.Next() {
+		sub, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, sub)
+	}
+	return list, rows.Err()
+}
+
+const updateSubscriptionSQL = `UPDATE webhook_subscriptions SET status = ?, consecutive_failures = ?, disabled_reason = ?,
+	last_error = ?, last_delivery_at = ? WHERE id = ?`
+
+// UpdateSubscription 与 modify 相同，在事务中读出、修改、写回。URL、事件和密钥注册后不可修改
+func (s *sqliteNotificationStore) UpdateSubscription(ctx context.Context, id string, fn func(sub *Subscription) error) (sub Subscription, err error) {
+	ctx, span := s.startTableSpan(ctx, "webhook_subscriptions", "UPDATE", updateSubscriptionSQL)
+	defer func() { endSpan(span, err) }()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Subscription{}, err
+	}
+	defer tx.Rollback()
+
+	sub, err = scanSubscription(tx.QueryRowContext(ctx, selectSubscriptionSQL, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Subscription{}, errSubscriptionNotFound
+	}
+	if err != nil {
+		return Subscription{}, err
+	}
+	if err := fn(&sub); err != nil {
+		return Subscription{}, err
+	}
+	if _, err := tx.ExecContext(ctx, updateSubscriptionSQL, sub.Status, sub.ConsecutiveFailures, sub.DisabledReason,
+		sub.LastError, formatStoreTime(sub.LastDeliveryAt), id); err != nil {
+		return Subscription{}, err
+	}
+	return sub, tx.Commit()
+}
+
+const deleteSubscriptionSQL = `DELETE FROM webhook_subscriptions WHERE id = ?`
+
+func (s *sqliteNotificationStore) DeleteSubscription(ctx context.Context, id string) (err error) {
+	ctx, span := s.startTableSpan(ctx, "webhook_subscriptions", "DELETE", deleteSubscriptionSQL)
+	defer func() { endSpan(span, err) }()
+
+	res, err := s.db.ExecContext(ctx, deleteSubscriptionSQL, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errSubscriptionNotFound
+	}
+	return nil
+}
+
+func (s *sqliteNotificationStore) Close() error {
+	return s.db.Close()
+}
+
+// beginEventSQL 事件不存在或已过期时写入处理中的记录，登记和检查在同一条语句中完成
+const beginEventSQL = `INSERT INTO processed_events (event_id, processed, expires_at) VALUES (?, 0, ?)
+	ON CONFLICT (event_id) DO UPDATE SET processed = 0, expires_at = excluded.expires_at WHERE processed_events.expires_at <= ?`
+
+const selectEventSQL = `SELECT processed FROM processed_events WHERE event_id = ?`
+
+func (s *sqliteNotificationStore) BeginEvent(ctx context.Context, id string, now, leaseUntil time.Time) (duplicate, busy bool, err error) {
+	ctx, span := s.startTableSpan(ctx, "processed_events", "INSERT", beginEventSQL)
+	defer func() { endSpan(span, err) }()
+
+	res, err := s.db.ExecContext(ctx, beginEventSQL, id, formatStoreTime(&leaseUntil), formatStoreTime(&now))
+	if err != nil {
+		return false, false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n > 0 {
+		return false, false, err
+	}
+
+	var processed bool
+	err = s.db.QueryRowContext(ctx, selectEventSQL, id).Scan(&processed)
+	if errors.Is(err, sql.ErrNoRows) {
+		// 处理中的记录刚因失败被删除，让发送方稍后重试
+		return false, true, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+	return processed, !processed, nil
+}
+
+const (
+	markEventProcessedSQL = `UPDATE processed_events SET processed = 1, expires_at = ? WHERE event_id = ?`
+	deleteEventSQL        = `DELETE FROM processed_events WHERE event_id = ?`
+)
+
+func (s *sqliteNotificationStore) FinishEvent(ctx context.Context, id string, ok bool, expiresAt time.Time) (err error) {
+	operation, stmt, args := "DELETE", deleteEventSQL, []interface{}{id}
+	if ok {
+		operation, stmt, args = "UPDATE", markEventProcessedSQL, []interface{}{formatStoreTime(&expiresAt), id}
+	}
+	ctx, span := s.startTableSpan(ctx, "processed_events", operation, stmt)
+	defer func() { endSpan(span, err) }()
+
+	_, err = s.db.ExecContext(ctx, stmt, args...)
+	return err
+}
+
+const deleteExpiredEventsSQL = `DELETE FROM processed_events WHERE expires_at <= ?`
+
+func (s *sqliteNotificationStore) DeleteExpiredEvents(ctx context.Context, now time.Time) (n int, err error) {
+	ctx, span := s.startTableSpan(ctx, "processed_events", "DELETE", deleteExpiredEventsSQL)
+	defer func() { endSpan(span, err) }()
+
+	res, err := s.db.ExecContext(ctx, deleteExpiredEventsSQL, formatStoreTime(&now))
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := res.RowsAffected()
+	return int(deleted), err
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestStores(t *testing.T) map[string]NotificationStore {
+	t.Helper()
+
+	sqliteStore, err := newSQLiteNotificationStore(context.Background(), filepath.Join(t.TempDir(), "notifications.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { sqliteStore.Close() })
+
+	return map[string]NotificationStore{
+		"memory": newMemoryNotificationStore(),
+		"sqlite": sqliteStore,
+	}
+}
+
+func newTestNotification(userID int) Notification {
+	now := time.Now().UTC()
+	return Notification{
+		UserID:       userID,
+		Type:         channelSMS,
+		Status:       statusQueued,
+		To:           "13800000000",
+		Template:     templateOrderCreated,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+		Message:      Message{UserID: userID, To: "13800000000", Body: "hi"},
+		TraceContext: map[string]string{"traceparent": "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"},
+	}
+}
+
+func TestNotificationStoreLifecycle(t *testing.T) {
+	for name, store := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			n := newTestNotification(1)
+			if err := store.Create(ctx, &n); err != nil {
+				t.Fatal(err)
+			}
+			if n.ID == 0 {
+				t.Fatal("id not assigned")
+			}
+
+			if err := store.RecordAttempt(ctx, n.ID, DeliveryAttempt{Attempt: 1, Provider: "sms-gateway", Error: "timeout", StartedAt: time.Now().UTC()}, statusQueued); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.RecordAttempt(ctx, n.ID, DeliveryAttempt{Attempt: 2, Provider: "sms-gateway", StartedAt: time.Now().UTC()}, statusSent); err != nil {
+				t.Fatal(err)
+			}
+			got, err := store.Get(ctx, n.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != statusSent || len(got.Attempts) != 2 || got.Attempts[0].Error != "timeout" || got.SentAt == nil || got.LastError != "" {
+				t.Errorf("after attempts: %+v", got)
+			}
+			if got.Message.Body != "hi" || got.TraceContext["traceparent"] == "" {
+				t.Errorf("message or trace context not stored: %+v", got)
+			}
+
+			receipt := DeliveryReceipt{NotificationID: n.ID, Status: statusDelivered, Timestamp: time.Now().UTC(), ReceivedAt: time.Now().UTC()}
+			got, err = store.ApplyReceipt(ctx, receipt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != statusDelivered || got.DeliveredAt == nil || got.Receipt == nil {
+				t.Errorf("after receipt: %+v", got)
+			}
+			// 重复的回执不报错，矛盾的回执返回冲突
+			if _, err := store.ApplyReceipt(ctx, receipt); err != nil {
+				t.Errorf("duplicate receipt: %v", err)
+			}
+			receipt.Status = statusFailed
+			if _, err := store.ApplyReceipt(ctx, receipt); !errors.Is(err, errReceiptConflict) {
+				t.Errorf("conflicting receipt: got %v", err)
+			}
+			if _, err := store.ApplyReceipt(ctx, DeliveryReceipt{NotificationID: 999, Status: statusDelivered}); !errors.Is(err, errNotificationNotFound) {
+				t.Errorf("unknown notification: got %v", err)
+			}
+
+			if pending, err := store.Pending(ctx); err != nil || len(pending) != 0 {
+				t.Errorf("pending %v, %v", pending, err)
+			}
+		})
+	}
+}
+
+func TestNotificationStoreReceiptBeforeSendResult(t *testing.T) {
+	for name, store := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			n := newTestNotification(1)
+			if err := store.Create(ctx, &n); err != nil {
+				t.Fatal(err)
+			}
+			// 回执先于发送结果到达时，发送结果不覆盖回执的状态
+			if _, err := store.ApplyReceipt(ctx, DeliveryReceipt{NotificationID: n.ID, Status: statusDelivered, Timestamp: time.Now().UTC()}); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.RecordAttempt(ctx, n.ID, DeliveryAttempt{Attempt: 1, StartedAt: time.Now().UTC()}, statusSent); err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := store.Get(ctx, n.ID); got.Status != statusDelivered || len(got.Attempts) != 1 {
+				t.Errorf("got %+v", got)
+			}
+
+			// 重放后旧回执失效
+			if err := store.UpdateStatus(ctx, n.ID, statusQueued, ""); err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := store.Get(ctx, n.ID); got.Status != statusQueued || got.Receipt != nil || got.DeliveredAt != nil {
+				t.Errorf("after requeue: %+v", got)
+			}
+		})
+	}
+}
+
+func TestNotificationStoreListByUser(t *testing.T) {
+	for name, store := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			for _, userID := range []int{1, 2, 1, 1} {
+				n := newTestNotification(userID)
+				if err := store.Create(ctx, &n); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			list, err := store.ListByUser(ctx, 1, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(list) != 2 || list[0].ID != 4 || list[1].ID != 3 {
+				t.Errorf("got %+v", list)
+			}
+			if list, _ := store.ListByUser(ctx, 3, 10); list == nil || len(list) != 0 {
+				t.Errorf("user without notifications: %#v", list)
+			}
+			if _, err := store.Get(ctx, 99); !errors.Is(err, errNotificationNotFound) {
+				t.Errorf("got %v, want errNotificationNotFound", err)
+			}
+		})
+	}
+}
+
+func TestPreferencesStore(t *testing.T) {
+	for name, store := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if _, err := store.GetPreferences(ctx, 7); !errors.Is(err, errPreferencesNotFound) {
+				t.Fatalf("got %v, want errPreferencesNotFound", err)
+			}
+			p := Preferences{UserID: 7, Channels: []string{channelSMS}, OptOut: []string{channelPush},
+				QuietHours: &QuietHours{Start: "22:00", End: "08:00"}, UpdatedAt: "2024-03-01T00:00:00Z"}
+			if err := store.PutPreferences(ctx, p); err != nil {
+				t.Fatal(err)
+			}
+			p.Channels = []string{channelEmail}
+			if err := store.PutPreferences(ctx, p); err != nil {
+				t.Fatal(err)
+			}
+			got, err := store.GetPreferences(ctx, 7)
+			if err != nil || len(got.Channels) != 1 || got.Channels[0] != channelEmail || got.QuietHours == nil || got.QuietHours.End != "08:00" {
+				t.Fatalf("got %+v, %v", got, err)
+			}
+			if err := store.DeletePreferences(ctx, 7); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.GetPreferences(ctx, 7); !errors.Is(err, errPreferencesNotFound) {
+				t.Errorf("after delete: %v", err)
+			}
+			if err := store.DeletePreferences(ctx, 7); err != nil {
+				t.Errorf("delete missing preferences: %v", err)
+			}
+		})
+	}
+}
+
+func TestSQLitePreferencesSurviveRestart(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "notifications.db")
+	store, err := newSQLiteNotificationStore(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.PutPreferences(ctx, Preferences{UserID: 3, OptOut: []string{channelSMS}}); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	store, err = newSQLiteNotificationStore(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if p, err := store.GetPreferences(ctx, 3); err != nil || len(p.OptOut) != 1 || p.OptOut[0] != channelSMS {
+		t.Errorf("got %+v, %v", p, err)
+	}
+}
+
+func TestDeadLetterStore(t *testing.T) {
+	for name, store := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			failedAt := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+			for _, id := range []int{3, 1} {
+				job := notificationJob{ID: id, Type: channelSMS, Message: Message{UserID: 1, To: "13800000000", Body: "hi"}, Attempts: 5, LastError: "timeout"}
+				if err := store.SaveDeadLetter(ctx, deadLetter{Job: job, Error: "timeout", FailedAt: failedAt}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			list, err := store.DeadLetters(ctx)
+			if err != nil || len(list) != 2 || list[0].Job.ID != 1 || list[1].Job.ID != 3 {
+				t.Fatalf("dead letters %+v: %v", list, err)
+			}
+			if dl := list[0]; dl.Job.Message.To != "13800000000" || dl.Job.Attempts != 5 || dl.Error != "timeout" || !dl.FailedAt.Equal(failedAt) {
+				t.Errorf("dead letter %+v", dl)
+			}
+
+			if dl, err := store.TakeDeadLetter(ctx, 1); err != nil || dl.Job.ID != 1 {
+				t.Fatalf("take: %+v, %v", dl, err)
+			}
+			if _, err := store.TakeDeadLetter(ctx, 1); !errors.Is(err, errDeadLetterNotFound) {
+				t.Errorf("second take: got %v, want errDeadLetterNotFound", err)
+			}
+			if list, _ := store.DeadLetters(ctx); len(list) != 1 {
+				t.Errorf("%d dead letters after take, want 1", len(list))
+			}
+		})
+	}
+}
+
+func TestWebhookSubscriptionStore(t *testing.T) {
+	for name, store := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			created := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+			for i, id := range []string{"wh_b", "wh_a"} {
+				sub := Subscription{ID: id, URL: "https://example.com/" + id, Events: []string{eventOrderCreated},
+					Secret: "secret-" + id, Status: subscriptionActive, CreatedAt: created.Add(time.Duration(i) * time.Second)}
+				if err := store.CreateSubscription(ctx, sub); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := store.CreateSubscription(ctx, Subscription{ID: "wh_a", Status: subscriptionActive, CreatedAt: created}); err == nil {
+				t.Error("duplicate subscription ID was accepted")
+			}
+
+			list, err := store.ListSubscriptions(ctx)
+			if err != nil || len(list) != 2 || list[0].ID != "wh_b" || list[1].ID != "wh_a" {
+				t.Fatalf("subscriptions %+v: %v", list, err)
+			}
+			if sub := list[1]; sub.Secret != "secret-wh_a" || len(sub.Events) != 1 || sub.Events[0] != eventOrderCreated || !sub.CreatedAt.Equal(created.Add(time.Second)) {
+				t.Errorf("subscription %+v", sub)
+			}
+
+			delivered := created.Add(time.Minute)
+			got, err := store.UpdateSubscription(ctx, "wh_a", func(sub *Subscription) error {
+				sub.Status = subscriptionDisabled
+				sub.ConsecutiveFailures = 3
+				sub.DisabledReason = "3 consecutive failures"
+				sub.LastError = "endpoint returned status 500"
+				sub.LastDeliveryAt = &delivered
+				return nil
+			})
+			if err != nil || got.Status != subscriptionDisabled {
+				t.Fatalf("update: %+v, %v", got, err)
+			}
+			got, err = store.GetSubscription(ctx, "wh_a")
+			if err != nil || got.Status != subscriptionDisabled || got.ConsecutiveFailures != 3 || got.LastError == "" ||
+				got.LastDeliveryAt == nil || !got.LastDeliveryAt.Equal(delivered) || got.Secret != "secret-wh_a" {
+				t.Errorf("after update %+v: %v", got, err)
+			}
+
+			// fn 返回错误时不写回
+			if _, err := store.UpdateSubscription(ctx, "wh_a", func(sub *Subscription) error {
+				sub.Status = subscriptionActive
+				return errors.New("abort")
+			}); err == nil {
+				t.Error("update error was not returned")
+			}
+			if got, _ := store.GetSubscription(ctx, "wh_a"); got.Status != subscriptionDisabled {
+				t.Errorf("aborted update was saved: %+v", got)
+			}
+
+			if _, err := store.UpdateSubscription(ctx, "wh_missing", func(*Subscription) error { return nil }); !errors.Is(err, errSubscriptionNotFound) {
+				t.Errorf("update missing: got %v, want errSubscriptionNotFound", err)
+			}
+			if err := store.DeleteSubscription(ctx, "wh_b"); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.DeleteSubscription(ctx, "wh_b"); !errors.Is(err, errSubscriptionNotFound) {
+				t.Errorf("second delete: got %v, want errSubscriptionNotFound", err)
+			}
+			if _, err := store.GetSubscription(ctx, "wh_b"); !errors.Is(err, errSubscriptionNotFound) {
+				t.Errorf("get deleted: got %v, want errSubscriptionNotFound", err)
+			}
+		})
+	}
+}
+
+func TestProcessedEventStore(t *testing.T) {
+	for name, store := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			now := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+			lease := now.Add(time.Minute)
+			check := func(step string, at time.Time, wantDuplicate, wantBusy bool) {
+				t.Helper()
+				duplicate, busy, err := store.BeginEvent(ctx, "evt-1", at, at.Add(time.Minute))
+				if err != nil || duplicate != wantDuplicate || busy != wantBusy {
+					t.Fatalf("%s: duplicate=%v busy=%v err=%v, want duplicate=%v busy=%v", step, duplicate, busy, err, wantDuplicate, wantBusy)
+				}
+			}
+
+			check("first delivery", now, false, false)
+			check("concurrent delivery", now, false, true)
+			// 处理失败后事件可以被重新投递
+			if err := store.FinishEvent(ctx, "evt-1", false, now.Add(time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+			check("retry after failure", now, false, false)
+			// 处理中的记录过期后（进程崩溃）事件可以被重新处理
+			check("retry after lease", lease, false, false)
+
+			if err := store.FinishEvent(ctx, "evt-1", true, now.Add(time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+			check("redelivery", now.Add(30*time.Minute), true, false)
+
+			if n, err := store.DeleteExpiredEvents(ctx, now.Add(time.Hour)); err != nil || n != 1 {
+				t.Fatalf("deleted %d, %v", n, err)
+			}
+			check("after expiry", now.Add(time.Hour), false, false)
+		})
+	}
+}
+
+func TestSQLiteProcessedEventsSurviveRestart(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "notifications.db")
+	store, err := newSQLiteNotificationStore(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &eventDeduper{store: store, ttl: time.Hour}
+	if _, _, err := d.begin(ctx, "evt-1"); err != nil {
+		t.Fatal(err)
+	}
+	d.finish(ctx, "evt-1", true)
+	store.Close()
+
+	store, err = newSQLiteNotificationStore(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	d = &eventDeduper{store: store, ttl: time.Hour}
+	if duplicate, _, err := d.begin(ctx, "evt-1"); err != nil || !duplicate {
+		t.Errorf("redelivery after restart: duplicate=%v, %v", duplicate, err)
+	}
+}
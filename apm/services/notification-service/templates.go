@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// 模板文件位于 templates/<模板名>/<locale>.txt 和 <locale>.html：
+// .txt 用 text/template 渲染邮件主题、纯文本正文、短信和推送，.html 用 html/template 渲染邮件 HTML 正文
+//
+//go:embed templates
+var templateFiles embed.FS
+
+const (
+	defaultLocale = "zh-CN"
+	// templateOrderCreated 订单创建通知，/notify、/email、/sms 和 order.created 事件的默认模板
+	templateOrderCreated = "order_created"
+)
+
+var (
+	// errUnknownTemplate 请求的模板不存在
+	errUnknownTemplate = errors.New("unknown notification template")
+	// errMissingTemplateData 渲染模板缺少必需的数据
+	errMissingTemplateData = errors.New("missing template data")
+)
+
+// userData 模板中可用的用户字段，与 user-service 的 User 一致
+type userData struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Email  string `json:"email"`
+	Phone  string `json:"phone"`
+	Locale string `json:"locale"`
+}
+
+// orderData 模板中可用的订单字段，与 order-service 的 Order 一致
+type orderData struct {
+	ID       int     `json:"id"`
+	UserID   int     `json:"user_id"`
+	Product  string  `json:"product"`
+	Amount   float64 `json:"amount"`
+	Status   string  `json:"status"`
+	CreateAt string  `json:"create_at"`
+}
+
+// templateData 渲染模板的数据
+type templateData struct {
+	User  userData
+	Order orderData
+}
+
+var templateFuncs = map[string]interface{}{
+	// formatTime 按 layout 格式化 RFC3339 时间，无法解析时原样输出
+	"formatTime": func(layout, value string) string {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return value
+		}
+		return t.Format(layout)
+	},
+}
+
+type localizedTemplate struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// templateEngine 按模板名和 locale 渲染各渠道的通知内容
+type templateEngine struct {
+	// templates 模板名 -> locale -> 模板
+	templates map[string]map[string]*localizedTemplate
+}
+
+func newTemplateEngine(fsys fs.FS) (*templateEngine, error) {
+	e := &templateEngine{templates: make(map[string]map[string]*localizedTemplate)}
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		ext := path.Ext(p)
+		if ext != ".txt" && ext != ".html" {
+			return nil
+		}
+		name := path.Base(path.Dir(p))
+		locale := strings.TrimSuffix(path.Base(p), ext)
+
+		content, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		if e.templates[name] == nil {
+			e.templates[name] = make(map[string]*localizedTemplate)
+		}
+		lt := e.templates[name][locale]
+		if lt == nil {
+			lt = &localizedTemplate{}
+			e.templates[name][locale] = lt
+		}
+		if ext == ".txt" {
+			lt.text, err = texttemplate.New(p).Funcs(templateFuncs).Parse(string(content))
+		} else {
+			lt.html, err = htmltemplate.New(p).Funcs(templateFuncs).Parse(string(content))
+		}
+		if err != nil {
+			return fmt.Errorf("parse %s: %w", p, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for name, locales := range e.templates {
+		if _, ok := locales[defaultLocale]; !ok {
+			return nil, fmt.Errorf("template %s has no %s version", name, defaultLocale)
+		}
+	}
+	return e, nil
+}
+
+// Render 渲染渠道的通知内容。locale 不受支持时使用默认的 zh-CN，返回实际使用的 locale
+func (e *templateEngine) Render(name, locale, channel string, data templateData) (Message, string, error) {
+	locales, ok := e.templates[name]
+	if !ok {
+		return Message{}, "", fmt.Errorf("%w: %s", errUnknownTemplate, name)
+	}
+	if name == templateOrderCreated && data.Order.ID == 0 {
+		return Message{}, "", fmt.Errorf("%w: order.id is required", errMissingTemplateData)
+	}
+
+	locale = normalizeLocale(locale)
+	lt, ok := locales[locale]
+	if !ok {
+		locale = defaultLocale
+		lt = locales[locale]
+	}
+
+	var msg Message
+	var err error
+	render := func(define string) string {
+		if err != nil || lt.text == nil {
+			return ""
+		}
+		var b bytes.Buffer
+		err = lt.text.ExecuteTemplate(&b, define, data)
+		return strings.TrimSpace(b.String())
+	}
+	switch channel {
+	case channelSMS:
+		msg.Body = render("sms")
+	case channelPush:
+		msg.Subject = render("push_title")
+		msg.Body = render("push_body")
+	default:
+		// 邮件和 webhook 使用邮件内容
+		msg.Subject = render("email_subject")
+		msg.Body = render("email_text")
+		if lt.html != nil && err == nil {
+			var b bytes.Buffer
+			err = lt.html.ExecuteTemplate(&b, "email_html", data)
+			msg.HTML = b.String()
+		}
+	}
+	if err != nil {
+		return Message{}, "", fmt.Errorf("render %s/%s for %s: %w", name, locale, channel, err)
+	}
+	return msg, locale, nil
+}
+
+// normalizeLocale 把 en、en_us、zh-cn 等写法规范为 en-US、zh-CN；
+// 也接受 Accept-Language 头，取第一个语言
+func normalizeLocale(s string) string {
+	s = strings.TrimSpace(strings.SplitN(s, ",", 2)[0])
+	s = strings.TrimSpace(strings.SplitN(s, ";", 2)[0])
+	parts := strings.FieldsFunc(s, func(r rune) bool { return r == '-' || r == '_' })
+	if len(parts) == 0 {
+		return defaultLocale
+	}
+	switch strings.ToLower(parts[0]) {
+	case "zh":
+		return "zh-CN"
+	case "en":
+		return "en-US"
+	}
+	if len(parts) == 1 {
+		return strings.ToLower(parts[0])
+	}
+	return strings.ToLower(parts[0]) + "-" + strings.ToUpper(parts[1])
+}
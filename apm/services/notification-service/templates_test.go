@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func testTemplateData() templateData {
+	return templateData{
+		User:  userData{ID: 1, Name: "张三", Email: "zhangsan@example.com"},
+		Order: orderData{ID: 1001, UserID: 1, Product: "laptop", Amount: 99.5, CreateAt: "2024-03-01T08:30:00Z"},
+	}
+}
+
+func TestRenderOrderCreated(t *testing.T) {
+	e, err := newTemplateEngine(templateFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		locale, channel string
+		subject, body   []string
+	}{
+		{"zh-CN", channelEmail, []string{"订单创建成功", "1001"}, []string{"张三，您好", "¥99.50", "2024年03月01日 08:30"}},
+		{"en-US", channelEmail, []string{"Your order 1001"}, []string{"Hi 张三", "CNY 99.50", "Mar 1, 2024 08:30"}},
+		{"zh-CN", channelSMS, nil, []string{"【APM 商城】", "1001", "laptop"}},
+		{"en-US", channelSMS, nil, []string{"[APM Shop]", "your order 1001"}},
+		{"en-US", channelPush, []string{"Order placed"}, []string{"Order 1001: laptop"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.locale+"/"+tc.channel, func(t *testing.T) {
+			msg, locale, err := e.Render(templateOrderCreated, tc.locale, tc.channel, testTemplateData())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if locale != tc.locale {
+				t.Errorf("locale %q, want %q", locale, tc.locale)
+			}
+			for _, want := range tc.subject {
+				if !strings.Contains(msg.Subject, want) {
+					t.Errorf("subject %q does not contain %q", msg.Subject, want)
+				}
+			}
+			for _, want := range tc.body {
+				if !strings.Contains(msg.Body, want) {
+					t.Errorf("body %q does not contain %q", msg.Body, want)
+				}
+			}
+			if (tc.channel == channelEmail) != (msg.HTML != "") {
+				t.Errorf("html %q for channel %s", msg.HTML, tc.channel)
+			}
+		})
+	}
+}
+
+func TestRenderEscapesHTMLAndFallsBack(t *testing.T) {
+	e, err := newTemplateEngine(templateFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := testTemplateData()
+	data.User.Name = "<script>alert(1)</script>"
+	msg, locale, err := e.Render(templateOrderCreated, "fr-FR", channelEmail, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if locale != defaultLocale {
+		t.Errorf("unsupported locale rendered as %q, want %q", locale, defaultLocale)
+	}
+	if strings.Contains(msg.HTML, "<script>") || !strings.Contains(msg.HTML, "&lt;script&gt;") {
+		t.Errorf("user name not escaped in html:\n%s", msg.HTML)
+	}
+
+	if _, _, err := e.Render("password_reset", "zh-CN", channelEmail, data); !errors.Is(err, errUnknownTemplate) {
+		t.Errorf("unknown template: got %v", err)
+	}
+	data.Order.ID = 0
+	if _, _, err := e.Render(templateOrderCreated, "zh-CN", channelEmail, data); !errors.Is(err, errMissingTemplateData) {
+		t.Errorf("missing order: got %v", err)
+	}
+}
+
+func TestNormalizeLocale(t *testing.T) {
+	cases := map[string]string{
+		"":                        defaultLocale,
+		"en":                      "en-US",
+		"en_gb":                   "en-US",
+		"zh-cn":                   "zh-CN",
+		"zh-TW":                   "zh-CN",
+		"en-US,en;q=0.9,zh;q=0.8": "en-US",
+		"fr-fr;q=0.9":             "fr-FR",
+	}
+	for in, want := range cases {
+		if got := normalizeLocale(in); got != want {
+			t.Errorf("normalizeLocale(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSMTPMessageIncludesHTMLAlternative(t *testing.T) {
+	p := newSMTPProvider("localhost:25", "noreply@apm.local", "", "")
+	raw := string(p.buildMessage(context.Background(), Message{To: "a@example.com", Subject: "s", Body: "plain\nbody", HTML: "<p>html</p>"}))
+
+	for _, want := range []string{"multipart/alternative", "Content-Type: text/plain", "plain\r\nbody", "Content-Type: text/html", "<p>html</p>"} {
+		if !strings.Contains(raw, want) {
+			t.Errorf("message does not contain %q:\n%s", want, raw)
+		}
+	}
+}
+
+func TestSendEmailRendersRequestData(t *testing.T) {
+	var err error
+	notificationTemplates, err = newTemplateEngine(templateFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 不启动 worker，只检查入队的内容
+	n := newNotifier(map[string]Provider{channelEmail: &flakyProvider{}}, []string{channelEmail})
+	jobQueue = newNotificationQueue(n, newMemoryNotificationStore(), 10, 1, time.Millisecond)
+	notificationRouter = newRouter(newMemoryNotificationStore(), n)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/email", sendEmail)
+
+	body := `{"locale":"en-US","user":{"id":1,"name":"Li","email":"li@example.com"},"order":{"id":42,"product":"book","amount":12}}`
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/email", strings.NewReader(body)))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+
+	job := <-jobQueue.ready
+	msg := job.Message
+	if msg.To != "li@example.com" || msg.UserID != 1 || msg.Locale != "en-US" || msg.Template != templateOrderCreated {
+		t.Errorf("message %+v", msg)
+	}
+	if !strings.Contains(msg.Subject, "42") || !strings.Contains(msg.Body, "CNY 12.00") {
+		t.Errorf("subject %q, body %q", msg.Subject, msg.Body)
+	}
+
+	// 缺少订单数据时拒绝
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/email", strings.NewReader(`{"user_id":1}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("missing order: status %d, want 400", w.Code)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// userLookupTimeout 查询用户服务的超时，请求没有更早的截止时间时使用
+const userLookupTimeout = 5 * time.Second
+
+var userLookups = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "user_lookups_total",
+		Help: "Total number of recipient lookups in user-service, by result (found, not_found, error)",
+	},
+	[]string{"result"},
+)
+
+func init() {
+	prometheus.MustRegister(userLookups)
+}
+
+// userDirectory 从用户服务查询收件人的联系方式。事件只带有用户 ID，
+// 没有联系方式时路由只能选择推送
+type userDirectory struct {
+	baseURL string
+	client  *http.Client
+}
+
+func newUserDirectory(baseURL string) *userDirectory {
+	return &userDirectory{baseURL: baseURL, client: &http.Client{Timeout: userLookupTimeout}}
+}
+
+// newUserDirectoryFromEnv 用户服务地址由 USER_SERVICE_URL 指定，默认 http://localhost:8081
+func newUserDirectoryFromEnv() *userDirectory {
+	url := os.Getenv("USER_SERVICE_URL")
+	if url == "" {
+		url = "http://localhost:8081"
+	}
+	return newUserDirectory(url)
+}
+
+// Lookup 返回用户的姓名和联系方式。用户不存在时返回只有 ID 的 userData，
+// 其他错误返回给调用方，由事件发送方重试
+func (d *userDirectory) Lookup(ctx context.Context, userID int) (user userData, err error) {
+	tracer := otel.Tracer("notification-service")
+	ctx, span := tracer.Start(ctx, "call-user-service",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.Int("user.id", userID)),
+	)
+	defer func() {
+		result := "found"
+		switch {
+		case err != nil:
+			result = "error"
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		case user.Email == "" && user.Phone == "":
+			result = "not_found"
+		}
+		userLookups.WithLabelValues(result).Inc()
+		span.End()
+	}()
+
+	url := fmt.Sprintf("%s/users/%d", d.baseURL, userID)
+	span.SetAttributes(attribute.String("http.url", url))
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return userData{}, err
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return userData{}, err
+	}
+	defer resp.Body.Close()
+
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return userData{ID: userID}, nil
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return userData{}, fmt.Errorf("user service returned status %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return userData{}, fmt.Errorf("decode user %d: %w", userID, err)
+	}
+	user.ID = userID
+	return user, nil
+}
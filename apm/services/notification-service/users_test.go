@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// useUserService 在测试期间用桩服务代替用户服务，users 中没有的用户返回 404，
+// status 非零时所有请求都返回该状态码
+func useUserService(t *testing.T, users map[int]userData, status int) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status != 0 {
+			w.WriteHeader(status)
+			return
+		}
+		id, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/users/"))
+		u, ok := users[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "User not found"})
+			return
+		}
+		json.NewEncoder(w).Encode(u)
+	}))
+	t.Cleanup(srv.Close)
+
+	old := userContacts
+	userContacts = newUserDirectory(srv.URL)
+	t.Cleanup(func() { userContacts = old })
+}
+
+func TestOrderEventsUseUserContacts(t *testing.T) {
+	var err error
+	notificationTemplates, err = newTemplateEngine(templateFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhooks = newTestDispatcher(t, 5, 10)
+	users := map[int]userData{1: {Name: "Li", Email: "li@example.com"}}
+
+	cases := []struct {
+		name        string
+		userID      int
+		userService int
+		status      int
+		// channel 和 to 入队的通知，为空时不应入队
+		channel string
+		to      string
+	}{
+		{"email from user-service", 1, 0, http.StatusOK, channelEmail, "li@example.com"},
+		{"unknown user falls back to push", 2, 0, http.StatusOK, channelPush, ""},
+		{"user-service failure is retried", 1, http.StatusServiceUnavailable, http.StatusInternalServerError, "", ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			useUserService(t, users, tc.userService)
+			n := newNotifier(map[string]Provider{channelEmail: &flakyProvider{}, channelPush: &flakyProvider{}}, []string{channelEmail, channelPush})
+			notificationStore = newMemoryNotificationStore()
+			notificationRouter = newRouter(notificationStore, n)
+			// 不启动 worker，只检查入队的内容
+			jobQueue = newNotificationQueue(n, notificationStore, 10, 1, time.Millisecond)
+
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.POST("/events", receiveEvent(newEventDeduper(notificationStore, time.Hour)))
+			event := `{"id":"evt-` + tc.name + `","type":"order.created","data":{"id":42,"user_id":` + strconv.Itoa(tc.userID) + `,"product":"book","amount":12}}`
+			w := serve(r, "POST", "/events", event, nil)
+			if w.Code != tc.status {
+				t.Fatalf("event: %d %s", w.Code, w.Body)
+			}
+			if tc.channel == "" {
+				if len(jobQueue.ready) != 0 {
+					t.Error("notification queued although the lookup failed")
+				}
+				return
+			}
+			job := <-jobQueue.ready
+			if job.Type != tc.channel || job.Message.To != tc.to {
+				t.Errorf("queued %s to %q, want %s to %q", job.Type, job.Message.To, tc.channel, tc.to)
+			}
+		})
+	}
+}
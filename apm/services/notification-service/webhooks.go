@@ -0,0 +1,565 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"notification-service/webhooksig"
+)
+
+const (
+	subscriptionActive   = "active"
+	subscriptionDisabled = "disabled"
+
+	// eventWebhookVerification 注册和重新启用订阅时发送的验证事件，端点必须原样返回 challenge
+	eventWebhookVerification = "webhook.verification"
+	// webhookTimeout 单次投递或验证的超时时间
+	webhookTimeout = 10 * time.Second
+	// minSecretLength 订阅方自带密钥的最短长度
+	minSecretLength = 16
+)
+
+// webhookEvents 可以订阅的事件类型
+var webhookEvents = map[string]bool{eventOrderCreated: true}
+
+var (
+	errSubscriptionNotFound = errors.New("webhook subscription not found")
+	// errInvalidSubscription URL、密钥或事件类型不合法
+	errInvalidSubscription = errors.New("invalid webhook subscription")
+	// errVerificationFailed 端点没有正确响应验证事件
+	errVerificationFailed = errors.New("webhook endpoint verification failed")
+)
+
+var (
+	webhookDeliveries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_deliveries_total",
+			Help: "Total number of webhook delivery attempts, by result (delivered, retried, failed, dropped)",
+		},
+		[]string{"event", "result"},
+	)
+
+	webhookDeliveryDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "webhook_delivery_duration_seconds",
+			Help:    "Duration of webhook HTTP requests to subscriber endpoints",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"event"},
+	)
+
+	webhookSubscriptionsDisabled = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "webhook_subscriptions_disabled_total",
+			Help: "Total number of webhook subscriptions disabled after repeated delivery failures",
+		},
+	)
+
+	// webhooks 订阅和投递，在 main 中初始化
+	webhooks *webhookDispatcher
+)
+
+func init() {
+	prometheus.MustRegister(webhookDeliveries)
+	prometheus.MustRegister(webhookDeliveryDuration)
+	prometheus.MustRegister(webhookSubscriptionsDisabled)
+}
+
+// Subscription 一个 webhook 订阅，密钥只在注册时返回一次
+type Subscription struct {
+	ID     string   `json:"id"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"-"`
+	Status string   `json:"status"`
+	// ConsecutiveFailures 连续失败的投递次数（每次重试都计入），成功后清零
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	DisabledReason      string     `json:"disabled_reason,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastDeliveryAt      *time.Time `json:"last_delivery_at,omitempty"`
+	CreatedAt           time.Time  `json:"created_at"`
+}
+
+func (s *Subscription) subscribed(event string) bool {
+	for _, e := range s.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookPayload 投递的请求体，id 和 type 与收到的事件相同
+type webhookPayload struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	OccurredAt string          `json:"occurred_at,omitempty"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// webhookDelivery 一次事件到一个订阅的投递
+type webhookDelivery struct {
+	EventID        string
+	Event          string
+	SubscriptionID string
+	Body           []byte
+	Attempts       int
+	TraceContext   map[string]string
+}
+
+// webhookDispatcher 管理订阅并投递事件：由 worker 池发送，失败后按指数退避重试，
+// 连续失败 disableAfter 次的订阅被停用。订阅和停用状态保存在 store 中，重启后保持；
+// 等待重试的投递只在内存中
+type webhookDispatcher struct {
+	store        NotificationStore
+	client       *http.Client
+	ready        chan *webhookDelivery
+	maxAttempts  int
+	retryBase    time.Duration
+	disableAfter int
+
+	done chan struct{}
+}
+
+func newWebhookDispatcher(store NotificationStore, client *http.Client, size, maxAttempts int, retryBase time.Duration, disableAfter int) *webhookDispatcher {
+	return &webhookDispatcher{
+		store:        store,
+		client:       client,
+		ready:        make(chan *webhookDelivery, size),
+		maxAttempts:  maxAttempts,
+		retryBase:    retryBase,
+		disableAfter: disableAfter,
+		done:         make(chan struct{}),
+	}
+}
+
+// newWebhookDispatcherFromEnv 由 WEBHOOK_QUEUE_SIZE（默认 1000）、WEBHOOK_MAX_ATTEMPTS（默认 5）、
+// WEBHOOK_RETRY_BASE（默认 1s）和 WEBHOOK_DISABLE_AFTER（默认 10）配置
+func newWebhookDispatcherFromEnv(store NotificationStore) *webhookDispatcher {
+	return newWebhookDispatcher(store, &http.Client{Timeout: webhookTimeout},
+		envInt("WEBHOOK_QUEUE_SIZE", 1000),
+		envInt("WEBHOOK_MAX_ATTEMPTS", 5),
+		envDuration("WEBHOOK_RETRY_BASE", time.Second),
+		envInt("WEBHOOK_DISABLE_AFTER", 10),
+	)
+}
+
+// webhookWorkers worker 数量，由 WEBHOOK_WORKERS 配置，默认 2
+func webhookWorkers() int {
+	return envInt("WEBHOOK_WORKERS", 2)
+}
+
+// Start 启动 workers 个 worker，ctx 结束后停止取新的投递
+func (d *webhookDispatcher) Start(ctx context.Context, workers int) {
+	go func() {
+		<-ctx.Done()
+		close(d.done)
+	}()
+	for i := 0; i < workers; i++ {
+		go func() {
+			for {
+				select {
+				case delivery := <-d.ready:
+					d.process(ctx, delivery)
+				case <-d.done:
+					return
+				}
+			}
+		}()
+	}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// validateSubscription 检查 URL 和事件类型，events 为空时订阅所有事件
+func validateSubscription(rawURL, secret string, events []string) ([]string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: url must be an absolute http or https URL", errInvalidSubscription)
+	}
+	if secret != "" && len(secret) < minSecretLength {
+		return nil, fmt.Errorf("%w: secret must be at least %d characters", errInvalidSubscription, minSecretLength)
+	}
+	if len(events) == 0 {
+		for e := range webhookEvents {
+			events = append(events, e)
+		}
+		sort.Strings(events)
+	}
+	for _, e := range events {
+		if !webhookEvents[e] {
+			return nil, fmt.Errorf("%w: unknown event %q", errInvalidSubscription, e)
+		}
+	}
+	return events, nil
+}
+
+// Register 验证端点后保存订阅，secret 为空时生成一个。返回订阅和密钥
+func (d *webhookDispatcher) Register(ctx context.Context, rawURL, secret string, events []string) (Subscription, string, error) {
+	events, err := validateSubscription(rawURL, secret, events)
+	if err != nil {
+		return Subscription{}, "", err
+	}
+	if secret == "" {
+		secret = "whsec_" + randomHex(24)
+	}
+	sub := &Subscription{
+		ID:        "wh_" + randomHex(8),
+		URL:       rawURL,
+		Events:    events,
+		Secret:    secret,
+		Status:    subscriptionActive,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := d.verify(ctx, sub); err != nil {
+		return Subscription{}, "", err
+	}
+	if err := d.store.CreateSubscription(ctx, *sub); err != nil {
+		return Subscription{}, "", fmt.Errorf("save webhook subscription: %w", err)
+	}
+	return *sub, secret, nil
+}
+
+// verify 向端点发送签名的验证事件，端点必须返回 2xx 和 {"challenge": 收到的 challenge}
+func (d *webhookDispatcher) verify(ctx context.Context, sub *Subscription) error {
+	id, challenge := "whv_"+randomHex(8), randomHex(16)
+	data, _ := json.Marshal(map[string]string{"challenge": challenge})
+	body, err := json.Marshal(webhookPayload{
+		ID:         id,
+		Type:       eventWebhookVerification,
+		OccurredAt: time.Now().UTC().Format(time.RFC3339),
+		Data:       data,
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	resp, err := d.post(ctx, sub, eventWebhookVerification, id, body)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errVerificationFailed, err)
+	}
+	var reply struct {
+		Challenge string `json:"challenge"`
+	}
+	if err := json.Unmarshal(resp, &reply); err != nil || reply.Challenge != challenge {
+		return fmt.Errorf("%w: response did not echo the challenge", errVerificationFailed)
+	}
+	return nil
+}
+
+// webhookError 端点返回非 2xx 状态
+type webhookError struct {
+	status int
+}
+
+func (e *webhookError) Error() string {
+	return fmt.Sprintf("endpoint returned status %d", e.status)
+}
+
+// post 发送一次签名请求，在 webhook-delivery span 中进行，返回 2xx 响应的响应体
+func (d *webhookDispatcher) post(ctx context.Context, sub *Subscription, event, id string, body []byte) ([]byte, error) {
+	tracer := otel.Tracer("notification-service")
+	ctx, span := tracer.Start(ctx, "webhook-delivery "+event, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("webhook.subscription_id", sub.ID),
+		attribute.String("webhook.event", event),
+		attribute.String("webhook.event_id", id),
+		attribute.String("http.url", sub.URL),
+	))
+	defer span.End()
+
+	resp, err := d.send(ctx, sub, event, id, body)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		var werr *webhookError
+		if errors.As(err, &werr) {
+			span.SetAttributes(attribute.Int("http.status_code", werr.status))
+		}
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("http.status_code", 200))
+	return resp, nil
+}
+
+func (d *webhookDispatcher) send(ctx context.Context, sub *Subscription, event, id string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "apm-notification-service/webhooks")
+	req.Header.Set(webhooksig.IDHeader, id)
+	req.Header.Set(webhooksig.EventHeader, event)
+	webhooksig.SetHeaders(req.Header, sub.Secret, time.Now(), body)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	start := time.Now()
+	resp, err := d.client.Do(req)
+	webhookDeliveryDuration.WithLabelValues(event).Observe(time.Since(start).Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &webhookError{status: resp.StatusCode}
+	}
+	return respBody, nil
+}
+
+// Publish 把事件放入每个订阅了该事件的活跃订阅的投递队列，返回投递数。
+// 队列已满时返回 errQueueFull，已放入的投递不撤回，接收方应按事件 ID 去重
+func (d *webhookDispatcher) Publish(ctx context.Context, ev Event) (int, error) {
+	body, err := json.Marshal(webhookPayload{ID: ev.ID, Type: ev.Type, OccurredAt: ev.OccurredAt, Data: ev.Data})
+	if err != nil {
+		return 0, err
+	}
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+
+	subs, err := d.store.ListSubscriptions(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("list webhook subscriptions: %w", err)
+	}
+	var targets []string
+	for _, sub := range subs {
+		if sub.Status == subscriptionActive && sub.subscribed(ev.Type) {
+			targets = append(targets, sub.ID)
+		}
+	}
+
+	for i, id := range targets {
+		delivery := &webhookDelivery{EventID: ev.ID, Event: ev.Type, SubscriptionID: id, Body: body, TraceContext: carrier}
+		select {
+		case d.ready <- delivery:
+		default:
+			return i, errQueueFull
+		}
+	}
+	return len(targets), nil
+}
+
+// process 投递一次；失败时记录到订阅上，安排重试或放弃，连续失败过多时停用订阅
+func (d *webhookDispatcher) process(ctx context.Context, delivery *webhookDelivery) {
+	parent := otel.GetTextMapPropagator().Extract(context.WithoutCancel(ctx), propagation.MapCarrier(delivery.TraceContext))
+	sub, err := d.store.GetSubscription(parent, delivery.SubscriptionID)
+	if err != nil && !errors.Is(err, errSubscriptionNotFound) {
+		// 读取失败不计入投递次数，稍后重试
+		log.Printf("Webhook %s to %s: load subscription: %v", delivery.EventID, delivery.SubscriptionID, err)
+		d.schedule(delivery, exponentialBackoff(d.retryBase, delivery.Attempts+1))
+		return
+	}
+	// 订阅在等待重试期间被删除或停用
+	if err != nil || sub.Status != subscriptionActive {
+		webhookDeliveries.WithLabelValues(delivery.Event, "dropped").Inc()
+		return
+	}
+
+	delivery.Attempts++
+	sctx, cancel := context.WithTimeout(parent, webhookTimeout)
+	defer cancel()
+	_, err = d.post(sctx, &sub, delivery.Event, delivery.EventID, delivery.Body)
+
+	now := time.Now().UTC()
+	disabled := false
+	_, serr := d.store.UpdateSubscription(parent, delivery.SubscriptionID, func(sub *Subscription) error {
+		disabled = false
+		sub.LastDeliveryAt = &now
+		if err == nil {
+			sub.ConsecutiveFailures = 0
+			sub.LastError = ""
+			return nil
+		}
+		sub.ConsecutiveFailures++
+		sub.LastError = err.Error()
+		// 410 Gone 表示端点不再接收事件
+		var werr *webhookError
+		gone := errors.As(err, &werr) && werr.status == http.StatusGone
+		if sub.Status == subscriptionActive && (gone || sub.ConsecutiveFailures >= d.disableAfter) {
+			sub.Status = subscriptionDisabled
+			sub.DisabledReason = fmt.Sprintf("%d consecutive failures, last: %v", sub.ConsecutiveFailures, err)
+			if gone {
+				sub.DisabledReason = "endpoint returned 410 Gone"
+			}
+			disabled = true
+		}
+		return nil
+	})
+	if serr != nil && !errors.Is(serr, errSubscriptionNotFound) {
+		log.Printf("Webhook %s to %s: save delivery result: %v", delivery.EventID, delivery.SubscriptionID, serr)
+	}
+
+	if err == nil {
+		webhookDeliveries.WithLabelValues(delivery.Event, "delivered").Inc()
+		return
+	}
+	if disabled {
+		webhookSubscriptionsDisabled.Inc()
+		webhookDeliveries.WithLabelValues(delivery.Event, "failed").Inc()
+		log.Printf("Webhook subscription %s disabled: %v", delivery.SubscriptionID, err)
+		return
+	}
+	if errors.Is(serr, errSubscriptionNotFound) {
+		webhookDeliveries.WithLabelValues(delivery.Event, "dropped").Inc()
+		return
+	}
+	if delivery.Attempts >= d.maxAttempts {
+		webhookDeliveries.WithLabelValues(delivery.Event, "failed").Inc()
+		log.Printf("Webhook %s to %s failed after %d attempts: %v", delivery.EventID, delivery.SubscriptionID, delivery.Attempts, err)
+		return
+	}
+	webhookDeliveries.WithLabelValues(delivery.Event, "retried").Inc()
+	d.schedule(delivery, exponentialBackoff(d.retryBase, delivery.Attempts))
+}
+
+// schedule 在 delay 后把投递放回队列，队列已满时等待
+func (d *webhookDispatcher) schedule(delivery *webhookDelivery, delay time.Duration) {
+	time.AfterFunc(delay, func() {
+		select {
+		case d.ready <- delivery:
+		case <-d.done:
+		}
+	})
+}
+
+// Get 返回订阅
+func (d *webhookDispatcher) Get(ctx context.Context, id string) (Subscription, error) {
+	return d.store.GetSubscription(ctx, id)
+}
+
+// List 按创建时间返回所有订阅
+func (d *webhookDispatcher) List(ctx context.Context) ([]Subscription, error) {
+	return d.store.ListSubscriptions(ctx)
+}
+
+// Delete 删除订阅，等待重试的投递会被丢弃
+func (d *webhookDispatcher) Delete(ctx context.Context, id string) error {
+	return d.store.DeleteSubscription(ctx, id)
+}
+
+// Enable 重新验证端点后启用订阅，失败计数清零。停用期间的事件不会补发
+func (d *webhookDispatcher) Enable(ctx context.Context, id string) (Subscription, error) {
+	sub, err := d.Get(ctx, id)
+	if err != nil {
+		return Subscription{}, err
+	}
+	if err := d.verify(ctx, &sub); err != nil {
+		return Subscription{}, err
+	}
+	return d.store.UpdateSubscription(ctx, id, func(sub *Subscription) error {
+		sub.Status = subscriptionActive
+		sub.ConsecutiveFailures = 0
+		sub.DisabledReason = ""
+		sub.LastError = ""
+		return nil
+	})
+}
+
+type subscriptionRequest struct {
+	URL    string   `json:"url" binding:"required"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+}
+
+// createWebhook 处理 POST /webhooks，验证端点后返回 201 和密钥
+func createWebhook(c *gin.Context) {
+	var req subscriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	sub, secret, err := webhooks.Register(c.Request.Context(), req.URL, req.Secret, req.Events)
+	switch {
+	case errors.Is(err, errInvalidSubscription), errors.Is(err, errVerificationFailed):
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	trace.SpanFromContext(c.Request.Context()).SetAttributes(attribute.String("webhook.subscription_id", sub.ID))
+	c.JSON(201, gin.H{"subscription": sub, "secret": secret})
+}
+
+// listWebhooks 处理 GET /webhooks
+func listWebhooks(c *gin.Context) {
+	list, err := webhooks.List(c.Request.Context())
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"subscriptions": list, "total": len(list)})
+}
+
+// getWebhook 处理 GET /webhooks/:id
+func getWebhook(c *gin.Context) {
+	sub, err := webhooks.Get(c.Request.Context(), c.Param("id"))
+	switch {
+	case errors.Is(err, errSubscriptionNotFound):
+		c.JSON(404, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(500, gin.H{"error": err.Error()})
+	default:
+		c.JSON(200, sub)
+	}
+}
+
+// deleteWebhook 处理 DELETE /webhooks/:id
+func deleteWebhook(c *gin.Context) {
+	err := webhooks.Delete(c.Request.Context(), c.Param("id"))
+	switch {
+	case errors.Is(err, errSubscriptionNotFound):
+		c.JSON(404, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(500, gin.H{"error": err.Error()})
+	default:
+		c.Status(204)
+	}
+}
+
+// enableWebhook 处理 POST /webhooks/:id/enable，重新启用被停用的订阅
+func enableWebhook(c *gin.Context) {
+	sub, err := webhooks.Enable(c.Request.Context(), c.Param("id"))
+	switch {
+	case errors.Is(err, errSubscriptionNotFound):
+		c.JSON(404, gin.H{"error": err.Error()})
+	case errors.Is(err, errVerificationFailed):
+		c.JSON(400, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(500, gin.H{"error": err.Error()})
+	default:
+		c.JSON(200, sub)
+	}
+}
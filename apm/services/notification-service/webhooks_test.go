@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"notification-service/webhooksig"
+)
+
+// webhookReceiver 校验签名的订阅方：回应验证事件，记录收到的事件，前 failures 次投递返回 status
+type webhookReceiver struct {
+	t      *testing.T
+	secret string
+
+	mu       sync.Mutex
+	failures int
+	status   int
+	echo     bool
+	events   []http.Header
+	bodies   []webhookPayload
+}
+
+func newWebhookReceiver(t *testing.T, secret string) (*webhookReceiver, *httptest.Server) {
+	r := &webhookReceiver{t: t, secret: secret, status: 500, echo: true}
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return r, srv
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := webhooksig.VerifyRequest(req, r.secret, 0)
+	if err != nil {
+		r.t.Errorf("signature: %v", err)
+		http.Error(w, err.Error(), 401)
+		return
+	}
+	var p webhookPayload
+	json.Unmarshal(body, &p)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if p.Type == eventWebhookVerification {
+		if !r.echo {
+			w.Write([]byte(`{}`))
+			return
+		}
+		w.Write(p.Data)
+		return
+	}
+	r.events = append(r.events, req.Header.Clone())
+	r.bodies = append(r.bodies, p)
+	if r.failures > 0 {
+		r.failures--
+		w.WriteHeader(r.status)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (r *webhookReceiver) received() ([]http.Header, []webhookPayload) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]http.Header(nil), r.events...), append([]webhookPayload(nil), r.bodies...)
+}
+
+func newTestDispatcher(t *testing.T, maxAttempts, disableAfter int) *webhookDispatcher {
+	t.Helper()
+	return startTestDispatcher(t, newMemoryNotificationStore(), maxAttempts, disableAfter)
+}
+
+func startTestDispatcher(t *testing.T, store NotificationStore, maxAttempts, disableAfter int) *webhookDispatcher {
+	t.Helper()
+	d := newWebhookDispatcher(store, http.DefaultClient, 10, maxAttempts, time.Millisecond, disableAfter)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	d.Start(ctx, 2)
+	return d
+}
+
+func testOrderEvent(id string) Event {
+	return Event{ID: id, Type: eventOrderCreated, OccurredAt: "2024-03-01T08:30:00Z", Data: json.RawMessage(`{"id":42,"user_id":1}`)}
+}
+
+func TestWebhookRegistration(t *testing.T) {
+	d := newTestDispatcher(t, 5, 10)
+	recv, srv := newWebhookReceiver(t, "partner-secret-123456")
+
+	sub, secret, err := d.Register(context.Background(), srv.URL, "partner-secret-123456", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret != "partner-secret-123456" || sub.Status != subscriptionActive || len(sub.Events) != 1 || sub.Events[0] != eventOrderCreated {
+		t.Errorf("subscription %+v, secret %q", sub, secret)
+	}
+
+	recv.mu.Lock()
+	recv.echo = false
+	recv.mu.Unlock()
+	if _, _, err := d.Register(context.Background(), srv.URL, "partner-secret-123456", nil); !errors.Is(err, errVerificationFailed) {
+		t.Errorf("endpoint not echoing challenge: got %v", err)
+	}
+	if _, _, err := d.Register(context.Background(), "http://127.0.0.1:1/hook", "partner-secret-123456", nil); !errors.Is(err, errVerificationFailed) {
+		t.Errorf("unreachable endpoint: got %v", err)
+	}
+
+	invalid := []subscriptionRequest{
+		{URL: "ftp://example.com/hook"},
+		{URL: "/relative"},
+		{URL: srv.URL, Secret: "short"},
+		{URL: srv.URL, Events: []string{"order.deleted"}},
+	}
+	for _, req := range invalid {
+		if _, _, err := d.Register(context.Background(), req.URL, req.Secret, req.Events); !errors.Is(err, errInvalidSubscription) {
+			t.Errorf("%+v: got %v, want a validation error", req, err)
+		}
+	}
+	if list, err := d.List(context.Background()); err != nil || len(list) != 1 {
+		t.Errorf("%d subscriptions, want 1: %v", len(list), err)
+	}
+}
+
+func TestWebhookDeliveryIsSignedAndRetried(t *testing.T) {
+	d := newTestDispatcher(t, 5, 10)
+	recv, srv := newWebhookReceiver(t, "partner-secret-123456")
+	sub, _, err := d.Register(context.Background(), srv.URL, recv.secret, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recv.mu.Lock()
+	recv.failures = 2
+	recv.mu.Unlock()
+
+	n, err := d.Publish(context.Background(), testOrderEvent("evt-1"))
+	if err != nil || n != 1 {
+		t.Fatalf("published %d, %v", n, err)
+	}
+	waitFor(t, "delivery", func() bool {
+		headers, _ := recv.received()
+		return len(headers) == 3
+	})
+
+	headers, bodies := recv.received()
+	for i, h := range headers {
+		if h.Get(webhooksig.IDHeader) != "evt-1" || h.Get(webhooksig.EventHeader) != eventOrderCreated {
+			t.Errorf("attempt %d headers %v", i+1, h)
+		}
+	}
+	if bodies[2].ID != "evt-1" || string(bodies[2].Data) != `{"id":42,"user_id":1}` {
+		t.Errorf("payload %+v", bodies[2])
+	}
+	waitFor(t, "failure count reset", func() bool {
+		got, _ := d.Get(context.Background(), sub.ID)
+		return got.ConsecutiveFailures == 0 && got.LastDeliveryAt != nil
+	})
+}
+
+func TestWebhookSubscriptionDisabledAfterFailures(t *testing.T) {
+	d := newTestDispatcher(t, 5, 3)
+	recv, srv := newWebhookReceiver(t, "partner-secret-123456")
+	sub, _, err := d.Register(context.Background(), srv.URL, recv.secret, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recv.mu.Lock()
+	recv.failures = 100
+	recv.mu.Unlock()
+
+	if _, err := d.Publish(context.Background(), testOrderEvent("evt-1")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "subscription disabled", func() bool {
+		got, _ := d.Get(context.Background(), sub.ID)
+		return got.Status == subscriptionDisabled
+	})
+	if headers, _ := recv.received(); len(headers) != 3 {
+		t.Errorf("%d attempts before disabling, want 3", len(headers))
+	}
+	if n, _ := d.Publish(context.Background(), testOrderEvent("evt-2")); n != 0 {
+		t.Errorf("published %d to a disabled subscription", n)
+	}
+
+	// 重新验证后启用
+	got, err := d.Enable(context.Background(), sub.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != subscriptionActive || got.ConsecutiveFailures != 0 || got.DisabledReason != "" {
+		t.Errorf("enabled subscription %+v", got)
+	}
+	if _, err := d.Enable(context.Background(), "wh_missing"); !errors.Is(err, errSubscriptionNotFound) {
+		t.Errorf("got %v, want errSubscriptionNotFound", err)
+	}
+}
+
+func TestWebhookSubscriptionsSurviveRestart(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "notifications.db")
+	store, err := newSQLiteNotificationStore(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recv, srv := newWebhookReceiver(t, "partner-secret-123456")
+	d := startTestDispatcher(t, store, 5, 2)
+	active, _, err := d.Register(ctx, srv.URL, recv.secret, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	disabled, _, err := d.Register(ctx, srv.URL, recv.secret, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recv.mu.Lock()
+	recv.failures, recv.status = 1, http.StatusGone
+	recv.mu.Unlock()
+	// 只投递给第二个订阅，让它被停用
+	d.schedule(&webhookDelivery{EventID: "evt-1", Event: eventOrderCreated, SubscriptionID: disabled.ID, Body: []byte(`{}`)}, 0)
+	waitFor(t, "subscription disabled", func() bool {
+		got, _ := d.Get(ctx, disabled.ID)
+		return got.Status == subscriptionDisabled
+	})
+	store.Close()
+
+	// 重启后订阅、密钥和停用状态都还在，事件只发给活跃的订阅
+	store, err = newSQLiteNotificationStore(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	d = startTestDispatcher(t, store, 5, 2)
+	list, err := d.List(ctx)
+	if err != nil || len(list) != 2 || list[0].ID != active.ID || list[1].ID != disabled.ID {
+		t.Fatalf("subscriptions after restart %+v: %v", list, err)
+	}
+	if list[1].Status != subscriptionDisabled || !strings.Contains(list[1].DisabledReason, "410") || list[1].LastDeliveryAt == nil {
+		t.Errorf("disabled subscription %+v", list[1])
+	}
+	if n, err := d.Publish(ctx, testOrderEvent("evt-2")); err != nil || n != 1 {
+		t.Fatalf("published %d, %v", n, err)
+	}
+	waitFor(t, "delivery signed with the stored secret", func() bool {
+		headers, _ := recv.received()
+		return len(headers) == 2 && headers[1].Get(webhooksig.IDHeader) == "evt-2"
+	})
+
+	if _, err := d.Enable(ctx, disabled.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Delete(ctx, active.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Delete(ctx, active.ID); !errors.Is(err, errSubscriptionNotFound) {
+		t.Errorf("second delete: got %v, want errSubscriptionNotFound", err)
+	}
+	if got, err := d.Get(ctx, disabled.ID); err != nil || got.Status != subscriptionActive || got.Secret != recv.secret {
+		t.Errorf("enabled subscription %+v: %v", got, err)
+	}
+}
+
+func TestWebhookGoneDisablesImmediately(t *testing.T) {
+	d := newTestDispatcher(t, 5, 10)
+	recv, srv := newWebhookReceiver(t, "partner-secret-123456")
+	sub, _, err := d.Register(context.Background(), srv.URL, recv.secret, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recv.mu.Lock()
+	recv.failures, recv.status = 1, http.StatusGone
+	recv.mu.Unlock()
+
+	if _, err := d.Publish(context.Background(), testOrderEvent("evt-1")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "subscription disabled", func() bool {
+		got, _ := d.Get(context.Background(), sub.ID)
+		return got.Status == subscriptionDisabled && strings.Contains(got.DisabledReason, "410")
+	})
+}
+
+func TestOrderEventsAreDeliveredToWebhooks(t *testing.T) {
+	var err error
+	notificationTemplates, err = newTemplateEngine(templateFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := newNotifier(map[string]Provider{channelPush: &flakyProvider{}}, []string{channelPush})
+	jobQueue = newNotificationQueue(n, newMemoryNotificationStore(), 10, 1, time.Millisecond)
+	notificationRouter = newRouter(newMemoryNotificationStore(), n)
+	webhooks = newTestDispatcher(t, 5, 10)
+	useUserService(t, nil, 0)
+
+	recv, srv := newWebhookReceiver(t, "partner-secret-123456")
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/webhooks", createWebhook)
+	r.GET("/webhooks/:id", getWebhook)
+	r.POST("/events", receiveEvent(newEventDeduper(newMemoryNotificationStore(), time.Hour)))
+
+	w := serve(r, "POST", "/webhooks", `{"url":"`+srv.URL+`","secret":"partner-secret-123456"}`, nil)
+	var created struct {
+		Subscription Subscription `json:"subscription"`
+		Secret       string       `json:"secret"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil || w.Code != http.StatusCreated || created.Secret != recv.secret {
+		t.Fatalf("create: %d %s", w.Code, w.Body)
+	}
+	// 密钥只在创建时返回
+	if w := serve(r, "GET", "/webhooks/"+created.Subscription.ID, "", nil); w.Code != http.StatusOK || strings.Contains(w.Body.String(), recv.secret) {
+		t.Errorf("get: %d %s", w.Code, w.Body)
+	}
+
+	event := `{"id":"evt-9","type":"order.created","data":{"id":42,"user_id":1,"product":"book","amount":12}}`
+	for i := 0; i < 2; i++ {
+		if w := serve(r, "POST", "/events", event, nil); w.Code != http.StatusOK {
+			t.Fatalf("event: %d %s", w.Code, w.Body)
+		}
+	}
+	waitFor(t, "webhook delivery", func() bool {
+		headers, _ := recv.received()
+		return len(headers) == 1
+	})
+	// 重复投递的事件不会再发给订阅方
+	time.Sleep(20 * time.Millisecond)
+	if headers, _ := recv.received(); len(headers) != 1 || headers[0].Get(webhooksig.IDHeader) != "evt-9" {
+		t.Errorf("received %v", headers)
+	}
+}
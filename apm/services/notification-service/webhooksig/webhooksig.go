@@ -0,0 +1,105 @@
+// Package webhooksig 签名和校验 notification-service 发出的 webhook。
+//
+// 每个请求带有 X-Webhook-Timestamp（Unix 秒）和 X-Webhook-Signature 头，签名为
+// "sha256=" + hex(HMAC-SHA256(secret, timestamp + "." + body))。接收方应使用 Verify 或
+// VerifyRequest 校验签名，并拒绝时间戳超出容忍范围的请求以防止重放；同一事件可能被投递多次，
+// 应按 X-Webhook-Id 去重。
+package webhooksig
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// IDHeader 投递的事件 ID，重试时保持不变
+	IDHeader = "X-Webhook-Id"
+	// EventHeader 事件类型，例如 order.created
+	EventHeader     = "X-Webhook-Event"
+	TimestampHeader = "X-Webhook-Timestamp"
+	SignatureHeader = "X-Webhook-Signature"
+
+	// DefaultTolerance 默认允许的时间戳偏差
+	DefaultTolerance = 5 * time.Minute
+	// MaxBodySize VerifyRequest 读取的最大请求体
+	MaxBodySize = 1 << 20
+
+	signaturePrefix = "sha256="
+)
+
+var (
+	ErrMissingHeaders   = errors.New("webhooksig: missing timestamp or signature header")
+	ErrInvalidTimestamp = errors.New("webhooksig: invalid timestamp")
+	// ErrTimestampOutOfRange 时间戳超出容忍范围，可能是重放的请求
+	ErrTimestampOutOfRange = errors.New("webhooksig: timestamp outside tolerance")
+	ErrSignatureMismatch   = errors.New("webhooksig: signature mismatch")
+	ErrBodyTooLarge        = errors.New("webhooksig: request body too large")
+)
+
+// Sign 返回 body 在 timestamp 时刻的签名
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	return sign(secret, strconv.FormatInt(timestamp.Unix(), 10), body)
+}
+
+func sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SetHeaders 为出站请求设置时间戳和签名头
+func SetHeaders(h http.Header, secret string, timestamp time.Time, body []byte) {
+	h.Set(TimestampHeader, strconv.FormatInt(timestamp.Unix(), 10))
+	h.Set(SignatureHeader, Sign(secret, timestamp, body))
+}
+
+// Verify 校验签名，并要求时间戳与当前时间相差不超过 tolerance（为 0 时使用 DefaultTolerance）
+func Verify(secret string, h http.Header, body []byte, tolerance time.Duration) error {
+	return verify(secret, h, body, tolerance, time.Now())
+}
+
+func verify(secret string, h http.Header, body []byte, tolerance time.Duration, now time.Time) error {
+	ts, sig := h.Get(TimestampHeader), h.Get(SignatureHeader)
+	if ts == "" || sig == "" {
+		return ErrMissingHeaders
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: %q", ErrInvalidTimestamp, ts)
+	}
+	if tolerance <= 0 {
+		tolerance = DefaultTolerance
+	}
+	if d := now.Sub(time.Unix(unix, 0)); d > tolerance || d < -tolerance {
+		return ErrTimestampOutOfRange
+	}
+	// 先校验签名再信任请求体，比较时间恒定
+	if !hmac.Equal([]byte(sig), []byte(sign(secret, ts, body))) {
+		return ErrSignatureMismatch
+	}
+	return nil
+}
+
+// VerifyRequest 读取请求体并校验签名，成功时返回请求体。请求体超过 MaxBodySize 时返回 ErrBodyTooLarge
+func VerifyRequest(r *http.Request, secret string, tolerance time.Duration) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, MaxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > MaxBodySize {
+		return nil, ErrBodyTooLarge
+	}
+	if err := Verify(secret, r.Header, body, tolerance); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
@@ -0,0 +1,80 @@
+package webhooksig
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"id":"evt-1","type":"order.created"}`)
+	h := http.Header{}
+	SetHeaders(h, "s3cret", now, body)
+
+	if err := verify("s3cret", h, body, 0, now.Add(time.Minute)); err != nil {
+		t.Fatalf("valid signature: %v", err)
+	}
+
+	cases := []struct {
+		name    string
+		secret  string
+		header  http.Header
+		body    []byte
+		now     time.Time
+		wantErr error
+	}{
+		{"wrong secret", "other", h, body, now, ErrSignatureMismatch},
+		{"tampered body", "s3cret", h, []byte(`{"id":"evt-2"}`), now, ErrSignatureMismatch},
+		{"stale", "s3cret", h, body, now.Add(10 * time.Minute), ErrTimestampOutOfRange},
+		{"from the future", "s3cret", h, body, now.Add(-10 * time.Minute), ErrTimestampOutOfRange},
+		{"missing headers", "s3cret", http.Header{}, body, now, ErrMissingHeaders},
+		{"bad timestamp", "s3cret", http.Header{TimestampHeader: {"yesterday"}, SignatureHeader: {"sha256=00"}}, body, now, ErrInvalidTimestamp},
+	}
+	for _, tc := range cases {
+		if err := verify(tc.secret, tc.header, tc.body, 0, tc.now); !errors.Is(err, tc.wantErr) {
+			t.Errorf("%s: got %v, want %v", tc.name, err, tc.wantErr)
+		}
+	}
+}
+
+func TestSignKnownAnswer(t *testing.T) {
+	// 接收方用其他语言实现时可以用这组值核对
+	got := Sign("s3cret", time.Unix(1700000000, 0), []byte(`{"hello":"world"}`))
+	want := "sha256=6f4351a15224248663bdabf854a428c6942e6be080ed368c1833e5d8a1d5e9a8"
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestVerifyRequestRejectsLargeBody(t *testing.T) {
+	req := httptest.NewRequest("POST", "/hook", strings.NewReader(strings.Repeat("x", MaxBodySize+1)))
+	if _, err := VerifyRequest(req, "s3cret", 0); !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("got %v, want ErrBodyTooLarge", err)
+	}
+}
+
+func ExampleVerifyRequest() {
+	secret := "whsec_example"
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := VerifyRequest(r, secret, DefaultTolerance)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		// 按 X-Webhook-Id 去重后处理事件
+		fmt.Printf("%s %s %s\n", r.Header.Get(IDHeader), r.Header.Get(EventHeader), body)
+	})
+
+	body := []byte(`{"id":"evt-1","type":"order.created"}`)
+	req := httptest.NewRequest("POST", "/hook", strings.NewReader(string(body)))
+	req.Header.Set(IDHeader, "evt-1")
+	req.Header.Set(EventHeader, "order.created")
+	SetHeaders(req.Header, secret, time.Now(), body)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	// Output: evt-1 order.created {"id":"evt-1","type":"order.created"}
+}
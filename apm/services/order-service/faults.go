@@ -0,0 +1,16 @@
+package main
+
+import (
+	_ "embed"
+
+	"github.com/xyzbit/devops-demo/pkg/faults"
+)
+
+// 故障注入的规则格式、/admin/faults 管理接口和鉴权见 pkg/faults，
+// 未设置 FAULTS_CONFIG 时使用内置的 faults.json 中的基线规则
+
+//go:embed faults.json
+var defaultFaults []byte
+
+// faultInjector 当前的故障规则，在 main 中加载；测试中默认没有规则
+var faultInjector = faults.New("", nil)
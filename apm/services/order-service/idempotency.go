@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotencyReplayedHeader 标记响应来自之前保存的结果
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+)
+
+var idempotencyRequests = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "idempotency_requests_total",
+		Help: "Total number of requests carrying an Idempotency-Key, by result (stored, replayed, conflict, not_stored)",
+	},
+	[]string{"result"},
+)
+
+func init() {
+	prometheus.MustRegister(idempotencyRequests)
+}
+
+// IdempotencyRecord 一个幂等键对应的请求及其结果，保存在订单仓储中，多个副本共用
+type IdempotencyRecord struct {
+	Key string
+	// Fingerprint 请求体的摘要，同一个键只能用于相同的请求
+	Fingerprint string
+	// Status 为 0 表示首个请求仍在处理中
+	Status int
+	Body   []byte
+	// ExpiresAt 之后记录失效；处理中的记录过期说明首个请求所在的副本已经崩溃，由重试重新执行
+	ExpiresAt time.Time
+}
+
+const (
+	// idempotencyPendingLease 处理中的记录的有效期，需大于创建订单的最长耗时
+	idempotencyPendingLease = 2 * time.Minute
+	// idempotencyPollInterval 等待首个请求（可能在其他副本上）结果的轮询间隔
+	idempotencyPollInterval = 50 * time.Millisecond
+)
+
+// idempotencyClaim 当前请求持有的幂等键，随请求的 context 传到创建订单的事务中
+type idempotencyClaim struct {
+	key string
+	ttl time.Duration
+}
+
+type idempotencyClaimKey struct{}
+
+func withIdempotencyClaim(ctx context.Context, claim idempotencyClaim) context.Context {
+	return context.WithValue(ctx, idempotencyClaimKey{}, claim)
+}
+
+func idempotencyClaimFromContext(ctx context.Context) (idempotencyClaim, bool) {
+	claim, ok := ctx.Value(idempotencyClaimKey{}).(idempotencyClaim)
+	return claim, ok
+}
+
+// created 订单创建成功时的结果，由仓储在写入订单的同一事务中保存，
+// 订单提交后副本立即崩溃，重试也会重放这个结果而不是再创建一个订单
+func (c idempotencyClaim) created(order Order, saga Saga) (IdempotencyRecord, error) {
+	body, err := json.Marshal(createdOrderResponse(order, saga))
+	if err != nil {
+		return IdempotencyRecord{}, err
+	}
+	return IdempotencyRecord{Key: c.key, Status: 201, Body: body, ExpiresAt: time.Now().Add(c.ttl)}, nil
+}
+
+// idempotencyStore 幂等结果保存在订单仓储中，结果在 ttl 后过期
+type idempotencyStore struct {
+	repo OrderRepository
+	ttl  time.Duration
+}
+
+func newIdempotencyStore(repo OrderRepository, ttl time.Duration) *idempotencyStore {
+	s := &idempotencyStore{repo: repo, ttl: ttl}
+	go s.sweep(time.Minute)
+	return s
+}
+
+// sweep 定期清理过期的结果
+func (s *idempotencyStore) sweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if _, err := s.repo.DeleteExpiredIdempotencyKeys(context.Background(), now); err != nil {
+			log.Printf("Failed to delete expired idempotency keys: %v", err)
+		}
+	}
+}
+
+// bodyRecorder 在写回客户端的同时保留响应体
+type bodyRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bodyRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bodyRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// idempotencyMiddleware 对携带 Idempotency-Key 的请求只执行一次：
+// 相同键和相同请求体的重试重放首次结果，相同键但请求体不同返回 422。
+// 结果保存在订单仓储中，多个副本共用，重启后仍然有效。5xx 结果不保存，客户端可以用同一个键重试
+func idempotencyMiddleware(store *idempotencyStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(idempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(body)
+		fingerprint := hex.EncodeToString(sum[:])
+
+		span := trace.SpanFromContext(c.Request.Context())
+		span.SetAttributes(attribute.String("idempotency.key", key))
+
+		ctx := c.Request.Context()
+		for {
+			now := time.Now()
+			entry, owner, err := store.repo.ClaimIdempotencyKey(ctx,
+				IdempotencyRecord{Key: key, Fingerprint: fingerprint, ExpiresAt: now.Add(idempotencyPendingLease)}, now)
+			if err != nil {
+				respondStoreError(c, "create_order", err)
+				c.Abort()
+				return
+			}
+			if owner {
+				recorder := &bodyRecorder{ResponseWriter: c.Writer}
+				c.Writer = recorder
+				c.Request = c.Request.WithContext(withIdempotencyClaim(ctx, idempotencyClaim{key: key, ttl: store.ttl}))
+				c.Next()
+
+				// 请求可能已超时，结果仍要落库
+				finishCtx := context.WithoutCancel(ctx)
+				status := c.Writer.Status()
+				if status < 500 {
+					// 创建成功的结果已在订单的事务中保存，这里保存的是其他 4xx 结果
+					err = store.repo.SaveIdempotencyResponse(finishCtx,
+						IdempotencyRecord{Key: key, Status: status, Body: recorder.body.Bytes(), ExpiresAt: time.Now().Add(store.ttl)})
+				} else {
+					err = store.repo.DeleteIdempotencyKey(finishCtx, key)
+				}
+				if err != nil {
+					log.Printf("Failed to finish idempotency key %s: %v", key, err)
+				}
+				if status < 500 && err == nil {
+					idempotencyRequests.WithLabelValues("stored").Inc()
+				} else {
+					idempotencyRequests.WithLabelValues("not_stored").Inc()
+				}
+				span.SetAttributes(attribute.Bool("idempotency.replayed", false))
+				return
+			}
+
+			if entry.Fingerprint != fingerprint {
+				idempotencyRequests.WithLabelValues("conflict").Inc()
+				span.SetAttributes(attribute.String("idempotency.result", "conflict"))
+				c.AbortWithStatusJSON(422, gin.H{"error": "Idempotency-Key was already used with a different request body"})
+				return
+			}
+
+			if entry.Status == 0 {
+				// 首个请求仍在处理中，等待其结果；它失败且未保存结果时由当前请求重新执行
+				select {
+				case <-time.After(idempotencyPollInterval):
+				case <-ctx.Done():
+					c.AbortWithStatusJSON(504, gin.H{"error": ctx.Err().Error()})
+					return
+				}
+				continue
+			}
+
+			idempotencyRequests.WithLabelValues("replayed").Inc()
+			span.SetAttributes(attribute.Bool("idempotency.replayed", true))
+			c.Header(idempotencyReplayedHeader, "true")
+			c.Data(entry.Status, "application/json; charset=utf-8", entry.Body)
+			c.Abort()
+			return
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newIdempotencyTestRouter(t *testing.T, repo OrderRepository) *gin.Engine {
+	t.Helper()
+
+	users := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":1,"name":"test"}`))
+	}))
+	t.Cleanup(users.Close)
+	t.Setenv("USER_SERVICE_URL", users.URL)
+
+	gin.SetMode(gin.TestMode)
+	orderRepo = repo
+	orderSaga = newOrderSaga(orderRepo, callUserService, newStockService(orderRepo, 100), newPaymentService(orderRepo, 1000))
+	r := gin.New()
+	r.POST("/orders", idempotencyMiddleware(newIdempotencyStore(orderRepo, time.Hour)), createOrder)
+	return r
+}
+
+func postOrder(r *gin.Engine, key, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/orders", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set(idempotencyKeyHeader, key)
+	}
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func countOrders(t *testing.T) int {
+	t.Helper()
+	page, err := orderRepo.List(context.Background(), OrderQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(page.Orders)
+}
+
+func TestIdempotencyKeyReplaysFirstResult(t *testing.T) {
+	r := newIdempotencyTestRouter(t, newMemoryOrderRepository(nil))
+	body := `{"user_id":1,"product":"p","amount":1}`
+
+	first := postOrder(r, "key-1", body)
+	if first.Code != 201 {
+		t.Fatalf("first: status %d: %s", first.Code, first.Body.String())
+	}
+	second := postOrder(r, "key-1", body)
+	if second.Code != 201 || second.Body.String() != first.Body.String() {
+		t.Fatalf("replay: got %d %s, want %d %s", second.Code, second.Body.String(), first.Code, first.Body.String())
+	}
+	if second.Header().Get(idempotencyReplayedHeader) != "true" {
+		t.Fatalf("replay is missing %s header", idempotencyReplayedHeader)
+	}
+
+	conflict := postOrder(r, "key-1", `{"user_id":1,"product":"other","amount":1}`)
+	if conflict.Code != 422 {
+		t.Fatalf("different body: got %d, want 422", conflict.Code)
+	}
+
+	if n := countOrders(t); n != 1 {
+		t.Fatalf("got %d orders, want 1", n)
+	}
+
+	// 不带幂等键的请求不受影响
+	postOrder(r, "", body)
+	postOrder(r, "", body)
+	if n := countOrders(t); n != 3 {
+		t.Fatalf("got %d orders, want 3", n)
+	}
+}
+
+func TestIdempotencyKeyConcurrentRetries(t *testing.T) {
+	r := newIdempotencyTestRouter(t, newMemoryOrderRepository(nil))
+	body := `{"user_id":1,"product":"p","amount":1}`
+
+	const retries = 10
+	var wg sync.WaitGroup
+	responses := make([]string, retries)
+	for i := 0; i < retries; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w := postOrder(r, "key-concurrent", body)
+			if w.Code != 201 {
+				t.Errorf("status %d: %s", w.Code, w.Body.String())
+			}
+			responses[i] = w.Body.String()
+		}(i)
+	}
+	wg.Wait()
+
+	if n := countOrders(t); n != 1 {
+		t.Fatalf("got %d orders, want 1", n)
+	}
+	for _, resp := range responses[1:] {
+		if resp != responses[0] {
+			t.Fatalf("responses differ: %s vs %s", resp, responses[0])
+		}
+	}
+}
+
+func TestIdempotencyKeyIsReleasedAfterServerError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	calls := 0
+	r := gin.New()
+	r.POST("/orders", idempotencyMiddleware(newIdempotencyStore(newMemoryOrderRepository(nil), time.Hour)), func(c *gin.Context) {
+		calls++
+		if calls == 1 {
+			c.JSON(503, gin.H{"error": "unavailable"})
+			return
+		}
+		c.JSON(201, gin.H{"ok": true})
+	})
+
+	if w := postOrder(r, "key-retry", `{}`); w.Code != 503 {
+		t.Fatalf("first: got %d, want 503", w.Code)
+	}
+	if w := postOrder(r, "key-retry", `{}`); w.Code != 201 {
+		t.Fatalf("retry: got %d, want 201", w.Code)
+	}
+}
+
+func TestIdempotencyKeySurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.db")
+	repo, err := newSQLiteOrderRepository(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := `{"user_id":1,"product":"p","amount":1}`
+	first := postOrder(newIdempotencyTestRouter(t, repo), "key-restart", body)
+	if first.Code != 201 {
+		t.Fatalf("first: status %d: %s", first.Code, first.Body.String())
+	}
+
+	// 订单已提交但副本在中间件保存结果前崩溃：结果已随订单一起写入
+	claim := IdempotencyRecord{Key: "key-crash", Fingerprint: "f", ExpiresAt: time.Now().Add(time.Hour)}
+	if _, owner, err := repo.ClaimIdempotencyKey(context.Background(), claim, time.Now()); err != nil || !owner {
+		t.Fatalf("claim: %v, %v", owner, err)
+	}
+	ctx := withIdempotencyClaim(context.Background(), idempotencyClaim{key: "key-crash", ttl: time.Hour})
+	if _, err := orderSaga.Start(ctx, 1, "p", 1); err != nil {
+		t.Fatal(err)
+	}
+	repo.Close()
+
+	// 重启后（或另一个副本）用同一个键重试，重放首次结果而不是再创建订单
+	repo, err = newSQLiteOrderRepository(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+	r := newIdempotencyTestRouter(t, repo)
+	second := postOrder(r, "key-restart", body)
+	if second.Code != 201 || second.Body.String() != first.Body.String() || second.Header().Get(idempotencyReplayedHeader) != "true" {
+		t.Fatalf("replay: got %d %s, want %d %s", second.Code, second.Body.String(), first.Code, first.Body.String())
+	}
+	rec, owner, err := repo.ClaimIdempotencyKey(context.Background(), claim, time.Now())
+	if err != nil || owner || rec.Status != 201 {
+		t.Fatalf("crashed request: %+v, owner %v: %v", rec, owner, err)
+	}
+	if n := countOrders(t); n != len(seedOrders)+2 {
+		t.Fatalf("got %d orders, want %d", n, len(seedOrders)+2)
+	}
+
+	// 过期的结果被清理，键可以重新使用
+	if n, err := repo.DeleteExpiredIdempotencyKeys(context.Background(), time.Now().Add(2*time.Hour)); err != nil || n != 2 {
+		t.Fatalf("deleted %d expired keys: %v", n, err)
+	}
+	if w := postOrder(r, "key-restart", body); w.Code != 201 || w.Header().Get(idempotencyReplayedHeader) != "" {
+		t.Fatalf("after expiry: got %d, replayed %q", w.Code, w.Header().Get(idempotencyReplayedHeader))
+	}
+}
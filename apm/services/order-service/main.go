@@ -3,10 +3,10 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"math/rand"
 	"net/http"
 	"os"
 	"strconv"
@@ -18,12 +18,22 @@ import (
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
 	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
 	"go.opentelemetry.io/otel/trace"
+
+	"github.com/xyzbit/devops-demo/pkg/deadline"
+	"github.com/xyzbit/devops-demo/pkg/deadline/gindeadline"
+	"github.com/xyzbit/devops-demo/pkg/faults"
+	"github.com/xyzbit/devops-demo/pkg/faults/ginfaults"
+	"github.com/xyzbit/devops-demo/pkg/identity/ginidentity"
+	"github.com/xyzbit/devops-demo/pkg/requestid"
+	"github.com/xyzbit/devops-demo/pkg/requestid/ginrequestid"
+	"order-service/userpb"
 )
 
 // Order 订单结构体
@@ -63,26 +73,10 @@ var (
 		[]string{"operation", "status"},
 	)
 
-	// 模拟订单数据
-	orders = []Order{
-		{
-			ID:       1,
-			UserID:   1,
-			Product:  "笔记本电脑",
-			Amount:   5999.99,
-			Status:   "completed",
-			CreateAt: "2023-01-01T10:00:00Z",
-		},
-		{
-			ID:       2,
-			UserID:   2,
-			Product:  "智能手机",
-			Amount:   2999.99,
-			Status:   "processing",
-			CreateAt: "2023-01-02T11:00:00Z",
-		},
-	}
-	nextOrderID = 3
+	// orderRepo 订单存储，在 main 中根据 ORDER_STORE 初始化
+	orderRepo OrderRepository
+	// orderSaga 创建订单的 saga 编排器
+	orderSaga *sagaOrchestrator
 )
 
 func init() {
@@ -92,6 +86,71 @@ func init() {
 	prometheus.MustRegister(orderOperations)
 }
 
+// serviceVersion 当前部署的版本，灰度实例通过 SERVICE_VERSION 区分
+func serviceVersion() string {
+	if v := os.Getenv("SERVICE_VERSION"); v != "" {
+		return v
+	}
+	return "1.0.0"
+}
+
+// idempotencyTTL 幂等结果的保存时间，由 IDEMPOTENCY_TTL 配置，默认 24h
+func idempotencyTTL() time.Duration {
+	if v := os.Getenv("IDEMPOTENCY_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("Invalid IDEMPOTENCY_TTL %q: %v", v, err)
+		}
+		return d
+	}
+	return 24 * time.Hour
+}
+
+// outboxPollInterval outbox 中继的轮询间隔，由 OUTBOX_POLL_INTERVAL 配置，默认 1s
+func outboxPollInterval() time.Duration {
+	if v := os.Getenv("OUTBOX_POLL_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("Invalid OUTBOX_POLL_INTERVAL %q: %v", v, err)
+		}
+		return d
+	}
+	return time.Second
+}
+
+// outboxMaxAttempts 事件最多投递的次数，由 OUTBOX_MAX_ATTEMPTS 配置，默认 20（按退避间隔约 15 分钟）
+func outboxMaxAttempts() int {
+	if v := os.Getenv("OUTBOX_MAX_ATTEMPTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			log.Fatalf("Invalid OUTBOX_MAX_ATTEMPTS %q", v)
+		}
+		return n
+	}
+	return 20
+}
+
+// standInConfig 库存和支付替身的参数：STOCK_PER_PRODUCT（每个商品的初始库存，默认 1000）
+// 和 PAYMENT_LIMIT（单笔扣款上限，默认 10000）
+func standInConfig() (stock int, paymentLimit float64) {
+	stock, paymentLimit = 1000, 10000
+	if v := os.Getenv("STOCK_PER_PRODUCT"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatalf("Invalid STOCK_PER_PRODUCT %q: %v", v, err)
+		}
+		stock = n
+	}
+	if v := os.Getenv("PAYMENT_LIMIT"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			log.Fatalf("Invalid PAYMENT_LIMIT %q: %v", v, err)
+		}
+		paymentLimit = f
+	}
+	return stock, paymentLimit
+}
+
 func initTracer() (*sdktrace.TracerProvider, error) {
 	endpoint := os.Getenv("JAEGER_ENDPOINT")
 	if endpoint == "" {
@@ -111,7 +170,7 @@ func initTracer() (*sdktrace.TracerProvider, error) {
 	res, err := resource.New(context.Background(),
 		resource.WithAttributes(
 			semconv.ServiceName("order-service"),
-			semconv.ServiceVersion("1.0.0"),
+			semconv.ServiceVersion(serviceVersion()),
 			attribute.String("environment", "development"),
 		),
 	)
@@ -137,16 +196,42 @@ func callUserService(ctx context.Context, userID int) (map[string]interface{}, e
 	ctx, span := tracer.Start(ctx, "call-user-service")
 	defer span.End()
 
+	transport := userTransportHTTP
+	if userGRPCClient != nil {
+		transport = userTransportGRPC
+	}
+	span.SetAttributes(
+		attribute.Int("user.id", userID),
+		attribute.String("user_service.transport", transport),
+	)
+
+	// 没有上游截止时间时使用默认超时，否则沿用上游剩余的时间预算
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+	}
+
+	start := time.Now()
+	var user map[string]interface{}
+	var err error
+	if transport == userTransportGRPC {
+		user, err = callUserServiceGRPC(ctx, span, userID)
+	} else {
+		user, err = callUserServiceHTTP(ctx, span, userID)
+	}
+	userServiceCallDuration.WithLabelValues(transport).Observe(time.Since(start).Seconds())
+	return user, err
+}
+
+func callUserServiceHTTP(ctx context.Context, span trace.Span, userID int) (map[string]interface{}, error) {
 	userServiceURL := os.Getenv("USER_SERVICE_URL")
 	if userServiceURL == "" {
 		userServiceURL = "http://localhost:8081"
 	}
 
 	url := fmt.Sprintf("%s/users/%d", userServiceURL, userID)
-	span.SetAttributes(
-		attribute.String("http.url", url),
-		attribute.Int("user.id", userID),
-	)
+	span.SetAttributes(attribute.String("http.url", url))
 
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
@@ -154,17 +239,29 @@ func callUserService(ctx context.Context, userID int) (map[string]interface{}, e
 		return nil, err
 	}
 
-	// 注入追踪头
+	// 注入追踪头、剩余时间预算和请求 ID
 	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	deadline.Inject(ctx, req)
+	requestid.Inject(ctx, req)
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		span.RecordError(err)
+		if ctx.Err() != nil {
+			deadline.RecordCancellation(span, err)
+		} else {
+			span.RecordError(err)
+		}
 		return nil, err
 	}
 	defer resp.Body.Close()
 
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		err := fmt.Errorf("user service returned status %d", resp.StatusCode)
+		span.RecordError(err)
+		return nil, err
+	}
+
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		span.RecordError(err)
@@ -176,8 +273,6 @@ func callUserService(ctx context.Context, userID int) (map[string]interface{}, e
 		span.RecordError(err)
 		return nil, err
 	}
-
-	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
 	return user, nil
 }
 
@@ -197,7 +292,8 @@ func loggingMiddleware() gin.HandlerFunc {
 		Formatter: func(param gin.LogFormatterParams) string {
 			spanCtx := trace.SpanContextFromContext(param.Request.Context())
 			traceID := spanCtx.TraceID().String()
-			return fmt.Sprintf(`{"time":"%s","service":"order-service","method":"%s","uri":"%s","status":%d,"latency":"%s","user_agent":"%s","trace_id":"%s"}`+"\n",
+			requestID, _ := param.Keys[ginrequestid.Key].(string)
+			return fmt.Sprintf(`{"time":"%s","service":"order-service","method":"%s","uri":"%s","status":%d,"latency":"%s","user_agent":"%s","trace_id":"%s","request_id":"%s"}`+"\n",
 				param.TimeStamp.Format(time.RFC3339),
 				param.Method,
 				param.Path,
@@ -205,6 +301,7 @@ func loggingMiddleware() gin.HandlerFunc {
 				param.Latency,
 				param.Request.UserAgent(),
 				traceID,
+				requestID,
 			)
 		},
 		Output: getLogWriter(),
@@ -220,36 +317,75 @@ func getLogWriter() io.Writer {
 	return io.MultiWriter(os.Stdout, logFile)
 }
 
-func simulateWork(ctx context.Context, operation string) {
+func simulateWork(ctx context.Context, operation string) error {
 	tracer := otel.Tracer("order-service")
-	_, span := tracer.Start(ctx, fmt.Sprintf("simulate-%s", operation))
+	ctx, span := tracer.Start(ctx, fmt.Sprintf("simulate-%s", operation))
 	defer span.End()
 
-	workTime := time.Duration(rand.Intn(300)+100) * time.Millisecond
-	time.Sleep(workTime)
-
+	// 耗时和错误由故障注入规则决定，默认使用 faults.json 中的基线规则
+	start := time.Now()
+	err := faultInjector.Inject(ctx, operation)
 	span.SetAttributes(
 		attribute.String("operation", operation),
-		attribute.Int64("work_duration_ms", workTime.Milliseconds()),
+		attribute.Int64("work_duration_ms", time.Since(start).Milliseconds()),
 	)
+
+	switch {
+	case err == nil:
+		return nil
+	case ctx.Err() != nil:
+		// 时间预算耗尽时立即中止
+		deadline.RecordCancellation(span, err)
+	default:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
 }
 
 func getOrders(c *gin.Context) {
 	span := trace.SpanFromContext(c.Request.Context())
 	span.SetAttributes(attribute.String("operation", "get_orders"))
 
-	simulateWork(c.Request.Context(), "database_query")
+	query, err := parseOrderQuery(c.Request.URL.Query())
+	if err != nil {
+		orderOperations.WithLabelValues("get_orders", "error").Inc()
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 记录过滤基数，便于分析不同查询组合的耗时
+	filters := query.filterFields()
+	span.SetAttributes(
+		attribute.Int("orders.filter.cardinality", len(filters)),
+		attribute.StringSlice("orders.filter.fields", filters),
+		attribute.String("orders.sort", query.SortBy),
+		attribute.Bool("orders.sort.desc", query.Desc),
+		attribute.Int("orders.limit", query.Limit),
+		attribute.Bool("orders.cursor", query.After != nil),
+	)
+
+	page, err := orderRepo.List(c.Request.Context(), query)
+	if err != nil {
+		respondStoreError(c, "get_orders", err)
+		return
+	}
 
 	orderOperations.WithLabelValues("get_orders", "success").Inc()
 	span.SetAttributes(
 		attribute.String("result", "success"),
-		attribute.Int("orders.count", len(orders)),
+		attribute.Int("orders.count", len(page.Orders)),
+		attribute.Int("orders.total", page.Total),
 	)
 
-	c.JSON(200, gin.H{
-		"orders": orders,
-		"total":  len(orders),
-	})
+	response := gin.H{
+		"orders": page.Orders,
+		"total":  page.Total,
+	}
+	if page.NextCursor != "" {
+		response["next_cursor"] = page.NextCursor
+	}
+	c.JSON(200, response)
 }
 
 func createOrder(c *gin.Context) {
@@ -273,40 +409,81 @@ func createOrder(c *gin.Context) {
 		attribute.Float64("amount", orderReq.Amount),
 	)
 
-	// 验证用户是否存在
-	user, err := callUserService(c.Request.Context(), orderReq.UserID)
+	// 验证用户、预留库存、扣款并确认订单，任何一步失败都会撤销已完成的步骤
+	saga, err := orderSaga.Start(c.Request.Context(), orderReq.UserID, orderReq.Product, orderReq.Amount)
+	span.SetAttributes(attribute.String("saga.id", saga.ID))
 	if err != nil {
-		orderOperations.WithLabelValues("create_order", "error").Inc()
-		span.RecordError(err)
-		c.JSON(400, gin.H{"error": "用户验证失败"})
+		respondSagaError(c, saga, err)
 		return
 	}
 
-	// 模拟订单处理
-	simulateWork(c.Request.Context(), "order_processing")
-
-	newOrder := Order{
-		ID:       nextOrderID,
-		UserID:   orderReq.UserID,
-		Product:  orderReq.Product,
-		Amount:   orderReq.Amount,
-		Status:   "processing",
-		CreateAt: time.Now().Format(time.RFC3339),
+	order, err := orderRepo.Get(c.Request.Context(), saga.OrderID)
+	if err != nil {
+		respondStoreError(c, "create_order", err)
+		return
 	}
 
-	orders = append(orders, newOrder)
-	nextOrderID++
-
 	orderOperations.WithLabelValues("create_order", "success").Inc()
 	span.SetAttributes(
 		attribute.String("result", "success"),
-		attribute.Int("order.id", newOrder.ID),
+		attribute.Int("order.id", order.ID),
 	)
 
-	c.JSON(201, gin.H{
-		"order": newOrder,
-		"user":  user,
-	})
+	c.JSON(201, createdOrderResponse(order, saga))
+}
+
+// createdOrderResponse 创建订单成功的响应，带幂等键时由仓储与订单一起保存
+func createdOrderResponse(order Order, saga Saga) gin.H {
+	return gin.H{
+		"order":   order,
+		"user":    saga.User,
+		"saga_id": saga.ID,
+	}
+}
+
+// respondSagaError 按失败的步骤返回对应的状态码
+func respondSagaError(c *gin.Context, saga Saga, err error) {
+	var stepErr *sagaError
+	var fault *faults.Error
+	switch {
+	case c.Request.Context().Err() != nil:
+		orderOperations.WithLabelValues("create_order", "cancelled").Inc()
+		c.JSON(504, gin.H{"error": c.Request.Context().Err().Error(), "saga_id": saga.ID})
+	case errors.As(err, &stepErr) && stepErr.step == sagaStepValidateUser:
+		orderOperations.WithLabelValues("create_order", "error").Inc()
+		c.JSON(400, gin.H{"error": "用户验证失败", "saga_id": saga.ID})
+	case errors.Is(err, errOutOfStock):
+		orderOperations.WithLabelValues("create_order", "rejected").Inc()
+		c.JSON(409, gin.H{"error": err.Error(), "saga_id": saga.ID})
+	case errors.Is(err, errPaymentDeclined):
+		orderOperations.WithLabelValues("create_order", "rejected").Inc()
+		c.JSON(402, gin.H{"error": err.Error(), "saga_id": saga.ID})
+	case errors.As(err, &fault):
+		orderOperations.WithLabelValues("create_order", "error").Inc()
+		c.JSON(fault.Status, gin.H{"error": err.Error(), "saga_id": saga.ID, "fault": fault.Rule})
+	default:
+		orderOperations.WithLabelValues("create_order", "error").Inc()
+		c.JSON(500, gin.H{"error": err.Error(), "saga_id": saga.ID})
+	}
+}
+
+func getSaga(c *gin.Context) {
+	span := trace.SpanFromContext(c.Request.Context())
+	span.SetAttributes(
+		attribute.String("operation", "get_saga"),
+		attribute.String("saga.id", c.Param("id")),
+	)
+
+	saga, err := orderRepo.GetSaga(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, errSagaNotFound) {
+		c.JSON(404, gin.H{"error": "Saga not found"})
+		return
+	}
+	if err != nil {
+		respondStoreError(c, "get_saga", err)
+		return
+	}
+	c.JSON(200, saga)
 }
 
 func getOrderByID(c *gin.Context) {
@@ -324,20 +501,106 @@ func getOrderByID(c *gin.Context) {
 		attribute.String("operation", "get_order"),
 	)
 
-	simulateWork(c.Request.Context(), "database_query")
+	order, err := orderRepo.Get(c.Request.Context(), orderID)
+	if errors.Is(err, errOrderNotFound) {
+		orderOperations.WithLabelValues("get_order", "not_found").Inc()
+		span.SetAttributes(attribute.String("result", "not_found"))
+		c.JSON(404, gin.H{"error": "Order not found"})
+		return
+	}
+	if err != nil {
+		respondStoreError(c, "get_order", err)
+		return
+	}
+
+	orderOperations.WithLabelValues("get_order", "success").Inc()
+	span.SetAttributes(attribute.String("result", "success"))
+	c.JSON(200, order)
+}
 
-	for _, order := range orders {
-		if order.ID == orderID {
-			orderOperations.WithLabelValues("get_order", "success").Inc()
-			span.SetAttributes(attribute.String("result", "success"))
-			c.JSON(200, order)
-			return
-		}
+func updateOrderStatus(c *gin.Context) {
+	var req struct {
+		Status string `json:"status" binding:"required"`
 	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		orderOperations.WithLabelValues("update_order_status", "error").Inc()
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if !validStatus(req.Status) {
+		orderOperations.WithLabelValues("update_order_status", "error").Inc()
+		c.JSON(400, gin.H{"error": fmt.Sprintf("unknown status %q", req.Status)})
+		return
+	}
+	transitionOrder(c, "update_order_status", req.Status)
+}
 
-	orderOperations.WithLabelValues("get_order", "not_found").Inc()
-	span.SetAttributes(attribute.String("result", "not_found"))
-	c.JSON(404, gin.H{"error": "Order not found"})
+func cancelOrder(c *gin.Context) {
+	transitionOrder(c, "cancel_order", OrderStatusCancelled)
+}
+
+// transitionOrder 按状态机迁移订单状态，非法迁移或并发修改返回 409
+func transitionOrder(c *gin.Context, operation, to string) {
+	orderID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		orderOperations.WithLabelValues(operation, "error").Inc()
+		c.JSON(400, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	span := trace.SpanFromContext(c.Request.Context())
+	span.SetAttributes(
+		attribute.Int("order.id", orderID),
+		attribute.String("operation", operation),
+	)
+
+	order, err := orderRepo.Get(c.Request.Context(), orderID)
+	if errors.Is(err, errOrderNotFound) {
+		orderOperations.WithLabelValues(operation, "not_found").Inc()
+		c.JSON(404, gin.H{"error": "Order not found"})
+		return
+	}
+	if err != nil {
+		respondStoreError(c, operation, err)
+		return
+	}
+
+	from := order.Status
+	err = checkTransition(from, to)
+	if err == nil {
+		err = orderRepo.UpdateStatus(c.Request.Context(), orderID, from, to)
+	}
+	if errors.Is(err, errStatusConflict) {
+		orderOperations.WithLabelValues(operation, "conflict").Inc()
+		span.SetAttributes(attribute.String("result", "conflict"))
+		c.JSON(409, gin.H{"error": fmt.Sprintf("cannot transition order from %s to %s", from, to), "status": from})
+		return
+	}
+	if err != nil {
+		respondStoreError(c, operation, err)
+		return
+	}
+
+	order.Status = to
+	orderTransitionsTotal.WithLabelValues(from, to).Inc()
+	span.AddEvent("order.transition", trace.WithAttributes(
+		attribute.String("order.status.from", from),
+		attribute.String("order.status.to", to),
+	))
+	orderOperations.WithLabelValues(operation, "success").Inc()
+	span.SetAttributes(attribute.String("result", "success"))
+	c.JSON(200, order)
+}
+
+// respondStoreError 存储调用失败时返回 504（时间预算耗尽）或 500
+func respondStoreError(c *gin.Context, operation string, err error) {
+	if c.Request.Context().Err() != nil {
+		orderOperations.WithLabelValues(operation, "cancelled").Inc()
+		c.JSON(504, gin.H{"error": err.Error()})
+		return
+	}
+	orderOperations.WithLabelValues(operation, "error").Inc()
+	c.JSON(500, gin.H{"error": err.Error()})
 }
 
 func main() {
@@ -351,21 +614,68 @@ func main() {
 		}
 	}()
 
+	orderRepo, err = newOrderRepository()
+	if err != nil {
+		log.Fatalf("Failed to initialize order store: %v", err)
+	}
+	defer orderRepo.Close()
+
+	// outbox 中继：把订单事件可靠地投递给通知服务
+	notificationURL := os.Getenv("NOTIFICATION_SERVICE_URL")
+	if notificationURL == "" {
+		notificationURL = "http://localhost:8083"
+	}
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	go newOutboxRelay(orderRepo, notificationURL, outboxPollInterval(), outboxMaxAttempts()).run(bgCtx)
+
+	// USER_SERVICE_TRANSPORT=grpc 时通过 gRPC 调用用户服务
+	userConn, err := dialUserServiceGRPC()
+	if err != nil {
+		log.Fatalf("Failed to connect to user service: %v", err)
+	}
+	if userConn != nil {
+		defer userConn.Close()
+		userGRPCClient = userpb.NewUserServiceClient(userConn)
+	}
+
+	faultInjector, err = faults.NewFromEnv(defaultFaults)
+	if err != nil {
+		log.Fatalf("Failed to load fault rules: %v", err)
+	}
+	go faultInjector.Watch(bgCtx, 5*time.Second)
+
+	stock, paymentLimit := standInConfig()
+	orderSaga = newOrderSaga(orderRepo, callUserService, newStockService(orderRepo, stock), newPaymentService(orderRepo, paymentLimit))
+	// 接管中断的 saga，包括本副本重启前和其他副本崩溃时留下的
+	go orderSaga.run(bgCtx, sagaResumeAfter/2)
+
 	r := gin.New()
 	// 添加中间件 - 顺序很重要！
 	r.Use(gin.Recovery())
 	r.Use(otelgin.Middleware("order-service")) // 必须在 loggingMiddleware 之前
+	r.Use(ginrequestid.Middleware())
+	r.Use(gindeadline.Middleware())
 	r.Use(prometheusMiddleware())
 	r.Use(loggingMiddleware())
+	// 只信任网关签名的身份，客户端自带的 X-User-ID 会被丢弃
+	r.Use(ginidentity.Middleware([]byte(os.Getenv("IDENTITY_SIGNING_KEY"))))
+	r.Use(ginfaults.Middleware(faultInjector))
 
 	r.GET("/health", func(c *gin.Context) {
-		c.JSON(200, gin.H{"status": "healthy", "service": "order-service"})
+		c.JSON(200, gin.H{"status": "healthy", "service": "order-service", "version": serviceVersion()})
 	})
 
 	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
 	r.GET("/orders", getOrders)
-	r.POST("/orders", createOrder)
+	r.POST("/orders", idempotencyMiddleware(newIdempotencyStore(orderRepo, idempotencyTTL())), createOrder)
 	r.GET("/orders/:id", getOrderByID)
+	r.PATCH("/orders/:id/status", updateOrderStatus)
+	r.POST("/orders/:id/cancel", cancelOrder)
+	r.GET("/sagas/:id", getSaga)
+
+	// 故障注入管理接口，需要 FAULTS_ADMIN_TOKEN
+	ginfaults.Register(r, faultInjector)
 
 	log.Println("Order Service starting on port 8080...")
 	if err := r.Run(":8080"); err != nil {
@@ -0,0 +1,10 @@
+CREATE TABLE orders (
+    id        INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id   INTEGER NOT NULL,
+    product   TEXT    NOT NULL,
+    amount    REAL    NOT NULL,
+    status    TEXT    NOT NULL,
+    create_at TEXT    NOT NULL
+);
+
+CREATE INDEX idx_orders_user_id ON orders (user_id);
@@ -0,0 +1,3 @@
+INSERT INTO orders (id, user_id, product, amount, status, create_at) VALUES
+    (1, 1, '笔记本电脑', 5999.99, 'completed', '2023-01-01T10:00:00Z'),
+    (2, 2, '智能手机', 2999.99, 'processing', '2023-01-02T11:00:00Z');
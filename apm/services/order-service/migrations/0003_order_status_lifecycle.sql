@@ -0,0 +1,2 @@
+-- 旧版本只使用 processing 和 completed，processing 对应已支付待发货
+UPDATE orders SET status = 'paid' WHERE status = 'processing';
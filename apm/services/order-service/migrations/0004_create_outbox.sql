@@ -0,0 +1,14 @@
+-- 与订单在同一事务中写入的待投递事件，时间均为固定宽度的 UTC 字符串
+CREATE TABLE outbox (
+    id              TEXT    PRIMARY KEY,
+    event_type      TEXT    NOT NULL,
+    payload         TEXT    NOT NULL,
+    trace_context   TEXT    NOT NULL,
+    attempts        INTEGER NOT NULL DEFAULT 0,
+    last_error      TEXT    NOT NULL DEFAULT '',
+    next_attempt_at TEXT    NOT NULL,
+    created_at      TEXT    NOT NULL,
+    published_at    TEXT
+);
+
+CREATE INDEX idx_outbox_pending ON outbox (published_at, next_attempt_at);
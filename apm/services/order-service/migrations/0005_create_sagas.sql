@@ -0,0 +1,18 @@
+-- 创建订单 saga 的执行状态，每完成一个步骤更新一次，重启后继续未结束的 saga
+CREATE TABLE sagas (
+    id              TEXT    PRIMARY KEY,
+    status          TEXT    NOT NULL,
+    completed_steps INTEGER NOT NULL DEFAULT 0,
+    failed_step     TEXT    NOT NULL DEFAULT '',
+    error           TEXT    NOT NULL DEFAULT '',
+    user_id         INTEGER NOT NULL,
+    product         TEXT    NOT NULL,
+    amount          REAL    NOT NULL,
+    user_data       TEXT    NOT NULL DEFAULT '',
+    order_id        INTEGER REFERENCES orders (id),
+    trace_context   TEXT    NOT NULL,
+    created_at      TEXT    NOT NULL,
+    updated_at      TEXT    NOT NULL
+);
+
+CREATE INDEX idx_sagas_status ON sagas (status);
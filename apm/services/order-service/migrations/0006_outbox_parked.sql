@@ -0,0 +1,2 @@
+-- 多次投递失败或被通知服务永久拒绝的事件不再重试，保留在表中供人工排查
+ALTER TABLE outbox ADD COLUMN parked_at TEXT;
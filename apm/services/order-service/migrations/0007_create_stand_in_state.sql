@@ -0,0 +1,15 @@
+-- 库存和支付替身的状态，以 saga ID 为键，接管 saga 的副本补偿时能找到其他副本的预留和扣款
+CREATE TABLE stock_reservations (
+    saga_id    TEXT PRIMARY KEY,
+    product    TEXT NOT NULL,
+    created_at TEXT NOT NULL
+);
+
+CREATE INDEX idx_stock_reservations_product ON stock_reservations (product);
+
+CREATE TABLE payment_charges (
+    saga_id    TEXT    PRIMARY KEY,
+    user_id    INTEGER NOT NULL,
+    amount     REAL    NOT NULL,
+    created_at TEXT    NOT NULL
+);
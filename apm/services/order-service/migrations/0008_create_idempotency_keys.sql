@@ -0,0 +1,11 @@
+-- 幂等键及其结果，多个副本共用；创建订单成功时与订单在同一事务中写入结果。
+-- status 为 0 表示首个请求仍在处理中，过期（expires_at）的记录由后台定期删除
+CREATE TABLE idempotency_keys (
+    idempotency_key TEXT    PRIMARY KEY,
+    fingerprint     TEXT    NOT NULL,
+    status          INTEGER NOT NULL DEFAULT 0,
+    body            BLOB,
+    expires_at      TEXT    NOT NULL
+);
+
+CREATE INDEX idx_idempotency_keys_expires_at ON idempotency_keys (expires_at);
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	eventOrderCreated = "order.created"
+	// eventIDHeader 事件的去重 ID，接收方据此丢弃重复投递
+	eventIDHeader = "X-Event-ID"
+	// outboxTimeFormat 固定宽度的 UTC 时间，字符串顺序与时间顺序一致
+	outboxTimeFormat = "2006-01-02T15:04:05.000000Z"
+	maxOutboxBackoff = time.Minute
+	// outboxClaimLease 领取事件后其他副本等待的时间，需大于一次投递的超时，
+	// 领取事件的副本崩溃时事件在这之后由其他副本重新投递
+	outboxClaimLease = 30 * time.Second
+)
+
+var (
+	outboxPublishAttempts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "outbox_publish_attempts_total",
+			Help: "Total number of outbox event delivery attempts",
+		},
+		[]string{"event_type", "result"},
+	)
+
+	outboxDeliveryLag = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "outbox_delivery_lag_seconds",
+			Help:    "Time from writing an outbox event to its successful delivery",
+			Buckets: []float64{0.1, 0.5, 1, 2, 5, 10, 30, 60, 300},
+		},
+		[]string{"event_type"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(outboxPublishAttempts)
+	prometheus.MustRegister(outboxDeliveryLag)
+}
+
+// OutboxEvent 与业务数据在同一事务中写入的待投递事件
+type OutboxEvent struct {
+	// ID 去重 ID，重试时保持不变
+	ID      string
+	Type    string
+	Payload json.RawMessage
+	// TraceContext 写入事件时的追踪上下文，投递时作为父 span
+	TraceContext  map[string]string
+	Attempts      int
+	LastError     string
+	NextAttemptAt time.Time
+	CreatedAt     time.Time
+}
+
+// eventEnvelope 投递给通知服务的事件格式
+type eventEnvelope struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	OccurredAt string          `json:"occurred_at"`
+	Data       json.RawMessage `json:"data"`
+}
+
+func newEventID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// newOrderCreatedEvent 在创建订单的事务中调用，记录订单内容和当前追踪上下文
+func newOrderCreatedEvent(ctx context.Context, order Order) (OutboxEvent, error) {
+	payload, err := json.Marshal(order)
+	if err != nil {
+		return OutboxEvent{}, err
+	}
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+
+	now := time.Now().UTC()
+	return OutboxEvent{
+		ID:            newEventID(),
+		Type:          eventOrderCreated,
+		Payload:       payload,
+		TraceContext:  carrier,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}, nil
+}
+
+// outboxBackoff 第 attempts 次失败后的重试间隔：1s、2s、4s…，最长 1 分钟
+func outboxBackoff(attempts int) time.Duration {
+	d := time.Second
+	for i := 1; i < attempts && d < maxOutboxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxOutboxBackoff {
+		d = maxOutboxBackoff
+	}
+	return d
+}
+
+// statusError 通知服务返回的非 2xx 响应
+type statusError struct {
+	status int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("notification-service returned status %d", e.status)
+}
+
+// permanent 除 408 和 429 以外的 4xx 表示事件本身被拒绝，重试也不会成功
+func (e *statusError) permanent() bool {
+	return e.status >= 400 && e.status < 500 && e.status != http.StatusRequestTimeout && e.status != http.StatusTooManyRequests
+}
+
+// outboxRelay 轮询 outbox 并把事件投递给通知服务。事件只有在收到 2xx 后才标记为已投递，
+// 因此同一事件可能被投递多次（至少一次），接收方按事件 ID 去重。
+// 失败 maxAttempts 次或被永久拒绝的事件停止重试（parked），留在 outbox 表中供人工排查
+type outboxRelay struct {
+	repo        OrderRepository
+	endpoint    string
+	client      *http.Client
+	interval    time.Duration
+	batch       int
+	maxAttempts int
+}
+
+func newOutboxRelay(repo OrderRepository, notificationURL string, interval time.Duration, maxAttempts int) *outboxRelay {
+	return &outboxRelay{
+		repo:        repo,
+		endpoint:    notificationURL + "/events",
+		client:      &http.Client{Timeout: 5 * time.Second},
+		interval:    interval,
+		batch:       50,
+		maxAttempts: maxAttempts,
+	}
+}
+
+func (r *outboxRelay) run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		r.publishPending(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// publishPending 投递所有到期的事件，返回成功投递的数量
+func (r *outboxRelay) publishPending(ctx context.Context) int {
+	events, err := r.repo.ClaimEvents(ctx, time.Now(), outboxClaimLease, r.batch)
+	if err != nil {
+		log.Printf("Failed to load outbox events: %v", err)
+		return 0
+	}
+
+	published := 0
+	for _, ev := range events {
+		if r.deliver(ctx, ev) {
+			published++
+		}
+	}
+	return published
+}
+
+// deliver 投递一个事件并记录结果，失败时按退避时间安排重试，不可重试或超过次数时停止投递
+func (r *outboxRelay) deliver(ctx context.Context, ev OutboxEvent) bool {
+	// 以创建订单时的 span 作为父 span，投递过程出现在同一条链路中
+	ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(ev.TraceContext))
+	tracer := otel.Tracer("order-service")
+	ctx, span := tracer.Start(ctx, fmt.Sprintf("outbox-publish %s", ev.Type),
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("outbox.event_id", ev.ID),
+			attribute.String("outbox.event_type", ev.Type),
+			attribute.Int("outbox.attempt", ev.Attempts+1),
+		),
+	)
+	defer span.End()
+
+	if err := r.publish(ctx, ev); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		attempts := ev.Attempts + 1
+		var statusErr *statusError
+		if errors.As(err, &statusErr) && statusErr.permanent() || attempts >= r.maxAttempts {
+			span.SetAttributes(attribute.Bool("outbox.parked", true))
+			outboxPublishAttempts.WithLabelValues(ev.Type, "parked").Inc()
+			log.Printf("Parking outbox event %s after %d attempts: %v", ev.ID, attempts, err)
+			if err := r.repo.Park(ctx, ev.ID, attempts, time.Now(), err.Error()); err != nil {
+				log.Printf("Failed to park outbox event %s: %v", ev.ID, err)
+			}
+			return false
+		}
+		next := time.Now().Add(outboxBackoff(attempts))
+		outboxPublishAttempts.WithLabelValues(ev.Type, "retry").Inc()
+		log.Printf("Failed to publish outbox event %s (attempt %d), retrying at %s: %v", ev.ID, attempts, next.Format(time.RFC3339), err)
+		if err := r.repo.ScheduleRetry(ctx, ev.ID, attempts, next, err.Error()); err != nil {
+			log.Printf("Failed to schedule outbox retry for %s: %v", ev.ID, err)
+		}
+		return false
+	}
+
+	outboxPublishAttempts.WithLabelValues(ev.Type, "published").Inc()
+	outboxDeliveryLag.WithLabelValues(ev.Type).Observe(time.Since(ev.CreatedAt).Seconds())
+	// 标记失败时事件会被再次投递，由接收方去重
+	if err := r.repo.MarkPublished(ctx, ev.ID, time.Now()); err != nil {
+		log.Printf("Failed to mark outbox event %s as published: %v", ev.ID, err)
+		return false
+	}
+	return true
+}
+
+func (r *outboxRelay) publish(ctx context.Context, ev OutboxEvent) error {
+	body, err := json.Marshal(eventEnvelope{
+		ID:         ev.ID,
+		Type:       ev.Type,
+		OccurredAt: ev.CreatedAt.Format(time.RFC3339),
+		Data:       ev.Payload,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", r.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(eventIDHeader, ev.ID)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &statusError{status: resp.StatusCode}
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// eventSink 模拟通知服务，前 failures 次请求返回 status（默认 500）
+type eventSink struct {
+	mu       sync.Mutex
+	failures int
+	status   int
+	requests []*http.Request
+	bodies   []eventEnvelope
+}
+
+func (s *eventSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var env eventEnvelope
+	json.NewDecoder(r.Body).Decode(&env)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r)
+	s.bodies = append(s.bodies, env)
+	if len(s.requests) <= s.failures {
+		if s.status == 0 {
+			s.status = 500
+		}
+		w.WriteHeader(s.status)
+		return
+	}
+	w.WriteHeader(200)
+}
+
+func TestOutboxRelayRetriesUntilDelivered(t *testing.T) {
+	otel.SetTracerProvider(sdktrace.NewTracerProvider())
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	for name, repo := range newTestRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			sink := &eventSink{failures: 1}
+			server := httptest.NewServer(sink)
+			defer server.Close()
+
+			ctx, span := otel.Tracer("test").Start(context.Background(), "create-order")
+			order := Order{UserID: 1, Product: "outbox", Amount: 10, Status: OrderStatusCreated, CreateAt: "2024-01-01T00:00:00Z"}
+			if err := repo.Create(ctx, &order); err != nil {
+				t.Fatal(err)
+			}
+			span.End()
+
+			relay := newOutboxRelay(repo, server.URL, time.Second, 10)
+			if n := relay.publishPending(context.Background()); n != 0 {
+				t.Fatalf("first attempt: published %d, want 0", n)
+			}
+
+			// 失败后按退避时间重试，未到期前不会再次投递
+			if events, _ := repo.ClaimEvents(context.Background(), time.Now(), outboxClaimLease, 10); len(events) != 0 {
+				t.Fatalf("event retried before backoff elapsed")
+			}
+			events, err := repo.ClaimEvents(context.Background(), time.Now().Add(outboxBackoff(1)+time.Second), outboxClaimLease, 10)
+			if err != nil || len(events) != 1 {
+				t.Fatalf("pending after failure: %v, %v", events, err)
+			}
+			if events[0].Attempts != 1 || events[0].LastError == "" {
+				t.Errorf("attempts %d, last error %q", events[0].Attempts, events[0].LastError)
+			}
+			if !relay.deliver(context.Background(), events[0]) {
+				t.Fatalf("second attempt was not delivered")
+			}
+
+			if events, _ := repo.ClaimEvents(context.Background(), time.Now().Add(time.Hour), outboxClaimLease, 10); len(events) != 0 {
+				t.Errorf("%d events still pending after delivery", len(events))
+			}
+
+			if len(sink.requests) != 2 {
+				t.Fatalf("got %d deliveries, want 2", len(sink.requests))
+			}
+			wantTrace := span.SpanContext().TraceID()
+			for i, r := range sink.requests {
+				id := r.Header.Get(eventIDHeader)
+				if id == "" || id != sink.bodies[0].ID || sink.bodies[i].ID != id {
+					t.Errorf("delivery %d: event id %q, body id %q, want stable id %q", i, id, sink.bodies[i].ID, sink.bodies[0].ID)
+				}
+				if sink.bodies[i].Type != eventOrderCreated {
+					t.Errorf("delivery %d: type %q", i, sink.bodies[i].Type)
+				}
+				// 投递链路延续创建订单时的追踪
+				got := trace.SpanContextFromContext(propagation.TraceContext{}.Extract(context.Background(), propagation.HeaderCarrier(r.Header)))
+				if got.TraceID() != wantTrace {
+					t.Errorf("delivery %d: trace %s, want %s", i, got.TraceID(), wantTrace)
+				}
+			}
+
+			var delivered Order
+			if err := json.Unmarshal(sink.bodies[1].Data, &delivered); err != nil || delivered.ID != order.ID {
+				t.Errorf("payload %s: %v", sink.bodies[1].Data, err)
+			}
+		})
+	}
+}
+
+func TestOutboxRelayParksUndeliverableEvents(t *testing.T) {
+	otel.SetTracerProvider(sdktrace.NewTracerProvider())
+
+	cases := []struct {
+		name        string
+		status      int
+		maxAttempts int
+		// parkedAfter 第几次失败后停止重试
+		parkedAfter int
+	}{
+		{"bad request parks immediately", 400, 10, 1},
+		{"unprocessable parks immediately", 422, 10, 1},
+		{"rate limited retries", 429, 3, 3},
+		{"request timeout retries", 408, 3, 3},
+		{"server error retries", 503, 3, 3},
+	}
+	for _, tc := range cases {
+		for name, repo := range newTestRepositories(t) {
+			t.Run(tc.name+"/"+name, func(t *testing.T) {
+				sink := &eventSink{failures: 100, status: tc.status}
+				server := httptest.NewServer(sink)
+				defer server.Close()
+
+				order := Order{UserID: 1, Product: "outbox", Amount: 10, Status: OrderStatusCreated, CreateAt: "2024-01-01T00:00:00Z"}
+				if err := repo.Create(context.Background(), &order); err != nil {
+					t.Fatal(err)
+				}
+				relay := newOutboxRelay(repo, server.URL, time.Second, tc.maxAttempts)
+				for i := 0; i < tc.maxAttempts+1; i++ {
+					events, err := repo.ClaimEvents(context.Background(), time.Now().Add(time.Hour), outboxClaimLease, 10)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if len(events) == 0 {
+						break
+					}
+					if relay.deliver(context.Background(), events[0]) {
+						t.Fatal("event was delivered")
+					}
+				}
+				if len(sink.requests) != tc.parkedAfter {
+					t.Errorf("got %d deliveries, want %d before parking", len(sink.requests), tc.parkedAfter)
+				}
+			})
+		}
+	}
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	cases := map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 4: 8 * time.Second, 7: time.Minute, 50: time.Minute}
+	for attempts, want := range cases {
+		if got := outboxBackoff(attempts); got != want {
+			t.Errorf("outboxBackoff(%d) = %s, want %s", attempts, got, want)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// 可排序字段，对应 sort 参数（前缀 "-" 表示降序）
+const (
+	sortByID        = "id"
+	sortByCreatedAt = "created_at"
+	sortByAmount    = "amount"
+)
+
+// OrderQuery GET /orders 的过滤、排序和分页条件，零值表示不过滤
+type OrderQuery struct {
+	UserID        *int
+	Statuses      []string
+	CreatedAfter  string // RFC3339 UTC，包含
+	CreatedBefore string // RFC3339 UTC，不包含
+	MinAmount     *float64
+	MaxAmount     *float64
+
+	SortBy string
+	Desc   bool
+	// Limit 为 0 表示不分页
+	Limit int
+	// After 上一页最后一条订单的位置，来自 cursor 参数
+	After *pageCursor
+}
+
+// OrderPage 一页查询结果
+type OrderPage struct {
+	Orders []Order
+	// Total 满足过滤条件的订单总数
+	Total int
+	// NextCursor 为空表示没有下一页
+	NextCursor string
+}
+
+// pageCursor 分页游标，编码后对客户端不透明；包含过滤条件和排序方式，换了条件或排序的游标会被拒绝
+type pageCursor struct {
+	SortBy string  `json:"s"`
+	Desc   bool    `json:"d"`
+	Filter string  `json:"f"`
+	ID     int     `json:"id"`
+	Amount float64 `json:"a,omitempty"`
+	Time   string  `json:"t,omitempty"`
+}
+
+func (pc *pageCursor) encode() string {
+	data, _ := json.Marshal(pc)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (*pageCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	var pc pageCursor
+	if err := json.Unmarshal(data, &pc); err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	return &pc, nil
+}
+
+func cursorFor(q OrderQuery, last Order) string {
+	pc := &pageCursor{SortBy: q.SortBy, Desc: q.Desc, Filter: q.filterDigest(), ID: last.ID}
+	switch q.SortBy {
+	case sortByAmount:
+		pc.Amount = last.Amount
+	case sortByCreatedAt:
+		pc.Time = last.CreateAt
+	}
+	return pc.encode()
+}
+
+// parseOrderQuery 解析并校验查询参数
+func parseOrderQuery(values url.Values) (OrderQuery, error) {
+	q := OrderQuery{SortBy: sortByID, Limit: defaultPageSize}
+	var errs []error
+
+	if v := values.Get("user_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid user_id %q", v))
+		}
+		q.UserID = &id
+	}
+	if v := values.Get("status"); v != "" {
+		for _, status := range strings.Split(v, ",") {
+			if !validStatus(status) {
+				errs = append(errs, fmt.Errorf("unknown status %q", status))
+			}
+			q.Statuses = append(q.Statuses, status)
+		}
+	}
+
+	parseTime := func(name string) string {
+		v := values.Get(name)
+		if v == "" {
+			return ""
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s %q, want RFC3339", name, v))
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339)
+	}
+	q.CreatedAfter = parseTime("created_after")
+	q.CreatedBefore = parseTime("created_before")
+
+	parseAmount := func(name string) *float64 {
+		v := values.Get(name)
+		if v == "" {
+			return nil
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s %q", name, v))
+		}
+		return &f
+	}
+	q.MinAmount = parseAmount("min_amount")
+	q.MaxAmount = parseAmount("max_amount")
+
+	if v := values.Get("sort"); v != "" {
+		q.Desc = strings.HasPrefix(v, "-")
+		q.SortBy = strings.TrimPrefix(v, "-")
+		switch q.SortBy {
+		case sortByID, sortByCreatedAt, sortByAmount:
+		default:
+			errs = append(errs, fmt.Errorf("cannot sort by %q", q.SortBy))
+		}
+	}
+
+	if v := values.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageSize {
+			errs = append(errs, fmt.Errorf("limit must be between 1 and %d", maxPageSize))
+		}
+		q.Limit = n
+	}
+
+	if v := values.Get("cursor"); v != "" {
+		pc, err := decodeCursor(v)
+		switch {
+		case err != nil:
+			errs = append(errs, err)
+		case pc.SortBy != q.SortBy || pc.Desc != q.Desc:
+			errs = append(errs, errors.New("cursor does not match sort"))
+		case pc.Filter != q.filterDigest():
+			errs = append(errs, errors.New("cursor does not match filters"))
+		default:
+			q.After = pc
+		}
+	}
+
+	return q, errors.Join(errs...)
+}
+
+// filterFields 生效的过滤条件名称，用于记录过滤基数
+func (q OrderQuery) filterFields() []string {
+	var fields []string
+	if q.UserID != nil {
+		fields = append(fields, "user_id")
+	}
+	if len(q.Statuses) > 0 {
+		fields = append(fields, "status")
+	}
+	if q.CreatedAfter != "" {
+		fields = append(fields, "created_after")
+	}
+	if q.CreatedBefore != "" {
+		fields = append(fields, "created_before")
+	}
+	if q.MinAmount != nil {
+		fields = append(fields, "min_amount")
+	}
+	if q.MaxAmount != nil {
+		fields = append(fields, "max_amount")
+	}
+	return fields
+}
+
+// filterDigest 过滤条件的摘要，写入游标；status 的顺序不影响结果
+func (q OrderQuery) filterDigest() string {
+	h := sha256.New()
+	if q.UserID != nil {
+		fmt.Fprintf(h, "user_id=%d;", *q.UserID)
+	}
+	if len(q.Statuses) > 0 {
+		statuses := append([]string(nil), q.Statuses...)
+		sort.Strings(statuses)
+		fmt.Fprintf(h, "status=%s;", strings.Join(statuses, ","))
+	}
+	if q.CreatedAfter != "" {
+		fmt.Fprintf(h, "created_after=%s;", q.CreatedAfter)
+	}
+	if q.CreatedBefore != "" {
+		fmt.Fprintf(h, "created_before=%s;", q.CreatedBefore)
+	}
+	if q.MinAmount != nil {
+		fmt.Fprintf(h, "min_amount=%v;", *q.MinAmount)
+	}
+	if q.MaxAmount != nil {
+		fmt.Fprintf(h, "max_amount=%v;", *q.MaxAmount)
+	}
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:12])
+}
+
+// matches 订单是否满足过滤条件（不含分页），供内存存储使用
+func (q OrderQuery) matches(o Order) bool {
+	if q.UserID != nil && o.UserID != *q.UserID {
+		return false
+	}
+	if len(q.Statuses) > 0 {
+		found := false
+		for _, s := range q.Statuses {
+			if o.Status == s {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if q.CreatedAfter != "" && o.CreateAt < q.CreatedAfter {
+		return false
+	}
+	if q.CreatedBefore != "" && o.CreateAt >= q.CreatedBefore {
+		return false
+	}
+	if q.MinAmount != nil && o.Amount < *q.MinAmount {
+		return false
+	}
+	if q.MaxAmount != nil && o.Amount > *q.MaxAmount {
+		return false
+	}
+	return true
+}
+
+// compare 按排序字段比较两个订单，字段相同时按 ID 比较，保证顺序稳定
+func (q OrderQuery) compare(a, b Order) int {
+	c := 0
+	switch q.SortBy {
+	case sortByAmount:
+		c = compareValues(a.Amount, b.Amount)
+	case sortByCreatedAt:
+		c = strings.Compare(a.CreateAt, b.CreateAt)
+	}
+	if c == 0 {
+		c = compareValues(a.ID, b.ID)
+	}
+	if q.Desc {
+		c = -c
+	}
+	return c
+}
+
+// afterCursor 订单是否排在游标位置之后
+func (q OrderQuery) afterCursor(o Order) bool {
+	if q.After == nil {
+		return true
+	}
+	last := Order{ID: q.After.ID, Amount: q.After.Amount, CreateAt: q.After.Time}
+	return q.compare(o, last) > 0
+}
+
+func compareValues[T int | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// paginate 对已过滤的订单排序并截取一页
+func (q OrderQuery) paginate(matched []Order) OrderPage {
+	sort.Slice(matched, func(i, j int) bool { return q.compare(matched[i], matched[j]) < 0 })
+
+	page := OrderPage{Orders: []Order{}, Total: len(matched)}
+	for _, o := range matched {
+		if !q.afterCursor(o) {
+			continue
+		}
+		if q.Limit > 0 && len(page.Orders) == q.Limit {
+			page.NextCursor = cursorFor(q, page.Orders[len(page.Orders)-1])
+			break
+		}
+		page.Orders = append(page.Orders, o)
+	}
+	return page
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+// collectPages 按游标翻完所有页，返回订单 ID 顺序
+func collectPages(t *testing.T, repo OrderRepository, values url.Values) []int {
+	t.Helper()
+
+	var ids []int
+	for pages := 0; ; pages++ {
+		if pages > 100 {
+			t.Fatalf("pagination does not terminate")
+		}
+		q, err := parseOrderQuery(values)
+		if err != nil {
+			t.Fatalf("parse %v: %v", values, err)
+		}
+		page, err := repo.List(context.Background(), q)
+		if err != nil {
+			t.Fatalf("list: %v", err)
+		}
+		for _, o := range page.Orders {
+			ids = append(ids, o.ID)
+		}
+		if page.NextCursor == "" {
+			return ids
+		}
+		values.Set("cursor", page.NextCursor)
+	}
+}
+
+func TestOrderQueryPagination(t *testing.T) {
+	repos := newTestRepositories(t)
+	statuses := []string{OrderStatusCreated, OrderStatusPaid, OrderStatusShipped}
+	for _, repo := range repos {
+		for i := 0; i < 25; i++ {
+			order := Order{
+				UserID: i%3 + 1,
+				// 金额和时间有重复值，验证游标在相同排序值下也不会跳过或重复
+				Amount:   float64(i % 5 * 100),
+				Product:  fmt.Sprintf("p-%d", i),
+				Status:   statuses[i%len(statuses)],
+				CreateAt: fmt.Sprintf("2024-01-%02dT00:00:00Z", i/2+1),
+			}
+			if err := repo.Create(context.Background(), &order); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	cases := []url.Values{
+		{"limit": {"4"}},
+		{"limit": {"3"}, "sort": {"-amount"}},
+		{"limit": {"5"}, "sort": {"created_at"}},
+		{"limit": {"2"}, "sort": {"-created_at"}, "user_id": {"2"}},
+		{"limit": {"3"}, "status": {"paid,shipped"}, "min_amount": {"100"}, "max_amount": {"300"}},
+		{"limit": {"4"}, "created_after": {"2024-01-03T00:00:00Z"}, "created_before": {"2024-01-08T00:00:00+00:00"}},
+	}
+	for _, values := range cases {
+		t.Run(values.Encode(), func(t *testing.T) {
+			// 不分页的查询结果作为基准
+			all := url.Values{}
+			for k, v := range values {
+				if k != "limit" {
+					all[k] = v
+				}
+			}
+			all.Set("limit", "100")
+			want := collectPages(t, repos["memory"], all)
+			if len(want) == 0 {
+				t.Fatalf("query matches no orders")
+			}
+
+			for name, repo := range repos {
+				got := collectPages(t, repo, cloneValues(values))
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("%s: got %v, want %v", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func cloneValues(v url.Values) url.Values {
+	c := url.Values{}
+	for k, vs := range v {
+		c[k] = append([]string(nil), vs...)
+	}
+	return c
+}
+
+func TestParseOrderQueryRejectsInvalidParameters(t *testing.T) {
+	idCursor := (&pageCursor{SortBy: sortByID, Filter: OrderQuery{}.filterDigest(), ID: 3}).encode()
+	cases := []url.Values{
+		{"user_id": {"abc"}},
+		{"status": {"processing"}},
+		{"created_after": {"yesterday"}},
+		{"min_amount": {"cheap"}},
+		{"sort": {"product"}},
+		{"limit": {"0"}},
+		{"limit": {"1000"}},
+		{"cursor": {"not-a-cursor"}},
+		{"cursor": {idCursor}, "sort": {"-amount"}},
+		// 游标与过滤条件绑定
+		{"cursor": {idCursor}, "user_id": {"1"}},
+	}
+	for _, values := range cases {
+		if _, err := parseOrderQuery(values); err == nil {
+			t.Errorf("%v: expected error", values)
+		}
+	}
+
+	// 只是 status 的顺序不同，游标仍然有效
+	q, _ := parseOrderQuery(url.Values{"status": {"paid,shipped"}})
+	cursor := cursorFor(q, Order{ID: 3})
+	if _, err := parseOrderQuery(url.Values{"status": {"shipped,paid"}, "cursor": {cursor}}); err != nil {
+		t.Errorf("reordered status: %v", err)
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// saga 状态：running 正向执行中，compensating 补偿中，completed 和 failed 是终态
+const (
+	SagaStatusRunning      = "running"
+	SagaStatusCompensating = "compensating"
+	SagaStatusCompleted    = "completed"
+	SagaStatusFailed       = "failed"
+)
+
+// 创建订单 saga 的步骤
+const (
+	sagaStepValidateUser  = "validate_user"
+	sagaStepReserveStock  = "reserve_stock"
+	sagaStepChargePayment = "charge_payment"
+	sagaStepConfirm       = "confirm"
+)
+
+// sagaCompensationTimeout 补偿不受原请求截止时间限制，但也不能无限等待
+const sagaCompensationTimeout = 30 * time.Second
+
+// sagaResumeAfter 未结束的 saga 超过这个时间没有进展，视为所在副本已经重启或崩溃，由任一副本接管。
+// 每一步都会更新 updated_at，这个时间需大于单个步骤（含补偿）的最长耗时
+const sagaResumeAfter = 2 * time.Minute
+
+var errSagaNotFound = errors.New("saga not found")
+
+var (
+	sagaStepsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "saga_steps_total",
+			Help: "Total number of saga step executions, by phase (action, compensation) and result",
+		},
+		[]string{"step", "phase", "result"},
+	)
+
+	sagasTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "sagas_total",
+			Help: "Total number of finished order creation sagas, by final status",
+		},
+		[]string{"status"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(sagaStepsTotal)
+	prometheus.MustRegister(sagasTotal)
+}
+
+// Saga 一次创建订单的执行状态，每完成一步都会持久化，重启后从记录的位置继续
+type Saga struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	// CompletedSteps 已完成的步骤数；补偿时按相反顺序逐个撤销并递减
+	CompletedSteps int `json:"completed_steps"`
+	// FailedStep 导致补偿的步骤及错误
+	FailedStep string `json:"failed_step,omitempty"`
+	Error      string `json:"error,omitempty"`
+
+	UserID  int     `json:"user_id"`
+	Product string  `json:"product"`
+	Amount  float64 `json:"amount"`
+	// User validate_user 步骤返回的用户信息
+	User    json.RawMessage `json:"user,omitempty"`
+	OrderID int             `json:"order_id,omitempty"`
+
+	// TraceContext 发起 saga 的请求的追踪上下文，恢复执行时作为父 span
+	TraceContext map[string]string `json:"-"`
+	CreatedAt    string            `json:"created_at"`
+	UpdatedAt    string            `json:"updated_at"`
+}
+
+// sagaError saga 在某个步骤失败并已完成补偿
+type sagaError struct {
+	step string
+	err  error
+}
+
+func (e *sagaError) Error() string { return fmt.Sprintf("%s: %v", e.step, e.err) }
+func (e *sagaError) Unwrap() error { return e.err }
+
+type sagaStep struct {
+	name   string
+	action func(ctx context.Context, s *Saga) error
+	// compensate 撤销 action 的效果，必须可以重复执行；为空表示无需补偿
+	compensate func(ctx context.Context, s *Saga) error
+}
+
+// sagaOrchestrator 按顺序执行创建订单的步骤，失败时按相反顺序补偿已完成的步骤
+type sagaOrchestrator struct {
+	repo  OrderRepository
+	steps []sagaStep
+}
+
+// newOrderSaga 创建订单 saga：验证用户 → 预留库存 → 扣款 → 确认订单
+func newOrderSaga(repo OrderRepository, validateUser func(ctx context.Context, userID int) (map[string]interface{}, error),
+	stock *stockService, payments *paymentService) *sagaOrchestrator {
+	o := &sagaOrchestrator{repo: repo}
+	o.steps = []sagaStep{
+		{
+			name: sagaStepValidateUser,
+			action: func(ctx context.Context, s *Saga) error {
+				user, err := validateUser(ctx, s.UserID)
+				if err != nil {
+					return err
+				}
+				s.User, err = json.Marshal(user)
+				return err
+			},
+		},
+		{
+			name: sagaStepReserveStock,
+			action: func(ctx context.Context, s *Saga) error {
+				return stock.Reserve(ctx, s.ID, s.Product)
+			},
+			compensate: func(ctx context.Context, s *Saga) error {
+				return stock.Release(ctx, s.ID)
+			},
+		},
+		{
+			name: sagaStepChargePayment,
+			action: func(ctx context.Context, s *Saga) error {
+				return payments.Charge(ctx, s.ID, s.UserID, s.Amount)
+			},
+			compensate: func(ctx context.Context, s *Saga) error {
+				return payments.Refund(ctx, s.ID)
+			},
+		},
+		{
+			name:   sagaStepConfirm,
+			action: o.confirm,
+		},
+	}
+	return o
+}
+
+// confirm 创建订单，订单、outbox 事件和 saga 的完成状态在同一事务中写入
+func (o *sagaOrchestrator) confirm(ctx context.Context, s *Saga) error {
+	if err := simulateWork(ctx, "order_processing"); err != nil {
+		return err
+	}
+
+	order := Order{
+		UserID:   s.UserID,
+		Product:  s.Product,
+		Amount:   s.Amount,
+		Status:   OrderStatusCreated,
+		CreateAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	done := *s
+	done.Status = SagaStatusCompleted
+	done.CompletedSteps = len(o.steps)
+	done.UpdatedAt = order.CreateAt
+	if err := o.repo.CompleteSaga(ctx, &done, &order); err != nil {
+		return err
+	}
+	*s = done
+	return nil
+}
+
+// Start 创建并执行一个新的 saga。失败时返回 *sagaError，此时已完成的步骤均已补偿
+func (o *sagaOrchestrator) Start(ctx context.Context, userID int, product string, amount float64) (Saga, error) {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	now := time.Now().UTC().Format(time.RFC3339)
+	s := Saga{
+		ID:           newEventID(),
+		Status:       SagaStatusRunning,
+		UserID:       userID,
+		Product:      product,
+		Amount:       amount,
+		TraceContext: carrier,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if err := o.repo.SaveSaga(ctx, s); err != nil {
+		return s, err
+	}
+	err := o.execute(ctx, &s)
+	return s, err
+}
+
+// run 定期接管中断的 saga，直到 ctx 结束
+func (o *sagaOrchestrator) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		o.Resume(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Resume 继续执行中断的 saga：正向执行中的继续正向执行，补偿中的继续补偿
+func (o *sagaOrchestrator) Resume(ctx context.Context) {
+	now := time.Now()
+	sagas, err := o.repo.ClaimSagas(ctx, now.Add(-sagaResumeAfter), now)
+	if err != nil {
+		log.Printf("Failed to load incomplete sagas: %v", err)
+		return
+	}
+	for i := range sagas {
+		s := &sagas[i]
+		// 以发起 saga 的请求作为父 span，恢复的步骤出现在原链路中
+		sctx := otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(s.TraceContext))
+		log.Printf("Resuming saga %s (%s, %d steps completed)", s.ID, s.Status, s.CompletedSteps)
+		err := o.execute(sctx, s)
+		switch {
+		case s.Status == SagaStatusFailed:
+			log.Printf("Resumed saga %s was compensated: %v", s.ID, err)
+		case err != nil:
+			log.Printf("Resumed saga %s did not complete: %v", s.ID, err)
+		}
+	}
+}
+
+// execute 从 saga 记录的位置继续执行
+func (o *sagaOrchestrator) execute(ctx context.Context, s *Saga) error {
+	tracer := otel.Tracer("order-service")
+	ctx, span := tracer.Start(ctx, "saga create-order", trace.WithAttributes(
+		attribute.String("saga.id", s.ID),
+		attribute.String("saga.status", s.Status),
+		attribute.Int("saga.completed_steps", s.CompletedSteps),
+	))
+	defer span.End()
+
+	var err error
+	if s.Status == SagaStatusRunning {
+		err = o.runForward(ctx, s)
+	}
+	if s.Status == SagaStatusCompensating {
+		if err == nil {
+			// 重启前已开始补偿，原始错误只保留了文本
+			err = errors.New(s.Error)
+		}
+		err = o.compensate(ctx, s, err)
+	}
+
+	span.SetAttributes(attribute.String("saga.result", s.Status))
+	if s.Status == SagaStatusCompleted || s.Status == SagaStatusFailed {
+		sagasTotal.WithLabelValues(s.Status).Inc()
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+// runForward 依次执行剩余步骤；某一步失败时把 saga 切换到补偿状态并返回该步骤的错误
+func (o *sagaOrchestrator) runForward(ctx context.Context, s *Saga) error {
+	for s.CompletedSteps < len(o.steps) {
+		step := o.steps[s.CompletedSteps]
+		err := o.runStep(ctx, s, step.name, "action", step.action)
+		if err == nil && s.Status == SagaStatusCompleted {
+			// confirm 步骤已在创建订单的事务中保存了 saga
+			return nil
+		}
+		if err == nil {
+			s.CompletedSteps++
+			err = o.save(ctx, s)
+		}
+		if err != nil {
+			s.Status = SagaStatusCompensating
+			s.FailedStep = step.name
+			s.Error = err.Error()
+			return err
+		}
+	}
+	return nil
+}
+
+// compensate 按相反顺序撤销已完成的步骤，全部撤销后返回包装了 cause 的 *sagaError。
+// 补偿失败时 saga 停留在 compensating，下次启动时继续补偿
+func (o *sagaOrchestrator) compensate(ctx context.Context, s *Saga, cause error) error {
+	// 原请求可能已经超时，补偿使用独立的截止时间
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), sagaCompensationTimeout)
+	defer cancel()
+
+	if err := o.save(ctx, s); err != nil {
+		log.Printf("Failed to save saga %s: %v", s.ID, err)
+	}
+	for s.CompletedSteps > 0 {
+		step := o.steps[s.CompletedSteps-1]
+		if step.compensate != nil {
+			if err := o.runStep(ctx, s, step.name, "compensation", step.compensate); err != nil {
+				log.Printf("Compensation %s of saga %s failed: %v", step.name, s.ID, err)
+				return fmt.Errorf("compensate %s: %w", step.name, err)
+			}
+		}
+		s.CompletedSteps--
+		if err := o.save(ctx, s); err != nil {
+			return fmt.Errorf("save saga after compensating %s: %w", step.name, err)
+		}
+	}
+
+	s.Status = SagaStatusFailed
+	if err := o.save(ctx, s); err != nil {
+		return fmt.Errorf("save saga: %w", err)
+	}
+	return &sagaError{step: s.FailedStep, err: cause}
+}
+
+// runStep 在独立的 span 中执行一个步骤或补偿
+func (o *sagaOrchestrator) runStep(ctx context.Context, s *Saga, name, phase string, fn func(context.Context, *Saga) error) error {
+	tracer := otel.Tracer("order-service")
+	ctx, span := tracer.Start(ctx, fmt.Sprintf("saga-%s %s", phase, name), trace.WithAttributes(
+		attribute.String("saga.id", s.ID),
+		attribute.String("saga.step", name),
+		attribute.String("saga.phase", phase),
+	))
+	defer span.End()
+
+	if err := fn(ctx, s); err != nil {
+		sagaStepsTotal.WithLabelValues(name, phase, "error").Inc()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	sagaStepsTotal.WithLabelValues(name, phase, "success").Inc()
+	return nil
+}
+
+func (o *sagaOrchestrator) save(ctx context.Context, s *Saga) error {
+	s.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	return o.repo.SaveSaga(ctx, *s)
+}
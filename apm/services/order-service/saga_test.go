@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xyzbit/devops-demo/pkg/faults"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func validUser(_ context.Context, userID int) (map[string]interface{}, error) {
+	return map[string]interface{}{"id": userID, "name": "test"}, nil
+}
+
+func TestOrderSagaCompletes(t *testing.T) {
+	for name, repo := range newTestRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			stock := newStockService(repo, 10)
+			payments := newPaymentService(repo, 1000)
+			saga, err := newOrderSaga(repo, validUser, stock, payments).Start(context.Background(), 1, "book", 99)
+			if err != nil {
+				t.Fatalf("start: %v", err)
+			}
+			if saga.Status != SagaStatusCompleted || saga.OrderID == 0 {
+				t.Fatalf("saga %+v", saga)
+			}
+
+			order, err := repo.Get(context.Background(), saga.OrderID)
+			if err != nil || order.Product != "book" || order.Status != OrderStatusCreated {
+				t.Fatalf("order %+v: %v", order, err)
+			}
+			if got, err := stock.Available(context.Background(), "book"); err != nil || got != 9 {
+				t.Errorf("stock %d, want 9: %v", got, err)
+			}
+			if amount, ok, err := payments.Charged(context.Background(), saga.ID); !ok || amount != 99 {
+				t.Errorf("charged %v, %v: %v", amount, ok, err)
+			}
+			stored, err := repo.GetSaga(context.Background(), saga.ID)
+			if err != nil || stored.Status != SagaStatusCompleted || stored.OrderID != order.ID {
+				t.Errorf("stored saga %+v: %v", stored, err)
+			}
+			if events, _ := repo.ClaimEvents(context.Background(), time.Now().Add(time.Hour), outboxClaimLease, 100); len(events) != 1 {
+				t.Errorf("%d outbox events, want 1", len(events))
+			}
+		})
+	}
+}
+
+func TestOrderSagaCompensatesFailedStep(t *testing.T) {
+	cases := []struct {
+		name       string
+		stock      int
+		amount     float64
+		failedStep string
+		wantErr    error
+	}{
+		{"payment declined", 10, 5000, sagaStepChargePayment, errPaymentDeclined},
+		{"out of stock", 0, 10, sagaStepReserveStock, errOutOfStock},
+	}
+	for _, tc := range cases {
+		for name, repo := range newTestRepositories(t) {
+			t.Run(tc.name+"/"+name, func(t *testing.T) {
+				stock := newStockService(repo, tc.stock)
+				payments := newPaymentService(repo, 1000)
+				before, _ := repo.List(context.Background(), OrderQuery{})
+
+				saga, err := newOrderSaga(repo, validUser, stock, payments).Start(context.Background(), 1, "book", tc.amount)
+				var stepErr *sagaError
+				if !errors.As(err, &stepErr) || stepErr.step != tc.failedStep || !errors.Is(err, tc.wantErr) {
+					t.Fatalf("got %v, want %v at %s", err, tc.wantErr, tc.failedStep)
+				}
+				if saga.Status != SagaStatusFailed || saga.CompletedSteps != 0 || saga.FailedStep != tc.failedStep {
+					t.Fatalf("saga %+v", saga)
+				}
+
+				// 已预留的库存被归还，没有扣款，也没有创建订单
+				if got, err := stock.Available(context.Background(), "book"); err != nil || got != tc.stock {
+					t.Errorf("stock %d, want %d: %v", got, tc.stock, err)
+				}
+				if _, ok, _ := payments.Charged(context.Background(), saga.ID); ok {
+					t.Errorf("payment was not refunded")
+				}
+				after, _ := repo.List(context.Background(), OrderQuery{})
+				if after.Total != before.Total {
+					t.Errorf("orders %d -> %d", before.Total, after.Total)
+				}
+				stored, err := repo.GetSaga(context.Background(), saga.ID)
+				if err != nil || stored.Status != SagaStatusFailed {
+					t.Errorf("stored saga %+v: %v", stored, err)
+				}
+			})
+		}
+	}
+}
+
+func TestOrderSagaCompensatesInjectedFault(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	if err := faultInjector.Replace([]faults.Rule{{Name: "payment-outage", Operation: "payment.charge", ErrorPercent: 100, Status: 503}}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { faultInjector.Replace(nil) })
+
+	repo := newMemoryOrderRepository(seedOrders)
+	stock := newStockService(repo, 10)
+	saga, err := newOrderSaga(repo, validUser, stock, newPaymentService(repo, 1000)).Start(context.Background(), 1, "book", 10)
+	var fault *faults.Error
+	if !errors.As(err, &fault) || fault.Rule != "payment-outage" || fault.Status != 503 {
+		t.Fatalf("got %v, want the injected fault", err)
+	}
+	if left, _ := stock.Available(context.Background(), "book"); saga.Status != SagaStatusFailed || saga.FailedStep != sagaStepChargePayment || left != 10 {
+		t.Errorf("saga %+v, stock %d", saga, left)
+	}
+
+	// 故障标记在替身的客户端 span 上
+	var tagged bool
+	for _, span := range recorder.Ended() {
+		for _, kv := range span.Attributes() {
+			if kv.Key == "fault.rule" && kv.Value.AsString() == "payment-outage" {
+				tagged = span.Name() == "payment charge"
+			}
+		}
+	}
+	if !tagged {
+		t.Error("payment charge span was not tagged with the fault")
+	}
+}
+
+func TestOrderSagaResumesAfterRestart(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	path := filepath.Join(t.TempDir(), "orders.db")
+	repo, err := newSQLiteOrderRepository(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 重启前一个 saga 完成了验证和预留库存，另一个在扣款后开始补偿。
+	// 预留和扣款由重启前的替身写入，重启后的替身从仓储中找到并撤销它们
+	forward := Saga{ID: "forward", Status: SagaStatusRunning, CompletedSteps: 2, UserID: 1, Product: "book", Amount: 10,
+		CreatedAt: "2024-01-01T00:00:00Z", UpdatedAt: "2024-01-01T00:00:00Z"}
+	backward := Saga{ID: "backward", Status: SagaStatusCompensating, CompletedSteps: 3, UserID: 1, Product: "book", Amount: 10,
+		FailedStep: sagaStepConfirm, Error: "interrupted", CreatedAt: "2024-01-01T00:00:01Z", UpdatedAt: "2024-01-01T00:00:01Z"}
+	for _, s := range []Saga{forward, backward} {
+		if err := repo.SaveSaga(context.Background(), s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := newStockService(repo, 10).Reserve(context.Background(), "backward", "book"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newPaymentService(repo, 1000).Charge(context.Background(), "backward", 1, 10); err != nil {
+		t.Fatal(err)
+	}
+	repo.Close()
+
+	repo, err = newSQLiteOrderRepository(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+
+	stock := newStockService(repo, 10)
+	payments := newPaymentService(repo, 1000)
+	newOrderSaga(repo, validUser, stock, payments).Resume(context.Background())
+
+	got, err := repo.GetSaga(context.Background(), "forward")
+	if err != nil || got.Status != SagaStatusCompleted || got.OrderID == 0 {
+		t.Fatalf("forward saga %+v: %v", got, err)
+	}
+	if _, err := repo.Get(context.Background(), got.OrderID); err != nil {
+		t.Errorf("resumed saga order: %v", err)
+	}
+
+	got, err = repo.GetSaga(context.Background(), "backward")
+	if err != nil || got.Status != SagaStatusFailed || got.CompletedSteps != 0 {
+		t.Fatalf("backward saga %+v: %v", got, err)
+	}
+	if _, ok, err := payments.Charged(context.Background(), "backward"); ok || err != nil {
+		t.Errorf("payment was not refunded: %v", err)
+	}
+	if left, err := stock.Available(context.Background(), "book"); err != nil || left != 10 {
+		t.Errorf("stock %d, want 10: reservation was not released: %v", left, err)
+	}
+	if incomplete, _ := repo.ClaimSagas(context.Background(), time.Now().Add(time.Hour), time.Now()); len(incomplete) != 0 {
+		t.Errorf("%d sagas still incomplete", len(incomplete))
+	}
+
+	// 正向步骤从中断处继续，补偿按相反顺序执行
+	var steps []string
+	for _, span := range recorder.Ended() {
+		for _, kv := range span.Attributes() {
+			if kv.Key == "saga.step" {
+				steps = append(steps, span.Name())
+			}
+		}
+	}
+	want := []string{
+		"saga-action charge_payment", "saga-action confirm",
+		"saga-compensation charge_payment", "saga-compensation reserve_stock",
+	}
+	if len(steps) != len(want) {
+		t.Fatalf("steps %v, want %v", steps, want)
+	}
+	for i := range want {
+		if steps[i] != want[i] {
+			t.Fatalf("steps %v, want %v", steps, want)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/xyzbit/devops-demo/pkg/deadline"
+)
+
+// 库存和支付还没有独立的服务，这里用替身模拟它们的行为和延迟，状态保存在订单仓储中。
+// 两个替身都以 saga ID 作为幂等键，saga 恢复时重复执行同一步骤不会重复扣减
+
+var (
+	errOutOfStock      = errors.New("out of stock")
+	errPaymentDeclined = errors.New("payment declined")
+)
+
+// standInCall 模拟一次远程调用：创建客户端 span，延迟和错误由操作 "<service>.<operation>" 的故障注入规则决定
+func standInCall(ctx context.Context, service, operation string, attrs ...attribute.KeyValue) (context.Context, trace.Span, error) {
+	tracer := otel.Tracer("order-service")
+	ctx, span := tracer.Start(ctx, fmt.Sprintf("%s %s", service, operation),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(append(attrs,
+			attribute.String("peer.service", service),
+			attribute.Bool("stand_in", true),
+		)...),
+	)
+
+	if err := faultInjector.Inject(ctx, service+"."+operation); err != nil {
+		if ctx.Err() != nil {
+			deadline.RecordCancellation(span, err)
+		}
+		return ctx, span, err
+	}
+	return ctx, span, nil
+}
+
+func endStandInSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// stockService 库存替身，每个商品初始有 initial 件库存。
+// 预留记录保存在订单仓储中，接管 saga 的副本也能归还其他副本预留的库存
+type stockService struct {
+	repo    OrderRepository
+	initial int
+}
+
+func newStockService(repo OrderRepository, initial int) *stockService {
+	return &stockService{repo: repo, initial: initial}
+}
+
+// Reserve 为 saga 预留一件商品，同一 saga 重复预留只生效一次
+func (s *stockService) Reserve(ctx context.Context, sagaID, product string) (err error) {
+	ctx, span, err := standInCall(ctx, "inventory", "reserve", attribute.String("saga.id", sagaID), attribute.String("product", product))
+	defer func() { endStandInSpan(span, err) }()
+	if err != nil {
+		return err
+	}
+
+	left, err := s.repo.ReserveStock(ctx, sagaID, product, s.initial)
+	if errors.Is(err, errOutOfStock) {
+		return fmt.Errorf("%w: %s", errOutOfStock, product)
+	}
+	if err != nil {
+		return err
+	}
+	span.SetAttributes(attribute.Int("stock.remaining", left))
+	return nil
+}
+
+// Release 归还 saga 预留的商品，没有预留时什么也不做
+func (s *stockService) Release(ctx context.Context, sagaID string) (err error) {
+	ctx, span, err := standInCall(ctx, "inventory", "release", attribute.String("saga.id", sagaID))
+	defer func() { endStandInSpan(span, err) }()
+	if err != nil {
+		return err
+	}
+
+	return s.repo.ReleaseStock(ctx, sagaID)
+}
+
+// Available 商品当前的可用库存
+func (s *stockService) Available(ctx context.Context, product string) (int, error) {
+	reserved, err := s.repo.ReservedStock(ctx, product)
+	if err != nil {
+		return 0, err
+	}
+	return s.initial - reserved, nil
+}
+
+// paymentService 支付替身，金额超过 limit 的扣款会被拒绝。
+// 扣款记录保存在订单仓储中，接管 saga 的副本也能退还其他副本的扣款
+type paymentService struct {
+	repo  OrderRepository
+	limit float64
+}
+
+func newPaymentService(repo OrderRepository, limit float64) *paymentService {
+	return &paymentService{repo: repo, limit: limit}
+}
+
+// Charge 扣款，同一 saga 重复扣款只生效一次
+func (p *paymentService) Charge(ctx context.Context, sagaID string, userID int, amount float64) (err error) {
+	ctx, span, err := standInCall(ctx, "payment", "charge",
+		attribute.String("saga.id", sagaID), attribute.Int("user.id", userID), attribute.Float64("amount", amount))
+	defer func() { endStandInSpan(span, err) }()
+	if err != nil {
+		return err
+	}
+
+	if amount > p.limit {
+		return fmt.Errorf("%w: amount %.2f exceeds limit %.2f", errPaymentDeclined, amount, p.limit)
+	}
+	return p.repo.SaveCharge(ctx, sagaID, userID, amount)
+}
+
+// Refund 退还 saga 的扣款，没有扣款时什么也不做
+func (p *paymentService) Refund(ctx context.Context, sagaID string) (err error) {
+	ctx, span, err := standInCall(ctx, "payment", "refund", attribute.String("saga.id", sagaID))
+	defer func() { endStandInSpan(span, err) }()
+	if err != nil {
+		return err
+	}
+
+	return p.repo.DeleteCharge(ctx, sagaID)
+}
+
+// Charged saga 当前的扣款金额
+func (p *paymentService) Charged(ctx context.Context, sagaID string) (float64, bool, error) {
+	return p.repo.GetCharge(ctx, sagaID)
+}
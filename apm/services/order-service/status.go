@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// 订单状态
+const (
+	OrderStatusCreated   = "created"
+	OrderStatusPaid      = "paid"
+	OrderStatusShipped   = "shipped"
+	OrderStatusCompleted = "completed"
+	OrderStatusCancelled = "cancelled"
+	OrderStatusRefunded  = "refunded"
+)
+
+// orderTransitions 每个状态允许迁移到的下一状态，cancelled 和 refunded 是终态
+var orderTransitions = map[string][]string{
+	OrderStatusCreated:   {OrderStatusPaid, OrderStatusCancelled},
+	OrderStatusPaid:      {OrderStatusShipped, OrderStatusCancelled, OrderStatusRefunded},
+	OrderStatusShipped:   {OrderStatusCompleted, OrderStatusRefunded},
+	OrderStatusCompleted: {OrderStatusRefunded},
+	OrderStatusCancelled: nil,
+	OrderStatusRefunded:  nil,
+}
+
+// errStatusConflict 订单状态已被其他请求修改，或迁移不合法
+var errStatusConflict = errors.New("order status conflict")
+
+var orderTransitionsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "order_transitions_total",
+		Help: "Total number of order status transitions",
+	},
+	[]string{"from", "to"},
+)
+
+func init() {
+	prometheus.MustRegister(orderTransitionsTotal)
+}
+
+func validStatus(status string) bool {
+	_, ok := orderTransitions[status]
+	return ok
+}
+
+// checkTransition 检查 from 能否迁移到 to
+func checkTransition(from, to string) error {
+	for _, next := range orderTransitions[from] {
+		if next == to {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: cannot transition order from %s to %s", errStatusConflict, from, to)
+}
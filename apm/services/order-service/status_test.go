@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestOrderStatusTransitions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	orderRepo = newMemoryOrderRepository(nil)
+	order := Order{UserID: 1, Product: "p", Amount: 1, Status: OrderStatusCreated}
+	if err := orderRepo.Create(context.Background(), &order); err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.PATCH("/orders/:id/status", updateOrderStatus)
+	r.POST("/orders/:id/cancel", cancelOrder)
+
+	steps := []struct {
+		method, path, body string
+		want               int
+	}{
+		{"PATCH", "/orders/1/status", `{"status":"shipped"}`, 409},
+		{"PATCH", "/orders/1/status", `{"status":"unknown"}`, 400},
+		{"PATCH", "/orders/1/status", `{"status":"paid"}`, 200},
+		{"PATCH", "/orders/1/status", `{"status":"shipped"}`, 200},
+		{"POST", "/orders/1/cancel", "", 409},
+		{"PATCH", "/orders/1/status", `{"status":"completed"}`, 200},
+		{"PATCH", "/orders/1/status", `{"status":"refunded"}`, 200},
+		{"PATCH", "/orders/1/status", `{"status":"paid"}`, 409},
+		{"POST", "/orders/2/cancel", "", 404},
+	}
+	for _, step := range steps {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(step.method, step.path, strings.NewReader(step.body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		if w.Code != step.want {
+			t.Fatalf("%s %s %s: got %d, want %d: %s", step.method, step.path, step.body, w.Code, step.want, w.Body.String())
+		}
+	}
+
+	got, _ := orderRepo.Get(context.Background(), 1)
+	if got.Status != OrderStatusRefunded {
+		t.Fatalf("final status %q, want %q", got.Status, OrderStatusRefunded)
+	}
+}
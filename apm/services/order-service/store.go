@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// errOrderNotFound 订单不存在
+var errOrderNotFound = errors.New("order not found")
+
+// OrderRepository 订单存储，实现必须可以被多个请求并发调用
+type OrderRepository interface {
+	// Create 保存新订单并回填 ID，同一事务中写入 order.created 的 outbox 事件
+	Create(ctx context.Context, order *Order) error
+	// Get 按 ID 查询订单，不存在时返回 errOrderNotFound
+	Get(ctx context.Context, id int) (Order, error)
+	// List 返回满足条件的一页订单
+	List(ctx context.Context, q OrderQuery) (OrderPage, error)
+	// UpdateStatus 仅当订单当前状态为 from 时改为 to，否则返回 errStatusConflict
+	UpdateStatus(ctx context.Context, id int, from, to string) error
+
+	// ClaimEvents 按写入顺序领取到期且尚未投递的 outbox 事件，并把它们的下次投递时间推迟 lease，
+	// 多个副本共用一个库时，同一事件在 lease 内只会被一个副本领取
+	ClaimEvents(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]OutboxEvent, error)
+	// MarkPublished 标记事件已投递
+	MarkPublished(ctx context.Context, id string, at time.Time) error
+	// ScheduleRetry 记录一次失败的投递并设置下次重试时间
+	ScheduleRetry(ctx context.Context, id string, attempts int, next time.Time, lastErr string) error
+	// Park 记录最后一次失败的投递并停止重试，事件不会再被领取
+	Park(ctx context.Context, id string, attempts int, at time.Time, lastErr string) error
+
+	// SaveSaga 保存 saga 的当前状态，已存在时覆盖
+	SaveSaga(ctx context.Context, saga Saga) error
+	// GetSaga 按 ID 查询 saga，不存在时返回 errSagaNotFound
+	GetSaga(ctx context.Context, id string) (Saga, error)
+	// ClaimSagas 按创建顺序领取 updated_at 早于 staleBefore 且尚未结束（running 或 compensating）的 saga，
+	// 并把它们的 updated_at 改为 now，其他副本不会再领取正在执行的 saga
+	ClaimSagas(ctx context.Context, staleBefore, now time.Time) ([]Saga, error)
+	// CompleteSaga 在同一事务中创建订单（连同 outbox 事件）并保存已完成的 saga，回填订单 ID；
+	// ctx 带有幂等键时同时保存创建成功的结果
+	CompleteSaga(ctx context.Context, saga *Saga, order *Order) error
+
+	// 库存和支付替身的状态以 saga ID 为键保存在这里，任何副本都能补偿其他副本留下的 saga。
+	// ReserveStock 为 saga 预留一件商品并返回剩余库存，同一 saga 重复预留只生效一次，
+	// 已预留数达到 initial 时返回 errOutOfStock
+	ReserveStock(ctx context.Context, sagaID, product string, initial int) (int, error)
+	// ReleaseStock 删除 saga 的预留，没有预留时什么也不做
+	ReleaseStock(ctx context.Context, sagaID string) error
+	// ReservedStock 商品当前被预留的件数
+	ReservedStock(ctx context.Context, product string) (int, error)
+	// SaveCharge 记录 saga 的扣款，已存在时覆盖
+	SaveCharge(ctx context.Context, sagaID string, userID int, amount float64) error
+	// DeleteCharge 删除 saga 的扣款，没有扣款时什么也不做
+	DeleteCharge(ctx context.Context, sagaID string) error
+	// GetCharge 查询 saga 的扣款金额，没有扣款时第二个返回值为 false
+	GetCharge(ctx context.Context, sagaID string) (float64, bool, error)
+
+	// ClaimIdempotencyKey 登记处理中的幂等键。键不存在或已过期时写入 rec 并返回 true，
+	// 否则返回已有的记录和 false。带幂等键创建订单时，CompleteSaga 在同一事务中保存 201 结果
+	ClaimIdempotencyKey(ctx context.Context, rec IdempotencyRecord, now time.Time) (IdempotencyRecord, bool, error)
+	// SaveIdempotencyResponse 保存处理中的幂等键的结果，已有结果时不覆盖
+	SaveIdempotencyResponse(ctx context.Context, rec IdempotencyRecord) error
+	// DeleteIdempotencyKey 删除处理中的幂等键，客户端可以用同一个键重试
+	DeleteIdempotencyKey(ctx context.Context, key string) error
+	// DeleteExpiredIdempotencyKeys 删除 now 之前过期的幂等键，返回删除的数量
+	DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int, error)
+
+	Close() error
+}
+
+// sortSagas 按创建顺序排列 saga
+func sortSagas(sagas []Saga) {
+	sort.Slice(sagas, func(i, j int) bool {
+		if sagas[i].CreatedAt != sagas[j].CreatedAt {
+			return sagas[i].CreatedAt < sagas[j].CreatedAt
+		}
+		return sagas[i].ID < sagas[j].ID
+	})
+}
+
+// seedOrders 演示用的初始订单
+var seedOrders = []Order{
+	{
+		ID:       1,
+		UserID:   1,
+		Product:  "笔记本电脑",
+		Amount:   5999.99,
+		Status:   OrderStatusCompleted,
+		CreateAt: "2023-01-01T10:00:00Z",
+	},
+	{
+		ID:       2,
+		UserID:   2,
+		Product:  "智能手机",
+		Amount:   2999.99,
+		Status:   OrderStatusPaid,
+		CreateAt: "2023-01-02T11:00:00Z",
+	},
+}
+
+// newOrderRepository 根据 ORDER_STORE 选择存储：memory（默认）或 sqlite，
+// sqlite 的数据库文件由 ORDER_DB_PATH 指定
+func newOrderRepository() (OrderRepository, error) {
+	switch store := os.Getenv("ORDER_STORE"); store {
+	case "", "memory":
+		return newMemoryOrderRepository(seedOrders), nil
+	case "sqlite":
+		path := os.Getenv("ORDER_DB_PATH")
+		if path == "" {
+			path = "/app/data/orders.db"
+		}
+		return newSQLiteOrderRepository(context.Background(), path)
+	default:
+		return nil, fmt.Errorf("unknown ORDER_STORE %q", store)
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// memoryOrderRepository 进程内存储，重启后数据丢失
+type memoryOrderRepository struct {
+	mu     sync.RWMutex
+	orders []Order
+	nextID int
+	// outbox 尚未投递的事件，投递成功后删除，停止重试后移到 parked
+	outbox []*OutboxEvent
+	parked []OutboxEvent
+	sagas  map[string]Saga
+	// reservations 和 charges 是库存和支付替身的状态，以 saga ID 为键
+	reservations map[string]string
+	charges      map[string]float64
+	idempotency  map[string]IdempotencyRecord
+}
+
+func newMemoryOrderRepository(seed []Order) *memoryOrderRepository {
+	r := &memoryOrderRepository{
+		orders: append([]Order(nil), seed...),
+		nextID: 1,
+		sagas:  make(map[string]Saga),
+
+		reservations: make(map[string]string),
+		charges:      make(map[string]float64),
+		idempotency:  make(map[string]IdempotencyRecord),
+	}
+	for _, o := range seed {
+		if o.ID >= r.nextID {
+			r.nextID = o.ID + 1
+		}
+	}
+	return r
+}
+
+func (r *memoryOrderRepository) Create(ctx context.Context, order *Order) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.createLocked(ctx, order)
+}
+
+func (r *memoryOrderRepository) createLocked(ctx context.Context, order *Order) error {
+	order.ID = r.nextID
+	event, err := newOrderCreatedEvent(ctx, *order)
+	if err != nil {
+		return err
+	}
+	// 订单和事件在同一把锁内写入，二者要么都可见，要么都不可见
+	r.nextID++
+	r.orders = append(r.orders, *order)
+	r.outbox = append(r.outbox, &event)
+	return nil
+}
+
+func (r *memoryOrderRepository) Get(_ context.Context, id int) (Order, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, o := range r.orders {
+		if o.ID == id {
+			return o, nil
+		}
+	}
+	return Order{}, errOrderNotFound
+}
+
+func (r *memoryOrderRepository) List(_ context.Context, q OrderQuery) (OrderPage, error) {
+	r.mu.RLock()
+	// 复制匹配的订单，排序和分页不受并发写入影响
+	var matched []Order
+	for _, o := range r.orders {
+		if q.matches(o) {
+			matched = append(matched, o)
+		}
+	}
+	r.mu.RUnlock()
+
+	return q.paginate(matched), nil
+}
+
+func (r *memoryOrderRepository) UpdateStatus(_ context.Context, id int, from, to string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.orders {
+		if r.orders[i].ID != id {
+			continue
+		}
+		if r.orders[i].Status != from {
+			return errStatusConflict
+		}
+		r.orders[i].Status = to
+		return nil
+	}
+	return errOrderNotFound
+}
+
+func (r *memoryOrderRepository) ClaimEvents(_ context.Context, now time.Time, lease time.Duration, limit int) ([]OutboxEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var events []OutboxEvent
+	for _, ev := range r.outbox {
+		if ev.NextAttemptAt.After(now) {
+			continue
+		}
+		events = append(events, *ev)
+		ev.NextAttemptAt = now.Add(lease)
+		if len(events) == limit {
+			break
+		}
+	}
+	return events, nil
+}
+
+func (r *memoryOrderRepository) MarkPublished(_ context.Context, id string, _ time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, ev := range r.outbox {
+		if ev.ID == id {
+			r.outbox = append(r.outbox[:i], r.outbox[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (r *memoryOrderRepository) ScheduleRetry(_ context.Context, id string, attempts int, next time.Time, lastErr string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, ev := range r.outbox {
+		if ev.ID == id {
+			ev.Attempts = attempts
+			ev.NextAttemptAt = next
+			ev.LastError = lastErr
+			return nil
+		}
+	}
+	return nil
+}
+
+func (r *memoryOrderRepository) Park(_ context.Context, id string, attempts int, _ time.Time, lastErr string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, ev := range r.outbox {
+		if ev.ID == id {
+			ev.Attempts = attempts
+			ev.LastError = lastErr
+			r.parked = append(r.parked, *ev)
+			r.outbox = append(r.outbox[:i], r.outbox[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (r *memoryOrderRepository) SaveSaga(_ context.Context, saga Saga) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sagas[saga.ID] = saga
+	return nil
+}
+
+func (r *memoryOrderRepository) GetSaga(_ context.Context, id string) (Saga, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	saga, ok := r.sagas[id]
+	if !ok {
+		return Saga{}, errSagaNotFound
+	}
+	return saga, nil
+}
+
+func (r *memoryOrderRepository) ClaimSagas(_ context.Context, staleBefore, now time.Time) ([]Saga, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stale := staleBefore.UTC().Format(time.RFC3339)
+	var sagas []Saga
+	for id, saga := range r.sagas {
+		if (saga.Status == SagaStatusRunning || saga.Status == SagaStatusCompensating) && saga.UpdatedAt < stale {
+			saga.UpdatedAt = now.UTC().Format(time.RFC3339)
+			r.sagas[id] = saga
+			sagas = append(sagas, saga)
+		}
+	}
+	sortSagas(sagas)
+	return sagas, nil
+}
+
+func (r *memoryOrderRepository) CompleteSaga(ctx context.Context, saga *Saga, order *Order) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.createLocked(ctx, order); err != nil {
+		return err
+	}
+	saga.OrderID = order.ID
+	r.sagas[saga.ID] = *saga
+	if claim, ok := idempotencyClaimFromContext(ctx); ok {
+		rec, err := claim.created(*order, *saga)
+		if err != nil {
+			return err
+		}
+		r.saveIdempotencyLocked(rec)
+	}
+	return nil
+}
+
+func (r *memoryOrderRepository) ReserveStock(_ context.Context, sagaID, product string, initial int) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	reserved := r.reservedLocked(product)
+	if _, ok := r.reservations[sagaID]; ok {
+		return initial - reserved, nil
+	}
+	if reserved >= initial {
+		return 0, errOutOfStock
+	}
+	r.reservations[sagaID] = product
+	return initial - reserved - 1, nil
+}
+
+func (r *memoryOrderRepository) reservedLocked(product string) int {
+	n := 0
+	for _, p := range r.reservations {
+		if p == product {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *memoryOrderRepository) ReleaseStock(_ context.Context, sagaID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.reservations, sagaID)
+	return nil
+}
+
+func (r *memoryOrderRepository) ReservedStock(_ context.Context, product string) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.reservedLocked(product), nil
+}
+
+func (r *memoryOrderRepository) SaveCharge(_ context.Context, sagaID string, _ int, amount float64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.charges[sagaID] = amount
+	return nil
+}
+
+func (r *memoryOrderRepository) DeleteCharge(_ context.Context, sagaID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.charges, sagaID)
+	return nil
+}
+
+func (r *memoryOrderRepository) GetCharge(_ context.Context, sagaID string) (float64, bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	amount, ok := r.charges[sagaID]
+	return amount, ok, nil
+}
+
+func (r *memoryOrderRepository) ClaimIdempotencyKey(_ context.Context, rec IdempotencyRecord, now time.Time) (IdempotencyRecord, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, ok := r.idempotency[rec.Key]; ok && existing.ExpiresAt.After(now) {
+		return existing, false, nil
+	}
+	rec.Status, rec.Body = 0, nil
+	r.idempotency[rec.Key] = rec
+	return rec, true, nil
+}
+
+func (r *memoryOrderRepository) SaveIdempotencyResponse(_ context.Context, rec IdempotencyRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.saveIdempotencyLocked(rec)
+	return nil
+}
+
+func (r *memoryOrderRepository) saveIdempotencyLocked(rec IdempotencyRecord) {
+	existing, ok := r.idempotency[rec.Key]
+	if !ok || existing.Status != 0 {
+		return
+	}
+	existing.Status = rec.Status
+	existing.Body = append([]byte(nil), rec.Body...)
+	existing.ExpiresAt = rec.ExpiresAt
+	r.idempotency[rec.Key] = existing
+}
+
+func (r *memoryOrderRepository) DeleteIdempotencyKey(_ context.Context, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, ok := r.idempotency[key]; ok && existing.Status == 0 {
+		delete(r.idempotency, key)
+	}
+	return nil
+}
+
+func (r *memoryOrderRepository) DeleteExpiredIdempotencyKeys(_ context.Context, now time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for key, rec := range r.idempotency {
+		if !rec.ExpiresAt.After(now) {
+			delete(r.idempotency, key)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *memoryOrderRepository) Close() error {
+	return nil
+}
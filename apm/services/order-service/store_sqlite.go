@@ -0,0 +1,738 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	_ "modernc.org/sqlite"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// sqliteOrderRepository 基于 SQLite 的持久化存储，启动时自动执行未应用的迁移
+type sqliteOrderRepository struct {
+	db     *sql.DB
+	dbName string
+}
+
+func newSQLiteOrderRepository(ctx context.Context, path string) (*sqliteOrderRepository, error) {
+	// 多个副本共用同一个数据库文件，事务开始时就取得写锁（BEGIN IMMEDIATE），
+	// 避免两个副本的事务都先读后写时其中一个因无法升级锁而失败
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate", path)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite %s: %w", path, err)
+	}
+	// SQLite 同一时刻只允许一个写入者，使用单连接避免 SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+
+	r := &sqliteOrderRepository{db: db, dbName: filepath.Base(path)}
+	if err := r.migrate(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// migrate 按文件名顺序执行尚未应用的迁移，每个迁移在独立事务中完成。
+// 是否已应用在同一事务中检查，多个副本同时启动时每个迁移只会执行一次
+func (r *sqliteOrderRepository) migrate(ctx context.Context) error {
+	if _, err := r.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    TEXT PRIMARY KEY,
+		applied_at TEXT NOT NULL
+	)`); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+
+	files, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		version := strings.TrimSuffix(filepath.Base(file), ".sql")
+
+		stmt, err := migrations.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		tx, err := r.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		var applied int
+		if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM schema_migrations WHERE version = ?`, version).Scan(&applied); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("check migration %s: %w", version, err)
+		}
+		if applied > 0 {
+			tx.Rollback()
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, string(stmt)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("apply migration %s: %w", version, err)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`,
+			version, time.Now().UTC().Format(time.RFC3339)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("record migration %s: %w", version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("commit migration %s: %w", version, err)
+		}
+	}
+	return nil
+}
+
+// startSpan 为一次 orders 表的数据库调用创建客户端 span
+func (r *sqliteOrderRepository) startSpan(ctx context.Context, operation, statement string) (context.Context, trace.Span) {
+	return r.startTableSpan(ctx, "orders", operation, statement)
+}
+
+func (r *sqliteOrderRepository) startOutboxSpan(ctx context.Context, operation, statement string) (context.Context, trace.Span) {
+	return r.startTableSpan(ctx, "outbox", operation, statement)
+}
+
+func (r *sqliteOrderRepository) startTableSpan(ctx context.Context, table, operation, statement string) (context.Context, trace.Span) {
+	// 没有父 span 时（如 outbox 轮询）不创建 span，避免每次轮询都产生一条孤立的链路
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+	tracer := otel.Tracer("order-service")
+	return tracer.Start(ctx, operation+" "+table,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemSqlite,
+			semconv.DBName(r.dbName),
+			semconv.DBOperation(operation),
+			semconv.DBStatement(statement),
+		),
+	)
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, errOrderNotFound) && !errors.Is(err, errStatusConflict) && !errors.Is(err, errSagaNotFound) &&
+		!errors.Is(err, errOutOfStock) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+const insertOrderSQL = `INSERT INTO orders (user_id, product, amount, status, create_at) VALUES (?, ?, ?, ?, ?)`
+
+const insertOutboxSQL = `INSERT INTO outbox (id, event_type, payload, trace_context, next_attempt_at, created_at) VALUES (?, ?, ?, ?, ?, ?)`
+
+func (r *sqliteOrderRepository) Create(ctx context.Context, order *Order) (err error) {
+	ctx, span := r.startSpan(ctx, "INSERT", insertOrderSQL+"; "+insertOutboxSQL)
+	defer func() { endSpan(span, err) }()
+
+	// 订单和 outbox 事件在同一事务中写入
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	id, err := insertOrder(ctx, tx, *order)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	order.ID = id
+	return nil
+}
+
+// insertOrder 在事务中写入订单及其 order.created 事件，返回订单 ID
+func insertOrder(ctx context.Context, tx *sql.Tx, order Order) (int, error) {
+	res, err := tx.ExecContext(ctx, insertOrderSQL, order.UserID, order.Product, order.Amount, order.Status, order.CreateAt)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	order.ID = int(id)
+
+	event, err := newOrderCreatedEvent(ctx, order)
+	if err != nil {
+		return 0, err
+	}
+	traceContext, err := json.Marshal(event.TraceContext)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, insertOutboxSQL, event.ID, event.Type, string(event.Payload), string(traceContext),
+		event.NextAttemptAt.Format(outboxTimeFormat), event.CreatedAt.Format(outboxTimeFormat)); err != nil {
+		return 0, err
+	}
+	return order.ID, nil
+}
+
+const selectOrderSQL = `SELECT id, user_id, product, amount, status, create_at FROM orders`
+
+func (r *sqliteOrderRepository) Get(ctx context.Context, id int) (order Order, err error) {
+	query := selectOrderSQL + ` WHERE id = ?`
+	ctx, span := r.startSpan(ctx, "SELECT", query)
+	defer func() { endSpan(span, err) }()
+
+	err = r.db.QueryRowContext(ctx, query, id).
+		Scan(&order.ID, &order.UserID, &order.Product, &order.Amount, &order.Status, &order.CreateAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Order{}, errOrderNotFound
+	}
+	return order, err
+}
+
+// sortColumns 排序字段对应的列
+var sortColumns = map[string]string{
+	sortByID:        "id",
+	sortByCreatedAt: "create_at",
+	sortByAmount:    "amount",
+}
+
+// whereClause 把过滤条件转换为 WHERE 子句，不包含游标条件
+func whereClause(q OrderQuery) (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	if q.UserID != nil {
+		conds = append(conds, "user_id = ?")
+		args = append(args, *q.UserID)
+	}
+	if len(q.Statuses) > 0 {
+		conds = append(conds, "status IN (?"+strings.Repeat(", ?", len(q.Statuses)-1)+")")
+		for _, s := range q.Statuses {
+			args = append(args, s)
+		}
+	}
+	if q.CreatedAfter != "" {
+		conds = append(conds, "create_at >= ?")
+		args = append(args, q.CreatedAfter)
+	}
+	if q.CreatedBefore != "" {
+		conds = append(conds, "create_at < ?")
+		args = append(args, q.CreatedBefore)
+	}
+	if q.MinAmount != nil {
+		conds = append(conds, "amount >= ?")
+		args = append(args, *q.MinAmount)
+	}
+	if q.MaxAmount != nil {
+		conds = append(conds, "amount <= ?")
+		args = append(args, *q.MaxAmount)
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+func (r *sqliteOrderRepository) List(ctx context.Context, q OrderQuery) (page OrderPage, err error) {
+	where, args := whereClause(q)
+	column := sortColumns[q.SortBy]
+	if column == "" {
+		column = "id"
+	}
+	dir, op := "ASC", ">"
+	if q.Desc {
+		dir, op = "DESC", "<"
+	}
+
+	// 游标分页：从上一页最后一条之后继续，按 (排序列, id) 比较保证顺序稳定
+	pageWhere, pageArgs := where, append([]interface{}(nil), args...)
+	if q.After != nil {
+		var value interface{} = q.After.ID
+		switch q.SortBy {
+		case sortByAmount:
+			value = q.After.Amount
+		case sortByCreatedAt:
+			value = q.After.Time
+		}
+		cond := fmt.Sprintf("(%s %s ? OR (%s = ? AND id %s ?))", column, op, column, op)
+		if pageWhere == "" {
+			pageWhere = " WHERE " + cond
+		} else {
+			pageWhere += " AND " + cond
+		}
+		pageArgs = append(pageArgs, value, value, q.After.ID)
+	}
+
+	query := selectOrderSQL + pageWhere + fmt.Sprintf(" ORDER BY %s %s, id %s", column, dir, dir)
+	if q.Limit > 0 {
+		// 多取一条判断是否还有下一页
+		query += " LIMIT ?"
+		pageArgs = append(pageArgs, q.Limit+1)
+	}
+
+	ctx, span := r.startSpan(ctx, "SELECT", query)
+	defer func() { endSpan(span, err) }()
+
+	rows, err := r.db.QueryContext(ctx, query, pageArgs...)
+	if err != nil {
+		return OrderPage{}, err
+	}
+	defer rows.Close()
+
+	page.Orders = []Order{}
+	for rows.Next() {
+		var o Order
+		if err := rows.Scan(&o.ID, &o.UserID, &o.Product, &o.Amount, &o.Status, &o.CreateAt); err != nil {
+			return OrderPage{}, err
+		}
+		page.Orders = append(page.Orders, o)
+	}
+	if err := rows.Err(); err != nil {
+		return OrderPage{}, err
+	}
+	if q.Limit > 0 && len(page.Orders) > q.Limit {
+		page.Orders = page.Orders[:q.Limit]
+		page.NextCursor = cursorFor(q, page.Orders[q.Limit-1])
+	}
+
+	err = r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM orders"+where, args...).Scan(&page.Total)
+	return page, err
+}
+
+const updateStatusSQL = `UPDATE orders SET status = ? WHERE id = ? AND status = ?`
+
+func (r *sqliteOrderRepository) UpdateStatus(ctx context.Context, id int, from, to string) (err error) {
+	ctx, span := r.startSpan(ctx, "UPDATE", updateStatusSQL)
+	defer func() { endSpan(span, err) }()
+
+	// 以当前状态为条件更新，并发迁移中只有一个能成功
+	res, err := r.db.ExecContext(ctx, updateStatusSQL, to, id, from)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+
+	var exists int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM orders WHERE id = ?`, id).Scan(&exists); err != nil {
+		return err
+	}
+	if exists == 0 {
+		return errOrderNotFound
+	}
+	return errStatusConflict
+}
+
+// claimEventsSQL 在一条语句中选出到期事件并推迟它们的投递时间，多个副本同时轮询时不会领取到同一事件
+const claimEventsSQL = `UPDATE outbox SET next_attempt_at = ?
+	WHERE id IN (SELECT id FROM outbox WHERE published_at IS NULL AND parked_at IS NULL AND next_attempt_at <= ? ORDER BY created_at, id LIMIT ?)
+	RETURNING id, event_type, payload, trace_context, attempts, last_error, next_attempt_at, created_at`
+
+func (r *sqliteOrderRepository) ClaimEvents(ctx context.Context, now time.Time, lease time.Duration, limit int) (events []OutboxEvent, err error) {
+	ctx, span := r.startOutboxSpan(ctx, "UPDATE", claimEventsSQL)
+	defer func() { endSpan(span, err) }()
+
+	rows, err := r.db.QueryContext(ctx, claimEventsSQL,
+		now.Add(lease).UTC().Format(outboxTimeFormat), now.UTC().Format(outboxTimeFormat), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ev OutboxEvent
+		var payload, traceContext, next, created string
+		if err := rows.Scan(&ev.ID, &ev.Type, &payload, &traceContext, &ev.Attempts, &ev.LastError, &next, &created); err != nil {
+			return nil, err
+		}
+		ev.Payload = json.RawMessage(payload)
+		if err := json.Unmarshal([]byte(traceContext), &ev.TraceContext); err != nil {
+			return nil, fmt.Errorf("outbox event %s: %w", ev.ID, err)
+		}
+		if ev.NextAttemptAt, err = time.Parse(outboxTimeFormat, next); err != nil {
+			return nil, err
+		}
+		if ev.CreatedAt, err = time.Parse(outboxTimeFormat, created); err != nil {
+			return nil, err
+		}
+		events = append(events, ev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// RETURNING 不保证顺序
+	sort.Slice(events, func(i, j int) bool {
+		if !events[i].CreatedAt.Equal(events[j].CreatedAt) {
+			return events[i].CreatedAt.Before(events[j].CreatedAt)
+		}
+		return events[i].ID < events[j].ID
+	})
+	return events, nil
+}
+
+const markPublishedSQL = `UPDATE outbox SET published_at = ? WHERE id = ?`
+
+func (r *sqliteOrderRepository) MarkPublished(ctx context.Context, id string, at time.Time) (err error) {
+	ctx, span := r.startOutboxSpan(ctx, "UPDATE", markPublishedSQL)
+	defer func() { endSpan(span, err) }()
+
+	_, err = r.db.ExecContext(ctx, markPublishedSQL, at.UTC().Format(outboxTimeFormat), id)
+	return err
+}
+
+const scheduleRetrySQL = `UPDATE outbox SET attempts = ?, next_attempt_at = ?, last_error = ? WHERE id = ?`
+
+func (r *sqliteOrderRepository) ScheduleRetry(ctx context.Context, id string, attempts int, next time.Time, lastErr string) (err error) {
+	ctx, span := r.startOutboxSpan(ctx, "UPDATE", scheduleRetrySQL)
+	defer func() { endSpan(span, err) }()
+
+	_, err = r.db.ExecContext(ctx, scheduleRetrySQL, attempts, next.UTC().Format(outboxTimeFormat), lastErr, id)
+	return err
+}
+
+const parkEventSQL = `UPDATE outbox SET attempts = ?, parked_at = ?, last_error = ? WHERE id = ?`
+
+func (r *sqliteOrderRepository) Park(ctx context.Context, id string, attempts int, at time.Time, lastErr string) (err error) {
+	ctx, span := r.startOutboxSpan(ctx, "UPDATE", parkEventSQL)
+	defer func() { endSpan(span, err) }()
+
+	_, err = r.db.ExecContext(ctx, parkEventSQL, attempts, at.UTC().Format(outboxTimeFormat), lastErr, id)
+	return err
+}
+
+func (r *sqliteOrderRepository) startSagaSpan(ctx context.Context, operation, statement string) (context.Context, trace.Span) {
+	return r.startTableSpan(ctx, "sagas", operation, statement)
+}
+
+const saveSagaSQL = `INSERT INTO sagas (id, status, completed_steps, failed_step, error, user_id, product, amount, user_data, order_id, trace_context, created_at, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (id) DO UPDATE SET status = excluded.status, completed_steps = excluded.completed_steps,
+		failed_step = excluded.failed_step, error = excluded.error, user_data = excluded.user_data,
+		order_id = excluded.order_id, updated_at = excluded.updated_at`
+
+func (r *sqliteOrderRepository) SaveSaga(ctx context.Context, saga Saga) (err error) {
+	ctx, span := r.startSagaSpan(ctx, "INSERT", saveSagaSQL)
+	defer func() { endSpan(span, err) }()
+
+	return saveSaga(ctx, r.db, saga)
+}
+
+// execer *sql.DB 和 *sql.Tx 共有的写入方法
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func saveSaga(ctx context.Context, db execer, saga Saga) error {
+	traceContext, err := json.Marshal(saga.TraceContext)
+	if err != nil {
+		return err
+	}
+	var orderID interface{}
+	if saga.OrderID != 0 {
+		orderID = saga.OrderID
+	}
+	_, err = db.ExecContext(ctx, saveSagaSQL, saga.ID, saga.Status, saga.CompletedSteps, saga.FailedStep, saga.Error,
+		saga.UserID, saga.Product, saga.Amount, string(saga.User), orderID, string(traceContext), saga.CreatedAt, saga.UpdatedAt)
+	return err
+}
+
+const sagaColumns = `id, status, completed_steps, failed_step, error, user_id, product, amount, user_data, order_id, trace_context, created_at, updated_at`
+
+const selectSagaSQL = `SELECT ` + sagaColumns + ` FROM sagas`
+
+func scanSaga(row interface{ Scan(...interface{}) error }) (Saga, error) {
+	var saga Saga
+	var user, traceContext string
+	var orderID sql.NullInt64
+	if err := row.Scan(&saga.ID, &saga.Status, &saga.CompletedSteps, &saga.FailedStep, &saga.Error,
+		&saga.UserID, &saga.Product, &saga.Amount, &user, &orderID, &traceContext, &saga.CreatedAt, &saga.UpdatedAt); err != nil {
+		return Saga{}, err
+	}
+	if user != "" {
+		saga.User = json.RawMessage(user)
+	}
+	saga.OrderID = int(orderID.Int64)
+	if err := json.Unmarshal([]byte(traceContext), &saga.TraceContext); err != nil {
+		return Saga{}, fmt.Errorf("saga %s: %w", saga.ID, err)
+	}
+	return saga, nil
+}
+
+func (r *sqliteOrderRepository) GetSaga(ctx context.Context, id string) (saga Saga, err error) {
+	query := selectSagaSQL + ` WHERE id = ?`
+	ctx, span := r.startSagaSpan(ctx, "SELECT", query)
+	defer func() { endSpan(span, err) }()
+
+	saga, err = scanSaga(r.db.QueryRowContext(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Saga{}, errSagaNotFound
+	}
+	return saga, err
+}
+
+const claimSagasSQL = `UPDATE sagas SET updated_at = ? WHERE status IN (?, ?) AND updated_at < ? RETURNING ` + sagaColumns
+
+func (r *sqliteOrderRepository) ClaimSagas(ctx context.Context, staleBefore, now time.Time) (sagas []Saga, err error) {
+	ctx, span := r.startSagaSpan(ctx, "UPDATE", claimSagasSQL)
+	defer func() { endSpan(span, err) }()
+
+	rows, err := r.db.QueryContext(ctx, claimSagasSQL, now.UTC().Format(time.RFC3339),
+		SagaStatusRunning, SagaStatusCompensating, staleBefore.UTC().Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		saga, err := scanSaga(rows)
+		if err != nil {
+			return nil, err
+		}
+		sagas = append(sagas, saga)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sortSagas(sagas)
+	return sagas, nil
+}
+
+func (r *sqliteOrderRepository) CompleteSaga(ctx context.Context, saga *Saga, order *Order) (err error) {
+	ctx, span := r.startSpan(ctx, "INSERT", insertOrderSQL+"; "+insertOutboxSQL+"; "+saveSagaSQL)
+	defer func() { endSpan(span, err) }()
+
+	// 订单、outbox 事件、saga 的完成状态和幂等键的结果要么一起写入，要么都不写入，
+	// 重启后不会出现订单已创建但 saga 仍在执行、或重试再创建一个订单的情况
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	id, err := insertOrder(ctx, tx, *order)
+	if err != nil {
+		return err
+	}
+	completed := *saga
+	completed.OrderID = id
+	if err := saveSaga(ctx, tx, completed); err != nil {
+		return err
+	}
+	if claim, ok := idempotencyClaimFromContext(ctx); ok {
+		created := *order
+		created.ID = id
+		rec, err := claim.created(created, completed)
+		if err != nil {
+			return err
+		}
+		if err := saveIdempotencyResponse(ctx, tx, rec); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	order.ID = id
+	saga.OrderID = id
+	return nil
+}
+
+const selectReservationSQL = `SELECT COUNT(*) FROM stock_reservations WHERE saga_id = ?`
+
+const countReservationsSQL = `SELECT COUNT(*) FROM stock_reservations WHERE product = ?`
+
+const insertReservationSQL = `INSERT INTO stock_reservations (saga_id, product, created_at) VALUES (?, ?, ?)`
+
+func (r *sqliteOrderRepository) ReserveStock(ctx context.Context, sagaID, product string, initial int) (remaining int, err error) {
+	ctx, span := r.startTableSpan(ctx, "stock_reservations", "INSERT", insertReservationSQL)
+	defer func() { endSpan(span, err) }()
+
+	// 事务以 IMMEDIATE 开始，多个副本同时预留时计数和写入不会交错
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var exists, reserved int
+	if err := tx.QueryRowContext(ctx, selectReservationSQL, sagaID).Scan(&exists); err != nil {
+		return 0, err
+	}
+	if err := tx.QueryRowContext(ctx, countReservationsSQL, product).Scan(&reserved); err != nil {
+		return 0, err
+	}
+	if exists > 0 {
+		return initial - reserved, nil
+	}
+	if reserved >= initial {
+		return 0, errOutOfStock
+	}
+	if _, err := tx.ExecContext(ctx, insertReservationSQL, sagaID, product, time.Now().UTC().Format(time.RFC3339)); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return initial - reserved - 1, nil
+}
+
+const deleteReservationSQL = `DELETE FROM stock_reservations WHERE saga_id = ?`
+
+func (r *sqliteOrderRepository) ReleaseStock(ctx context.Context, sagaID string) (err error) {
+	ctx, span := r.startTableSpan(ctx, "stock_reservations", "DELETE", deleteReservationSQL)
+	defer func() { endSpan(span, err) }()
+
+	_, err = r.db.ExecContext(ctx, deleteReservationSQL, sagaID)
+	return err
+}
+
+func (r *sqliteOrderRepository) ReservedStock(ctx context.Context, product string) (n int, err error) {
+	ctx, span := r.startTableSpan(ctx, "stock_reservations", "SELECT", countReservationsSQL)
+	defer func() { endSpan(span, err) }()
+
+	err = r.db.QueryRowContext(ctx, countReservationsSQL, product).Scan(&n)
+	return n, err
+}
+
+const saveChargeSQL = `INSERT INTO payment_charges (saga_id, user_id, amount, created_at) VALUES (?, ?, ?, ?)
+	ON CONFLICT (saga_id) DO UPDATE SET user_id = excluded.user_id, amount = excluded.amount`
+
+func (r *sqliteOrderRepository) SaveCharge(ctx context.Context, sagaID string, userID int, amount float64) (err error) {
+	ctx, span := r.startTableSpan(ctx, "payment_charges", "INSERT", saveChargeSQL)
+	defer func() { endSpan(span, err) }()
+
+	_, err = r.db.ExecContext(ctx, saveChargeSQL, sagaID, userID, amount, time.Now().UTC().Format(time.RFC3339))
+	return err
+}
+
+const deleteChargeSQL = `DELETE FROM payment_charges WHERE saga_id = ?`
+
+func (r *sqliteOrderRepository) DeleteCharge(ctx context.Context, sagaID string) (err error) {
+	ctx, span := r.startTableSpan(ctx, "payment_charges", "DELETE", deleteChargeSQL)
+	defer func() { endSpan(span, err) }()
+
+	_, err = r.db.ExecContext(ctx, deleteChargeSQL, sagaID)
+	return err
+}
+
+const selectChargeSQL = `SELECT amount FROM payment_charges WHERE saga_id = ?`
+
+func (r *sqliteOrderRepository) GetCharge(ctx context.Context, sagaID string) (amount float64, ok bool, err error) {
+	ctx, span := r.startTableSpan(ctx, "payment_charges", "SELECT", selectChargeSQL)
+	defer func() { endSpan(span, err) }()
+
+	err = r.db.QueryRowContext(ctx, selectChargeSQL, sagaID).Scan(&amount)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return amount, true, nil
+}
+
+func (r *sqliteOrderRepository) startIdempotencySpan(ctx context.Context, operation, statement string) (context.Context, trace.Span) {
+	return r.startTableSpan(ctx, "idempotency_keys", operation, statement)
+}
+
+const selectIdempotencyKeySQL = `SELECT fingerprint, status, body, expires_at FROM idempotency_keys WHERE idempotency_key = ?`
+
+const claimIdempotencyKeySQL = `INSERT INTO idempotency_keys (idempotency_key, fingerprint, status, body, expires_at) VALUES (?, ?, 0, NULL, ?)
+	ON CONFLICT (idempotency_key) DO UPDATE SET fingerprint = excluded.fingerprint, status = 0, body = NULL, expires_at = excluded.expires_at`
+
+func (r *sqliteOrderRepository) ClaimIdempotencyKey(ctx context.Context, rec IdempotencyRecord, now time.Time) (_ IdempotencyRecord, owner bool, err error) {
+	ctx, span := r.startIdempotencySpan(ctx, "INSERT", claimIdempotencyKeySQL)
+	defer func() { endSpan(span, err) }()
+
+	// 事务以 IMMEDIATE 开始，多个副本同时登记同一个键时只有一个能写入
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	defer tx.Rollback()
+
+	existing := IdempotencyRecord{Key: rec.Key}
+	var expiresAt string
+	err = tx.QueryRowContext(ctx, selectIdempotencyKeySQL, rec.Key).Scan(&existing.Fingerprint, &existing.Status, &existing.Body, &expiresAt)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return IdempotencyRecord{}, false, err
+	default:
+		if existing.ExpiresAt, err = time.Parse(outboxTimeFormat, expiresAt); err != nil {
+			return IdempotencyRecord{}, false, err
+		}
+		if existing.ExpiresAt.After(now) {
+			return existing, false, nil
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, claimIdempotencyKeySQL, rec.Key, rec.Fingerprint, rec.ExpiresAt.UTC().Format(outboxTimeFormat)); err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	rec.Status, rec.Body = 0, nil
+	return rec, true, nil
+}
+
+const saveIdempotencyResponseSQL = `UPDATE idempotency_keys SET status = ?, body = ?, expires_at = ? WHERE idempotency_key = ? AND status = 0`
+
+func (r *sqliteOrderRepository) SaveIdempotencyResponse(ctx context.Context, rec IdempotencyRecord) (err error) {
+	ctx, span := r.startIdempotencySpan(ctx, "UPDATE", saveIdempotencyResponseSQL)
+	defer func() { endSpan(span, err) }()
+
+	return saveIdempotencyResponse(ctx, r.db, rec)
+}
+
+func saveIdempotencyResponse(ctx context.Context, db execer, rec IdempotencyRecord) error {
+	_, err := db.ExecContext(ctx, saveIdempotencyResponseSQL, rec.Status, rec.Body, rec.ExpiresAt.UTC().Format(outboxTimeFormat), rec.Key)
+	return err
+}
+
+const deleteIdempotencyKeySQL = `DELETE FROM idempotency_keys WHERE idempotency_key = ? AND status = 0`
+
+func (r *sqliteOrderRepository) DeleteIdempotencyKey(ctx context.Context, key string) (err error) {
+	ctx, span := r.startIdempotencySpan(ctx, "DELETE", deleteIdempotencyKeySQL)
+	defer func() { endSpan(span, err) }()
+
+	_, err = r.db.ExecContext(ctx, deleteIdempotencyKeySQL, key)
+	return err
+}
+
+const deleteExpiredIdempotencyKeysSQL = `DELETE FROM idempotency_keys WHERE expires_at <= ?`
+
+func (r *sqliteOrderRepository) DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (n int, err error) {
+	ctx, span := r.startIdempotencySpan(ctx, "DELETE", deleteExpiredIdempotencyKeysSQL)
+	defer func() { endSpan(span, err) }()
+
+	res, err := r.db.ExecContext(ctx, deleteExpiredIdempotencyKeysSQL, now.UTC().Format(outboxTimeFormat))
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := res.RowsAffected()
+	return int(deleted), err
+}
+
+func (r *sqliteOrderRepository) Close() error {
+	return r.db.Close()
+}
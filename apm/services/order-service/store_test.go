@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 运行方式：go test -race ./...
+
+func newTestRepositories(t *testing.T) map[string]OrderRepository {
+	t.Helper()
+
+	sqliteRepo, err := newSQLiteOrderRepository(context.Background(), filepath.Join(t.TempDir(), "orders.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { sqliteRepo.Close() })
+
+	return map[string]OrderRepository{
+		"memory": newMemoryOrderRepository(seedOrders),
+		"sqlite": sqliteRepo,
+	}
+}
+
+func TestOrderRepositoryConcurrentAccess(t *testing.T) {
+	const workers = 20
+	const perWorker = 10
+
+	for name, repo := range newTestRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			var wg sync.WaitGroup
+			ids := make(chan int, workers*perWorker)
+
+			for w := 0; w < workers; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < perWorker; i++ {
+						order := Order{UserID: w, Product: fmt.Sprintf("p-%d-%d", w, i), Amount: 1, Status: OrderStatusCreated, CreateAt: "2024-01-01T00:00:00Z"}
+						if err := repo.Create(ctx, &order); err != nil {
+							t.Errorf("create: %v", err)
+							return
+						}
+						ids <- order.ID
+						// 读写交替进行，让竞态检测器覆盖读路径
+						if _, err := repo.List(ctx, OrderQuery{}); err != nil {
+							t.Errorf("list: %v", err)
+						}
+						if _, err := repo.Get(ctx, order.ID); err != nil {
+							t.Errorf("get %d: %v", order.ID, err)
+						}
+					}
+				}(w)
+			}
+			wg.Wait()
+			close(ids)
+
+			seen := make(map[int]bool)
+			for id := range ids {
+				if seen[id] {
+					t.Fatalf("duplicate order id %d", id)
+				}
+				seen[id] = true
+			}
+
+			page, err := repo.List(ctx, OrderQuery{})
+			if err != nil {
+				t.Fatalf("list: %v", err)
+			}
+			if want := len(seedOrders) + workers*perWorker; len(page.Orders) != want || page.Total != want {
+				t.Fatalf("got %d orders (total %d), want %d", len(page.Orders), page.Total, want)
+			}
+		})
+	}
+}
+
+func TestOrderRepositoryGetNotFound(t *testing.T) {
+	for name, repo := range newTestRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			if _, err := repo.Get(context.Background(), 9999); !errors.Is(err, errOrderNotFound) {
+				t.Fatalf("got %v, want errOrderNotFound", err)
+			}
+			order, err := repo.Get(context.Background(), 1)
+			if err != nil || order.Product != seedOrders[0].Product {
+				t.Fatalf("seed order: got %+v, %v", order, err)
+			}
+		})
+	}
+}
+
+func TestOrderRepositoryConcurrentStatusUpdate(t *testing.T) {
+	const workers = 10
+
+	for name, repo := range newTestRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			order := Order{UserID: 1, Product: "p", Amount: 1, Status: OrderStatusCreated, CreateAt: "2024-01-01T00:00:00Z"}
+			if err := repo.Create(ctx, &order); err != nil {
+				t.Fatalf("create: %v", err)
+			}
+
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			succeeded := 0
+			for w := 0; w < workers; w++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					err := repo.UpdateStatus(ctx, order.ID, OrderStatusCreated, OrderStatusPaid)
+					switch {
+					case err == nil:
+						mu.Lock()
+						succeeded++
+						mu.Unlock()
+					case !errors.Is(err, errStatusConflict):
+						t.Errorf("update: %v", err)
+					}
+				}()
+			}
+			wg.Wait()
+
+			if succeeded != 1 {
+				t.Fatalf("%d concurrent transitions succeeded, want 1", succeeded)
+			}
+			if err := repo.UpdateStatus(ctx, 9999, OrderStatusCreated, OrderStatusPaid); !errors.Is(err, errOrderNotFound) {
+				t.Fatalf("missing order: got %v, want errOrderNotFound", err)
+			}
+		})
+	}
+}
+
+func TestSQLiteMigrationsAreIdempotent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.db")
+	for i := 0; i < 2; i++ {
+		repo, err := newSQLiteOrderRepository(context.Background(), path)
+		if err != nil {
+			t.Fatalf("open #%d: %v", i, err)
+		}
+		page, err := repo.List(context.Background(), OrderQuery{})
+		repo.Close()
+		if err != nil {
+			t.Fatalf("list #%d: %v", i, err)
+		}
+		if len(page.Orders) != len(seedOrders) {
+			t.Fatalf("open #%d: got %d orders, want %d", i, len(page.Orders), len(seedOrders))
+		}
+	}
+}
+
+// TestCreateOrderHandlerConcurrent 并发调用创建订单接口，过去 nextOrderID 在这里存在数据竞争
+func TestCreateOrderHandlerConcurrent(t *testing.T) {
+	users := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":1,"name":"test"}`))
+	}))
+	defer users.Close()
+	t.Setenv("USER_SERVICE_URL", users.URL)
+
+	gin.SetMode(gin.TestMode)
+	orderRepo = newMemoryOrderRepository(seedOrders)
+	orderSaga = newOrderSaga(orderRepo, callUserService, newStockService(orderRepo, 100), newPaymentService(orderRepo, 1000))
+	r := gin.New()
+	r.POST("/orders", createOrder)
+
+	const requests = 20
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", "/orders", strings.NewReader(`{"user_id":1,"product":"p","amount":1}`))
+			req.Header.Set("Content-Type", "application/json")
+			r.ServeHTTP(w, req)
+			if w.Code != 201 {
+				t.Errorf("status %d: %s", w.Code, w.Body.String())
+			}
+		}()
+	}
+	wg.Wait()
+
+	page, _ := orderRepo.List(context.Background(), OrderQuery{})
+	if want := len(seedOrders) + requests; len(page.Orders) != want {
+		t.Fatalf("got %d orders, want %d", len(page.Orders), want)
+	}
+}
+
+func TestSQLiteReplicasShareOneDatabase(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "orders.db")
+	// 两个副本同时启动，迁移只执行一次
+	replicas := make([]*sqliteOrderRepository, 2)
+	var wg sync.WaitGroup
+	for i := range replicas {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			repo, err := newSQLiteOrderRepository(ctx, path)
+			if err != nil {
+				t.Errorf("replica %d: %v", i, err)
+				return
+			}
+			replicas[i] = repo
+		}(i)
+	}
+	wg.Wait()
+	for _, repo := range replicas {
+		if repo == nil {
+			t.FailNow()
+		}
+		t.Cleanup(func() { repo.Close() })
+	}
+
+	ids := make(chan int, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			order := Order{UserID: 1, Product: fmt.Sprintf("p-%d", i), Amount: 1, Status: OrderStatusCreated, CreateAt: "2024-01-01T00:00:00Z"}
+			if err := replicas[i%2].Create(ctx, &order); err != nil {
+				t.Errorf("create: %v", err)
+				return
+			}
+			ids <- order.ID
+		}(i)
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[int]bool)
+	for id := range ids {
+		if seen[id] {
+			t.Fatalf("duplicate order id %d", id)
+		}
+		seen[id] = true
+		// 任一副本创建的订单都能从另一个副本读到
+		for i, repo := range replicas {
+			if _, err := repo.Get(ctx, id); err != nil {
+				t.Errorf("replica %d get %d: %v", i, id, err)
+			}
+		}
+	}
+	page, err := replicas[1].List(ctx, OrderQuery{})
+	if err != nil || page.Total != len(seedOrders)+20 {
+		t.Fatalf("list: total %d, %v", page.Total, err)
+	}
+
+	// 两个副本同时领取 outbox 事件，每个事件只被领取一次
+	claimed := make(chan string, 40)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			events, err := replicas[i%2].ClaimEvents(ctx, time.Now().Add(time.Hour), outboxClaimLease, 5)
+			if err != nil {
+				t.Errorf("claim: %v", err)
+			}
+			for _, ev := range events {
+				claimed <- ev.ID
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(claimed)
+	events := make(map[string]bool)
+	for id := range claimed {
+		if events[id] {
+			t.Fatalf("event %s claimed twice", id)
+		}
+		events[id] = true
+	}
+	if len(events) != 20 {
+		t.Errorf("claimed %d events, want 20", len(events))
+	}
+}
+
+func TestOrderRepositoryClaims(t *testing.T) {
+	for name, repo := range newTestRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			now := time.Now()
+			for i := 0; i < 3; i++ {
+				order := Order{UserID: 1, Product: fmt.Sprintf("p-%d", i), Amount: 1, Status: OrderStatusCreated, CreateAt: "2024-01-01T00:00:00Z"}
+				if err := repo.Create(ctx, &order); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			events, err := repo.ClaimEvents(ctx, now.Add(time.Second), time.Minute, 2)
+			if err != nil || len(events) != 2 || events[0].CreatedAt.After(events[1].CreatedAt) {
+				t.Fatalf("first claim: %+v, %v", events, err)
+			}
+			if events, _ := repo.ClaimEvents(ctx, now.Add(time.Second), time.Minute, 10); len(events) != 1 {
+				t.Errorf("second claim got %d events, want the remaining 1", len(events))
+			}
+			// lease 到期后未投递的事件可以被重新领取
+			if events, _ := repo.ClaimEvents(ctx, now.Add(2*time.Minute), time.Minute, 10); len(events) != 3 {
+				t.Errorf("claim after lease got %d events, want 3", len(events))
+			}
+
+			stale := now.Add(-time.Hour).UTC().Format(time.RFC3339)
+			fresh := now.UTC().Format(time.RFC3339)
+			for _, s := range []Saga{
+				{ID: "stale", Status: SagaStatusRunning, CreatedAt: stale, UpdatedAt: stale},
+				{ID: "stale-compensating", Status: SagaStatusCompensating, CreatedAt: stale, UpdatedAt: stale},
+				{ID: "in-flight", Status: SagaStatusRunning, CreatedAt: fresh, UpdatedAt: fresh},
+				{ID: "done", Status: SagaStatusFailed, CreatedAt: stale, UpdatedAt: stale},
+			} {
+				if err := repo.SaveSaga(ctx, s); err != nil {
+					t.Fatal(err)
+				}
+			}
+			sagas, err := repo.ClaimSagas(ctx, now.Add(-sagaResumeAfter), now)
+			if err != nil || len(sagas) != 2 || sagas[0].ID != "stale" || sagas[1].ID != "stale-compensating" {
+				t.Fatalf("claim sagas: %+v, %v", sagas, err)
+			}
+			// 已领取的 saga 在 sagaResumeAfter 内不会被其他副本再次领取
+			if sagas, _ := repo.ClaimSagas(ctx, now.Add(-sagaResumeAfter), now); len(sagas) != 0 {
+				t.Errorf("claimed again: %+v", sagas)
+			}
+		})
+	}
+}
+
+func TestOrderRepositoryStandInState(t *testing.T) {
+	for name, repo := range newTestRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			for _, id := range []string{"s1", "s2"} {
+				if _, err := repo.ReserveStock(ctx, id, "book", 2); err != nil {
+					t.Fatalf("reserve %s: %v", id, err)
+				}
+			}
+			// 重复预留不占用新的库存
+			if left, err := repo.ReserveStock(ctx, "s1", "book", 2); err != nil || left != 0 {
+				t.Errorf("repeated reserve: %d, %v", left, err)
+			}
+			if _, err := repo.ReserveStock(ctx, "s3", "book", 2); !errors.Is(err, errOutOfStock) {
+				t.Errorf("got %v, want errOutOfStock", err)
+			}
+			if err := repo.ReleaseStock(ctx, "s1"); err != nil {
+				t.Fatal(err)
+			}
+			if n, err := repo.ReservedStock(ctx, "book"); err != nil || n != 1 {
+				t.Errorf("reserved %d, %v", n, err)
+			}
+
+			if err := repo.SaveCharge(ctx, "s1", 1, 10); err != nil {
+				t.Fatal(err)
+			}
+			if amount, ok, err := repo.GetCharge(ctx, "s1"); err != nil || !ok || amount != 10 {
+				t.Errorf("charge %v, %v, %v", amount, ok, err)
+			}
+			if err := repo.DeleteCharge(ctx, "s1"); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok, err := repo.GetCharge(ctx, "s1"); err != nil || ok {
+				t.Errorf("charge still present: %v", err)
+			}
+		})
+	}
+}
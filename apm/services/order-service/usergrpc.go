@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/xyzbit/devops-demo/pkg/deadline"
+	"github.com/xyzbit/devops-demo/pkg/requestid"
+	"order-service/userpb"
+)
+
+// 调用用户服务的传输方式，由 USER_SERVICE_TRANSPORT 选择
+const (
+	userTransportHTTP = "http"
+	userTransportGRPC = "grpc"
+)
+
+// requestIDMetadataKey gRPC 调用中携带请求 ID 的 metadata 键
+const requestIDMetadataKey = "x-request-id"
+
+var (
+	userServiceCallDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "user_service_call_duration_seconds",
+			Help:    "Duration of calls to user-service, by transport (http, grpc)",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"transport"},
+	)
+
+	// userGRPCClient 不为空时通过 gRPC 调用用户服务，在 main 中初始化
+	userGRPCClient userpb.UserServiceClient
+)
+
+func init() {
+	prometheus.MustRegister(userServiceCallDuration)
+}
+
+// dialUserServiceGRPC 按 USER_SERVICE_TRANSPORT 决定是否使用 gRPC。
+// 使用 HTTP 时返回 nil；gRPC 地址由 USER_SERVICE_GRPC_ADDR 配置，默认 localhost:9090
+func dialUserServiceGRPC() (*grpc.ClientConn, error) {
+	switch transport := os.Getenv("USER_SERVICE_TRANSPORT"); transport {
+	case "", userTransportHTTP:
+		return nil, nil
+	case userTransportGRPC:
+	default:
+		return nil, fmt.Errorf("unknown USER_SERVICE_TRANSPORT %q, want %s or %s", transport, userTransportHTTP, userTransportGRPC)
+	}
+
+	addr := os.Getenv("USER_SERVICE_GRPC_ADDR")
+	if addr == "" {
+		addr = "localhost:9090"
+	}
+	// 连接是惰性建立的，用户服务尚未启动时不会失败
+	return grpc.Dial(addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		// otelgrpc 创建客户端 span 并把追踪上下文写入 metadata
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+	)
+}
+
+func callUserServiceGRPC(ctx context.Context, span trace.Span, userID int) (map[string]interface{}, error) {
+	// 截止时间由 gRPC 自动传递，这里只需要补充请求 ID
+	if id, ok := requestid.FromContext(ctx); ok {
+		ctx = metadata.AppendToOutgoingContext(ctx, requestIDMetadataKey, id)
+	}
+
+	user, err := userGRPCClient.GetUser(ctx, &userpb.GetUserRequest{Id: int32(userID)})
+	span.SetAttributes(attribute.String("rpc.grpc.status_code", status.Code(err).String()))
+	if err != nil {
+		if ctx.Err() != nil {
+			deadline.RecordCancellation(span, err)
+		} else {
+			span.RecordError(err)
+		}
+		return nil, fmt.Errorf("user service returned %s: %w", status.Code(err), err)
+	}
+
+	// 与 HTTP 接口的 JSON 字段保持一致
+	return map[string]interface{}{
+		"id":        int(user.GetId()),
+		"name":      user.GetName(),
+		"email":     user.GetEmail(),
+		"age":       int(user.GetAge()),
+		"status":    user.GetStatus(),
+		"create_at": user.GetCreateAt(),
+	}, nil
+}
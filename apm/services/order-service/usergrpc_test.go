@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/xyzbit/devops-demo/pkg/requestid"
+	"order-service/userpb"
+)
+
+// fakeUserService 模拟 user-service 的 gRPC 接口，只认识用户 1
+type fakeUserService struct {
+	userpb.UnimplementedUserServiceServer
+	requestIDs chan string
+}
+
+func (f *fakeUserService) GetUser(ctx context.Context, req *userpb.GetUserRequest) (*userpb.User, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	f.requestIDs <- strings.Join(md.Get(requestIDMetadataKey), ",")
+	if req.GetId() != 1 {
+		return nil, status.Error(codes.NotFound, "User not found")
+	}
+	return &userpb.User{Id: 1, Name: "张三", Email: "zhangsan@example.com", Age: 25, Status: "active", CreateAt: "2023-01-01T00:00:00Z"}, nil
+}
+
+func TestCallUserServiceGRPC(t *testing.T) {
+	fake := &fakeUserService{requestIDs: make(chan string, 2)}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	userpb.RegisterUserServiceServer(s, fake)
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	userGRPCClient = userpb.NewUserServiceClient(conn)
+	defer func() { userGRPCClient = nil }()
+
+	ctx := requestid.WithContext(context.Background(), "req-order-1")
+	user, err := callUserService(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 返回值与 HTTP 接口解析出的字段一致
+	if user["id"] != 1 || user["email"] != "zhangsan@example.com" || user["create_at"] != "2023-01-01T00:00:00Z" {
+		t.Errorf("got %v", user)
+	}
+	if got := <-fake.requestIDs; got != "req-order-1" {
+		t.Errorf("request id %q, want req-order-1", got)
+	}
+
+	if _, err := callUserService(ctx, 2); status.Code(err) != codes.NotFound {
+		t.Errorf("missing user: got %v, want NotFound", err)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"container/list"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var cacheRequestsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cache_requests_total",
+		Help: "Total number of user cache lookups, by result (hit, miss)",
+	},
+	[]string{"result"},
+)
+
+func init() {
+	prometheus.MustRegister(cacheRequestsTotal)
+}
+
+type cacheEntry struct {
+	id      int
+	user    User
+	expires time.Time
+}
+
+// userCache 按用户 ID 缓存查询结果的 LRU 缓存，条目在 ttl 后过期。
+// 用户被修改或删除时调用 Invalidate 移除对应条目
+type userCache struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	entries  map[int]*list.Element
+	// order 最近使用的条目在前
+	order *list.List
+	// epoch 每次失效时递增；加载开始后发生过失效的结果不写入缓存，避免写回旧数据
+	epoch uint64
+}
+
+func newUserCache(capacity int, ttl time.Duration) *userCache {
+	return &userCache{capacity: capacity, ttl: ttl, entries: make(map[int]*list.Element), order: list.New()}
+}
+
+// newUserCacheFromEnv 由 USER_CACHE_SIZE（默认 1000）和 USER_CACHE_TTL（默认 30s）配置
+func newUserCacheFromEnv() *userCache {
+	capacity, ttl := 1000, 30*time.Second
+	if v := os.Getenv("USER_CACHE_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			log.Fatalf("Invalid USER_CACHE_SIZE %q", v)
+		}
+		capacity = n
+	}
+	if v := os.Getenv("USER_CACHE_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("Invalid USER_CACHE_TTL %q: %v", v, err)
+		}
+		ttl = d
+	}
+	return newUserCache(capacity, ttl)
+}
+
+// Get 返回未过期的缓存用户，并记录命中或未命中。未命中时同时返回当前 epoch，供 Set 使用
+func (c *userCache) Get(id int) (User, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[id]; ok {
+		e := el.Value.(*cacheEntry)
+		if time.Now().Before(e.expires) {
+			c.order.MoveToFront(el)
+			cacheRequestsTotal.WithLabelValues("hit").Inc()
+			return e.user, c.epoch, true
+		}
+		c.removeElement(el)
+	}
+	cacheRequestsTotal.WithLabelValues("miss").Inc()
+	return User{}, c.epoch, false
+}
+
+// Set 缓存从存储加载的用户。epoch 是加载前 Get 返回的值，期间发生过失效时放弃写入
+func (c *userCache) Set(id int, user User, epoch uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if epoch != c.epoch {
+		return
+	}
+	if el, ok := c.entries[id]; ok {
+		e := el.Value.(*cacheEntry)
+		e.user = user
+		e.expires = time.Now().Add(c.ttl)
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[id] = c.order.PushFront(&cacheEntry{id: id, user: user, expires: time.Now().Add(c.ttl)})
+	for c.order.Len() > c.capacity {
+		c.removeElement(c.order.Back())
+	}
+}
+
+// Invalidate 移除用户的缓存条目
+func (c *userCache) Invalidate(id int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.epoch++
+	if el, ok := c.entries[id]; ok {
+		c.removeElement(el)
+	}
+}
+
+// Len 当前缓存的条目数（含尚未清理的过期条目）
+func (c *userCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *userCache) removeElement(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*cacheEntry).id)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUserCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newUserCache(2, time.Minute)
+	for id := 1; id <= 2; id++ {
+		_, epoch, _ := c.Get(id)
+		c.Set(id, User{ID: id}, epoch)
+	}
+	// 访问 1 后 2 成为最久未使用的条目
+	if _, _, hit := c.Get(1); !hit {
+		t.Fatal("expected hit for 1")
+	}
+	_, epoch, _ := c.Get(3)
+	c.Set(3, User{ID: 3}, epoch)
+
+	if _, _, hit := c.Get(2); hit {
+		t.Error("2 should have been evicted")
+	}
+	for _, id := range []int{1, 3} {
+		if u, _, hit := c.Get(id); !hit || u.ID != id {
+			t.Errorf("%d: hit %v, user %+v", id, hit, u)
+		}
+	}
+	if c.Len() != 2 {
+		t.Errorf("len %d, want 2", c.Len())
+	}
+}
+
+func TestUserCacheExpires(t *testing.T) {
+	c := newUserCache(10, 20*time.Millisecond)
+	_, epoch, _ := c.Get(1)
+	c.Set(1, User{ID: 1}, epoch)
+	if _, _, hit := c.Get(1); !hit {
+		t.Fatal("expected hit before ttl")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, _, hit := c.Get(1); hit {
+		t.Fatal("expected miss after ttl")
+	}
+}
+
+func TestUserCacheInvalidate(t *testing.T) {
+	c := newUserCache(10, time.Minute)
+	_, epoch, _ := c.Get(1)
+	c.Set(1, User{ID: 1, Name: "old"}, epoch)
+
+	c.Invalidate(1)
+	if _, _, hit := c.Get(1); hit {
+		t.Fatal("expected miss after invalidate")
+	}
+}
+
+// TestUserCacheDropsStaleLoad 加载期间用户被修改时，加载到的旧数据不能写入缓存
+func TestUserCacheDropsStaleLoad(t *testing.T) {
+	c := newUserCache(10, time.Minute)
+	_, epoch, _ := c.Get(1)
+	stale := User{ID: 1, Name: "old"}
+
+	c.Invalidate(1)
+	c.Set(1, stale, epoch)
+
+	if _, _, hit := c.Get(1); hit {
+		t.Fatal("stale user was cached")
+	}
+}
+
+func TestUserCacheConcurrentAccess(t *testing.T) {
+	c := newUserCache(8, time.Minute)
+	var wg sync.WaitGroup
+	for w := 0; w < 10; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				id := (w + i) % 16
+				if _, epoch, hit := c.Get(id); !hit {
+					c.Set(id, User{ID: id}, epoch)
+				}
+				if i%10 == 0 {
+					c.Invalidate(id)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	if c.Len() > 8 {
+		t.Fatalf("len %d exceeds capacity", c.Len())
+	}
+}
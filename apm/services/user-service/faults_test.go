@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xyzbit/devops-demo/pkg/faults"
+)
+
+// useFaultRules 在测试期间替换全局的故障规则，测试结束后清空
+func useFaultRules(t *testing.T, rules ...faults.Rule) {
+	t.Helper()
+	if err := faultInjector.Replace(rules); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { faultInjector.Replace(nil) })
+}
+
+func TestSimulateWorkFaults(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	users = newUserStore(seedUsers)
+	usersCache = newUserCache(10, time.Minute)
+	useFaultRules(t, faults.Rule{Name: "insert-fails", Operation: "database_insert", ErrorPercent: 100, Status: 503})
+	r := gin.New()
+	r.POST("/users", createUser)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/users", strings.NewReader(`{"name":"n","email":"n@example.com","age":20}`)))
+	if w.Code != 503 || !strings.Contains(w.Body.String(), `"fault":"insert-fails"`) {
+		t.Errorf("create user: %d %s", w.Code, w.Body)
+	}
+	if n := len(users.List()); n != len(seedUsers) {
+		t.Errorf("%d users, the failed insert should not be stored", n)
+	}
+
+	// 注入的延迟遵守时间预算
+	if _, err := faultInjector.Put(faults.Rule{Name: "slow-query", Operation: "database_query", Delay: faults.Duration(time.Second)}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := loadUser(ctx, 1); err != context.DeadlineExceeded || time.Since(start) > 500*time.Millisecond {
+		t.Errorf("got %v after %v, want DeadlineExceeded", err, time.Since(start))
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/xyzbit/devops-demo/pkg/requestid"
+	"user-service/userpb"
+)
+
+// requestIDMetadataKey gRPC 调用中携带请求 ID 的 metadata 键
+const requestIDMetadataKey = "x-request-id"
+
+var (
+	grpcRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "grpc_requests_total",
+			Help: "Total number of gRPC requests",
+		},
+		[]string{"method", "code"},
+	)
+
+	grpcRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "grpc_request_duration_seconds",
+			Help:    "Duration of gRPC requests in seconds",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"method"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(grpcRequestsTotal)
+	prometheus.MustRegister(grpcRequestDuration)
+}
+
+// grpcAddr gRPC 监听地址，由 GRPC_PORT 配置，默认 9090
+func grpcAddr() string {
+	if port := os.Getenv("GRPC_PORT"); port != "" {
+		return ":" + port
+	}
+	return ":9090"
+}
+
+// newGRPCServer 创建注册了 UserService 的 gRPC 服务，与 HTTP 服务共用同一个存储和缓存
+func newGRPCServer() *grpc.Server {
+	s := grpc.NewServer(
+		// otelgrpc 创建服务端 span 并从 metadata 中提取追踪上下文
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(
+			grpcRequestIDInterceptor(),
+			grpcMetricsInterceptor(),
+			grpcLoggingInterceptor(),
+		),
+	)
+	userpb.RegisterUserServiceServer(s, &userGRPCServer{})
+	return s
+}
+
+// startGRPCServer 在 HTTP 服务之外监听 addr 并启动 gRPC 服务
+func startGRPCServer(addr string) (*grpc.Server, error) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := newGRPCServer()
+	go func() {
+		if err := s.Serve(lis); err != nil {
+			log.Printf("gRPC server stopped: %v", err)
+		}
+	}()
+	return s, nil
+}
+
+// grpcRequestIDInterceptor 与 HTTP 的 ginrequestid.Middleware 相同：沿用合法的请求 ID，
+// 缺失或不合法时生成新的，写入响应 header、请求上下文和当前 span
+func grpcRequestIDInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		var id string
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get(requestIDMetadataKey); len(values) > 0 {
+				id = values[0]
+			}
+		}
+		id = requestid.Resolve(id)
+
+		grpc.SetHeader(ctx, metadata.Pairs(requestIDMetadataKey, id))
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("request.id", id))
+		return handler(requestid.WithContext(ctx, id), req)
+	}
+}
+
+func grpcMetricsInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+
+		grpcRequestsTotal.WithLabelValues(info.FullMethod, status.Code(err).String()).Inc()
+		grpcRequestDuration.WithLabelValues(info.FullMethod).Observe(time.Since(start).Seconds())
+		return resp, err
+	}
+}
+
+// grpcLoggingInterceptor 输出与 HTTP 访问日志相同格式的 JSON 日志
+func grpcLoggingInterceptor() grpc.UnaryServerInterceptor {
+	out := getLogWriter()
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+
+		requestID, _ := requestid.FromContext(ctx)
+		fmt.Fprintf(out, `{"time":"%s","service":"user-service","method":"GRPC","uri":"%s","status":"%s","latency":"%s","trace_id":"%s","request_id":"%s"}`+"\n",
+			start.Format(time.RFC3339),
+			info.FullMethod,
+			status.Code(err),
+			time.Since(start),
+			trace.SpanContextFromContext(ctx).TraceID(),
+			requestID,
+		)
+		return resp, err
+	}
+}
+
+// userGRPCServer userpb.UserService 的实现
+type userGRPCServer struct {
+	userpb.UnimplementedUserServiceServer
+}
+
+func (s *userGRPCServer) GetUser(ctx context.Context, req *userpb.GetUserRequest) (*userpb.User, error) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(
+		attribute.Int("user.id", int(req.GetId())),
+		attribute.String("operation", "get_user"),
+	)
+
+	user, hit, err := lookupUser(ctx, int(req.GetId()))
+	span.SetAttributes(attribute.Bool("cache.hit", hit))
+	if err != nil {
+		return nil, grpcError(ctx, "get_user", err)
+	}
+
+	userOperations.WithLabelValues("get_user", "success").Inc()
+	span.SetAttributes(attribute.String("result", "success"))
+	return toProtoUser(user), nil
+}
+
+func (s *userGRPCServer) ListUsers(ctx context.Context, _ *userpb.ListUsersRequest) (*userpb.ListUsersResponse, error) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.String("operation", "get_all_users"))
+
+	userList, err := loadAllUsers(ctx)
+	if err != nil {
+		return nil, grpcError(ctx, "get_all_users", err)
+	}
+
+	resp := &userpb.ListUsersResponse{Total: int32(len(userList))}
+	for _, u := range userList {
+		resp.Users = append(resp.Users, toProtoUser(u))
+	}
+	userOperations.WithLabelValues("get_all_users", "success").Inc()
+	span.SetAttributes(
+		attribute.String("result", "success"),
+		attribute.Int("users.count", len(userList)),
+	)
+	return resp, nil
+}
+
+// grpcError 把错误转换为 gRPC 状态码，与 HTTP 接口的 404 / 504 对应
+func grpcError(ctx context.Context, operation string, err error) error {
+	span := trace.SpanFromContext(ctx)
+	switch {
+	case ctx.Err() != nil:
+		userOperations.WithLabelValues(operation, "cancelled").Inc()
+		return status.FromContextError(ctx.Err()).Err()
+	case errors.Is(err, errUserNotFound):
+		userOperations.WithLabelValues(operation, "not_found").Inc()
+		span.SetAttributes(attribute.String("result", "not_found"))
+		return status.Error(codes.NotFound, "User not found")
+	default:
+		userOperations.WithLabelValues(operation, "error").Inc()
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+func toProtoUser(u User) *userpb.User {
+	return &userpb.User{
+		Id:       int32(u.ID),
+		Name:     u.Name,
+		Email:    u.Email,
+		Age:      int32(u.Age),
+		Status:   u.Status,
+		CreateAt: u.CreateAt,
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/xyzbit/devops-demo/pkg/faults"
+	"github.com/xyzbit/devops-demo/pkg/requestid"
+	"user-service/userpb"
+)
+
+// newTestGRPCClient 在随机端口上启动 gRPC 服务并返回连接到它的客户端
+func newTestGRPCClient(t *testing.T) userpb.UserServiceClient {
+	t.Helper()
+	users = newUserStore(seedUsers)
+	usersCache = newUserCache(10, time.Minute)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newGRPCServer()
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return userpb.NewUserServiceClient(conn)
+}
+
+func TestGRPCGetUser(t *testing.T) {
+	client := newTestGRPCClient(t)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), requestIDMetadataKey, "req-grpc-1")
+	var header metadata.MD
+	user, err := client.GetUser(ctx, &userpb.GetUserRequest{Id: 1}, grpc.Header(&header))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.GetId() != 1 || user.GetEmail() != "zhangsan@example.com" || user.GetStatus() != UserStatusActive {
+		t.Errorf("got %v", user)
+	}
+	// 沿用调用方传入的请求 ID
+	if got := header.Get(requestIDMetadataKey); len(got) != 1 || got[0] != "req-grpc-1" {
+		t.Errorf("request id header %v, want req-grpc-1", got)
+	}
+	// 与 HTTP 接口共用缓存
+	if _, _, hit := usersCache.Get(1); !hit {
+		t.Error("user was not cached")
+	}
+
+	// 不合法的请求 ID 被替换，不会原样写入访问日志
+	for _, inbound := range []string{`x","level":"error`, "a b", strings.Repeat("a", 65)} {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), requestIDMetadataKey, inbound)
+		var header metadata.MD
+		if _, err := client.GetUser(ctx, &userpb.GetUserRequest{Id: 1}, grpc.Header(&header)); err != nil {
+			t.Fatal(err)
+		}
+		if got := header.Get(requestIDMetadataKey); len(got) != 1 || got[0] == inbound || !requestid.Pattern.MatchString(got[0]) {
+			t.Errorf("inbound %q: request id header %v, want a new id", inbound, got)
+		}
+	}
+
+	_, err = client.GetUser(context.Background(), &userpb.GetUserRequest{Id: 404})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("missing user: got %v, want NotFound", err)
+	}
+}
+
+func TestGRPCListUsers(t *testing.T) {
+	client := newTestGRPCClient(t)
+
+	resp, err := client.ListUsers(context.Background(), &userpb.ListUsersRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetTotal() != int32(len(seedUsers)) || len(resp.GetUsers()) != len(seedUsers) {
+		t.Fatalf("got %d users (total %d), want %d", len(resp.GetUsers()), resp.GetTotal(), len(seedUsers))
+	}
+	for i, u := range resp.GetUsers() {
+		if int(u.GetId()) != seedUsers[i].ID {
+			t.Errorf("user %d: id %d, want %d", i, u.GetId(), seedUsers[i].ID)
+		}
+	}
+}
+
+func TestGRPCGetUserDeadlineExceeded(t *testing.T) {
+	client := newTestGRPCClient(t)
+	useFaultRules(t, faults.Rule{Name: "slow-query", Operation: "database_query", Delay: faults.Duration(time.Second)})
+
+	// 注入的查询延迟比截止时间长
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := client.GetUser(ctx, &userpb.GetUserRequest{Id: 2})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("got %v, want DeadlineExceeded", err)
+	}
+}
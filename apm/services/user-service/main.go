@@ -2,10 +2,10 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"math/rand"
 	"os"
 	"strconv"
 	"time"
@@ -13,9 +13,16 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/xyzbit/devops-demo/pkg/deadline"
+	"github.com/xyzbit/devops-demo/pkg/deadline/gindeadline"
+	"github.com/xyzbit/devops-demo/pkg/faults"
+	"github.com/xyzbit/devops-demo/pkg/faults/ginfaults"
+	"github.com/xyzbit/devops-demo/pkg/identity/ginidentity"
+	"github.com/xyzbit/devops-demo/pkg/requestid/ginrequestid"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
 	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/resource"
@@ -61,33 +68,10 @@ var (
 		[]string{"operation", "status"},
 	)
 
-	// 模拟用户数据
-	users = map[int]User{
-		1: {
-			ID:       1,
-			Name:     "张三",
-			Email:    "zhangsan@example.com",
-			Age:      25,
-			Status:   "active",
-			CreateAt: "2023-01-01T00:00:00Z",
-		},
-		2: {
-			ID:       2,
-			Name:     "李四",
-			Email:    "lisi@example.com",
-			Age:      30,
-			Status:   "active",
-			CreateAt: "2023-01-02T00:00:00Z",
-		},
-		3: {
-			ID:       3,
-			Name:     "王五",
-			Email:    "wangwu@example.com",
-			Age:      28,
-			Status:   "inactive",
-			CreateAt: "2023-01-03T00:00:00Z",
-		},
-	}
+	// users 用户存储
+	users = newUserStore(seedUsers)
+	// usersCache 用户查询缓存，在 main 中初始化
+	usersCache *userCache
 )
 
 func init() {
@@ -159,8 +143,9 @@ func loggingMiddleware() gin.HandlerFunc {
 			// 获取 trace ID
 			spanCtx := trace.SpanContextFromContext(param.Request.Context())
 			traceID := spanCtx.TraceID().String()
+			requestID, _ := param.Keys[ginrequestid.Key].(string)
 
-			return fmt.Sprintf(`{"time":"%s","service":"user-service","method":"%s","uri":"%s","status":%d,"latency":"%s","user_agent":"%s","trace_id":"%s"}`+"\n",
+			return fmt.Sprintf(`{"time":"%s","service":"user-service","method":"%s","uri":"%s","status":%d,"latency":"%s","user_agent":"%s","trace_id":"%s","request_id":"%s"}`+"\n",
 				param.TimeStamp.Format(time.RFC3339),
 				param.Method,
 				param.Path,
@@ -168,6 +153,7 @@ func loggingMiddleware() gin.HandlerFunc {
 				param.Latency,
 				param.Request.UserAgent(),
 				traceID,
+				requestID,
 			)
 		},
 		Output: getLogWriter(),
@@ -186,20 +172,64 @@ func getLogWriter() io.Writer {
 	return io.MultiWriter(os.Stdout, logFile)
 }
 
-func simulateWork(ctx context.Context, operation string) {
+func simulateWork(ctx context.Context, operation string) error {
 	// 创建子 span
 	tracer := otel.Tracer("user-service")
-	_, span := tracer.Start(ctx, fmt.Sprintf("simulate-%s", operation))
+	ctx, span := tracer.Start(ctx, fmt.Sprintf("simulate-%s", operation))
 	defer span.End()
 
-	// 模拟一些工作时间
-	workTime := time.Duration(rand.Intn(500)+50) * time.Millisecond
-	time.Sleep(workTime)
-
+	// 耗时和错误由故障注入规则决定，默认使用 faults.json 中的基线规则
+	start := time.Now()
+	err := faultInjector.Inject(ctx, operation)
 	span.SetAttributes(
 		attribute.String("operation", operation),
-		attribute.Int64("work_duration_ms", workTime.Milliseconds()),
+		attribute.Int64("work_duration_ms", time.Since(start).Milliseconds()),
 	)
+
+	switch {
+	case err == nil:
+		return nil
+	case ctx.Err() != nil:
+		// 时间预算耗尽时立即中止
+		deadline.RecordCancellation(span, err)
+	default:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+// loadUser 从存储查询用户，包含模拟的数据库查询和数据处理耗时
+func loadUser(ctx context.Context, userID int) (User, error) {
+	// 模拟数据库查询
+	if err := simulateWork(ctx, "database_query"); err != nil {
+		return User{}, err
+	}
+
+	user, err := users.Get(userID)
+	if err != nil {
+		return User{}, err
+	}
+
+	// 模拟一些额外的处理
+	if err := simulateWork(ctx, "data_processing"); err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+// lookupUser 先查缓存，未命中时从存储加载并写入缓存。HTTP 和 gRPC 接口共用
+func lookupUser(ctx context.Context, userID int) (user User, hit bool, err error) {
+	user, epoch, hit := usersCache.Get(userID)
+	if hit {
+		return user, true, nil
+	}
+	user, err = loadUser(ctx, userID)
+	if err != nil {
+		return User{}, false, err
+	}
+	usersCache.Set(userID, user, epoch)
+	return user, false, nil
 }
 
 func getUserByID(c *gin.Context) {
@@ -218,20 +248,13 @@ func getUserByID(c *gin.Context) {
 		attribute.String("operation", "get_user"),
 	)
 
-	// 模拟数据库查询
-	simulateWork(c.Request.Context(), "database_query")
-
-	user, exists := users[userID]
-	if !exists {
-		userOperations.WithLabelValues("get_user", "not_found").Inc()
-		span.SetAttributes(attribute.String("result", "not_found"))
-		c.JSON(404, gin.H{"error": "User not found"})
+	user, hit, err := lookupUser(c.Request.Context(), userID)
+	span.SetAttributes(attribute.Bool("cache.hit", hit))
+	if err != nil {
+		respondUserError(c, "get_user", err)
 		return
 	}
 
-	// 模拟一些额外的处理
-	simulateWork(c.Request.Context(), "data_processing")
-
 	userOperations.WithLabelValues("get_user", "success").Inc()
 	span.SetAttributes(
 		attribute.String("result", "success"),
@@ -242,22 +265,33 @@ func getUserByID(c *gin.Context) {
 	c.JSON(200, user)
 }
 
+// loadAllUsers 查询所有用户，包含模拟的数据库查询和数据处理耗时
+func loadAllUsers(ctx context.Context) ([]User, error) {
+	// 模拟数据库查询
+	if err := simulateWork(ctx, "database_query_all"); err != nil {
+		return nil, err
+	}
+
+	userList := users.List()
+
+	// 模拟数据处理
+	if err := simulateWork(ctx, "data_processing"); err != nil {
+		return nil, err
+	}
+	return userList, nil
+}
+
 func getAllUsers(c *gin.Context) {
 	// 添加追踪信息
 	span := trace.SpanFromContext(c.Request.Context())
 	span.SetAttributes(attribute.String("operation", "get_all_users"))
 
-	// 模拟数据库查询
-	simulateWork(c.Request.Context(), "database_query_all")
-
-	var userList []User
-	for _, user := range users {
-		userList = append(userList, user)
+	userList, err := loadAllUsers(c.Request.Context())
+	if err != nil {
+		respondUserError(c, "get_all_users", err)
+		return
 	}
 
-	// 模拟数据处理
-	simulateWork(c.Request.Context(), "data_processing")
-
 	userOperations.WithLabelValues("get_all_users", "success").Inc()
 	span.SetAttributes(
 		attribute.String("result", "success"),
@@ -270,9 +304,97 @@ func getAllUsers(c *gin.Context) {
 	})
 }
 
+// userRequest POST 和 PUT 的请求体；status 为空时视为 active
+type userRequest struct {
+	Name   string `json:"name"`
+	Email  string `json:"email"`
+	Age    int    `json:"age"`
+	Status string `json:"status"`
+}
+
+func (r userRequest) apply(u *User) {
+	u.Name = r.Name
+	u.Email = r.Email
+	u.Age = r.Age
+	u.Status = r.Status
+	if u.Status == "" {
+		u.Status = UserStatusActive
+	}
+}
+
+// userPatch PATCH 的请求体，只修改出现的字段
+type userPatch struct {
+	Name   *string `json:"name"`
+	Email  *string `json:"email"`
+	Age    *int    `json:"age"`
+	Status *string `json:"status"`
+}
+
+func (p userPatch) apply(u *User) {
+	if p.Name != nil {
+		u.Name = *p.Name
+	}
+	if p.Email != nil {
+		u.Email = *p.Email
+	}
+	if p.Age != nil {
+		u.Age = *p.Age
+	}
+	if p.Status != nil {
+		u.Status = *p.Status
+	}
+}
+
+// parseUserID 解析路径中的用户 ID，失败时已写回 400
+func parseUserID(c *gin.Context, operation string) (int, bool) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		userOperations.WithLabelValues(operation, "error").Inc()
+		c.JSON(400, gin.H{"error": "Invalid user ID"})
+		return 0, false
+	}
+	trace.SpanFromContext(c.Request.Context()).SetAttributes(
+		attribute.Int("user.id", userID),
+		attribute.String("operation", operation),
+	)
+	return userID, true
+}
+
+// respondUserError 把存储错误转换为响应：字段不合法 400，用户不存在 404，邮箱冲突 409，
+// 超出时间预算 504，注入的故障使用规则中的状态码
+func respondUserError(c *gin.Context, operation string, err error) {
+	span := trace.SpanFromContext(c.Request.Context())
+	var invalid *validationError
+	var fault *faults.Error
+	switch {
+	case c.Request.Context().Err() != nil:
+		userOperations.WithLabelValues(operation, "cancelled").Inc()
+		c.JSON(504, gin.H{"error": err.Error()})
+	case errors.As(err, &fault):
+		userOperations.WithLabelValues(operation, "error").Inc()
+		span.SetAttributes(attribute.String("result", "fault"))
+		c.JSON(fault.Status, gin.H{"error": err.Error(), "fault": fault.Rule})
+	case errors.As(err, &invalid):
+		userOperations.WithLabelValues(operation, "error").Inc()
+		span.SetAttributes(attribute.String("result", "invalid"))
+		c.JSON(400, gin.H{"error": err.Error(), "field": invalid.field})
+	case errors.Is(err, errUserNotFound):
+		userOperations.WithLabelValues(operation, "not_found").Inc()
+		span.SetAttributes(attribute.String("result", "not_found"))
+		c.JSON(404, gin.H{"error": "User not found"})
+	case errors.Is(err, errEmailTaken):
+		userOperations.WithLabelValues(operation, "conflict").Inc()
+		span.SetAttributes(attribute.String("result", "conflict"))
+		c.JSON(409, gin.H{"error": err.Error(), "field": "email"})
+	default:
+		userOperations.WithLabelValues(operation, "error").Inc()
+		c.JSON(500, gin.H{"error": err.Error()})
+	}
+}
+
 func createUser(c *gin.Context) {
-	var newUser User
-	if err := c.ShouldBindJSON(&newUser); err != nil {
+	var req userRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		userOperations.WithLabelValues("create_user", "error").Inc()
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
@@ -282,19 +404,22 @@ func createUser(c *gin.Context) {
 	span := trace.SpanFromContext(c.Request.Context())
 	span.SetAttributes(
 		attribute.String("operation", "create_user"),
-		attribute.String("user.name", newUser.Name),
-		attribute.String("user.email", newUser.Email),
+		attribute.String("user.name", req.Name),
+		attribute.String("user.email", req.Email),
 	)
 
-	// 生成新的用户 ID
-	newUser.ID = len(users) + 1
-	newUser.CreateAt = time.Now().Format(time.RFC3339)
-	newUser.Status = "active"
-
 	// 模拟数据库写入
-	simulateWork(c.Request.Context(), "database_insert")
+	if err := simulateWork(c.Request.Context(), "database_insert"); err != nil {
+		respondUserError(c, "create_user", err)
+		return
+	}
 
-	users[newUser.ID] = newUser
+	var newUser User
+	req.apply(&newUser)
+	if err := users.Create(&newUser); err != nil {
+		respondUserError(c, "create_user", err)
+		return
+	}
 
 	userOperations.WithLabelValues("create_user", "success").Inc()
 	span.SetAttributes(
@@ -305,6 +430,79 @@ func createUser(c *gin.Context) {
 	c.JSON(201, newUser)
 }
 
+// replaceUser PUT /users/:id，替换用户的全部可写字段
+func replaceUser(c *gin.Context) {
+	userID, ok := parseUserID(c, "replace_user")
+	if !ok {
+		return
+	}
+	var req userRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		userOperations.WithLabelValues("replace_user", "error").Inc()
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	updateUser(c, "replace_user", userID, req.apply)
+}
+
+// patchUser PATCH /users/:id，只修改请求中出现的字段
+func patchUser(c *gin.Context) {
+	userID, ok := parseUserID(c, "patch_user")
+	if !ok {
+		return
+	}
+	var patch userPatch
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		userOperations.WithLabelValues("patch_user", "error").Inc()
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	updateUser(c, "patch_user", userID, patch.apply)
+}
+
+func updateUser(c *gin.Context, operation string, userID int, apply func(u *User)) {
+	// 模拟数据库写入
+	if err := simulateWork(c.Request.Context(), "database_update"); err != nil {
+		respondUserError(c, operation, err)
+		return
+	}
+
+	user, err := users.Update(userID, apply)
+	if err != nil {
+		respondUserError(c, operation, err)
+		return
+	}
+	usersCache.Invalidate(userID)
+
+	userOperations.WithLabelValues(operation, "success").Inc()
+	trace.SpanFromContext(c.Request.Context()).SetAttributes(attribute.String("result", "success"))
+	c.JSON(200, user)
+}
+
+// deleteUser DELETE /users/:id，成功时返回 204
+func deleteUser(c *gin.Context) {
+	userID, ok := parseUserID(c, "delete_user")
+	if !ok {
+		return
+	}
+
+	// 模拟数据库写入
+	if err := simulateWork(c.Request.Context(), "database_delete"); err != nil {
+		respondUserError(c, "delete_user", err)
+		return
+	}
+
+	if err := users.Delete(userID); err != nil {
+		respondUserError(c, "delete_user", err)
+		return
+	}
+	usersCache.Invalidate(userID)
+
+	userOperations.WithLabelValues("delete_user", "success").Inc()
+	trace.SpanFromContext(c.Request.Context()).SetAttributes(attribute.String("result", "success"))
+	c.Status(204)
+}
+
 func main() {
 	// 初始化追踪
 	tp, err := initTracer()
@@ -317,14 +515,35 @@ func main() {
 		}
 	}()
 
+	usersCache = newUserCacheFromEnv()
+
+	faultInjector, err = faults.NewFromEnv(defaultFaults)
+	if err != nil {
+		log.Fatalf("Failed to load fault rules: %v", err)
+	}
+	go faultInjector.Watch(context.Background(), 5*time.Second)
+
+	// gRPC 接口与 HTTP 接口共用同一个存储
+	grpcServer, err := startGRPCServer(grpcAddr())
+	if err != nil {
+		log.Fatalf("Failed to start gRPC server: %v", err)
+	}
+	defer grpcServer.GracefulStop()
+	log.Printf("User Service gRPC listening on %s...", grpcAddr())
+
 	// 创建 Gin 路由
 	r := gin.New()
 
 	// 添加中间件 - 顺序很重要！
 	r.Use(gin.Recovery())
 	r.Use(otelgin.Middleware("user-service")) // 必须在 loggingMiddleware 之前
+	r.Use(ginrequestid.Middleware())
+	r.Use(gindeadline.Middleware())
 	r.Use(prometheusMiddleware())
 	r.Use(loggingMiddleware())
+	// 只信任网关签名的身份，客户端自带的 X-User-ID 会被丢弃
+	r.Use(ginidentity.Middleware([]byte(os.Getenv("IDENTITY_SIGNING_KEY"))))
+	r.Use(ginfaults.Middleware(faultInjector))
 
 	// 健康检查
 	r.GET("/health", func(c *gin.Context) {
@@ -338,6 +557,12 @@ func main() {
 	r.GET("/users/:id", getUserByID)
 	r.GET("/users", getAllUsers)
 	r.POST("/users", createUser)
+	r.PUT("/users/:id", replaceUser)
+	r.PATCH("/users/:id", patchUser)
+	r.DELETE("/users/:id", deleteUser)
+
+	// 故障注入管理接口，需要 FAULTS_ADMIN_TOKEN
+	ginfaults.Register(r, faultInjector)
 
 	log.Println("User Service starting on port 8080...")
 	if err := r.Run(":8080"); err != nil {
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTestRouter 使用种子数据和空缓存注册用户接口
+func newTestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	users = newUserStore(seedUsers)
+	usersCache = newUserCache(10, time.Minute)
+	useFaultRules(t)
+
+	r := gin.New()
+	r.GET("/users/:id", getUserByID)
+	r.PUT("/users/:id", replaceUser)
+	r.PATCH("/users/:id", patchUser)
+	r.DELETE("/users/:id", deleteUser)
+	return r
+}
+
+func serve(r *gin.Engine, method, path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestUpdateAndDeleteUserErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+		// field 响应中标出的字段，为空时不检查
+		field string
+	}{
+		{"put invalid id", "PUT", "/users/abc", `{"name":"n","email":"n@example.com"}`, 400, ""},
+		{"put malformed body", "PUT", "/users/1", `{"name":`, 400, ""},
+		{"put empty name", "PUT", "/users/1", `{"name":"","email":"n@example.com","age":20}`, 400, "name"},
+		{"put invalid email", "PUT", "/users/1", `{"name":"n","email":"not-an-email","age":20}`, 400, "email"},
+		{"put invalid status", "PUT", "/users/1", `{"name":"n","email":"n@example.com","status":"banned"}`, 400, "status"},
+		{"put missing user", "PUT", "/users/99", `{"name":"n","email":"n@example.com","age":20}`, 404, ""},
+		{"put duplicate email", "PUT", "/users/1", `{"name":"n","email":"LiSi@example.com","age":20}`, 409, "email"},
+		{"patch invalid id", "PATCH", "/users/abc", `{"age":20}`, 400, ""},
+		{"patch invalid age", "PATCH", "/users/1", `{"age":200}`, 400, "age"},
+		{"patch wrong type", "PATCH", "/users/1", `{"age":"old"}`, 400, ""},
+		{"patch missing user", "PATCH", "/users/99", `{"age":20}`, 404, ""},
+		{"patch duplicate email", "PATCH", "/users/2", `{"email":"zhangsan@example.com"}`, 409, "email"},
+		{"delete invalid id", "DELETE", "/users/abc", "", 400, ""},
+		{"delete missing user", "DELETE", "/users/99", "", 404, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRouter(t)
+			w := serve(r, tt.method, tt.path, tt.body)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			var resp map[string]interface{}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp["error"] == nil {
+				t.Fatalf("response %s: %v", w.Body, err)
+			}
+			if tt.field != "" && resp["field"] != tt.field {
+				t.Errorf("field %v, want %s", resp["field"], tt.field)
+			}
+			// 失败的修改不影响存储的数据
+			for _, seed := range seedUsers {
+				if u, err := users.Get(seed.ID); err != nil || u != seed {
+					t.Errorf("user %d changed to %+v: %v", seed.ID, u, err)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateAndDeleteInvalidateCache(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		status int
+		// wantGet 修改后 GET 的状态码和用户名
+		wantGet  int
+		wantName string
+	}{
+		{"put", "PUT", `{"name":"张三丰","email":"zhangsan@example.com","age":25}`, 200, 200, "张三丰"},
+		{"patch", "PATCH", `{"name":"张三丰"}`, 200, 200, "张三丰"},
+		{"delete", "DELETE", "", 204, 404, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRouter(t)
+			// 先读一次，让用户进入缓存
+			if w := serve(r, "GET", "/users/1", ""); w.Code != 200 {
+				t.Fatalf("get: %d %s", w.Code, w.Body)
+			}
+			if usersCache.Len() != 1 {
+				t.Fatalf("cache has %d entries, want 1", usersCache.Len())
+			}
+
+			if w := serve(r, tt.method, "/users/1", tt.body); w.Code != tt.status {
+				t.Fatalf("%s: %d %s", tt.method, w.Code, w.Body)
+			}
+			if usersCache.Len() != 0 {
+				t.Errorf("cache entry was not invalidated")
+			}
+
+			w := serve(r, "GET", "/users/1", "")
+			if w.Code != tt.wantGet {
+				t.Fatalf("get after %s: %d %s", tt.method, w.Code, w.Body)
+			}
+			if tt.wantName != "" {
+				var u User
+				if err := json.Unmarshal(w.Body.Bytes(), &u); err != nil || u.Name != tt.wantName {
+					t.Errorf("got %+v, want name %s: %v", u, tt.wantName, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// errUserNotFound 用户不存在
+	errUserNotFound = errors.New("user not found")
+	// errEmailTaken 邮箱已被其他用户使用
+	errEmailTaken = errors.New("email already in use")
+)
+
+// 用户状态
+const (
+	UserStatusActive   = "active"
+	UserStatusInactive = "inactive"
+)
+
+// validationError 请求字段不合法，返回 400
+type validationError struct {
+	field  string
+	reason string
+}
+
+func (e *validationError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.field, e.reason)
+}
+
+// seedUsers 启动时预置的用户
+var seedUsers = []User{
+	{ID: 1, Name: "张三", Email: "zhangsan@example.com", Age: 25, Status: UserStatusActive, CreateAt: "2023-01-01T00:00:00Z"},
+	{ID: 2, Name: "李四", Email: "lisi@example.com", Age: 30, Status: UserStatusActive, CreateAt: "2023-01-02T00:00:00Z"},
+	{ID: 3, Name: "王五", Email: "wangwu@example.com", Age: 28, Status: UserStatusInactive, CreateAt: "2023-01-03T00:00:00Z"},
+}
+
+// userStore 进程内的用户存储，可被多个请求并发调用。
+// ID 单调递增，删除用户后不会复用其 ID
+type userStore struct {
+	mu    sync.RWMutex
+	users map[int]User
+	// byEmail 规范化后的邮箱 -> 用户 ID，用于唯一性检查
+	byEmail map[string]int
+	nextID  int
+}
+
+func newUserStore(seed []User) *userStore {
+	s := &userStore{users: make(map[int]User), byEmail: make(map[string]int), nextID: 1}
+	for _, u := range seed {
+		s.users[u.ID] = u
+		s.byEmail[normalizeEmail(u.Email)] = u.ID
+		if u.ID >= s.nextID {
+			s.nextID = u.ID + 1
+		}
+	}
+	return s
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// validateUser 检查可写字段
+func validateUser(u User) error {
+	if strings.TrimSpace(u.Name) == "" {
+		return &validationError{field: "name", reason: "must not be empty"}
+	}
+	addr, err := mail.ParseAddress(u.Email)
+	if err != nil || addr.Address != u.Email || !strings.Contains(u.Email[strings.LastIndex(u.Email, "@")+1:], ".") {
+		return &validationError{field: "email", reason: fmt.Sprintf("%q is not a valid email address", u.Email)}
+	}
+	if u.Age < 0 || u.Age > 150 {
+		return &validationError{field: "age", reason: "must be between 0 and 150"}
+	}
+	if u.Status != UserStatusActive && u.Status != UserStatusInactive {
+		return &validationError{field: "status", reason: fmt.Sprintf("must be %q or %q", UserStatusActive, UserStatusInactive)}
+	}
+	return nil
+}
+
+func (s *userStore) Get(id int) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return User{}, errUserNotFound
+	}
+	return u, nil
+}
+
+// List 按 ID 顺序返回所有用户
+func (s *userStore) List() []User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]User, 0, len(s.users))
+	for _, u := range s.users {
+		list = append(list, u)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Create 校验并保存新用户，回填 ID 和创建时间
+func (s *userStore) Create(u *User) error {
+	if err := validateUser(*u); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := normalizeEmail(u.Email)
+	if _, taken := s.byEmail[key]; taken {
+		return errEmailTaken
+	}
+	u.ID = s.nextID
+	u.CreateAt = time.Now().UTC().Format(time.RFC3339)
+	s.nextID++
+	s.users[u.ID] = *u
+	s.byEmail[key] = u.ID
+	return nil
+}
+
+// Update 用 apply 修改用户的可写字段，校验通过后保存，ID 和创建时间不可修改
+func (s *userStore) Update(id int, apply func(u *User)) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.users[id]
+	if !ok {
+		return User{}, errUserNotFound
+	}
+	updated := current
+	apply(&updated)
+	updated.ID = current.ID
+	updated.CreateAt = current.CreateAt
+	if err := validateUser(updated); err != nil {
+		return User{}, err
+	}
+
+	oldKey, newKey := normalizeEmail(current.Email), normalizeEmail(updated.Email)
+	if owner, taken := s.byEmail[newKey]; taken && owner != id {
+		return User{}, errEmailTaken
+	}
+	delete(s.byEmail, oldKey)
+	s.byEmail[newKey] = id
+	s.users[id] = updated
+	return updated, nil
+}
+
+// Delete 删除用户，其邮箱可以被新用户使用
+func (s *userStore) Delete(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return errUserNotFound
+	}
+	delete(s.users, id)
+	delete(s.byEmail, normalizeEmail(u.Email))
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// 运行方式：go test -race ./...
+
+func TestUserStoreConcurrentCreateAndDelete(t *testing.T) {
+	s := newUserStore(seedUsers)
+	const workers = 20
+
+	var wg sync.WaitGroup
+	ids := make(chan int, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			u := User{Name: "u", Email: fmt.Sprintf("u%d@example.com", w), Status: UserStatusActive}
+			if err := s.Create(&u); err != nil {
+				t.Errorf("create: %v", err)
+				return
+			}
+			ids <- u.ID
+			s.List()
+			// 一半的用户创建后立即删除，删除不能影响后续分配的 ID
+			if w%2 == 0 {
+				if err := s.Delete(u.ID); err != nil {
+					t.Errorf("delete %d: %v", u.ID, err)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[int]bool)
+	for id := range ids {
+		if seen[id] {
+			t.Fatalf("duplicate user id %d", id)
+		}
+		seen[id] = true
+	}
+	if got, want := len(s.List()), len(seedUsers)+workers/2; got != want {
+		t.Fatalf("got %d users, want %d", got, want)
+	}
+}
+
+func TestUserStoreDoesNotReuseDeletedIDs(t *testing.T) {
+	s := newUserStore(seedUsers)
+	if err := s.Delete(3); err != nil {
+		t.Fatal(err)
+	}
+	u := User{Name: "new", Email: "new@example.com", Status: UserStatusActive}
+	if err := s.Create(&u); err != nil {
+		t.Fatal(err)
+	}
+	if u.ID != 4 {
+		t.Fatalf("got id %d, want 4", u.ID)
+	}
+	if _, err := s.Get(3); !errors.Is(err, errUserNotFound) {
+		t.Fatalf("deleted user: got %v", err)
+	}
+}
+
+func TestUserStoreEmailUniqueness(t *testing.T) {
+	s := newUserStore(seedUsers)
+
+	dup := User{Name: "dup", Email: "ZhangSan@example.com", Status: UserStatusActive}
+	if err := s.Create(&dup); !errors.Is(err, errEmailTaken) {
+		t.Fatalf("create with taken email: got %v", err)
+	}
+	if _, err := s.Update(2, func(u *User) { u.Email = "zhangsan@example.com" }); !errors.Is(err, errEmailTaken) {
+		t.Fatalf("update to taken email: got %v", err)
+	}
+	// 保留自己的邮箱不算冲突，改掉后旧邮箱可以被别人使用
+	if _, err := s.Update(1, func(u *User) { u.Age = 26 }); err != nil {
+		t.Fatalf("update keeping email: %v", err)
+	}
+	if _, err := s.Update(1, func(u *User) { u.Email = "zs@example.com" }); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Create(&dup); err != nil {
+		t.Fatalf("create with released email: %v", err)
+	}
+}
+
+func TestValidateUser(t *testing.T) {
+	valid := User{Name: "a", Email: "a@example.com", Age: 20, Status: UserStatusActive}
+	if err := validateUser(valid); err != nil {
+		t.Fatalf("valid user: %v", err)
+	}
+
+	cases := map[string]func(u *User){
+		"name":      func(u *User) { u.Name = " " },
+		"email":     func(u *User) { u.Email = "not-an-email" },
+		"email_tld": func(u *User) { u.Email = "a@localhost" },
+		"email_fmt": func(u *User) { u.Email = "A <a@example.com>" },
+		"age":       func(u *User) { u.Age = -1 },
+		"status":    func(u *User) { u.Status = "deleted" },
+	}
+	for name, mutate := range cases {
+		u := valid
+		mutate(&u)
+		var invalid *validationError
+		if err := validateUser(u); !errors.As(err, &invalid) {
+			t.Errorf("%s: got %v, want validation error", name, err)
+		}
+	}
+}
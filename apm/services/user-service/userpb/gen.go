@@ -0,0 +1,6 @@
+// Package userpb 由 user.proto 生成的消息和 gRPC 桩代码。
+// order-service/userpb 是同一份 proto 生成的副本，修改 user.proto 后两边一起重新生成
+package userpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative user.proto
+//go:generate protoc --go_out=../../order-service/userpb --go_opt=paths=source_relative,Muser.proto=order-service/userpb --go-grpc_out=../../order-service/userpb --go-grpc_opt=paths=source_relative,Muser.proto=order-service/userpb user.proto
@@ -11,6 +11,8 @@ import (
 	"os"
 	"strings"
 	"time"
+
+	"github.com/xyzbit/devops-demo/pkg/faults"
 	// "go.opentelemetry.io/otel/trace" // 假设如果用了OTel，会这样获取traceID
 )
 
@@ -34,8 +36,17 @@ func main() {
 	InitLogger()
 	slog.Info("Application starting...")
 
+	faultInjector, err := faults.NewFromEnv(defaultFaults)
+	if err != nil {
+		slog.Error("Failed to load fault rules.", slog.Any("error", err))
+		os.Exit(1)
+	}
+	go faultInjector.Watch(context.Background(), 5*time.Second)
+
 	// --- HTTP服务器逻辑 ---
-	http.HandleFunc("/hello", func(w http.ResponseWriter, r *http.Request) {
+	mux := http.NewServeMux()
+	faultInjector.Register(mux)
+	mux.HandleFunc("/hello", func(w http.ResponseWriter, r *http.Request) {
 		// 为每个请求创建上下文相关的logger
 		// 在实际应用中，trace_id和request_id会由中间件或上游服务注入到context或请求头
 		ctx := r.Context()             // 假设context中已包含追踪信息
@@ -54,18 +65,9 @@ func main() {
 
 		reqLogger.Info("Received request for /hello.")
 
-		// 模拟业务处理
-		processingTime := time.Duration(rand.Intn(100)+20) * time.Millisecond
-		time.Sleep(processingTime)
-
-		if rand.Intn(10) < 2 { // 20%概率出错
-			reqLogger.Error("Failed to process /hello request.",
-				slog.String("error", "simulated internal error processing hello request"),
-				slog.Duration("processing_time_ms", processingTime),
-			)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
-		}
+		// 处理耗时和错误率由故障注入规则决定，默认使用 faults.json 中的基线规则，
+		// 注入的错误由中间件返回并记录日志
+		processingTime := faults.Delay(ctx)
 
 		reqLogger.Info("Successfully processed /hello request.",
 			slog.Duration("processing_time_ms", processingTime),
@@ -76,7 +78,7 @@ func main() {
 
 	port := "8088" // Go应用监听的端口
 	slog.Info("HTTP server listening on port.", slog.String("port", port))
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
+	if err := http.ListenAndServe(":"+port, faultInjector.Middleware(mux)); err != nil {
 		slog.Error("Failed to start HTTP server.", slog.Any("error", err))
 		os.Exit(1)
 	}
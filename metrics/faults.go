@@ -0,0 +1,9 @@
+package main
+
+import _ "embed"
+
+// 故障注入的规则格式、/admin/faults 管理接口和鉴权见 pkg/faults，
+// 未设置 FAULTS_CONFIG 时使用内置的 faults.json 中的基线规则
+
+//go:embed faults.json
+var defaultFaults []byte
@@ -1,15 +1,16 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
-	"math/rand"
 	"net/http" // For prometheus.NewGoCollector example
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/xyzbit/devops-demo/pkg/faults"
 )
 
 var (
@@ -32,35 +33,63 @@ var (
 )
 
 func handleHello(w http.ResponseWriter, r *http.Request) {
-	startTime := time.Now()
-	time.Sleep(time.Duration(rand.Intn(500)+50) * time.Millisecond)
-	statusCode := http.StatusOK
-	if rand.Intn(10) == 0 {
-		statusCode = http.StatusInternalServerError
-		w.WriteHeader(statusCode)
-		fmt.Fprintf(w, "Oops! Something went wrong.")
-	} else {
-		w.WriteHeader(statusCode)
-		fmt.Fprintf(w, "Hello from Go Metrics App!")
-	}
-	duration := time.Since(startTime).Seconds()
+	// 处理耗时和错误率由故障注入规则决定，默认使用 faults.json 中的基线规则
+	fmt.Fprintf(w, "Hello from Go Metrics App!")
+}
 
-	httpRequestsTotal.With(prometheus.Labels{
-		"method":      r.Method,
-		"path":        r.URL.Path,
-		"status_code": fmt.Sprintf("%d", statusCode),
-	}).Inc()
+// statusRecorder 记录处理函数写出的状态码
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
 
-	httpRequestDurationSeconds.With(prometheus.Labels{
-		"method": r.Method,
-		"path":   r.URL.Path,
-	}).Observe(duration)
+// instrument 记录请求数和耗时，包括故障注入返回的错误和 panic
+func instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		startTime := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			p := recover()
+			if p != nil {
+				rec.status = http.StatusInternalServerError
+			}
+			duration := time.Since(startTime).Seconds()
 
-	log.Printf("%s %s - %d, duration: %.3fs", r.Method, r.URL.Path, statusCode, duration)
+			httpRequestsTotal.With(prometheus.Labels{
+				"method":      r.Method,
+				"path":        r.URL.Path,
+				"status_code": fmt.Sprintf("%d", rec.status),
+			}).Inc()
+
+			httpRequestDurationSeconds.With(prometheus.Labels{
+				"method": r.Method,
+				"path":   r.URL.Path,
+			}).Observe(duration)
+
+			log.Printf("%s %s - %d, duration: %.3fs", r.Method, r.URL.Path, rec.status, duration)
+			if p != nil {
+				panic(p)
+			}
+		}()
+		next.ServeHTTP(rec, r)
+	})
 }
 
 func main() {
-	http.HandleFunc("/hello", handleHello)
+	faultInjector, err := faults.NewFromEnv(defaultFaults)
+	if err != nil {
+		log.Fatalf("Failed to load fault rules: %v", err)
+	}
+	go faultInjector.Watch(context.Background(), 5*time.Second)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hello", handleHello)
+	faultInjector.Register(mux)
 
 	metricsMux := http.NewServeMux()
 	metricsMux.Handle("/metrics", promhttp.Handler())
@@ -73,7 +102,7 @@ func main() {
 	}()
 
 	log.Println("Application server listening on :8080")
-	if err := http.ListenAndServe(":8080", nil); err != nil {
+	if err := http.ListenAndServe(":8080", instrument(faultInjector.Middleware(mux))); err != nil {
 		log.Fatalf("Failed to start application server: %v", err)
 	}
 }
@@ -0,0 +1,58 @@
+// Package deadline 在服务之间传递请求的剩余时间预算。
+//
+// 调用方把 ctx 的剩余时间（毫秒）写入 X-Request-Budget-Ms，被调用方据此设置请求上下文的截止时间，
+// 上游已经放弃的请求在下游也会尽快终止。gin 服务通过 deadline/gindeadline 接入。
+package deadline
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Header 调用方传递的剩余时间预算（毫秒）
+const Header = "X-Request-Budget-Ms"
+
+// FromRequest 返回 r 携带的剩余时间预算，没有或不合法时第二个返回值为 false
+func FromRequest(r *http.Request) (time.Duration, bool) {
+	budget, err := strconv.ParseInt(r.Header.Get(Header), 10, 64)
+	if err != nil || budget <= 0 {
+		return 0, false
+	}
+	return time.Duration(budget) * time.Millisecond, true
+}
+
+// WithBudget 按 r 携带的预算为 ctx 设置截止时间，并在 ctx 的 span 上记录 deadline.budget_ms。
+// 没有预算时原样返回 ctx，调用方都需要调用返回的 cancel
+func WithBudget(ctx context.Context, r *http.Request) (context.Context, context.CancelFunc) {
+	budget, ok := FromRequest(r)
+	if !ok {
+		return ctx, func() {}
+	}
+	ctx, cancel := context.WithTimeout(ctx, budget)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int64("deadline.budget_ms", budget.Milliseconds()))
+	return ctx, cancel
+}
+
+// Inject 把 ctx 的剩余时间写入出站请求头，下游据此设置自己的截止时间；已过期时写入 1
+func Inject(ctx context.Context, req *http.Request) {
+	if deadline, ok := ctx.Deadline(); ok {
+		remaining := time.Until(deadline).Milliseconds()
+		if remaining < 1 {
+			remaining = 1
+		}
+		req.Header.Set(Header, strconv.FormatInt(remaining, 10))
+	}
+}
+
+// RecordCancellation 在 span 上记录因截止时间或取消而中止的工作
+func RecordCancellation(span trace.Span, err error) {
+	span.SetAttributes(attribute.Bool("cancelled", true))
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
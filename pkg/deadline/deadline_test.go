@@ -0,0 +1,64 @@
+package deadline
+
+import (
+	"context"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestWithBudget(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   time.Duration
+	}{
+		{"no header", "", 0},
+		{"budget", "250", 250 * time.Millisecond},
+		{"zero", "0", 0},
+		{"negative", "-5", 0},
+		{"not a number", "soon", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.header != "" {
+				r.Header.Set(Header, tt.header)
+			}
+			ctx, cancel := WithBudget(context.Background(), r)
+			defer cancel()
+
+			deadline, ok := ctx.Deadline()
+			if ok != (tt.want > 0) {
+				t.Fatalf("deadline set = %v, want %v", ok, tt.want > 0)
+			}
+			if remaining := time.Until(deadline); ok && (remaining > tt.want || remaining < tt.want-100*time.Millisecond) {
+				t.Errorf("remaining %v, want about %v", remaining, tt.want)
+			}
+		})
+	}
+}
+
+func TestInject(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	Inject(context.Background(), req)
+	if got := req.Header.Get(Header); got != "" {
+		t.Errorf("header %q without a deadline", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	Inject(ctx, req)
+	if ms, err := strconv.Atoi(req.Header.Get(Header)); err != nil || ms <= 900 || ms > 1000 {
+		t.Errorf("header %q, want about 1000", req.Header.Get(Header))
+	}
+
+	// 已经过期的截止时间仍然传给下游，下游会立即放弃
+	expired, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	Inject(expired, req)
+	if got := req.Header.Get(Header); got != "1" {
+		t.Errorf("expired deadline: header %q, want 1", got)
+	}
+}
@@ -0,0 +1,19 @@
+// Package gindeadline 在 gin 服务中按 X-Request-Budget-Ms 设置请求的截止时间
+package gindeadline
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/xyzbit/devops-demo/pkg/deadline"
+)
+
+// Middleware 把请求头中的剩余时间预算转换为请求上下文的截止时间，
+// 应放在 otelgin 之后，预算记录在请求的 span 上
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := deadline.WithBudget(c.Request.Context(), c.Request)
+		defer cancel()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
@@ -0,0 +1,33 @@
+package gindeadline
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xyzbit/devops-demo/pkg/deadline"
+)
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware())
+	r.GET("/slow", func(c *gin.Context) {
+		select {
+		case <-time.After(time.Second):
+			c.String(200, "done")
+		case <-c.Request.Context().Done():
+			c.String(504, c.Request.Context().Err().Error())
+		}
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/slow", nil)
+	req.Header.Set(deadline.Header, "20")
+	start := time.Now()
+	r.ServeHTTP(w, req)
+	if w.Code != 504 || time.Since(start) > 500*time.Millisecond {
+		t.Errorf("with budget: %d %s after %v", w.Code, w.Body, time.Since(start))
+	}
+}
@@ -0,0 +1,306 @@
+// Package faults 按 HTTP 路由或内部操作注入延迟、错误和 panic，用于在 APM 看板上做可重复的故障演练。
+//
+// 规则来自 FAULTS_CONFIG 指定的 JSON 文件（修改后自动重新加载）或 /admin/faults 接口，
+// 未设置 FAULTS_CONFIG 时使用服务内置的默认规则。路由规则和操作规则分别按顺序匹配，第一条命中的规则生效。
+// 注入的故障会标记在当前 span 上并计入 faults_injected_total。
+package faults
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var faultsInjected = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "faults_injected_total",
+		Help: "Total number of injected faults",
+	},
+	[]string{"rule", "type"},
+)
+
+func init() {
+	prometheus.MustRegister(faultsInjected)
+}
+
+// Error 注入到内部操作上的错误，Status 为对应的 HTTP 状态码
+type Error struct {
+	Rule   string
+	Status int
+}
+
+func (e *Error) Error() string {
+	return "injected fault: " + e.Rule
+}
+
+// Injector 保存当前生效的故障规则
+type Injector struct {
+	path       string // FAULTS_CONFIG，为空时使用 defaults
+	defaults   []byte
+	adminToken string // FAULTS_ADMIN_TOKEN，为空时管理接口不可用
+
+	mu      sync.RWMutex
+	rules   []Rule
+	source  string
+	modTime time.Time
+}
+
+// New 创建一个没有规则的 Injector，Reset 时从 path 或 defaults（JSON 格式的 Config）加载规则
+func New(path string, defaults []byte) *Injector {
+	return &Injector{path: path, defaults: defaults}
+}
+
+// NewFromEnv 从 FAULTS_CONFIG 或 defaults 加载规则，管理接口的令牌取自 FAULTS_ADMIN_TOKEN
+func NewFromEnv(defaults []byte) (*Injector, error) {
+	f := New(os.Getenv("FAULTS_CONFIG"), defaults)
+	f.adminToken = os.Getenv("FAULTS_ADMIN_TOKEN")
+	if err := f.Reset(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Reset 丢弃通过接口修改的规则，重新加载配置文件或内置规则
+func (f *Injector) Reset() error {
+	data, source := f.defaults, "default"
+	var modTime time.Time
+	if f.path != "" {
+		info, err := os.Stat(f.path)
+		if err != nil {
+			return fmt.Errorf("stat fault config: %w", err)
+		}
+		if data, err = os.ReadFile(f.path); err != nil {
+			return fmt.Errorf("read fault config: %w", err)
+		}
+		source, modTime = f.path, info.ModTime()
+	}
+
+	var cfg Config
+	if len(data) > 0 {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&cfg); err != nil {
+			return fmt.Errorf("parse fault config %s: %w", source, err)
+		}
+	}
+	if err := f.replace(cfg.Rules, source); err != nil {
+		return fmt.Errorf("invalid fault config %s: %w", source, err)
+	}
+	f.mu.Lock()
+	f.modTime = modTime
+	f.mu.Unlock()
+	return nil
+}
+
+// Watch 定期检查配置文件的修改时间，变化后重新加载。非法的配置会被拒绝并保留当前规则
+func (f *Injector) Watch(ctx context.Context, interval time.Duration) {
+	if f.path == "" {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(f.path)
+			if err != nil {
+				slog.Error("Failed to stat fault config.", slog.Any("error", err))
+				continue
+			}
+			f.mu.RLock()
+			unchanged := info.ModTime().Equal(f.modTime)
+			f.mu.RUnlock()
+			if unchanged {
+				continue
+			}
+			if err := f.Reset(); err != nil {
+				slog.Error("Failed to reload fault config, keeping previous rules.", slog.Any("error", err))
+				continue
+			}
+			slog.Info("Fault rules reloaded.", slog.String("path", f.path))
+		}
+	}
+}
+
+// Replace 用 rules 替换全部规则
+func (f *Injector) Replace(rules []Rule) error {
+	return f.replace(rules, "api")
+}
+
+func (f *Injector) replace(rules []Rule, source string) error {
+	now := time.Now()
+	seen := make(map[string]bool, len(rules))
+	validated := make([]Rule, 0, len(rules))
+	for _, r := range rules {
+		if err := r.validate(now); err != nil {
+			return err
+		}
+		if seen[r.Name] {
+			return fmt.Errorf("duplicate rule %q", r.Name)
+		}
+		seen[r.Name] = true
+		validated = append(validated, r)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules, f.source = validated, source
+	return nil
+}
+
+// Put 添加一条规则并放在最前面优先匹配，同名规则会被替换
+func (f *Injector) Put(rule Rule) (Rule, error) {
+	if err := rule.validate(time.Now()); err != nil {
+		return Rule{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	rules := []Rule{rule}
+	for _, r := range f.rules {
+		if r.Name != rule.Name {
+			rules = append(rules, r)
+		}
+	}
+	f.rules, f.source = rules, "api"
+	return rule, nil
+}
+
+// Remove 删除一条规则，规则不存在时返回 false
+func (f *Injector) Remove(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, r := range f.rules {
+		if r.Name == name {
+			f.rules = append(f.rules[:i:i], f.rules[i+1:]...)
+			f.source = "api"
+			return true
+		}
+	}
+	return false
+}
+
+// Rules 返回尚未过期的规则及其来源
+func (f *Injector) Rules() ([]Rule, string) {
+	now := time.Now()
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	rules := make([]Rule, 0, len(f.rules))
+	for _, r := range f.rules {
+		if !r.expired(now) {
+			rules = append(rules, r)
+		}
+	}
+	return rules, f.source
+}
+
+func (f *Injector) match(matches func(Rule) bool) (Rule, bool) {
+	now := time.Now()
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for _, r := range f.rules {
+		if !r.expired(now) && matches(r) {
+			return r, true
+		}
+	}
+	return Rule{}, false
+}
+
+// Inject 对内部操作应用匹配的规则：等待注入的延迟，抽中错误时返回 *Error。
+// 延迟期间 ctx 结束时返回 ctx.Err()
+func (f *Injector) Inject(ctx context.Context, operation string) error {
+	rule, ok := f.match(func(r Rule) bool { return r.matchesOperation(operation) })
+	if !ok {
+		return nil
+	}
+	in := rule.roll()
+	recordFault(ctx, in)
+	if err := sleepContext(ctx, in.delay); err != nil {
+		return err
+	}
+	if in.status != 0 {
+		return &Error{Rule: rule.Name, Status: in.status}
+	}
+	return nil
+}
+
+// Outcome 一次请求命中的路由规则和抽签结果
+type Outcome struct {
+	// Rule 命中的规则名，为空表示没有命中任何规则
+	Rule string
+	// Type 注入的故障类型（delay、error、panic），基线规则和没有抽中时为空
+	Type   string
+	Delay  time.Duration
+	Status int // 0 表示不返回错误
+	Panic  bool
+}
+
+// Route 对 HTTP 请求应用匹配的路由规则并等待注入的延迟，延迟期间 ctx 结束时返回 ctx.Err()。
+// 返回错误状态码和 panic 由调用方（Middleware 或 ginfaults）处理
+func (f *Injector) Route(ctx context.Context, route, method string) (Outcome, error) {
+	rule, ok := f.match(func(r Rule) bool { return r.matchesRoute(route, method) })
+	if !ok {
+		return Outcome{}, nil
+	}
+	in := rule.roll()
+	out := Outcome{Rule: rule.Name, Delay: in.delay, Status: in.status, Panic: in.panic}
+	if !rule.Baseline {
+		out.Type = in.kind()
+	}
+	recordFault(ctx, in)
+	if err := sleepContext(ctx, in.delay); err != nil {
+		return out, err
+	}
+	if in.panic {
+		trace.SpanFromContext(ctx).SetStatus(codes.Error, "injected panic")
+	}
+	return out, nil
+}
+
+// recordFault 在当前 span 上标记注入的故障，基线规则不做标记
+func recordFault(ctx context.Context, in injection) {
+	kind := in.kind()
+	if in.rule.Baseline || kind == "" {
+		return
+	}
+	attrs := []attribute.KeyValue{
+		attribute.Bool("fault.injected", true),
+		attribute.String("fault.rule", in.rule.Name),
+		attribute.String("fault.type", kind),
+		attribute.Int64("fault.delay_ms", in.delay.Milliseconds()),
+	}
+	if in.status != 0 {
+		attrs = append(attrs, attribute.Int("fault.status", in.status))
+	}
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attrs...)
+	span.AddEvent("fault.injected", trace.WithAttributes(attrs...))
+	faultsInjected.WithLabelValues(in.rule.Name, kind).Inc()
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,221 @@
+package faults
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestRuleValidation(t *testing.T) {
+	invalid := []Rule{
+		{Route: "/users"},
+		{Name: "no-target"},
+		{Name: "both", Route: "/users", Operation: "database_query"},
+		{Name: "method-without-route", Operation: "database_query", Method: "GET"},
+		{Name: "negative-delay", Route: "*", Delay: Duration(-time.Second)},
+		{Name: "percent", Route: "*", ErrorPercent: 150},
+		{Name: "background-panic", Operation: "*", PanicPercent: 10},
+		{Name: "status", Route: "*", ErrorPercent: 10, Status: 200},
+	}
+	for _, r := range invalid {
+		if err := r.validate(time.Now()); err == nil {
+			t.Errorf("%+v: expected an error", r)
+		}
+	}
+
+	now := time.Now()
+	r := Rule{Name: "drill", Route: "/users/:id", Method: "get", ErrorPercent: 50, TTL: Duration(time.Minute)}
+	if err := r.validate(now); err != nil {
+		t.Fatal(err)
+	}
+	if r.Status != 500 || r.Method != "GET" || r.ExpiresAt == nil || !r.ExpiresAt.Equal(now.Add(time.Minute)) {
+		t.Errorf("normalized rule %+v", r)
+	}
+
+	f := New("", nil)
+	if err := f.Replace([]Rule{{Name: "a", Route: "*"}, {Name: "a", Operation: "*"}}); err == nil {
+		t.Error("duplicate names should be rejected")
+	}
+}
+
+func TestRouteMatching(t *testing.T) {
+	tests := []struct {
+		rule   Rule
+		route  string
+		method string
+		want   bool
+	}{
+		{Rule{Route: "*"}, "/users/:id", "GET", true},
+		{Rule{Route: "*"}, "/health", "GET", false},
+		{Rule{Route: "*"}, "/metrics", "GET", false},
+		{Rule{Route: "*"}, "/admin/faults/:name", "DELETE", false},
+		{Rule{Route: "/users/:id", Method: "GET"}, "/users/:id", "GET", true},
+		{Rule{Route: "/users/:id", Method: "GET"}, "/users/:id", "PUT", false},
+		{Rule{Route: "/users/:id"}, "/users", "GET", false},
+		{Rule{Operation: "*"}, "/users", "GET", false},
+	}
+	for _, tt := range tests {
+		if got := tt.rule.matchesRoute(tt.route, tt.method); got != tt.want {
+			t.Errorf("%+v matchesRoute(%s %s) = %v, want %v", tt.rule, tt.method, tt.route, got, tt.want)
+		}
+	}
+}
+
+func TestInjectTagsSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+	f := New("", nil)
+	if err := f.Replace([]Rule{
+		{Name: "insert-fails", Operation: "database_insert", ErrorPercent: 100, Status: 503, Delay: Duration(time.Millisecond)},
+		{Name: "baseline", Operation: "*", ErrorPercent: 100, Baseline: true},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	before := testutil.ToFloat64(faultsInjected.WithLabelValues("insert-fails", "error"))
+
+	ctx, span := tracer.Start(context.Background(), "insert")
+	err := f.Inject(ctx, "database_insert")
+	span.End()
+	var fault *Error
+	if !errors.As(err, &fault) || fault.Rule != "insert-fails" || fault.Status != 503 {
+		t.Fatalf("got %v, want the injected error", err)
+	}
+	if got := testutil.ToFloat64(faultsInjected.WithLabelValues("insert-fails", "error")) - before; got != 1 {
+		t.Errorf("faults_injected_total increased by %v, want 1", got)
+	}
+	attrs := map[string]string{}
+	for _, kv := range recorder.Ended()[0].Attributes() {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	if attrs["fault.injected"] != "true" || attrs["fault.rule"] != "insert-fails" || attrs["fault.type"] != "error" || attrs["fault.status"] != "503" {
+		t.Errorf("span attributes %v", attrs)
+	}
+	if events := recorder.Ended()[0].Events(); len(events) != 1 || events[0].Name != "fault.injected" {
+		t.Errorf("span events %v", events)
+	}
+
+	// 基线规则照常返回错误，但不做故障标记
+	ctx, span = tracer.Start(context.Background(), "query")
+	err = f.Inject(ctx, "database_query")
+	span.End()
+	if !errors.As(err, &fault) || fault.Rule != "baseline" {
+		t.Fatalf("got %v, want the baseline error", err)
+	}
+	if attrs := recorder.Ended()[1].Attributes(); len(attrs) != 0 {
+		t.Errorf("baseline rule tagged the span: %v", attrs)
+	}
+}
+
+func TestInjectRespectsContext(t *testing.T) {
+	f := New("", nil)
+	if _, err := f.Put(Rule{Name: "slow-query", Operation: "database_query", Delay: Duration(time.Second)}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := f.Inject(ctx, "database_query"); err != context.DeadlineExceeded || time.Since(start) > 500*time.Millisecond {
+		t.Errorf("got %v after %v, want DeadlineExceeded", err, time.Since(start))
+	}
+	if err := f.Inject(context.Background(), "cache_get"); err != nil {
+		t.Errorf("unmatched operation: %v", err)
+	}
+}
+
+func TestPutRemoveAndExpiry(t *testing.T) {
+	f := New("", []byte(`{"rules":[{"name":"baseline","operation":"*","baseline":true}]}`))
+	if err := f.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Put(Rule{Name: "drill", Route: "/users/:id", TTL: Duration(time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	// 新规则优先匹配
+	rules, source := f.Rules()
+	if len(rules) != 2 || rules[0].Name != "drill" || rules[0].ExpiresAt == nil || source != "api" {
+		t.Errorf("rules %+v from %s", rules, source)
+	}
+	if !f.Remove("drill") || f.Remove("drill") {
+		t.Error("Remove should report whether the rule existed")
+	}
+
+	expired := time.Now().Add(-time.Second)
+	if err := f.Replace([]Rule{{Name: "old", Route: "*", ErrorPercent: 100, ExpiresAt: &expired}}); err != nil {
+		t.Fatal(err)
+	}
+	if rules, _ := f.Rules(); len(rules) != 0 {
+		t.Errorf("expired rule listed: %+v", rules)
+	}
+	if out, err := f.Route(context.Background(), "/users", "GET"); out.Rule != "" || err != nil {
+		t.Errorf("expired rule applied: %+v %v", out, err)
+	}
+
+	if err := f.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if rules, source := f.Rules(); len(rules) != 1 || !rules[0].Baseline || source != "default" {
+		t.Errorf("reset: %+v from %s", rules, source)
+	}
+}
+
+func TestConfigReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "faults.json")
+	if err := os.WriteFile(path, []byte(`{"rules":[{"name":"v1","route":"*","delay":"1ms"}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("FAULTS_CONFIG", path)
+	f, err := NewFromEnv(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules, source := f.Rules(); len(rules) != 1 || rules[0].Name != "v1" || source != path {
+		t.Fatalf("rules %+v from %s", rules, source)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go f.Watch(ctx, 5*time.Millisecond)
+
+	writeConfig := func(data string, mtime time.Time) {
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 非法的配置被拒绝，保留当前规则
+	writeConfig(`{"rules":[{"name":"broken","route":"*","error_percent":500}]}`, time.Now().Add(time.Minute))
+	time.Sleep(30 * time.Millisecond)
+	if rules, _ := f.Rules(); len(rules) != 1 || rules[0].Name != "v1" {
+		t.Errorf("invalid config replaced the rules: %+v", rules)
+	}
+
+	writeConfig(`{"rules":[{"name":"v2","operation":"database_query","delay":"2ms"}]}`, time.Now().Add(2*time.Minute))
+	deadline := time.Now().Add(time.Second)
+	for {
+		if rules, _ := f.Rules(); len(rules) == 1 && rules[0].Name == "v2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("config was not reloaded")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Setenv("FAULTS_CONFIG", filepath.Join(t.TempDir(), "missing.json"))
+	if _, err := NewFromEnv(nil); err == nil {
+		t.Error("missing config file should fail")
+	}
+	t.Setenv("FAULTS_CONFIG", "")
+	if _, err := NewFromEnv([]byte(`{"rules":[{"name":"x","route":"*","typo":1}]}`)); err == nil {
+		t.Error("unknown fields in the default config should fail")
+	}
+}
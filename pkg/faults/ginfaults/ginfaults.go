@@ -0,0 +1,43 @@
+// Package ginfaults 把 faults.Injector 接入 gin：按路由模板注入故障，并挂载 /admin/faults 管理接口
+package ginfaults
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xyzbit/devops-demo/pkg/faults"
+)
+
+// Middleware 按 gin 路由模板应用匹配的规则，应放在 otelgin、指标和日志中间件之后，
+// 这样注入的延迟和错误会出现在 span、指标和访问日志中
+func Middleware(f *faults.Injector) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			route = c.Request.URL.Path
+		}
+		out, err := f.Route(c.Request.Context(), route, c.Request.Method)
+		if out.Type != "" {
+			c.Header(faults.Header, out.Rule)
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(504, gin.H{"error": err.Error()})
+			return
+		}
+		if out.Panic {
+			panic(fmt.Sprintf("injected panic: %s", out.Rule))
+		}
+		if out.Status != 0 {
+			c.AbortWithStatusJSON(out.Status, gin.H{"error": "injected fault", "fault": out.Rule})
+			return
+		}
+		c.Next()
+	}
+}
+
+// Register 在 r 上挂载 /admin/faults 管理接口，鉴权和各个方法由 Injector.AdminHandler 处理
+func Register(r gin.IRoutes, f *faults.Injector) {
+	h := gin.WrapH(f.AdminHandler())
+	r.Any("/admin/faults", h)
+	r.Any("/admin/faults/:name", h)
+}
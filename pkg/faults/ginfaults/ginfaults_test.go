@@ -0,0 +1,102 @@
+package ginfaults
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xyzbit/devops-demo/pkg/faults"
+)
+
+func newTestRouter(f *faults.Injector) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(gin.Recovery())
+	r.Use(Middleware(f))
+	r.GET("/health", func(c *gin.Context) { c.JSON(200, gin.H{"status": "healthy"}) })
+	r.GET("/users/:id", func(c *gin.Context) { c.JSON(200, gin.H{"id": c.Param("id")}) })
+	r.POST("/users", func(c *gin.Context) { c.JSON(201, gin.H{}) })
+	Register(r, f)
+	return r
+}
+
+func do(r *gin.Engine, method, path, token, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestMiddleware(t *testing.T) {
+	f := faults.New("", nil)
+	if err := f.Replace([]faults.Rule{
+		{Name: "get-user-outage", Route: "/users/:id", Method: "GET", ErrorPercent: 100, Status: 503},
+		{Name: "everything-panics", Route: "*", Method: "POST", PanicPercent: 100},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	r := newTestRouter(f)
+
+	// 按路由模板而不是请求路径匹配
+	w := do(r, "GET", "/users/1", "", "")
+	if w.Code != 503 || !strings.Contains(w.Body.String(), `"fault":"get-user-outage"`) || w.Header().Get(faults.Header) != "get-user-outage" {
+		t.Errorf("get user: %d %s", w.Code, w.Body)
+	}
+	// panic 由 gin.Recovery 兜底
+	if w := do(r, "POST", "/users", "", "{}"); w.Code != 500 {
+		t.Errorf("injected panic: status %d", w.Code)
+	}
+	// 通配规则不影响健康检查和管理接口
+	if w := do(r, "GET", "/health", "", ""); w.Code != 200 {
+		t.Errorf("health: status %d", w.Code)
+	}
+	if w := do(r, "POST", "/admin/faults", "", `{"name":"x","operation":"*"}`); w.Code != 403 {
+		t.Errorf("admin without token: status %d", w.Code)
+	}
+}
+
+func TestMiddlewareTimeout(t *testing.T) {
+	f := faults.New("", nil)
+	if err := f.Replace([]faults.Rule{{Name: "slow", Route: "/users/:id", Delay: faults.Duration(time.Second)}}); err != nil {
+		t.Fatal(err)
+	}
+	r := newTestRouter(f)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/users/1", nil).WithContext(ctx))
+	if w.Code != 504 || w.Header().Get(faults.Header) != "slow" {
+		t.Errorf("status %d %v", w.Code, w.Header())
+	}
+}
+
+func TestRegister(t *testing.T) {
+	t.Setenv("FAULTS_CONFIG", "")
+	t.Setenv("FAULTS_ADMIN_TOKEN", "s3cret")
+	f, err := faults.NewFromEnv(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newTestRouter(f)
+
+	if w := do(r, "POST", "/admin/faults", "wrong", `{"name":"x","operation":"*"}`); w.Code != 401 {
+		t.Errorf("wrong token: status %d", w.Code)
+	}
+	if w := do(r, "POST", "/admin/faults", "s3cret", `{"name":"x","operation":"*"}`); w.Code != 201 {
+		t.Errorf("add: %d %s", w.Code, w.Body)
+	}
+	if w := do(r, "GET", "/admin/faults", "s3cret", ""); w.Code != 200 || !strings.Contains(w.Body.String(), `"name":"x"`) {
+		t.Errorf("list: %d %s", w.Code, w.Body)
+	}
+	if w := do(r, "DELETE", "/admin/faults/x", "s3cret", ""); w.Code != 204 {
+		t.Errorf("delete: status %d", w.Code)
+	}
+}
@@ -0,0 +1,164 @@
+package faults
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Header 响应头，值为命中的规则名，基线规则不设置
+const Header = "X-Fault-Injected"
+
+// adminPath 管理接口的路径前缀
+const adminPath = "/admin/faults"
+
+type delayKey struct{}
+
+// Delay 返回 Middleware 为本次请求注入的延迟
+func Delay(ctx context.Context) time.Duration {
+	d, _ := ctx.Value(delayKey{}).(time.Duration)
+	return d
+}
+
+// Middleware 按请求路径应用第一条匹配的路由规则：等待注入的延迟，然后按抽签结果返回错误、panic 或继续处理。
+// 注入的故障在这里记录日志，处理函数可以通过 Delay 取得注入的延迟
+func (f *Injector) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		out, err := f.Route(r.Context(), r.URL.Path, r.Method)
+		if out.Rule == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		reqLogger := slog.Default().With(
+			slog.String("request_id", r.Header.Get("X-Request-ID")),
+			slog.String("http_method", r.Method),
+			slog.String("http_path", r.URL.Path),
+			slog.String("fault_rule", out.Rule),
+			slog.Duration("processing_time_ms", out.Delay),
+		)
+		if out.Type != "" {
+			w.Header().Set(Header, out.Rule)
+			reqLogger = reqLogger.With(slog.String("fault_type", out.Type))
+			reqLogger.Info("Fault injected.")
+		}
+		if err != nil {
+			// 请求在注入的延迟期间结束，与 ginfaults 一样返回 504
+			http.Error(w, err.Error(), http.StatusGatewayTimeout)
+			return
+		}
+		if out.Panic {
+			panic(fmt.Sprintf("injected panic: %s", out.Rule))
+		}
+		if out.Status != 0 {
+			reqLogger.Error(fmt.Sprintf("Failed to process %s request.", r.URL.Path),
+				slog.String("error", "injected fault: "+out.Rule),
+				slog.Int("status", out.Status),
+			)
+			http.Error(w, http.StatusText(out.Status), out.Status)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), delayKey{}, out.Delay)))
+	})
+}
+
+// Register 在 mux 上注册 /admin/faults 管理接口
+func (f *Injector) Register(mux *http.ServeMux) {
+	h := f.AdminHandler()
+	mux.Handle(adminPath, h)
+	mux.Handle(adminPath+"/", h)
+}
+
+// AdminHandler 返回 /admin/faults 管理接口：
+//
+//	GET    /admin/faults        列出规则
+//	PUT    /admin/faults        替换全部规则
+//	POST   /admin/faults        添加或替换一条规则
+//	DELETE /admin/faults        恢复配置文件中的规则
+//	DELETE /admin/faults/{name} 删除一条规则
+//
+// 请求需要携带 Authorization: Bearer <FAULTS_ADMIN_TOKEN>，未配置令牌时接口返回 403
+func (f *Injector) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if f.adminToken == "" {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "fault admin API is disabled, set FAULTS_ADMIN_TOKEN to enable it"})
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(f.adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="faults"`)
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid admin token"})
+			return
+		}
+
+		name, hasName := strings.CutPrefix(strings.TrimPrefix(r.URL.Path, adminPath), "/")
+		switch {
+		case !hasName && r.Method == http.MethodGet:
+			f.writeRules(w)
+		case !hasName && r.Method == http.MethodPut:
+			var cfg Config
+			if err := decodeJSON(r, &cfg); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			if err := f.Replace(cfg.Rules); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			slog.Info("Fault rules replaced.", slog.Int("rules", len(cfg.Rules)))
+			f.writeRules(w)
+		case !hasName && r.Method == http.MethodPost:
+			var rule Rule
+			if err := decodeJSON(r, &rule); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			rule, err := f.Put(rule)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			slog.Info("Fault rule added.", slog.String("fault_rule", rule.Name))
+			writeJSON(w, http.StatusCreated, rule)
+		case !hasName && r.Method == http.MethodDelete:
+			if err := f.Reset(); err != nil {
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+				return
+			}
+			f.writeRules(w)
+		case hasName && name != "" && r.Method == http.MethodDelete:
+			if !f.Remove(name) {
+				writeJSON(w, http.StatusNotFound, map[string]string{"error": "Fault rule not found"})
+				return
+			}
+			slog.Info("Fault rule removed.", slog.String("fault_rule", name))
+			w.WriteHeader(http.StatusNoContent)
+		case !hasName || name != "":
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		default:
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+		}
+	})
+}
+
+func (f *Injector) writeRules(w http.ResponseWriter) {
+	rules, source := f.Rules()
+	writeJSON(w, http.StatusOK, map[string]interface{}{"rules": rules, "source": source})
+}
+
+func decodeJSON(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,149 @@
+package faults
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestMux(t *testing.T, f *Injector) http.Handler {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hello", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "delay=%s", Delay(r.Context()))
+	})
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {})
+	f.Register(mux)
+	return f.Middleware(mux)
+}
+
+func request(h http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestMiddleware(t *testing.T) {
+	f := New("", nil)
+	if err := f.Replace([]Rule{
+		{Name: "hello-outage", Route: "/hello", Method: "POST", ErrorPercent: 100, Status: 503},
+		{Name: "hello-panics", Route: "/hello", Method: "DELETE", PanicPercent: 100},
+		{Name: "baseline", Route: "*", Delay: Duration(2 * time.Millisecond), Baseline: true},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	h := newTestMux(t, f)
+
+	w := request(h, "POST", "/hello", "", "")
+	if w.Code != 503 || w.Header().Get(Header) != "hello-outage" {
+		t.Errorf("outage: %d %v", w.Code, w.Header())
+	}
+	// 基线规则的延迟传给处理函数，但不做故障标记
+	w = request(h, "GET", "/hello", "", "")
+	if w.Code != 200 || w.Body.String() != "delay=2ms" || w.Header().Get(Header) != "" {
+		t.Errorf("baseline: %d %q %v", w.Code, w.Body, w.Header())
+	}
+	// 请求在延迟期间结束时返回 504，而不是空的 200
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/hello", nil).WithContext(ctx))
+	if w.Code != 504 || w.Body.String() == "delay=2ms" {
+		t.Errorf("cancelled: %d %q", w.Code, w.Body)
+	}
+	func() {
+		defer func() {
+			if p := recover(); p != "injected panic: hello-panics" {
+				t.Errorf("recovered %v", p)
+			}
+		}()
+		request(h, "DELETE", "/hello", "", "")
+	}()
+	if w := request(h, "GET", "/health", "", ""); w.Code != 200 || w.Header().Get(Header) != "" {
+		t.Errorf("health: %d", w.Code)
+	}
+}
+
+func TestAdminAPI(t *testing.T) {
+	t.Setenv("FAULTS_CONFIG", "")
+	t.Setenv("FAULTS_ADMIN_TOKEN", "s3cret")
+	f, err := NewFromEnv([]byte(`{"rules":[{"name":"baseline","operation":"*","baseline":true}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newTestMux(t, f)
+
+	for _, token := range []string{"", "wrong"} {
+		if w := request(h, "GET", "/admin/faults", token, ""); w.Code != 401 {
+			t.Errorf("token %q: status %d", token, w.Code)
+		}
+	}
+	if w := request(h, "POST", "/admin/faults", "s3cret", `{"name":"drill","route":"/hello","error_percent":20,"ttl":"10m"}`); w.Code != 201 {
+		t.Fatalf("add: %d %s", w.Code, w.Body)
+	}
+	var list struct {
+		Rules  []Rule `json:"rules"`
+		Source string `json:"source"`
+	}
+	w := request(h, "GET", "/admin/faults", "s3cret", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Rules) != 2 || list.Rules[0].Name != "drill" || list.Rules[0].ExpiresAt == nil || list.Source != "api" {
+		t.Errorf("list: %s", w.Body)
+	}
+
+	for _, body := range []string{`{"name":"bad","route":"*","delay":"soon"}`, `{"name":"bad"}`, `{"name":"bad","route":"*","typo":1}`, `not json`} {
+		if w := request(h, "POST", "/admin/faults", "s3cret", body); w.Code != 400 {
+			t.Errorf("%s: status %d", body, w.Code)
+		}
+	}
+	if w := request(h, "PUT", "/admin/faults", "s3cret", `{"rules":[{"name":"a","route":"*"},{"name":"a","route":"*"}]}`); w.Code != 400 {
+		t.Errorf("duplicate names: status %d", w.Code)
+	}
+	if w := request(h, "DELETE", "/admin/faults/drill", "s3cret", ""); w.Code != 204 {
+		t.Errorf("delete: status %d", w.Code)
+	}
+	if w := request(h, "DELETE", "/admin/faults/drill", "s3cret", ""); w.Code != 404 {
+		t.Errorf("delete missing: status %d", w.Code)
+	}
+	if w := request(h, "PATCH", "/admin/faults", "s3cret", ""); w.Code != 405 {
+		t.Errorf("patch: status %d", w.Code)
+	}
+	if w := request(h, "PUT", "/admin/faults", "s3cret", `{"rules":[{"name":"only","route":"/hello"}]}`); w.Code != 200 || !strings.Contains(w.Body.String(), `"only"`) {
+		t.Errorf("put: %d %s", w.Code, w.Body)
+	}
+
+	// 重置为内置规则
+	w = request(h, "DELETE", "/admin/faults", "s3cret", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || list.Source != "default" || len(list.Rules) != 1 || !list.Rules[0].Baseline {
+		t.Errorf("reset: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestAdminAPIDisabledWithoutToken(t *testing.T) {
+	t.Setenv("FAULTS_CONFIG", "")
+	t.Setenv("FAULTS_ADMIN_TOKEN", "")
+	f, err := NewFromEnv(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newTestMux(t, f)
+	for _, method := range []string{"GET", "POST", "DELETE"} {
+		if w := request(h, method, "/admin/faults", "", `{"name":"x","route":"*"}`); w.Code != 403 {
+			t.Errorf("%s: status %d", method, w.Code)
+		}
+	}
+	if rules, _ := f.Rules(); len(rules) != 0 {
+		t.Errorf("rules changed without a token: %+v", rules)
+	}
+}
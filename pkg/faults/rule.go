@@ -0,0 +1,141 @@
+package faults
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// Rule 一条故障规则。Route 匹配路由（gin 的路由模板或 net/http 的请求路径），Operation 匹配内部操作名，
+// 两者只能设置一个。"*" 匹配全部，路由规则的 "*" 不包括 /health 和 /metrics。
+// panic 只能注入到路由上，内部操作可能运行在后台 goroutine 中
+type Rule struct {
+	Name         string   `json:"name"`
+	Route        string   `json:"route,omitempty"`
+	Method       string   `json:"method,omitempty"`
+	Operation    string   `json:"operation,omitempty"`
+	Delay        Duration `json:"delay,omitempty"`
+	Jitter       Duration `json:"jitter,omitempty"`
+	ErrorPercent float64  `json:"error_percent,omitempty"`
+	Status       int      `json:"status,omitempty"`
+	PanicPercent float64  `json:"panic_percent,omitempty"`
+	// Baseline 基线规则模拟正常的处理耗时和偶发错误，不打 fault.* 标签，也不计入 faults_injected_total
+	Baseline bool `json:"baseline,omitempty"`
+	// TTL 规则的有效期，加载时换算为 ExpiresAt，便于演练结束后自动恢复
+	TTL       Duration   `json:"ttl,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Config 规则文件和 PUT /admin/faults 的格式
+type Config struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Duration 在 JSON 中以 "500ms"、"2s" 形式表示
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"500ms\"")
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", s)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (r *Rule) validate(now time.Time) error {
+	switch {
+	case r.Name == "":
+		return errors.New("name is required")
+	case r.Route == "" && r.Operation == "":
+		return fmt.Errorf("rule %q: route or operation is required", r.Name)
+	case r.Route != "" && r.Operation != "":
+		return fmt.Errorf("rule %q: route and operation are mutually exclusive", r.Name)
+	case r.Method != "" && r.Route == "":
+		return fmt.Errorf("rule %q: method requires route", r.Name)
+	case r.Delay < 0 || r.Jitter < 0 || r.TTL < 0:
+		return fmt.Errorf("rule %q: durations must not be negative", r.Name)
+	case r.ErrorPercent < 0 || r.ErrorPercent > 100 || r.PanicPercent < 0 || r.PanicPercent > 100:
+		return fmt.Errorf("rule %q: percentages must be between 0 and 100", r.Name)
+	case r.PanicPercent > 0 && r.Route == "":
+		return fmt.Errorf("rule %q: panic_percent requires route", r.Name)
+	case r.Status != 0 && (r.Status < 400 || r.Status > 599):
+		return fmt.Errorf("rule %q: status must be between 400 and 599", r.Name)
+	}
+	if r.Status == 0 {
+		r.Status = 500
+	}
+	r.Method = strings.ToUpper(r.Method)
+	if r.TTL > 0 && r.ExpiresAt == nil {
+		expiresAt := now.Add(time.Duration(r.TTL))
+		r.ExpiresAt = &expiresAt
+	}
+	return nil
+}
+
+func (r Rule) expired(now time.Time) bool {
+	return r.ExpiresAt != nil && !now.Before(*r.ExpiresAt)
+}
+
+// matchesRoute 管理接口本身永远不会被注入故障
+func (r Rule) matchesRoute(route, method string) bool {
+	if r.Route == "" || strings.HasPrefix(route, adminPath) {
+		return false
+	}
+	if r.Method != "" && r.Method != method {
+		return false
+	}
+	if r.Route == "*" {
+		return route != "/health" && route != "/metrics"
+	}
+	return r.Route == route
+}
+
+func (r Rule) matchesOperation(operation string) bool {
+	return r.Operation == "*" || (r.Operation != "" && r.Operation == operation)
+}
+
+// injection 一次匹配后抽签得到的故障
+type injection struct {
+	rule   Rule
+	delay  time.Duration
+	status int // 0 表示不返回错误
+	panic  bool
+}
+
+func (r Rule) roll() injection {
+	in := injection{rule: r, delay: time.Duration(r.Delay)}
+	if r.Jitter > 0 {
+		in.delay += time.Duration(rand.Int63n(int64(r.Jitter)))
+	}
+	if r.PanicPercent > 0 && rand.Float64()*100 < r.PanicPercent {
+		in.panic = true
+	} else if r.ErrorPercent > 0 && rand.Float64()*100 < r.ErrorPercent {
+		in.status = r.Status
+	}
+	return in
+}
+
+// kind 返回最严重的故障类型，没有注入任何故障时返回空字符串
+func (in injection) kind() string {
+	switch {
+	case in.panic:
+		return "panic"
+	case in.status != 0:
+		return "error"
+	case in.delay > 0:
+		return "delay"
+	}
+	return ""
+}
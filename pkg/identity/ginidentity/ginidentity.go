@@ -0,0 +1,44 @@
+// Package ginidentity 在下游 gin 服务中校验网关签名的 X-User-Identity 头
+package ginidentity
+
+import (
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xyzbit/devops-demo/pkg/identity"
+	"github.com/xyzbit/devops-demo/pkg/requestid"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Middleware 丢弃客户端自带的 X-User-ID，校验 X-User-Identity 并把其中的用户写回 X-User-ID、
+// 请求上下文和 span 的 enduser.id。没有身份头的请求作为匿名请求放行，签名不对或已过期时返回 401。
+// key 为空时不信任任何身份头，两个头都会被丢弃
+func Middleware(key []byte) gin.HandlerFunc {
+	if len(key) == 0 {
+		log.Printf("IDENTITY_SIGNING_KEY is not set, identity headers will be ignored")
+	}
+	return func(c *gin.Context) {
+		header := c.GetHeader(identity.Header)
+		c.Request.Header.Del(identity.UserIDHeader)
+		c.Request.Header.Del(identity.Header)
+		if header == "" || len(key) == 0 {
+			c.Next()
+			return
+		}
+
+		id, err := identity.Verify(key, header, time.Now(), identity.DefaultMaxAge)
+		if err != nil {
+			log.Printf("Rejected identity header for %s (request_id=%s): %v", c.Request.URL.Path, c.GetHeader(requestid.Header), err)
+			trace.SpanFromContext(c.Request.Context()).AddEvent("identity.rejected")
+			c.AbortWithStatusJSON(401, gin.H{"error": "invalid identity"})
+			return
+		}
+
+		c.Request.Header.Set(identity.UserIDHeader, id.Subject)
+		trace.SpanFromContext(c.Request.Context()).SetAttributes(semconv.EnduserID(id.Subject))
+		c.Request = c.Request.WithContext(identity.WithContext(c.Request.Context(), id))
+		c.Next()
+	}
+}
@@ -0,0 +1,60 @@
+package ginidentity
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xyzbit/devops-demo/pkg/identity"
+)
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	key := []byte("secret")
+	newRouter := func(key []byte) *gin.Engine {
+		r := gin.New()
+		r.Use(Middleware(key))
+		r.GET("/whoami", func(c *gin.Context) {
+			id, _ := identity.FromContext(c.Request.Context())
+			c.String(200, c.GetHeader(identity.UserIDHeader)+"|"+id.Subject)
+		})
+		return r
+	}
+	now := time.Now().Unix()
+
+	tests := []struct {
+		name       string
+		key        []byte
+		userID     string
+		identity   string
+		wantStatus int
+		wantBody   string
+	}{
+		{"anonymous", key, "", "", 200, "|"},
+		{"spoofed user id is dropped", key, "1", "", 200, "|"},
+		{"verified identity", key, "1", identity.Sign(key, identity.Identity{Subject: "7", IssuedAt: now}), 200, "7|7"},
+		{"wrong key", key, "", identity.Sign([]byte("other"), identity.Identity{Subject: "7", IssuedAt: now}), 401, ""},
+		{"expired", key, "", identity.Sign(key, identity.Identity{Subject: "7", IssuedAt: now - 3600}), 401, ""},
+		{"no key configured", nil, "1", identity.Sign(key, identity.Identity{Subject: "7", IssuedAt: now}), 200, "|"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/whoami", nil)
+			if tt.userID != "" {
+				req.Header.Set(identity.UserIDHeader, tt.userID)
+			}
+			if tt.identity != "" {
+				req.Header.Set(identity.Header, tt.identity)
+			}
+			w := httptest.NewRecorder()
+			newRouter(tt.key).ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus == 200 && w.Body.String() != tt.wantBody {
+				t.Errorf("body %q, want %q", w.Body, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package identity
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	key := []byte("k1")
+	now := time.Unix(1_800_000_000, 0)
+	valid := Sign(key, Identity{Subject: "42", IssuedAt: now.Unix()})
+	encoded, _, _ := strings.Cut(valid, ".")
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"1","iat":1800000000}`))
+
+	tests := []struct {
+		name   string
+		key    []byte
+		header string
+		now    time.Time
+		want   error
+	}{
+		{"valid", key, valid, now, nil},
+		{"within max age", key, valid, now.Add(DefaultMaxAge - time.Second), nil},
+		{"small clock skew", key, valid, now.Add(-10 * time.Second), nil},
+		{"expired", key, valid, now.Add(DefaultMaxAge + time.Second), ErrExpired},
+		{"issued in the future", key, valid, now.Add(-time.Minute), ErrExpired},
+		{"wrong key", []byte("k2"), valid, now, ErrSignature},
+		{"forged payload", key, forged + "." + strings.SplitN(valid, ".", 2)[1], now, ErrSignature},
+		{"no signature", key, encoded, now, ErrMalformed},
+		{"bad base64", key, encoded + ".!!", now, ErrMalformed},
+		{"empty subject", key, Sign(key, Identity{IssuedAt: now.Unix()}), now, ErrMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := Verify(tt.key, tt.header, tt.now, DefaultMaxAge)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+			if err == nil && (id.Subject != "42" || id.IssuedAt != now.Unix()) {
+				t.Errorf("identity %+v", id)
+			}
+		})
+	}
+}
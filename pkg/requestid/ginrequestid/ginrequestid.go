@@ -0,0 +1,27 @@
+// Package ginrequestid 在 gin 服务中为每个请求确定请求 ID
+package ginrequestid
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/xyzbit/devops-demo/pkg/requestid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Key 保存在 gin.Context 中的请求 ID，供访问日志使用
+const Key = "request.id"
+
+// Middleware 沿用请求头中合法的请求 ID，缺失或不合法时生成新的，
+// 并写入请求头、响应头、gin.Context、请求上下文和当前 span。应放在 otelgin 之后
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := requestid.Resolve(c.GetHeader(requestid.Header))
+		c.Request.Header.Set(requestid.Header, id)
+
+		c.Set(Key, id)
+		c.Header(requestid.Header, id)
+		trace.SpanFromContext(c.Request.Context()).SetAttributes(attribute.String("request.id", id))
+		c.Request = c.Request.WithContext(requestid.WithContext(c.Request.Context(), id))
+		c.Next()
+	}
+}
@@ -0,0 +1,49 @@
+package ginrequestid
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xyzbit/devops-demo/pkg/requestid"
+)
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware())
+	// 处理函数把上下文中的请求 ID 注入出站请求，返回出站请求头和 gin.Context 中的值
+	r.GET("/echo", func(c *gin.Context) {
+		out, _ := http.NewRequest("GET", "http://upstream/", nil)
+		requestid.Inject(c.Request.Context(), out)
+		c.String(200, out.Header.Get(requestid.Header)+"|"+c.GetString(Key))
+	})
+
+	tests := []struct {
+		inbound string
+		keep    bool
+	}{
+		{"", false},
+		{"abc-123", true},
+		{`x","level":"error`, false},
+	}
+	for _, tt := range tests {
+		inbound := tt.inbound
+		req := httptest.NewRequest("GET", "/echo", nil)
+		if inbound != "" {
+			req.Header.Set(requestid.Header, inbound)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		got := w.Header().Get(requestid.Header)
+		if tt.keep != (got == inbound) || !requestid.Pattern.MatchString(got) {
+			t.Errorf("inbound %q: response id %q", inbound, got)
+		}
+		// 转发给下游和访问日志使用的 ID 与响应中的一致
+		if w.Body.String() != got+"|"+got {
+			t.Errorf("inbound %q: propagated %q, response id %q", inbound, w.Body, got)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+// Package requestid 生成、校验和传递贯穿整条调用链的请求 ID。
+//
+// 入站的 X-Request-ID 只有满足 Pattern 时才会沿用，过长或带有引号、换行等字符的值会被替换，
+// 避免客户端伪造访问日志。gin 服务通过 requestid/ginrequestid 接入，gRPC 服务在拦截器中调用 Resolve。
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+)
+
+// Header 贯穿整条调用链的请求 ID
+const Header = "X-Request-ID"
+
+// Pattern 可以沿用的请求 ID
+var Pattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// New 生成 32 个十六进制字符的请求 ID
+func New() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// Resolve 沿用合法的入站请求 ID，缺失或不合法时生成新的
+func Resolve(inbound string) string {
+	if Pattern.MatchString(inbound) {
+		return inbound
+	}
+	return New()
+}
+
+type contextKey struct{}
+
+// WithContext 把请求 ID 保存到 ctx 中，供访问日志和出站调用使用
+func WithContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext 返回 ctx 中的请求 ID
+func FromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(contextKey{}).(string)
+	return id, ok && id != ""
+}
+
+// Inject 把 ctx 中的请求 ID 写入出站请求头
+func Inject(ctx context.Context, req *http.Request) {
+	if id, ok := FromContext(ctx); ok {
+		req.Header.Set(Header, id)
+	}
+}
@@ -0,0 +1,53 @@
+package requestid
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name    string
+		inbound string
+		keep    bool
+	}{
+		{"missing", "", false},
+		{"valid", "abc-123_x.Y", true},
+		{"max length", strings.Repeat("a", 64), true},
+		{"too long", strings.Repeat("a", 65), false},
+		{"json injection", `x","level":"error`, false},
+		{"newline", "x\nforged", false},
+		{"space", "a b", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Resolve(tt.inbound)
+			if tt.keep && got != tt.inbound {
+				t.Errorf("got %q, want %q", got, tt.inbound)
+			}
+			if !tt.keep && (got == tt.inbound || len(got) != 32 || !Pattern.MatchString(got)) {
+				t.Errorf("got %q, want a new id", got)
+			}
+		})
+	}
+
+	// 每次生成的 ID 不同
+	if a, b := New(), New(); a == b {
+		t.Errorf("duplicate ids %q", a)
+	}
+}
+
+func TestInject(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	Inject(context.Background(), req)
+	if got := req.Header.Get(Header); got != "" {
+		t.Errorf("header %q without a request id", got)
+	}
+
+	Inject(WithContext(context.Background(), "req-1"), req)
+	if got := req.Header.Get(Header); got != "req-1" {
+		t.Errorf("header %q, want req-1", got)
+	}
+}
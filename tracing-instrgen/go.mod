@@ -3,11 +3,13 @@ module github.com/xyzbit/devops-demo/tracing-instrgen
 go 1.24.2
 
 require (
+	go.opentelemetry.io/otel/trace v1.37.0
 	google.golang.org/grpc v1.74.2
 	google.golang.org/protobuf v1.36.6
 )
 
 require (
+	go.opentelemetry.io/otel v1.37.0 // indirect
 	golang.org/x/net v0.40.0 // indirect
 	golang.org/x/sys v0.33.0 // indirect
 	golang.org/x/text v0.25.0 // indirect
@@ -7,11 +7,15 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 
 	pb "github.com/xyzbit/devops-demo/tracing-instrgen/proto"
 )
@@ -20,6 +24,11 @@ const (
 	serviceName = "svca"
 	servicePort = ":8080"
 	svcbAddress = "svcb:50051"
+
+	// deadlineHeader 调用方传递的剩余时间预算（毫秒）
+	deadlineHeader = "X-Request-Budget-Ms"
+	// defaultTimeout 调用方未传递时间预算时 gRPC 调用的超时
+	defaultTimeout = 5 * time.Second
 )
 
 // UserHandler 用户处理器
@@ -53,6 +62,29 @@ func createGRPCClient() pb.UserServiceClient {
 	return pb.NewUserServiceClient(conn)
 }
 
+// requestContext 把请求头中的时间预算转换为截止时间，gRPC 会通过 grpc-timeout 继续传递给 svcb
+func requestContext(r *http.Request) (context.Context, context.CancelFunc) {
+	timeout := defaultTimeout
+	if budget, err := strconv.ParseInt(r.Header.Get(deadlineHeader), 10, 64); err == nil && budget > 0 {
+		timeout = time.Duration(budget) * time.Millisecond
+	}
+	return context.WithTimeout(r.Context(), timeout)
+}
+
+// writeGRPCError 把 gRPC 错误转换为 HTTP 响应，超时和取消会记录到当前 span
+func writeGRPCError(ctx context.Context, w http.ResponseWriter, err error) {
+	log.Printf("调用 gRPC 服务失败: %v", err)
+	switch status.Code(err) {
+	case codes.DeadlineExceeded, codes.Canceled:
+		span := trace.SpanFromContext(ctx)
+		span.RecordError(err)
+		span.AddEvent("request cancelled")
+		http.Error(w, "请求超时", http.StatusGatewayTimeout)
+	default:
+		http.Error(w, "内部服务错误", http.StatusInternalServerError)
+	}
+}
+
 // GetUser HTTP 处理器 - 获取用户
 func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request) {
 	userID := r.URL.Query().Get("user_id")
@@ -61,12 +93,14 @@ func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ctx, cancel := requestContext(r)
+	defer cancel()
+
 	// 调用 gRPC 服务
 	grpcReq := &pb.GetUserRequest{UserId: userID}
-	grpcResp, err := h.grpcClient.GetUser(context.Background(), grpcReq)
+	grpcResp, err := h.grpcClient.GetUser(ctx, grpcReq)
 	if err != nil {
-		log.Printf("调用 gRPC 服务失败: %v", err)
-		http.Error(w, "内部服务错误", http.StatusInternalServerError)
+		writeGRPCError(ctx, w, err)
 		return
 	}
 
@@ -92,15 +126,17 @@ func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ctx, cancel := requestContext(r)
+	defer cancel()
+
 	// 调用 gRPC 服务
 	grpcReq := &pb.CreateUserRequest{
 		Name:  req.Name,
 		Email: req.Email,
 	}
-	grpcResp, err := h.grpcClient.CreateUser(context.Background(), grpcReq)
+	grpcResp, err := h.grpcClient.CreateUser(ctx, grpcReq)
 	if err != nil {
-		log.Printf("调用 gRPC 服务失败: %v", err)
-		http.Error(w, "内部服务错误", http.StatusInternalServerError)
+		writeGRPCError(ctx, w, err)
 		return
 	}
 
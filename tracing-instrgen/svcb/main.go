@@ -10,8 +10,10 @@ import (
 	"syscall"
 	"time"
 
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
 
 	pb "github.com/xyzbit/devops-demo/tracing-instrgen/proto"
 )
@@ -26,10 +28,26 @@ type UserServer struct {
 	pb.UnimplementedUserServiceServer
 }
 
+// simulateWork 模拟耗时操作，调用方的截止时间到达时立即中止并记录到当前 span
+func simulateWork(ctx context.Context, d time.Duration) error {
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		span := trace.SpanFromContext(ctx)
+		span.RecordError(ctx.Err())
+		span.AddEvent("work cancelled")
+		return status.FromContextError(ctx.Err()).Err()
+	}
+}
+
 // GetUser 获取用户信息
 func (s *UserServer) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.GetUserResponse, error) {
 	// 模拟数据库查询延迟
-	time.Sleep(50 * time.Millisecond)
+	if err := simulateWork(ctx, 50*time.Millisecond); err != nil {
+		log.Printf("获取用户已取消: %s, %v", req.UserId, err)
+		return nil, err
+	}
 
 	// 模拟用户数据
 	response := &pb.GetUserResponse{
@@ -46,7 +64,10 @@ func (s *UserServer) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.G
 // CreateUser 创建用户
 func (s *UserServer) CreateUser(ctx context.Context, req *pb.CreateUserRequest) (*pb.CreateUserResponse, error) {
 	// 模拟数据库写入延迟
-	time.Sleep(100 * time.Millisecond)
+	if err := simulateWork(ctx, 100*time.Millisecond); err != nil {
+		log.Printf("创建用户已取消: %s, %v", req.Name, err)
+		return nil, err
+	}
 
 	// 生成用户ID
 	userID := fmt.Sprintf("user_%d", time.Now().Unix())